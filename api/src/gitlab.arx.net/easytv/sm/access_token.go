@@ -0,0 +1,88 @@
+package sm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// PersonalAccessTokenPrefix tells the personal access tokens
+// apart from the session ids and the oauth tokens
+const PersonalAccessTokenPrefix = "smpat_"
+
+// PersonalAccessToken is a long lived token of a content owner that
+// is used in place of a session, only the SHA-256 of the token is stored
+type PersonalAccessToken struct {
+	ID      int64
+	OwnerID int64
+	Name    string
+	// The plain text of a new token, it is only set when the token is created
+	Token       string
+	HashedToken string
+	// The permissions of the token, the role of the owner still applies
+	Scopes       []string
+	CreationDate time.Time
+	// nil if the token doesn't expire
+	ExpirationDate *time.Time
+	LastUsed       *time.Time
+	Revoked        bool
+}
+
+// IsActive checks if the token can be used
+func (this *PersonalAccessToken) IsActive(now time.Time) bool {
+	return !this.Revoked && (this.ExpirationDate == nil || this.ExpirationDate.After(now))
+}
+
+// IsPersonalAccessToken checks if a credential is a personal access token
+func IsPersonalAccessToken(value string) bool {
+	return strings.HasPrefix(value, PersonalAccessTokenPrefix)
+}
+
+// GeneratePersonalAccessToken returns a random personal access token
+func GeneratePersonalAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + hex.EncodeToString(b), nil
+}
+
+type PersonalAccessTokenRepository interface {
+	Insert(token *PersonalAccessToken) error
+
+	GetByID(id int64) (*PersonalAccessToken, error)
+
+	// Returns the tokens of the content owner, the newest first
+	GetForOwner(owner_id int64) ([]*PersonalAccessToken, error)
+
+	// Returns an active token and updates the last time it was used
+	Use(hashed_token string) (*PersonalAccessToken, error)
+
+	// Saves the revoked flag
+	Save(token *PersonalAccessToken) error
+
+	// Revokes all the tokens of the content owner
+	RevokeForOwner(owner_id int64) error
+}
+
+// errors
+
+var ErrTokenNameTooShort = errors.New("Token name too short")
+var ErrInvalidTokenExpiration = errors.New("The expiration of the token should be in the future")
+
+// service
+
+type PersonalAccessTokenService interface {
+	// Creates a token, its plain text is only returned here.
+	// The scopes should be permissions of the role of the owner.
+	CreateToken(owner_id int64, name string, scopes []string,
+		expiration *time.Time) (*PersonalAccessToken, error)
+
+	RevokeToken(owner_id, id int64) error
+
+	// Returns the token and its owner, fails with ErrInvalidAccessToken
+	// if the token isn't active and ErrNotAMember if the owner left its organisation
+	Authenticate(token string) (*PersonalAccessToken, *ContentOwner, error)
+}
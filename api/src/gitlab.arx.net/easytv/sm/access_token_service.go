@@ -0,0 +1,129 @@
+package sm
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type access_token_service struct {
+	repository       PersonalAccessTokenRepository
+	owner_repository ContentOwnerRepository
+	role_service     RoleService
+}
+
+func NewPersonalAccessTokenService(repository PersonalAccessTokenRepository,
+	owner_repository ContentOwnerRepository,
+	role_service RoleService) PersonalAccessTokenService {
+	return &access_token_service{
+		repository:       repository,
+		owner_repository: owner_repository,
+		role_service:     role_service,
+	}
+}
+
+func (this *access_token_service) CreateToken(owner_id int64, name string,
+	scopes []string, expiration *time.Time) (*PersonalAccessToken, error) {
+
+	if len(name) < 2 {
+		return nil, ErrTokenNameTooShort
+	} else if expiration != nil && !expiration.After(time.Now()) {
+		return nil, ErrInvalidTokenExpiration
+	} else if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	owner := ContentOwner{ID: owner_id}
+
+	if err := this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return nil, err
+	} else if len(owner.Username) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var role_id int64
+	if owner.RoleID != nil {
+		role_id = *owner.RoleID
+	}
+
+	role, err := this.role_service.GetSessionRole(RoleContentOwner, role_id)
+
+	if err != nil {
+		return nil, err
+	} else if role == nil {
+		return nil, ErrNotFound
+	}
+
+	// A token can't do more than its owner
+	for _, scope := range scopes {
+		if !role.Allows(scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	plain_token, err := GeneratePersonalAccessToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := PersonalAccessToken{
+		OwnerID:        owner.ID,
+		Name:           name,
+		Token:          plain_token,
+		HashedToken:    HashApiKey(plain_token),
+		Scopes:         scopes,
+		CreationDate:   time.Now(),
+		ExpirationDate: expiration,
+	}
+
+	if err = this.repository.Insert(&token); err != nil {
+		return nil, err
+	}
+
+	log.Infof("content owner=%v created token=%v,'%v' scopes=%v expiration=%v",
+		owner.ID, token.ID, token.Name, strings.Join(scopes, ","), expiration)
+
+	return &token, nil
+}
+
+func (this *access_token_service) RevokeToken(owner_id, id int64) error {
+	token, err := this.repository.GetByID(id)
+
+	if err != nil {
+		return err
+	} else if token == nil || token.OwnerID != owner_id {
+		return ErrNotFound
+	}
+
+	log.Infof("content owner=%v revoked token=%v,'%v'", owner_id, token.ID, token.Name)
+
+	token.Revoked = true
+
+	return this.repository.Save(token)
+}
+
+func (this *access_token_service) Authenticate(
+	plain_token string) (*PersonalAccessToken, *ContentOwner, error) {
+
+	token, err := this.repository.Use(HashApiKey(plain_token))
+
+	if err != nil {
+		return nil, nil, err
+	} else if token == nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	owner := ContentOwner{ID: token.OwnerID}
+
+	if err = this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return nil, nil, err
+	} else if len(owner.Username) == 0 {
+		return nil, nil, ErrInvalidAccessToken
+	} else if owner.OrganisationID == nil {
+		return nil, nil, ErrNotAMember
+	}
+
+	return token, &owner, nil
+}
@@ -6,6 +6,8 @@ type AdminUser struct {
 	ID       int64
 	Username string
 	Password string
+	// nil for the default role
+	RoleID *int64
 }
 
 type AdminRepository interface {
@@ -16,6 +18,8 @@ type AdminRepository interface {
 	Insert(user *AdminUser) error
 
 	SavePassword(admin *AdminUser) error
+
+	GetAll() ([]*AdminUser, error)
 }
 
 var ErrInvalidCredentials = errors.New("credentials were not valid")
@@ -1,35 +1,287 @@
 package sm
 
-import "mime/multipart"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// AssetUrlPrefix is the path the assets are downloaded from.
+// The value of an AssetParam is the url of an asset.
+const AssetUrlPrefix = "/asset/"
+
+// OwnerAssetExpiration is how long the uploads of the content owners are kept,
+// the GC deletes the older ones that no active job uses
+const OwnerAssetExpiration = 60 * 60 * 24 * 30 // 30 days
+
+// AssetUrlParam returns the url param of an asset url,
+// or an empty string if it isn't an asset url
+func AssetUrlParam(url string) string {
+	if !strings.HasPrefix(url, AssetUrlPrefix) {
+		return ""
+	}
+	return url[len(AssetUrlPrefix):]
+}
 
 type Asset struct {
-	ID       int64
-	JobID    int64
+	ID int64
+	// 0 for the uploads of the content owners
+	JobID int64
+	// The organisation of an upload of a content owner, its jobs can use it
+	OrganisationID int64
+	// The content owner that uploaded it, 0 for the assets of the modules
+	OwnerID  int64
 	UrlParam string
-	Path     string
-	Size     int64
+	// The key of the file in the AssetStore
+	Path string
+	Size int64
+	// The hex SHA-256 of the content, the expected one while uploading
+	Checksum string
+	// The uploads are hidden until all the chunks are received
+	Complete     bool
+	UploadedSize int64
+	// The name the file was uploaded with, the versions of a name share it
+	OriginalName string
+	// The version of OriginalName, the first upload of a name is 1
+	Version int
+	// Detected from the content when the upload is complete
+	MimeType string
+	// The module and the step that uploaded the asset, 0 for the uploads of the content owners
+	ModuleID int64
+	StepID   int64
+	// What happens to an asset with the same name when the upload finishes
+	DuplicatePolicy DuplicatePolicy
+}
+
+// DuplicatePolicy is what happens when a file is uploaded with the name of an
+// asset of the same job, or of the same organisation for the content owners
+type DuplicatePolicy string
+
+const (
+	// The file is saved as the next version of the name
+	DuplicateVersion DuplicatePolicy = "version"
+	// The upload fails with ErrAssetExists
+	DuplicateReject DuplicatePolicy = "reject"
+	// The file takes the place of the asset, the url of the asset stays the same
+	DuplicateReplace DuplicatePolicy = "replace"
+)
+
+// ParseDuplicatePolicy checks the policy of an upload, empty for DuplicateReject
+func ParseDuplicatePolicy(value string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(value) {
+	case "", DuplicateReject:
+		return DuplicateReject, nil
+	case DuplicateVersion, DuplicateReplace:
+		return DuplicatePolicy(value), nil
+	}
+	return "", ErrInvalidDuplicatePolicy
+}
+
+// VersionedName returns the name that a version of a file is saved with,
+// "video.mp4" becomes "video-v2.mp4"
+func VersionedName(filename string, version int) string {
+	filename = path.Base(filename)
+	if version <= 1 {
+		return filename
+	}
+
+	ext := path.Ext(filename)
+
+	return fmt.Sprintf("%s-v%d%s", strings.TrimSuffix(filename, ext), version, ext)
+}
+
+// mime_sniff_size is how much of the content DetectMimeType looks at
+const mime_sniff_size = 512
+
+// mime_sniffer keeps the start of the content that is written to it
+type mime_sniffer struct {
+	head []byte
+}
+
+func (this *mime_sniffer) Write(p []byte) (int, error) {
+	if n := mime_sniff_size - len(this.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		this.head = append(this.head, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// DetectMimeType returns the type of a file from the start of its content,
+// the extension decides when the content is plain text or isn't recognized
+func DetectMimeType(filename string, head []byte) string {
+	detected := http.DetectContentType(head)
+
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		if by_extension := mime.TypeByExtension(path.Ext(filename)); by_extension != "" {
+			return by_extension
+		}
+	}
+	return detected
+}
+
+// IsOwnerAsset checks if a content owner uploaded the asset
+func (this *Asset) IsOwnerAsset() bool {
+	return this.JobID == 0
+}
+
+// OwnerAssetPrefix returns the prefix of the keys of the uploads
+// of the content owners of an organisation
+func OwnerAssetPrefix(organisation_id int64) string {
+	return fmt.Sprintf("organisation/%d/", organisation_id)
+}
+
+// OwnerAssetKey returns the name that the url param of a file
+// of a content owner is derived from
+func OwnerAssetKey(organisation_id int64, filename string) string {
+	return OwnerAssetPrefix(organisation_id) + path.Base(filename)
+}
+
+// UploadChunkPrefix returns the prefix of the keys of the chunks of an upload,
+// they are in the folder of the asset so the GC of the job deletes them
+func UploadChunkPrefix(asset *Asset) string {
+	return fmt.Sprintf("%s/.upload/%d/", path.Dir(asset.Key()), asset.ID)
+}
+
+// UploadChunkKey returns the key of the chunk that starts at offset,
+// the keys sort like the offsets
+func UploadChunkKey(asset *Asset, offset int64) string {
+	return fmt.Sprintf("%s%020d", UploadChunkPrefix(asset), offset)
 }
 
 type AssetRepository interface {
+	// ErrAssetExists if an asset of the same state has the url param
 	Create(asset *Asset) error
 
 	GetAssetsForJob(job_id int64, assets *[]*Asset) error
 
-	GetAssetsForUser(content_owner_id int64, assets *[]*Asset) error
+	GetAssetsForOrganisation(organisation_id int64, assets *[]*Asset) error
+
+	// Returns the complete uploads of the content owners of the organisation
+	GetOwnerAssets(organisation_id int64) ([]*Asset, error)
+
+	// Returns the uploads of the content owners, complete or not, that were
+	// created before the date and aren't used by an active job
+	GetExpiredOwnerAssets(before time.Time) ([]*Asset, error)
+
+	// Checks if the input of a job that isn't finished has the asset url
+	IsUsedByActiveJob(url string) (bool, error)
 
 	DeleteAssetsForJob(job_id int64) error
 
 	GetAsset(asset_id int64) (*Asset, error)
 
+	// Returns a complete asset
 	GetAssetByUrlParam(url_param string) (*Asset, error)
+
+	// Returns an upload that isn't complete
+	GetUploadByUrlParam(url_param string) (*Asset, error)
+
+	// Moves the uploaded size of an upload from offset to new_offset,
+	// false if the uploaded size isn't offset
+	AdvanceUpload(asset_id, offset, new_offset int64) (bool, error)
+
+	// Returns the latest complete version of a name of the job,
+	// or of the uploads of the organisation if job_id is 0
+	GetLatestVersion(job_id, organisation_id int64, name string) (*Asset, error)
+
+	// Deletes the asset replaced_id and creates the asset that takes its place,
+	// ErrAssetExists if the asset was replaced already
+	Replace(replaced_id int64, asset *Asset) error
+
+	// Saves the checksum and the mime type and marks an upload as complete,
+	// false if it was complete already. The asset replaced_id is deleted
+	// in the same transaction unless it is 0. ErrAssetExists if
+	// another asset took the name.
+	CompleteUpload(asset *Asset, replaced_id int64) (bool, error)
+
+	// Sets the uploaded size of an upload to 0
+	ResetUpload(asset_id int64) error
+
+	DeleteAsset(asset_id int64) error
 }
 
+// errors
+
+var ErrUploadOffsetMismatch = errors.New("The offset of the chunk isn't the uploaded size")
+var ErrUploadTooLarge = errors.New("The chunk exceeds the size of the upload")
+var ErrUploadIncomplete = errors.New("The upload is missing chunks")
+var ErrChecksumMismatch = errors.New("The checksum of the upload doesn't match")
+var ErrInvalidUploadSize = errors.New("The size of the upload should be positive")
+var ErrAssetExists = errors.New("An asset with the same name exists")
+var ErrAssetInUse = errors.New("The asset is used by an active job")
+var ErrInvalidDuplicatePolicy = errors.New("The duplicate policy should be version, reject or replace")
+
 type AssetService interface {
+	// Saves a file of a step, policy decides what happens
+	// if the job has an asset with the same name
 	CreateAsset(step_id int64,
 		module *Module,
 		file multipart.File,
 		filename string,
-		filesize int64) (*Asset, error)
+		filesize int64,
+		policy DuplicatePolicy) (*Asset, error)
+
+	// Starts a resumable upload of a file, an upload of the same
+	// file that isn't complete is returned instead.
+	// checksum is the optional hex SHA-256 of the file.
+	CreateUpload(step_id int64,
+		module *Module,
+		filename string,
+		size int64,
+		checksum string,
+		policy DuplicatePolicy) (*Asset, error)
+
+	// Returns the upload with the uploaded size
+	GetUpload(step_id int64, module *Module, asset_id int64) (*Asset, error)
+
+	// Saves the chunk that starts at offset, offset should be the uploaded size
+	WriteChunk(step_id int64,
+		module *Module,
+		asset_id int64,
+		offset int64,
+		content io.Reader,
+		length int64) (*Asset, error)
+
+	// Joins the chunks into the asset, the asset can't be downloaded before.
+	// Fails with ErrChecksumMismatch and restarts the upload if the
+	// content doesn't match the checksum.
+	FinishUpload(step_id int64, module *Module, asset_id int64, checksum string) (*Asset, error)
+
+	// The uploads of the content owners, the jobs of the organisation
+	// can use them as input
+
+	CreateOwnerAsset(organisation_id, owner_id int64,
+		file multipart.File,
+		filename string,
+		filesize int64,
+		policy DuplicatePolicy) (*Asset, error)
+
+	CreateOwnerUpload(organisation_id, owner_id int64,
+		filename string,
+		size int64,
+		checksum string,
+		policy DuplicatePolicy) (*Asset, error)
+
+	// Returns an upload or a complete asset of the organisation
+	GetOwnerAsset(organisation_id, asset_id int64) (*Asset, error)
+
+	WriteOwnerChunk(organisation_id, asset_id int64,
+		offset int64,
+		content io.Reader,
+		length int64) (*Asset, error)
+
+	FinishOwnerUpload(organisation_id, asset_id int64, checksum string) (*Asset, error)
+
+	// Fails with ErrAssetInUse while a job that isn't finished uses the asset
+	DeleteOwnerAsset(organisation_id, asset_id int64) error
 
 	GC() error
 }
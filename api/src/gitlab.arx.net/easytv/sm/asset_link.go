@@ -0,0 +1,126 @@
+package sm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AssetLinkExpiration is how long the download links of the content owners work
+const AssetLinkExpiration = 60 * 60 // 1 hour
+
+// ModuleAssetLinkExpiration is how long the links of the start requests work,
+// they never outlive the expiration date of the job
+const ModuleAssetLinkExpiration = 60 * 60 * 24 // 1 day
+
+// The kinds of AssetLinkScope
+const (
+	// The module that performs the step, AssetLinkScope.ID is the step id
+	AssetScopeStep = "step"
+	// The content owners of the organisation that owns the asset
+	AssetScopeOrganisation = "organisation"
+)
+
+// AssetLinkScope is who a download link was given to
+type AssetLinkScope struct {
+	Kind string
+	ID   int64
+}
+
+func (this AssetLinkScope) String() string {
+	return fmt.Sprintf("%s:%d", this.Kind, this.ID)
+}
+
+// StepScope returns the scope of the links given to the module of a step
+func StepScope(step_id int64) AssetLinkScope {
+	return AssetLinkScope{Kind: AssetScopeStep, ID: step_id}
+}
+
+// OrganisationScope returns the scope of the links given to the content owners
+func OrganisationScope(organisation_id int64) AssetLinkScope {
+	return AssetLinkScope{Kind: AssetScopeOrganisation, ID: organisation_id}
+}
+
+// ParseAssetLinkScope parses the form returned by AssetLinkScope.String
+func ParseAssetLinkScope(value string) (AssetLinkScope, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || (parts[0] != AssetScopeStep && parts[0] != AssetScopeOrganisation) {
+		return AssetLinkScope{}, ErrInvalidAssetLink
+	}
+
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return AssetLinkScope{}, ErrInvalidAssetLink
+	}
+
+	return AssetLinkScope{Kind: parts[0], ID: id}, nil
+}
+
+// errors
+
+var ErrInvalidAssetLink = errors.New("The download link of the asset is invalid")
+var ErrAssetLinkExpired = errors.New("The download link of the asset has expired")
+
+// AssetLinker signs the download links of the assets, the links
+// are only accepted with the key that signed them
+type AssetLinker struct {
+	key []byte
+	// The url that the api is reached at, the links are relative if it is empty
+	base_url string
+}
+
+// NewAssetLinker creates a linker that signs with key,
+// the links are resolved against base_url
+func NewAssetLinker(key []byte, base_url string) *AssetLinker {
+	return &AssetLinker{
+		key:      key,
+		base_url: strings.TrimSuffix(base_url, "/"),
+	}
+}
+
+func (this *AssetLinker) signature(url_param, scope string, expires int64) string {
+	mac := hmac.New(sha256.New, this.key)
+	mac.Write([]byte(fmt.Sprintf("asset-link\n%s\n%s\n%d", url_param, scope, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Link returns the signed download url of an asset url,
+// the values that aren't asset urls are returned as they are
+func (this *AssetLinker) Link(asset_url string, scope AssetLinkScope, expires time.Time) string {
+	url_param := AssetUrlParam(asset_url)
+	if len(url_param) == 0 {
+		return asset_url
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("scope", scope.String())
+	query.Set("signature", this.signature(url_param, scope.String(), expires.Unix()))
+
+	return this.base_url + asset_url + "?" + query.Encode()
+}
+
+// Verify checks the signature and the expiration of the query of a download link,
+// it returns the scope the link was given to
+func (this *AssetLinker) Verify(url_param string, query url.Values, now time.Time) (AssetLinkScope, error) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return AssetLinkScope{}, ErrInvalidAssetLink
+	}
+
+	signature := this.signature(url_param, query.Get("scope"), expires)
+
+	if !hmac.Equal([]byte(signature), []byte(query.Get("signature"))) {
+		return AssetLinkScope{}, ErrInvalidAssetLink
+	} else if now.Unix() >= expires {
+		return AssetLinkScope{}, ErrAssetLinkExpired
+	}
+
+	return ParseAssetLinkScope(query.Get("scope"))
+}
@@ -2,17 +2,19 @@ package sm
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strconv"
 	"time"
 )
 
@@ -20,15 +22,18 @@ type asset_service struct {
 	task_repository TaskRepository
 	job_repository  JobRepository
 	repository      AssetRepository
+	store           AssetStore
 }
 
 func NewAssetService(repository AssetRepository,
 	job_repository JobRepository,
-	task_repository TaskRepository) AssetService {
+	task_repository TaskRepository,
+	store AssetStore) AssetService {
 	return &asset_service{
 		repository:      repository,
 		task_repository: task_repository,
 		job_repository:  job_repository,
+		store:           store,
 	}
 }
 
@@ -56,28 +61,38 @@ func (this *asset_service) GC() error {
 				log.Warningf("GC: Failed to delete params for job %d (%s)", job.ID, err)
 			}
 
-			// Delete asset records
-			err = this.repository.DeleteAssetsForJob(job.ID)
+			// Delete asset files, the older assets aren't under the prefix of the job
+			assets := make([]*Asset, 0)
+			err = this.repository.GetAssetsForJob(job.ID, &assets)
 
 			if err != nil {
-				log.Warningf("GC: Failed to delete asset entries for job %d (%s)", job.ID, err)
+				log.Warningf("GC: Failed to get asset entries for job %d (%s)", job.ID, err)
 			}
 
-			// Delete asset files
-			job_id_hash := md5.Sum([]byte(strconv.FormatInt(job.ID, 10)))
-
-			asset_folder := fmt.Sprintf("/asset/%s/%d",
-				hex.EncodeToString(job_id_hash[:1]),
-				job.ID)
+			for _, asset := range assets {
+				if err = this.store.Delete(asset.Key()); err != nil {
+					log.Warningf("GC: Failed to delete asset \"%s\" for job %d (%s)",
+						asset.Key(),
+						job.ID,
+						err)
+				}
+			}
 
-			err = os.RemoveAll(asset_folder)
+			prefix := JobAssetPrefix(job.ID)
 
-			if err != nil {
-				log.Warningf("GC: Failed to remove asset folder \"%s\" for job %d (%s)",
-					asset_folder,
+			if err = DeleteAssetPrefix(this.store, prefix); err != nil {
+				log.Warningf("GC: Failed to delete assets \"%s\" for job %d (%s)",
+					prefix,
 					job.ID,
 					err)
 			}
+
+			// Delete asset records
+			err = this.repository.DeleteAssetsForJob(job.ID)
+
+			if err != nil {
+				log.Warningf("GC: Failed to delete asset entries for job %d (%s)", job.ID, err)
+			}
 		}
 
 		jobs, err = this.job_repository.GetNewExpiredJobsAt(
@@ -90,17 +105,42 @@ func (this *asset_service) GC() error {
 		}
 	}
 
+	if err = this.gcOwnerAssets(now); err != nil {
+		return err
+	}
+
 	log.Infof("GC: Completed")
 
 	return this.job_repository.ExpireJobsBefore(now)
 }
 
-func (this *asset_service) CreateAsset(step_id int64,
-	module *Module,
-	file multipart.File,
-	filename string,
-	filesize int64) (*Asset, error) {
-	job, err := this.job_repository.GetJobByID(step_id)
+// Deletes the uploads of the content owners that are older than
+// OwnerAssetExpiration, unless an active job uses them
+func (this *asset_service) gcOwnerAssets(now time.Time) error {
+	assets, err := this.repository.GetExpiredOwnerAssets(
+		now.Add(-OwnerAssetExpiration * time.Second))
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("GC: %d expired assets of content owners", len(assets))
+
+	for _, asset := range assets {
+		if err = this.deleteOwnerAsset(asset); err != nil {
+			log.Warningf("GC: Failed to delete asset %d of organisation %d (%s)",
+				asset.ID,
+				asset.OrganisationID,
+				err)
+		}
+	}
+
+	return nil
+}
+
+// activeJob returns the job of a step that the module is working on
+func (this *asset_service) activeJob(step_id int64, module *Module) (*Job, error) {
+	job, err := this.job_repository.GetJobByStepID(step_id)
 
 	if err != nil {
 		return nil, err
@@ -116,53 +156,645 @@ func (this *asset_service) CreateAsset(step_id int64,
 		return nil, err
 	}
 
-	step := job.Steps[job.CurrentStep]
-	task, err := this.task_repository.GetTask(step.TaskID)
+	step := job.StepByID(step_id)
+	task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
 
-	// Check that this module is responsible for this job step
+	// Check that this module is responsible for this active job step
 	if err != nil {
 		return nil, err
 	} else if task == nil {
 		return nil, fmt.Errorf("Task %d doesn't exist even though step %d claims so",
 			step.TaskID, step.ID)
-	} else if task.ModuleID != module.ID {
+	} else if task.ModuleID != module.ID || step.Status == StepWaiting {
 		// as far as this service is concerned this job doesn't exist
 		return nil, ErrNotFound
+	} else if step.Status == StepCompleted {
+		return nil, ErrJobIsCompleted
 	}
 
-	// Create Asset object and save the file
-	job_id_hash := md5.Sum([]byte(strconv.FormatInt(step_id, 10)))
+	return job, nil
+}
+
+func asset_url_param(job_id int64, filename string) string {
+	url_hex := md5.Sum([]byte(fmt.Sprintf("%d/%s", job_id, filename)))
+
+	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(url_hex[:])
+}
+
+func owner_asset_url_param(organisation_id int64, filename string) string {
+	url_hex := md5.Sum([]byte(OwnerAssetKey(organisation_id, filename)))
+
+	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(url_hex[:])
+}
+
+// set_asset_name sets the url param of the version of the asset
+// and a key of its own
+func set_asset_name(asset *Asset) {
+	name := VersionedName(asset.OriginalName, asset.Version)
+
+	if asset.IsOwnerAsset() {
+		asset.Path = UniqueAssetKey(OwnerAssetPrefix(asset.OrganisationID), name)
+		asset.UrlParam = owner_asset_url_param(asset.OrganisationID, name)
+	} else {
+		asset.Path = UniqueAssetKey(JobAssetPrefix(asset.JobID), name)
+		asset.UrlParam = asset_url_param(asset.JobID, name)
+	}
+}
+
+// applyDuplicatePolicy names the asset after the policy and the assets
+// with the same name, it returns the asset that is replaced or nil.
+// The repository checks the name again when the asset is saved.
+func (this *asset_service) applyDuplicatePolicy(asset *Asset) (*Asset, error) {
+	latest, err := this.repository.GetLatestVersion(
+		asset.JobID, asset.OrganisationID, asset.OriginalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	asset.Version = 1
+
+	if latest != nil {
+		switch asset.DuplicatePolicy {
+		case DuplicateVersion:
+			asset.Version = latest.Version + 1
+		case DuplicateReplace:
+			asset.Version = latest.Version
+		default:
+			return nil, ErrAssetExists
+		}
+	}
+
+	// A version can have the name of another file,
+	// "a-v2.txt" is the second version of "a.txt" as well
+	for {
+		set_asset_name(asset)
+
+		current, err := this.repository.GetAssetByUrlParam(asset.UrlParam)
+
+		if err != nil {
+			return nil, err
+		} else if current == nil {
+			return nil, nil
+		}
+
+		switch asset.DuplicatePolicy {
+		case DuplicateReplace:
+			return current, this.checkNotInUse(current)
+		case DuplicateVersion:
+			asset.Version++
+		default:
+			return nil, ErrAssetExists
+		}
+	}
+}
+
+// checkNotInUse returns ErrAssetInUse if the file of an owner asset
+// that would be replaced or deleted is the input of a job that isn't finished
+func (this *asset_service) checkNotInUse(asset *Asset) error {
+	if !asset.IsOwnerAsset() {
+		return nil
+	}
+
+	// The jobs get the asset when their step starts
+	in_use, err := this.repository.IsUsedByActiveJob(AssetUrlPrefix + asset.UrlParam)
+
+	if err != nil {
+		return err
+	} else if in_use {
+		return ErrAssetInUse
+	}
+	return nil
+}
+
+// putAsset saves the file and the complete asset
+func (this *asset_service) putAsset(asset *Asset, file io.Reader) error {
+	replaced, err := this.applyDuplicatePolicy(asset)
+
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	sniffer := &mime_sniffer{}
 
-	url_hex := md5.Sum([]byte(fmt.Sprintf("%d/%s", job.ID, filename)))
+	// The key is unique, the files of the other assets aren't touched
+	err = this.store.Put(asset.Key(), io.TeeReader(file, io.MultiWriter(hash, sniffer)), asset.Size)
+	if err != nil {
+		return err
+	}
+
+	asset.Checksum = hex.EncodeToString(hash.Sum(nil))
+	asset.MimeType = DetectMimeType(asset.OriginalName, sniffer.head)
+	asset.Complete = true
+	asset.UploadedSize = asset.Size
+
+	// Save Asset to DB, the replaced asset is swapped in the same transaction
+	if replaced != nil {
+		err = this.repository.Replace(replaced.ID, asset)
+	} else {
+		err = this.repository.Create(asset)
+	}
+
+	if err != nil {
+		this.store.Delete(asset.Key())
+		return err
+	}
 
+	if replaced != nil {
+		this.deleteReplaced(replaced, asset)
+	}
+	return nil
+}
+
+// deleteReplaced deletes the file of an asset that another asset replaced
+func (this *asset_service) deleteReplaced(replaced, asset *Asset) {
+	log.Infof("asset=%v of path=%v is replaced by asset=%v", replaced.ID, replaced.Path, asset.ID)
+
+	if err := this.store.Delete(replaced.Key()); err != nil {
+		log.Warnf("asset=%v failed to delete the replaced file \"%s\" (%s)",
+			asset.ID, replaced.Key(), err)
+	}
+}
+
+func (this *asset_service) CreateAsset(step_id int64,
+	module *Module,
+	file multipart.File,
+	filename string,
+	filesize int64,
+	policy DuplicatePolicy) (*Asset, error) {
+	job, err := this.activeJob(step_id, module)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Asset object and save the file
 	log.Infof("saving asset filename=%v for step=%v", filename, step_id)
 
 	asset := Asset{
-		JobID: job.ID,
-		Path: fmt.Sprintf("/asset/%s/%d/%s",
-			hex.EncodeToString(job_id_hash[:1]),
-			job.ID,
-			filename),
-		Size:     filesize,
-		UrlParam: base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(url_hex[:]),
+		JobID:           job.ID,
+		OrganisationID:  job.OrganisationID,
+		Size:            filesize,
+		OriginalName:    path.Base(filename),
+		ModuleID:        module.ID,
+		StepID:          step_id,
+		DuplicatePolicy: policy,
+	}
+
+	if err = this.putAsset(&asset, file); err != nil {
+		return nil, err
+	}
+
+	log.Infof("asset file=%v saved with id=%v version=%v", filename, asset.ID, asset.Version)
+
+	return &asset, nil
+}
+
+// createUpload saves a new upload, an upload of the same file
+// that isn't complete is returned instead
+func (this *asset_service) createUpload(asset *Asset) (*Asset, error) {
+	if asset.Size <= 0 {
+		return nil, ErrInvalidUploadSize
+	}
+
+	asset.Checksum = strings.ToLower(asset.Checksum)
+
+	// The policy is checked again when the upload finishes
+	if _, err := this.applyDuplicatePolicy(asset); err != nil {
+		return nil, err
+	}
+
+	// A client that lost the id of the upload can continue it
+	current, err := this.repository.GetUploadByUrlParam(asset.UrlParam)
+
+	if err != nil {
+		return nil, err
+	} else if current != nil && (current.StepID != asset.StepID || current.ModuleID != asset.ModuleID) {
+		// Only the step that started the upload can continue it
+		return nil, ErrAssetExists
+	} else if current != nil && current.Size == asset.Size && current.Checksum == asset.Checksum &&
+		current.DuplicatePolicy == asset.DuplicatePolicy {
+		return current, nil
+	} else if current != nil {
+		// The file changed, the upload starts over
+		log.Infof("upload=%v of path=%v is replaced", current.ID, current.Path)
+
+		if err = DeleteAssetPrefix(this.store, UploadChunkPrefix(current)); err != nil {
+			return nil, err
+		}
+		if err = this.repository.DeleteAsset(current.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = this.repository.Create(asset); err != nil {
+		return nil, err
+	}
+
+	log.Infof("upload=%v of path=%v size=%v created", asset.ID, asset.Path, asset.Size)
+
+	return asset, nil
+}
+
+func (this *asset_service) CreateUpload(step_id int64,
+	module *Module,
+	filename string,
+	size int64,
+	checksum string,
+	policy DuplicatePolicy) (*Asset, error) {
+	job, err := this.activeJob(step_id, module)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("step=%v uploads filename=%v", step_id, filename)
+
+	return this.createUpload(&Asset{
+		JobID:           job.ID,
+		OrganisationID:  job.OrganisationID,
+		Size:            size,
+		Checksum:        checksum,
+		OriginalName:    path.Base(filename),
+		ModuleID:        module.ID,
+		StepID:          step_id,
+		DuplicatePolicy: policy,
+	})
+}
+
+func (this *asset_service) GetUpload(step_id int64, module *Module, asset_id int64) (*Asset, error) {
+	job, err := this.activeJob(step_id, module)
+
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := this.repository.GetAsset(asset_id)
+
+	if err != nil {
+		return nil, err
+	} else if asset == nil || asset.JobID != job.ID ||
+		asset.StepID != step_id || asset.ModuleID != module.ID {
+		// The other steps of the job can't touch the upload
+		return nil, ErrNotFound
+	}
+	return asset, nil
+}
+
+func (this *asset_service) WriteChunk(step_id int64,
+	module *Module,
+	asset_id int64,
+	offset int64,
+	content io.Reader,
+	length int64) (*Asset, error) {
+	asset, err := this.GetUpload(step_id, module, asset_id)
+
+	if err != nil {
+		return nil, err
+	}
+	return this.writeChunk(asset, offset, content, length)
+}
+
+func (this *asset_service) writeChunk(asset *Asset,
+	offset int64,
+	content io.Reader,
+	length int64) (*Asset, error) {
+	if asset.Complete || offset != asset.UploadedSize {
+		return asset, ErrUploadOffsetMismatch
+	} else if length <= 0 || offset+length > asset.Size {
+		return asset, ErrUploadTooLarge
+	}
+
+	key := UploadChunkKey(asset, offset)
+
+	if err := this.store.Put(key, io.LimitReader(content, length), length); err != nil {
+		return nil, err
+	}
+
+	// A connection that closed early leaves a shorter chunk
+	object, err := this.store.Stat(key)
+
+	if err != nil {
+		return nil, err
+	} else if object.Size != length {
+		this.store.Delete(key)
+		return asset, ErrUploadIncomplete
+	}
+
+	if ok, err := this.repository.AdvanceUpload(asset.ID, offset, offset+length); err != nil {
+		return nil, err
+	} else if !ok {
+		// Another request saved the chunk of the offset first
+		current, err := this.repository.GetAsset(asset.ID)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrUploadOffsetMismatch
+	}
+
+	asset.UploadedSize = offset + length
+
+	return asset, nil
+}
+
+// chunk_reader reads the chunks of an upload one after the other,
+// a chunk is only opened when the previous one is read
+type chunk_reader struct {
+	store   AssetStore
+	keys    []string
+	current io.ReadCloser
+}
+
+func (this *chunk_reader) Read(p []byte) (int, error) {
+	for {
+		if this.current == nil {
+			if len(this.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			content, _, err := this.store.Get(this.keys[0])
+			if err != nil {
+				return 0, err
+			}
+
+			this.current = content
+			this.keys = this.keys[1:]
+		}
+
+		n, err := this.current.Read(p)
+
+		if err == io.EOF {
+			this.current.Close()
+			this.current = nil
+
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (this *chunk_reader) Close() {
+	if this.current != nil {
+		this.current.Close()
+	}
+}
+
+func (this *asset_service) FinishUpload(step_id int64,
+	module *Module,
+	asset_id int64,
+	checksum string) (*Asset, error) {
+	asset, err := this.GetUpload(step_id, module, asset_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if asset, err = this.finishUpload(asset, checksum); err == nil {
+		log.Infof("upload=%v of step=%v is complete", asset.ID, step_id)
+	}
+	return asset, err
+}
+
+// finishUpload joins the chunks of the upload into the asset
+func (this *asset_service) finishUpload(asset *Asset, checksum string) (*Asset, error) {
+	if asset.Complete {
+		return asset, nil
+	} else if asset.UploadedSize != asset.Size {
+		return asset, ErrUploadIncomplete
+	}
+
+	if len(checksum) == 0 {
+		checksum = asset.Checksum
+	}
+	checksum = strings.ToLower(checksum)
+
+	prefix := UploadChunkPrefix(asset)
+
+	chunks, err := this.store.List(prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Key < chunks[j].Key })
+
+	// The chunks should cover the file without gaps
+	keys := make([]string, len(chunks))
+	offset := int64(0)
+
+	for i, chunk := range chunks {
+		chunk_offset, err := strconv.ParseInt(strings.TrimPrefix(chunk.Key, prefix), 10, 64)
+
+		if err != nil || chunk_offset != offset {
+			return asset, ErrUploadIncomplete
+		}
+
+		keys[i] = chunk.Key
+		offset += chunk.Size
+	}
+
+	if offset != asset.Size {
+		return asset, ErrUploadIncomplete
+	}
+
+	// Another upload can have taken the name since this one started
+	replaced, err := this.repository.GetAssetByUrlParam(asset.UrlParam)
+
+	if err != nil {
+		return nil, err
+	} else if replaced != nil && asset.DuplicatePolicy != DuplicateReplace {
+		return asset, ErrAssetExists
+	} else if replaced != nil {
+		if err = this.checkNotInUse(replaced); err != nil {
+			return asset, err
+		}
+	}
+
+	replaced_id := int64(0)
+	if replaced != nil {
+		replaced_id = replaced.ID
 	}
 
-	os.MkdirAll(filepath.Dir(asset.Path), os.ModePerm)
-	f, err := os.OpenFile(asset.Path, os.O_WRONLY|os.O_CREATE, 0666)
+	// The key of the upload is unique, a replaced asset keeps its file
+	// until the upload is complete
+	computed, mime_type, err := this.readChunks(keys, asset.OriginalName, func(content io.Reader) error {
+		return this.store.Put(asset.Key(), content, asset.Size)
+	})
+
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = io.Copy(f, file)
-	f.Close()
+	if len(checksum) > 0 && checksum != computed {
+		this.store.Delete(asset.Key())
+		return this.restartUpload(asset, checksum, computed)
+	}
 
-	// Save Asset to DB
-	if err = this.repository.Create(&asset); err != nil {
-		os.Remove(asset.Path)
+	asset.Checksum = computed
+	asset.MimeType = mime_type
+
+	if ok, err := this.repository.CompleteUpload(asset, replaced_id); err == ErrAssetExists {
+		this.store.Delete(asset.Key())
+		return asset, err
+	} else if err != nil {
 		return nil, err
+	} else if !ok {
+		// Another request finished it
+		return this.repository.GetAsset(asset.ID)
+	}
+
+	if replaced != nil {
+		this.deleteReplaced(replaced, asset)
+	}
+
+	if err = DeleteAssetPrefix(this.store, prefix); err != nil {
+		log.Warnf("upload=%v failed to delete the chunks (%s)", asset.ID, err)
+	}
+
+	asset.Complete = true
+
+	return asset, nil
+}
+
+// readChunks gives the content of the chunks to write,
+// it returns the hex SHA-256 and the mime type of the content
+func (this *asset_service) readChunks(keys []string,
+	filename string,
+	write func(content io.Reader) error) (string, string, error) {
+	hash := sha256.New()
+	sniffer := &mime_sniffer{}
+	reader := &chunk_reader{store: this.store, keys: keys}
+
+	err := write(io.TeeReader(reader, io.MultiWriter(hash, sniffer)))
+	reader.Close()
+
+	if err != nil {
+		return "", "", err
 	}
+	return hex.EncodeToString(hash.Sum(nil)), DetectMimeType(filename, sniffer.head), nil
+}
 
-	log.Infof("asset file=%v saved with id=Creating%v", filename, asset.ID)
+// restartUpload deletes the chunks of an upload that doesn't match its checksum
+func (this *asset_service) restartUpload(asset *Asset, checksum, computed string) (*Asset, error) {
+	log.Warnf("upload=%v checksum=%v doesn't match computed=%v, restarting it",
+		asset.ID, checksum, computed)
+
+	if err := DeleteAssetPrefix(this.store, UploadChunkPrefix(asset)); err != nil {
+		return nil, err
+	}
+	if err := this.repository.ResetUpload(asset.ID); err != nil {
+		return nil, err
+	}
+
+	asset.UploadedSize = 0
+	return asset, ErrChecksumMismatch
+}
+
+func (this *asset_service) CreateOwnerAsset(organisation_id, owner_id int64,
+	file multipart.File,
+	filename string,
+	filesize int64,
+	policy DuplicatePolicy) (*Asset, error) {
+	log.Infof("saving asset filename=%v of user=%v", filename, owner_id)
+
+	asset := Asset{
+		OrganisationID:  organisation_id,
+		OwnerID:         owner_id,
+		Size:            filesize,
+		OriginalName:    path.Base(filename),
+		DuplicatePolicy: policy,
+	}
+
+	if err := this.putAsset(&asset, file); err != nil {
+		return nil, err
+	}
+
+	log.Infof("asset file=%v saved with id=%v version=%v", filename, asset.ID, asset.Version)
 
 	return &asset, nil
 }
+
+func (this *asset_service) CreateOwnerUpload(organisation_id, owner_id int64,
+	filename string,
+	size int64,
+	checksum string,
+	policy DuplicatePolicy) (*Asset, error) {
+	log.Infof("user=%v uploads filename=%v", owner_id, filename)
+
+	return this.createUpload(&Asset{
+		OrganisationID:  organisation_id,
+		OwnerID:         owner_id,
+		Size:            size,
+		Checksum:        checksum,
+		OriginalName:    path.Base(filename),
+		DuplicatePolicy: policy,
+	})
+}
+
+func (this *asset_service) GetOwnerAsset(organisation_id, asset_id int64) (*Asset, error) {
+	asset, err := this.repository.GetAsset(asset_id)
+
+	if err != nil {
+		return nil, err
+	} else if asset == nil || !asset.IsOwnerAsset() || asset.OrganisationID != organisation_id {
+		return nil, ErrNotFound
+	}
+	return asset, nil
+}
+
+func (this *asset_service) WriteOwnerChunk(organisation_id, asset_id int64,
+	offset int64,
+	content io.Reader,
+	length int64) (*Asset, error) {
+	asset, err := this.GetOwnerAsset(organisation_id, asset_id)
+
+	if err != nil {
+		return nil, err
+	}
+	return this.writeChunk(asset, offset, content, length)
+}
+
+func (this *asset_service) FinishOwnerUpload(organisation_id, asset_id int64,
+	checksum string) (*Asset, error) {
+	asset, err := this.GetOwnerAsset(organisation_id, asset_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if asset, err = this.finishUpload(asset, checksum); err == nil {
+		log.Infof("upload=%v of organisation=%v is complete", asset.ID, organisation_id)
+	}
+	return asset, err
+}
+
+func (this *asset_service) DeleteOwnerAsset(organisation_id, asset_id int64) error {
+	asset, err := this.GetOwnerAsset(organisation_id, asset_id)
+
+	if err != nil {
+		return err
+	}
+
+	if err = this.checkNotInUse(asset); err != nil {
+		return err
+	}
+
+	return this.deleteOwnerAsset(asset)
+}
+
+func (this *asset_service) deleteOwnerAsset(asset *Asset) error {
+	if err := DeleteAssetPrefix(this.store, UploadChunkPrefix(asset)); err != nil {
+		return err
+	}
+	if err := this.store.Delete(asset.Key()); err != nil {
+		return err
+	}
+
+	log.Infof("asset=%v of organisation=%v deleted", asset.ID, asset.OrganisationID)
+
+	return this.repository.DeleteAsset(asset.ID)
+}
@@ -0,0 +1,94 @@
+package sm
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AssetObject is a file of an AssetStore
+type AssetObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// AssetStore keeps the files of the assets, the keys are slash separated paths.
+// The implementations are in the store package.
+type AssetStore interface {
+	// Writes the content of the key, size is the length of the content
+	Put(key string, content io.Reader, size int64) error
+
+	// The caller closes the content, fails with ErrAssetNotFound
+	Get(key string) (io.ReadCloser, *AssetObject, error)
+
+	// Fails with ErrAssetNotFound
+	Stat(key string) (*AssetObject, error)
+
+	// Deleting a key that doesn't exist isn't an error
+	Delete(key string) error
+
+	// Returns the objects with keys that start with the prefix
+	List(prefix string) ([]*AssetObject, error)
+}
+
+var ErrAssetNotFound = errors.New("Asset file doesn't exist")
+
+// legacy_asset_root is the directory the paths of the older assets start with
+const legacy_asset_root = "/asset/"
+
+// JobAssetPrefix returns the prefix of the keys of the assets of a job,
+// the jobs are spread in 256 folders by the md5 of their id
+func JobAssetPrefix(job_id int64) string {
+	job_id_hash := md5.Sum([]byte(strconv.FormatInt(job_id, 10)))
+
+	return fmt.Sprintf("%s/%d/", hex.EncodeToString(job_id_hash[:1]), job_id)
+}
+
+// UniqueAssetKey returns a key under prefix that no other asset has,
+// an upload never overwrites the file of another asset.
+// The last segment of the key is the name of the file.
+func UniqueAssetKey(prefix, filename string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return prefix + hex.EncodeToString(b) + "/" + path.Base(filename)
+}
+
+// Key returns the key of the asset in the AssetStore.
+// The older assets saved the path in the /asset directory.
+func (this *Asset) Key() string {
+	return strings.TrimPrefix(this.Path, legacy_asset_root)
+}
+
+// Filename returns the name the asset was uploaded with,
+// the older assets only have the name of their path
+func (this *Asset) Filename() string {
+	if len(this.OriginalName) > 0 {
+		return this.OriginalName
+	}
+	return path.Base(this.Path)
+}
+
+// DeleteAssetPrefix deletes all the objects of the prefix
+func DeleteAssetPrefix(store AssetStore, prefix string) error {
+	objects, err := store.List(prefix)
+
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if err = store.Delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type AccessTokenController struct {
+	sessions         *gosession.SessionStore
+	token_repository sm.PersonalAccessTokenRepository
+	token_service    sm.PersonalAccessTokenService
+}
+
+func describe_access_token(token *sm.PersonalAccessToken) map[string]interface{} {
+	var expiration_date, last_used *int64
+
+	if token.ExpirationDate != nil {
+		expiration_date = new(int64)
+		*expiration_date = token.ExpirationDate.Unix()
+	}
+	if token.LastUsed != nil {
+		last_used = new(int64)
+		*last_used = token.LastUsed.Unix()
+	}
+
+	return map[string]interface{}{
+		"id":              token.ID,
+		"name":            token.Name,
+		"scopes":          token.Scopes,
+		"creation_date":   token.CreationDate.Unix(),
+		"expiration_date": expiration_date,
+		"last_used":       last_used,
+		"revoked":         token.Revoked,
+		"active":          token.IsActive(time.Now()),
+	}
+}
+
+// The tokens are managed with an interactive session only
+func (this *AccessTokenController) verifySession(
+	w http.ResponseWriter, r *http.Request) (*gosession.Session, bool) {
+
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return nil, false
+	} else if IsTokenSession(session) {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeForbidden,
+			"description": "The tokens can't be managed with an access token"})
+		return nil, false
+	}
+	return session, true
+}
+
+func (this *AccessTokenController) GetTokens(w http.ResponseWriter, r *http.Request) {
+	session, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	tokens, err := this.token_repository.GetForOwner(user_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	tokens_json := make([]map[string]interface{}, 0, len(tokens))
+	for _, token := range tokens {
+		tokens_json = append(tokens_json, describe_access_token(token))
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the access tokens of the user",
+		"tokens":      tokens_json})
+}
+
+// Creates a token with "name", "scopes" and optionally
+// "expires_in" seconds, the token doesn't expire without it
+func (this *AccessTokenController) PostToken(w http.ResponseWriter, r *http.Request) {
+	session, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	name, _ := data["name"].(string)
+	values, _ := data["scopes"].([]interface{})
+
+	scopes := make([]string, 0, len(values))
+	for _, value := range values {
+		if scope, ok := value.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var expiration *time.Time
+	if expires_in, ok := data["expires_in"].(float64); ok {
+		expiration = new(time.Time)
+		*expiration = time.Now().Add(time.Duration(expires_in) * time.Second)
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	token, err := this.token_service.CreateToken(user_id, name, scopes, expiration)
+
+	if err == nil {
+		// This is the last time the server will have access to the token in plaintext
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The token was created, it won't be shown again",
+			"token_id":    token.ID,
+			"token":       token.Token})
+	} else if err == sm.ErrTokenNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"name\" string parameter"})
+	} else if err == sm.ErrInvalidTokenExpiration {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidTokenExpiration,
+			"description": "The \"expires_in\" should be positive"})
+	} else if err == sm.ErrInvalidScope {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidScope,
+			"description": "\"scopes\" should be a non empty array of permissions that your role grants"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *AccessTokenController) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	session, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "token_id")
+	if !ok {
+		return
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	err := this.token_service.RevokeToken(user_id, id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The token was revoked"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The token was not found"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -25,6 +26,8 @@ type AdminController struct {
 	module_service    sm.ModuleService
 	owner_repository  sm.ContentOwnerRepository
 	owner_service     sm.ContentOwnerService
+	role_service      sm.RoleService
+	job_service       sm.JobService
 }
 
 func (this *AdminController) GetLog(w http.ResponseWriter, r *http.Request) {
@@ -88,10 +91,11 @@ func (this *AdminController) CreateService(w http.ResponseWriter, r *http.Reques
 
 	if err == nil && module != nil {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"code":        sm.OK,
-			"description": "Service created",
-			"api_key":     module.ApiKey,
-			"service_id":  module.ID})
+			"code":           sm.OK,
+			"description":    "Service created",
+			"api_key":        module.ApiKey,
+			"signing_secret": module.SigningSecret,
+			"service_id":     module.ID})
 	} else if err == sm.ErrServiceNameTooShort {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeMissingInput,
@@ -168,6 +172,13 @@ func (this *AdminController) GetService(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	keys, err := this.module_repository.GetApiKeys(module.ID)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
 	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"code":        sm.OK,
 		"description": "Success",
@@ -175,10 +186,219 @@ func (this *AdminController) GetService(w http.ResponseWriter, r *http.Request)
 			"id":          module.ID,
 			"name":        module.Name,
 			"description": module.Description,
-			"api_key":     module.ApiKey,
+			"api_keys":    describe_api_keys(keys),
 			"enabled":     module.Enabled}})
 }
 
+func describe_api_keys(keys []*sm.ModuleApiKey) []map[string]interface{} {
+	now := time.Now()
+	keys_json := make([]map[string]interface{}, len(keys))
+
+	for i, key := range keys {
+		var expiration_date, last_used *int64
+		if key.ExpirationDate != nil {
+			expiration_date = new(int64)
+			*expiration_date = key.ExpirationDate.Unix()
+		}
+		if key.LastUsed != nil {
+			last_used = new(int64)
+			*last_used = key.LastUsed.Unix()
+		}
+
+		keys_json[i] = map[string]interface{}{
+			"id":              key.ID,
+			"label":           key.Label,
+			"creation_date":   key.CreationDate.Unix(),
+			"expiration_date": expiration_date,
+			"last_used":       last_used,
+			"revoked":         key.Revoked,
+			"active":          key.IsActive(now),
+		}
+	}
+
+	return keys_json
+}
+
+// Reads the id of the service from the url, responds if it is invalid
+func read_service_id(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "service_id"), 10, 64)
+
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"id\" parameter"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (this *AdminController) GetApiKeys(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_service_id(w, r)
+	if !ok {
+		return
+	}
+
+	module, err := this.module_repository.GetModuleByID(id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if module == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("A service with id=%d doesn't exist", id)})
+		return
+	}
+
+	keys, err := this.module_repository.GetApiKeys(module.ID)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"api_keys":    describe_api_keys(keys)})
+}
+
+// Creates a key with a "label" that expires after "expires_in" seconds,
+// a key without "expires_in" doesn't expire
+func (this *AdminController) PostApiKey(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_service_id(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	label, _ := data["label"].(string)
+
+	if len(label) == 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"label\" parameter"})
+		return
+	}
+
+	var expiration *time.Time
+	if expires_in, ok := data["expires_in"].(float64); ok {
+		expiration = new(time.Time)
+		*expiration = time.Now().Add(time.Duration(expires_in) * time.Second)
+	}
+
+	module, key, err := this.module_service.CreateApiKey(id, label, expiration)
+
+	if err == nil {
+		// This is the last time the server will have access to the key in plaintext
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Api key created",
+			"api_key_id":  key.ID,
+			"api_key":     module.ApiKey})
+	} else if err == sm.ErrInvalidApiKeyExpiration {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidApiKeyExpiration,
+			"description": "The \"expires_in\" should be positive"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("Service with id=%d was not found", id)})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+// Creates a new key, the previous keys expire after
+// "grace_period" seconds (one day by default)
+func (this *AdminController) RotateApiKeys(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_service_id(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	grace_period := sm.DefaultApiKeyGracePeriod
+	if seconds, ok := data["grace_period"].(float64); ok {
+		grace_period = time.Duration(seconds) * time.Second
+	}
+
+	module, err := this.module_service.RenewApiKey(id, grace_period)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Api key renewed",
+			"api_key":     module.ApiKey})
+	} else if err == sm.ErrInvalidGracePeriod {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidGracePeriod,
+			"description": "The \"grace_period\" can't be negative"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("Service with id=%d was not found", id)})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *AdminController) DeleteApiKey(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_service_id(w, r)
+	if !ok {
+		return
+	}
+
+	key_id, err := strconv.ParseInt(chi.URLParam(r, "key_id"), 10, 64)
+
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"key_id\" parameter"})
+		return
+	}
+
+	err = this.module_service.RevokeApiKey(id, key_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Api key revoked"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("Api key with id=%d was not found", key_id)})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
 func (this *AdminController) SetAvailability(w http.ResponseWriter, r *http.Request) {
 	session, _ := this.sessions.Get(r, w)
 
@@ -237,12 +457,31 @@ func (this *AdminController) RegisterOwner(w http.ResponseWriter, r *http.Reques
 	name, _ := data["name"].(string)
 	email, _ := data["email"].(string)
 	username, _ := data["username"].(string)
+	role, _ := data["role"].(string)
+	password := this.owner_service.GenerateRandomPassword(15)
+
+	var content_owner *sm.ContentOwner
+	var err error
+
+	// Without an organisation, one with the name of the owner is created
+	if organisation_id, ok := data["organisation_id"].(float64); ok {
+		content_owner, err = this.owner_service.CreateMember(
+			int64(organisation_id),
+			name,
+			username,
+			email,
+			password)
+	} else {
+		content_owner, err = this.owner_service.CreateContentOwner(
+			name,
+			username,
+			email,
+			password)
+	}
 
-	content_owner, err := this.owner_service.CreateContentOwner(
-		name,
-		username,
-		email,
-		this.owner_service.GenerateRandomPassword(15))
+	if err == nil && len(role) > 0 {
+		err = this.role_service.SetOwnerRole(content_owner.ID, role)
+	}
 
 	if err == nil {
 		// This is the last time the server will have access to the password in plaintext
@@ -275,6 +514,49 @@ func (this *AdminController) RegisterOwner(w http.ResponseWriter, r *http.Reques
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeContentOwnerEmailExists,
 			"description": "A content owner with this email already exists"})
+	} else if err == sm.ErrOrganisationNameExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeOrganisationNameExists,
+			"description": "An organisation with this name already exists"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The organisation or the role was not found"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *AdminController) CancelJob(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	job_id, ok := read_url_id(w, r, "job_id")
+	if !ok {
+		return
+	}
+
+	err := this.job_service.CancelJobAsOperator(job_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The job was canceled"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("A job with id=%d doesn't exist", job_id)})
+	} else if err == sm.ErrJobIsCompleted {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeJobAlreadyCompleted,
+			"description": "The job is already completed"})
+	} else if err == sm.ErrJobIsCanceled {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeJobAlreadyCanceled,
+			"description": "The job is already canceled"})
 	} else {
 		InternalServerError(w, err)
 	}
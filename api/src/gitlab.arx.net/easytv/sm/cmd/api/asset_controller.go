@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+// AssetController manages the files that the content owners
+// upload as the input of their jobs
+type AssetController struct {
+	sessions         *gosession.SessionStore
+	asset_repository sm.AssetRepository
+	asset_service    sm.AssetService
+	asset_linker     *sm.AssetLinker
+}
+
+// Returns a signed link that the members of the organisation download the asset from
+func (this *AssetController) downloadUrl(organisation_id int64, asset *sm.Asset) string {
+	return this.asset_linker.Link(fmt.Sprintf("/asset/%s", asset.UrlParam),
+		sm.OrganisationScope(organisation_id),
+		time.Now().Add(sm.AssetLinkExpiration*time.Second))
+}
+
+func (this *AssetController) assetJson(organisation_id int64, asset *sm.Asset) map[string]interface{} {
+	asset_json := describe_asset(asset)
+	asset_json["download_url"] = this.downloadUrl(organisation_id, asset)
+
+	return asset_json
+}
+
+func write_owner_asset_error(w http.ResponseWriter, err error, asset *sm.Asset) {
+	if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The asset doesn't exist"})
+	} else if err == sm.ErrAssetInUse {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeAssetInUse,
+			"description": "The asset is used by a job that isn't finished"})
+	} else {
+		write_upload_error(w, err, asset, 0)
+	}
+}
+
+// Returns the organisation and the user of the session
+func (this *AssetController) verifySession(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return 0, 0, false
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return 0, 0, false
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	return SessionOrganisationID(session), user_id, true
+}
+
+// Parses the asset id of the url
+func (this *AssetController) assetID(w http.ResponseWriter, r *http.Request, param string) (int64, bool) {
+	asset_id, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid asset id"})
+		return 0, false
+	}
+	return asset_id, true
+}
+
+func (this *AssetController) GetAssets(w http.ResponseWriter, r *http.Request) {
+	organisation_id, _, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	assets, err := this.asset_repository.GetOwnerAssets(organisation_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	assets_json := make([]map[string]interface{}, len(assets))
+	for i, asset := range assets {
+		assets_json[i] = this.assetJson(organisation_id, asset)
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"assets":      assets_json})
+}
+
+// GetAsset returns an asset or the progress of an upload
+func (this *AssetController) GetAsset(w http.ResponseWriter, r *http.Request) {
+	organisation_id, _, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	asset_id, ok := this.assetID(w, r, "asset_id")
+	if !ok {
+		return
+	}
+
+	asset, err := this.asset_service.GetOwnerAsset(organisation_id, asset_id)
+
+	if err == nil {
+		upload := describe_upload(asset)
+		if asset.Complete {
+			upload["download_url"] = this.downloadUrl(organisation_id, asset)
+		}
+
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Success",
+			"upload":      upload})
+	} else {
+		write_owner_asset_error(w, err, nil)
+	}
+}
+
+// PostAsset uploads a file in a single multipart request
+func (this *AssetController) PostAsset(w http.ResponseWriter, r *http.Request) {
+	organisation_id, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	r.ParseMultipartForm(1024 * 512) // 1MB
+
+	file, handler, err := r.FormFile("asset")
+
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeEmptyAsset,
+			"description": "The \"asset\" file is missing"})
+		return
+	}
+
+	defer file.Close()
+
+	policy, err := sm.ParseDuplicatePolicy(r.FormValue("on_duplicate"))
+	if err != nil {
+		write_owner_asset_error(w, err, nil)
+		return
+	}
+
+	asset, err := this.asset_service.CreateOwnerAsset(
+		organisation_id, user_id, file, handler.Filename, handler.Size, policy)
+
+	if err == nil {
+		response := this.assetJson(organisation_id, asset)
+		response["code"] = sm.OK
+		response["description"] = "Asset was uploaded"
+
+		httpio.WriteJSON(w, http.StatusOK, response)
+	} else {
+		write_owner_asset_error(w, err, nil)
+	}
+}
+
+func (this *AssetController) DeleteAsset(w http.ResponseWriter, r *http.Request) {
+	organisation_id, _, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	asset_id, ok := this.assetID(w, r, "asset_id")
+	if !ok {
+		return
+	}
+
+	err := this.asset_service.DeleteOwnerAsset(organisation_id, asset_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Asset was deleted"})
+	} else {
+		write_owner_asset_error(w, err, nil)
+	}
+}
+
+// CreateUpload starts a resumable upload like the one of the modules
+func (this *AssetController) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	organisation_id, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	filename, _ := data["filename"].(string)
+	size, _ := data["size"].(float64)
+	checksum, _ := data["checksum"].(string)
+	on_duplicate, _ := data["on_duplicate"].(string)
+
+	if len(filename) == 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "filename is required"})
+		return
+	}
+
+	policy, err := sm.ParseDuplicatePolicy(on_duplicate)
+	if err != nil {
+		write_owner_asset_error(w, err, nil)
+		return
+	}
+
+	asset, err := this.asset_service.CreateOwnerUpload(
+		organisation_id, user_id, filename, int64(size), checksum, policy)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Upload the chunks from the offset",
+			"upload":      describe_upload(asset)})
+	} else {
+		write_owner_asset_error(w, err, nil)
+	}
+}
+
+// PutUploadChunk saves the body as the chunk that starts at the Upload-Offset header
+func (this *AssetController) PutUploadChunk(w http.ResponseWriter, r *http.Request) {
+	organisation_id, _, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	asset_id, ok := this.assetID(w, r, "upload_id")
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+
+	if err != nil || r.ContentLength <= 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "The Upload-Offset and Content-Length headers are required"})
+		return
+	}
+
+	asset, err := this.asset_service.WriteOwnerChunk(
+		organisation_id, asset_id, offset, r.Body, r.ContentLength)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The chunk was saved",
+			"upload":      describe_upload(asset)})
+	} else {
+		write_owner_asset_error(w, err, asset)
+	}
+}
+
+func (this *AssetController) FinishUpload(w http.ResponseWriter, r *http.Request) {
+	organisation_id, _, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	asset_id, ok := this.assetID(w, r, "upload_id")
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	checksum, _ := data["checksum"].(string)
+
+	asset, err := this.asset_service.FinishOwnerUpload(organisation_id, asset_id, checksum)
+
+	if err == nil {
+		response := this.assetJson(organisation_id, asset)
+		response["code"] = sm.OK
+		response["description"] = "Asset was uploaded"
+
+		httpio.WriteJSON(w, http.StatusOK, response)
+	} else {
+		write_owner_asset_error(w, err, asset)
+	}
+}
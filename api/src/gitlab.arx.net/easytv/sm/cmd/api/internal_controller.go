@@ -1,16 +1,18 @@
 package main
 
 import (
-	"crypto/sha256"
 	"database/sql"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"strconv"
-	"encoding/hex"
+	"time"
 	
 	"github.com/go-chi/chi"
+	log "github.com/sirupsen/logrus"
 
 	"gitlab.arx.net/arx/httpio"
 	"gitlab.arx.net/easytv/sm"
@@ -26,6 +28,8 @@ type InternalController struct {
 	owner_repository  sm.ContentOwnerRepository
 	asset_repository  sm.AssetRepository
 	asset_service     sm.AssetService
+	asset_store       sm.AssetStore
+	asset_linker      *sm.AssetLinker
 }
 
 //
@@ -54,10 +58,7 @@ func (this *InternalController) check_api_key(
 		return nil
 	}
 
-	hashed_key := sha256.Sum256([]byte(api_key))
-	hashed_key_str := hex.EncodeToString(hashed_key[:])
-
-	module, err := this.module_repository.GetModuleByKey(hashed_key_str[:])
+	module, err := this.module_repository.GetModuleByKey(sm.HashApiKey(api_key))
 
 	if err == sql.ErrNoRows {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -81,33 +82,17 @@ func (this *InternalController) RegisterTask(w http.ResponseWriter, r *http.Requ
 
 	data, _ := httpio.ReadJSON(r)
 
-	input := make(map[string]sm.ParamType)
+	input := make(map[string]sm.TaskParam)
 	if input_data, ok := data["input"].(map[string]interface{}); ok {
 		for name, value := range input_data {
-
-			str_val, ok := value.(string)
-			if !ok {
-				httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-					"code":        sm.CodeInvalidInput,
-					"description": fmt.Sprintf("\"%s\" should have a string value", name)})
-				return
-			}
-			input[name] = sm.GetParamTypeFromSting(str_val)
+			input[name] = sm.ParseTaskParam(value)
 		}
 	}
 
-	output := make(map[string]sm.ParamType)
+	output := make(map[string]sm.TaskParam)
 	if output_data, ok := data["output"].(map[string]interface{}); ok {
 		for name, value := range output_data {
-
-			str_val, ok := value.(string)
-			if !ok {
-				httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-					"code":        sm.CodeInvalidOutput,
-					"description": fmt.Sprintf("\"%s\" should have a string value", name)})
-				return
-			}
-			output[name] = sm.GetParamTypeFromSting(str_val)
+			output[name] = sm.ParseTaskParam(value)
 		}
 	}
 
@@ -121,8 +106,60 @@ func (this *InternalController) RegisterTask(w http.ResponseWriter, r *http.Requ
 		cancel_url = fmt.Sprintf("REST %v", cancel_url)
 	}
 
+	retry := sm.DefaultRetryPolicy
+	if retry_data, ok := data["retry"].(map[string]interface{}); ok {
+		if max_attempts, ok := retry_data["max_attempts"].(float64); ok {
+			retry.MaxAttempts = int(max_attempts)
+		}
+
+		if backoff_base, ok := retry_data["backoff_base"].(float64); ok {
+			retry.BackoffBase = time.Duration(backoff_base * float64(time.Second))
+		}
+
+		if status_codes, ok := retry_data["status_codes"].([]interface{}); ok {
+			retry.StatusCodes = make([]int, len(status_codes))
+			for i, code := range status_codes {
+				code_val, ok := code.(float64)
+				if !ok {
+					httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+						"code":        sm.CodeInvalidRetryPolicy,
+						"description": "\"status_codes\" should be an array of numbers"})
+					return
+				}
+				retry.StatusCodes[i] = int(code_val)
+			}
+		}
+	}
+
+	// Seconds that an asynchronous step can wait for `finish`, 0 means no limit
+	max_async_duration, _ := data["max_async_duration"].(float64)
+
+	// Optional JSON Schemas of the input and the output
+	schemas := make(map[string]sm.Schema)
+	for _, key := range []string{"input_schema", "output_schema"} {
+		if value, ok := data[key]; !ok || value == nil {
+			continue
+		} else if schema, ok := value.(map[string]interface{}); ok {
+			schemas[key] = schema
+		} else {
+			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"code":        sm.CodeInvalidSchema,
+				"description": fmt.Sprintf("\"%s\" should be a JSON Schema object", key)})
+			return
+		}
+	}
+
 	task, err := this.task_service.RegisterTask(
-		module.ID, name, desc, start_url, cancel_url, input, output)
+		module.ID, name, desc, start_url, cancel_url, input, output,
+		schemas["input_schema"], schemas["output_schema"], retry,
+		time.Duration(max_async_duration*float64(time.Second)))
+
+	if e, ok := err.(*sm.ErrInvalidSchema); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidSchema,
+			"description": e.Error()})
+		return
+	}
 
 	switch err {
 	case nil:
@@ -153,11 +190,11 @@ func (this *InternalController) RegisterTask(w http.ResponseWriter, r *http.Requ
 	case sm.ErrInvalidInputType:
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeInvalidInput,
-			"description": "Input should be (\"string\", \"int\" or \"double\")"})
+			"description": "Input should be (\"string\", \"int\", \"double\", \"bool\", \"json\", \"array\", \"asset\" or \"enum\" with \"values\"), optional input can have a valid \"default\""})
 	case sm.ErrInvalidOutputType:
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeInvalidOutput,
-			"description": "Output should be (\"string\", \"int\" or \"double\")"})
+			"description": "Output should be (\"string\", \"int\", \"double\", \"bool\", \"json\", \"array\", \"asset\" or \"enum\" with \"values\") without a \"default\""})
 	case sm.ErrEmptyInput:
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeTaskNoInputParameter,
@@ -166,6 +203,16 @@ func (this *InternalController) RegisterTask(w http.ResponseWriter, r *http.Requ
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeTaskNoOutputParameter,
 			"description": "There should be at least one output parameter"})
+	case sm.ErrInvalidRetryPolicy:
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidRetryPolicy,
+			"description": fmt.Sprintf("\"retry\" needs max_attempts between 1 and %d, "+
+				"a backoff_base between %v and %v and valid http status codes",
+				sm.MaxRetryAttempts, sm.MinRetryBackoff, sm.MaxRetryBackoff)})
+	case sm.ErrInvalidMaxAsyncDuration:
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidMaxAsyncDuration,
+			"description": "\"max_async_duration\" can't be negative"})
 	default:
 		InternalServerError(w, err)
 	}
@@ -274,27 +321,54 @@ func (this *InternalController) GetTasks(w http.ResponseWriter, r *http.Request)
 
 	for i, task := range tasks {
 
-		input := make(map[string]string)
+		input := sm.DescribeParams(task.Input)
+		output := sm.DescribeParams(task.Output)
 
-		for name, param_type := range task.Input {
-			input[name] = sm.ParamTypeStr(param_type)
-		}
+		versions, err := this.task_repository.GetTaskVersions(task.ID)
 
-		output := make(map[string]string)
+		if err != nil {
+			InternalServerError(w, err)
+			return
+		}
 
-		for name, param_type := range task.Output {
-			output[name] = sm.ParamTypeStr(param_type)
+		versions_data := make([]map[string]interface{}, len(versions))
+
+		for j, version := range versions {
+			versions_data[j] = map[string]interface{}{
+				"version":    version.Version,
+				"start_url":  version.StartUrl,
+				"cancel_url": version.CancelUrl,
+				"input":      sm.DescribeParams(version.Input),
+				"output":     sm.DescribeParams(version.Output),
+				"retry": map[string]interface{}{
+					"max_attempts": version.Retry.MaxAttempts,
+					"backoff_base": version.Retry.BackoffBase.Seconds(),
+					"status_codes": version.Retry.StatusCodes},
+				"max_async_duration": version.MaxAsyncDuration.Seconds(),
+				"input_schema":       version.InputSchema,
+				"output_schema":      version.OutputSchema,
+				"creation_date":      version.CreationDate.Unix(),
+				"retired":            version.Retired}
 		}
 
 		task_data[i] = map[string]interface{}{
 			"id":          task.ID,
+			"version":     task.Version,
 			"name":        task.Name,
 			"description": task.Description,
 			"start_url":   task.StartUrl,
 			"cancel_url":  task.CancelUrl,
 			"enabled":     task.Enabled,
 			"input":       input,
-			"output":      output}
+			"output":      output,
+			"retry": map[string]interface{}{
+				"max_attempts": task.Retry.MaxAttempts,
+				"backoff_base": task.Retry.BackoffBase.Seconds(),
+				"status_codes": task.Retry.StatusCodes},
+			"max_async_duration": task.MaxAsyncDuration.Seconds(),
+			"input_schema":       task.InputSchema,
+			"output_schema":      task.OutputSchema,
+			"versions":           versions_data}
 	}
 
 	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -541,16 +615,21 @@ func (this *InternalController) UploadAsset(w http.ResponseWriter, r *http.Reque
 
 	defer file.Close()
 
+	policy, err := sm.ParseDuplicatePolicy(r.FormValue("on_duplicate"))
+	if err != nil {
+		write_upload_error(w, err, nil, step_id)
+		return
+	}
+
 	asset, err := this.asset_service.CreateAsset(
-		step_id, module, file, handler.Filename, handler.Size)
+		step_id, module, file, handler.Filename, handler.Size, policy)
 
 	if err == nil {
-		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"code":        sm.OK,
-			"description": "Asset was uploaded",
-			"asset_id":    asset.ID,
-			"asset_url":   fmt.Sprintf("/asset/%s", asset.UrlParam),
-		})
+		response := describe_asset(asset)
+		response["code"] = sm.OK
+		response["description"] = "Asset was uploaded"
+
+		httpio.WriteJSON(w, http.StatusOK, response)
 	} else if err == sm.ErrNotFound {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeNotFound,
@@ -562,7 +641,234 @@ func (this *InternalController) UploadAsset(w http.ResponseWriter, r *http.Reque
 			"description": "You can't upload an asset for a job that is completed or canceled",
 		})
 	} else {
+		write_upload_error(w, err, nil, step_id)
+	}
+}
+
+// describe_asset returns the metadata of a complete asset,
+// the uploader is a module and a step or a content owner
+func describe_asset(asset *sm.Asset) map[string]interface{} {
+	return map[string]interface{}{
+		"asset_id":        asset.ID,
+		"asset_url":       fmt.Sprintf("/asset/%s", asset.UrlParam),
+		"asset_size":      asset.Size,
+		"asset_checksum":  asset.Checksum,
+		"asset_filename":  asset.Filename(),
+		"asset_version":   asset.Version,
+		"asset_mime_type": asset.MimeType,
+		"module_id":       asset.ModuleID,
+		"step_id":         asset.StepID,
+		"owner_id":        asset.OwnerID,
+	}
+}
+
+func describe_upload(asset *sm.Asset) map[string]interface{} {
+	upload := map[string]interface{}{
+		"upload_id":    asset.ID,
+		"size":         asset.Size,
+		"offset":       asset.UploadedSize,
+		"checksum":     asset.Checksum,
+		"complete":     asset.Complete,
+		"filename":     asset.Filename(),
+		"version":      asset.Version,
+		"on_duplicate": asset.DuplicatePolicy,
+	}
+
+	if asset.Complete {
+		upload["asset_url"] = fmt.Sprintf("/asset/%s", asset.UrlParam)
+		upload["mime_type"] = asset.MimeType
+	}
+	return upload
+}
+
+func write_upload_error(w http.ResponseWriter, err error, asset *sm.Asset, step_id int64) {
+	response := map[string]interface{}{}
+
+	// The module continues from the offset of the upload
+	if asset != nil {
+		response["upload"] = describe_upload(asset)
+	}
+
+	switch err {
+	case sm.ErrNotFound:
+		response["code"] = sm.CodeNotFound
+		response["description"] = fmt.Sprintf("A job or upload for job id=%d doesn't exist", step_id)
+	case sm.ErrJobIsCanceled, sm.ErrJobIsCompleted:
+		response["code"] = sm.CodeForbiddenAsset
+		response["description"] = "You can't upload an asset for a job that is completed or canceled"
+	case sm.ErrInvalidUploadSize, sm.ErrInvalidDuplicatePolicy:
+		response["code"] = sm.CodeInvalidInput
+		response["description"] = err.Error()
+	case sm.ErrAssetExists:
+		response["code"] = sm.CodeAssetExists
+		response["description"] = "An asset with the same name exists, upload it with on_duplicate version or replace"
+	case sm.ErrUploadOffsetMismatch, sm.ErrUploadTooLarge:
+		response["code"] = sm.CodeUploadOffsetMismatch
+		response["description"] = err.Error()
+	case sm.ErrUploadIncomplete:
+		response["code"] = sm.CodeUploadIncomplete
+		response["description"] = err.Error()
+	case sm.ErrChecksumMismatch:
+		response["code"] = sm.CodeChecksumMismatch
+		response["description"] = "The checksum doesn't match, upload the file again"
+	default:
 		InternalServerError(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, response)
+}
+
+// Parses the job and the upload ids of the url
+func (this *InternalController) uploadParams(w http.ResponseWriter,
+	r *http.Request) (*sm.Module, int64, int64, bool) {
+
+	module := this.check_api_key(w, r)
+	if module == nil {
+		return nil, 0, 0, false // invalid API key, check_api_key handled the response
+	}
+
+	step_id, err := strconv.ParseInt(chi.URLParam(r, "job_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid job id"})
+		return nil, 0, 0, false
+	}
+
+	asset_id, err := strconv.ParseInt(chi.URLParam(r, "upload_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid upload id"})
+		return nil, 0, 0, false
+	}
+
+	return module, step_id, asset_id, true
+}
+
+// CreateUpload starts a resumable upload, the chunks are sent
+// with PutUploadChunk and the asset is created by FinishUpload
+func (this *InternalController) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	module := this.check_api_key(w, r)
+	if module == nil {
+		return // invalid API key, check_api_key handled the response
+	}
+
+	step_id, err := strconv.ParseInt(chi.URLParam(r, "job_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid job id"})
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	filename, _ := data["filename"].(string)
+	size, _ := data["size"].(float64)
+	checksum, _ := data["checksum"].(string)
+	on_duplicate, _ := data["on_duplicate"].(string)
+
+	if len(filename) == 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "filename is required"})
+		return
+	}
+
+	policy, err := sm.ParseDuplicatePolicy(on_duplicate)
+	if err != nil {
+		write_upload_error(w, err, nil, step_id)
+		return
+	}
+
+	asset, err := this.asset_service.CreateUpload(
+		step_id, module, filename, int64(size), checksum, policy)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Upload the chunks from the offset",
+			"upload":      describe_upload(asset),
+		})
+	} else {
+		write_upload_error(w, err, nil, step_id)
+	}
+}
+
+func (this *InternalController) GetUpload(w http.ResponseWriter, r *http.Request) {
+	module, step_id, asset_id, ok := this.uploadParams(w, r)
+	if !ok {
+		return
+	}
+
+	asset, err := this.asset_service.GetUpload(step_id, module, asset_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Returning the progress of the upload",
+			"upload":      describe_upload(asset),
+		})
+	} else {
+		write_upload_error(w, err, nil, step_id)
+	}
+}
+
+// PutUploadChunk saves the body as the chunk that starts at the
+// Upload-Offset header, the offset is the uploaded size of the upload
+func (this *InternalController) PutUploadChunk(w http.ResponseWriter, r *http.Request) {
+	module, step_id, asset_id, ok := this.uploadParams(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+
+	if err != nil || r.ContentLength <= 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "The Upload-Offset and Content-Length headers are required"})
+		return
+	}
+
+	asset, err := this.asset_service.WriteChunk(
+		step_id, module, asset_id, offset, r.Body, r.ContentLength)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The chunk was saved",
+			"upload":      describe_upload(asset),
+		})
+	} else {
+		write_upload_error(w, err, asset, step_id)
+	}
+}
+
+func (this *InternalController) FinishUpload(w http.ResponseWriter, r *http.Request) {
+	module, step_id, asset_id, ok := this.uploadParams(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	checksum, _ := data["checksum"].(string)
+
+	asset, err := this.asset_service.FinishUpload(step_id, module, asset_id, checksum)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":           sm.OK,
+			"description":    "Asset was uploaded",
+			"asset_id":       asset.ID,
+			"asset_url":      fmt.Sprintf("/asset/%s", asset.UrlParam),
+			"asset_checksum": asset.Checksum,
+		})
+	} else {
+		write_upload_error(w, err, asset, step_id)
 	}
 }
 
@@ -580,22 +886,39 @@ func (this *InternalController) GetAssets(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The job_id is the id of the step, the assets belong to its job
+	job, err := this.job_repository.GetJobByStepID(job_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if job == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": fmt.Sprintf("A job with id=%d doesn't exist", job_id)})
+		return
+	}
+
 	assets := make([]*sm.Asset, 0)
-	err = this.asset_repository.GetAssetsForJob(job_id, &assets)
+	err = this.asset_repository.GetAssetsForJob(job.ID, &assets)
 
 	if err != nil {
 		InternalServerError(w, err)
 		return
 	}
 
+	// The links work for the module of the step until the job expires
+	expires := time.Now().Add(sm.ModuleAssetLinkExpiration * time.Second)
+	if job.ExpirationDate.Before(expires) {
+		expires = job.ExpirationDate
+	}
+
 	asset_json := make([]map[string]interface{}, len(assets))
 
 	for index, asset := range assets {
-		asset_json[index] = map[string]interface{}{
-			"asset_id":   asset.ID,
-			"asset_url":  fmt.Sprintf("/asset/%s", asset.UrlParam),
-			"asset_size": asset.Size,
-		}
+		asset_json[index] = describe_asset(asset)
+		asset_json[index]["download_url"] = this.asset_linker.Link(
+			fmt.Sprintf("/asset/%s", asset.UrlParam), sm.StepScope(job_id), expires)
 	}
 
 	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -605,6 +928,84 @@ func (this *InternalController) GetAssets(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// Writes the response of a download link that doesn't give access to the asset
+func write_asset_link_error(w http.ResponseWriter, err error) {
+	code := sm.CodeInvalidAssetLink
+	if err == sm.ErrAssetLinkExpired {
+		code = sm.CodeAssetLinkExpired
+	}
+
+	httpio.WriteJSON(w, http.StatusForbidden, map[string]interface{}{
+		"code":        code,
+		"description": err.Error(),
+	})
+}
+
+// Checks that the signed link of the request gives access to the asset.
+// The links of a job stop working when it is canceled or expires,
+// the links of a module only work while its step is active.
+func (this *InternalController) checkAssetLink(w http.ResponseWriter, r *http.Request, asset *sm.Asset) bool {
+	now := time.Now()
+
+	scope, err := this.asset_linker.Verify(asset.UrlParam, r.URL.Query(), now)
+	if err != nil {
+		write_asset_link_error(w, err)
+		return false
+	}
+
+	organisation_id := asset.OrganisationID
+
+	if !asset.IsOwnerAsset() {
+		job, err := this.job_repository.GetJobByID(asset.JobID)
+
+		if err != nil {
+			InternalServerError(w, err)
+			return false
+		} else if job == nil || job.IsCanceled || !now.Before(job.ExpirationDate) {
+			write_asset_link_error(w, sm.ErrAssetLinkExpired)
+			return false
+		}
+
+		organisation_id = job.OrganisationID
+	}
+
+	switch scope.Kind {
+	case sm.AssetScopeOrganisation:
+		if scope.ID == organisation_id {
+			return true
+		}
+	case sm.AssetScopeStep:
+		step_job, err := this.job_repository.GetJobByStepID(scope.ID)
+
+		if err != nil {
+			InternalServerError(w, err)
+			return false
+		} else if step_job == nil || step_job.IsCompleted || step_job.IsCanceled {
+			write_asset_link_error(w, sm.ErrAssetLinkExpired)
+			return false
+		}
+
+		if err = this.job_repository.GetJobSteps(step_job.ID, &step_job.Steps); err != nil {
+			InternalServerError(w, err)
+			return false
+		}
+
+		if step := step_job.StepByID(scope.ID); step == nil || step.Status != sm.StepActive {
+			write_asset_link_error(w, sm.ErrAssetLinkExpired)
+			return false
+		}
+
+		// The steps reach the assets of their job and the uploads of its organisation
+		if (asset.IsOwnerAsset() && step_job.OrganisationID == organisation_id) ||
+			step_job.ID == asset.JobID {
+			return true
+		}
+	}
+
+	write_asset_link_error(w, sm.ErrInvalidAssetLink)
+	return false
+}
+
 func (this *InternalController) DownloadAsset(w http.ResponseWriter, r *http.Request) {
 	param := chi.URLParam(r, "asset_param")
 
@@ -617,9 +1018,44 @@ func (this *InternalController) DownloadAsset(w http.ResponseWriter, r *http.Req
 			"code":        sm.CodeNotFound,
 			"description": "Asset doesn't exist",
 		})
-	} else {
+	} else if this.checkAssetLink(w, r, asset) {
+		content, object, err := this.asset_store.Get(asset.Key())
+
+		if err == sm.ErrAssetNotFound {
+			httpio.WriteJSON(w, http.StatusNotFound, map[string]interface{}{
+				"code":        sm.CodeNotFound,
+				"description": "Asset doesn't exist",
+			})
+			return
+		} else if err != nil {
+			InternalServerError(w, err)
+			return
+		}
+
+		defer content.Close()
+
 		w.Header().Set("Content-Disposition",
-			fmt.Sprintf("attachement; filename=%s", url.QueryEscape(filepath.Base(asset.Path))))
-		http.ServeFile(w, r, asset.Path)
+			fmt.Sprintf("attachement; filename=%s", url.QueryEscape(asset.Filename())))
+
+		// The older assets have no detected type, the extension decides
+		content_type := asset.MimeType
+		if content_type == "" {
+			content_type = mime.TypeByExtension(filepath.Ext(asset.Filename()))
+		}
+		if content_type != "" {
+			w.Header().Set("Content-Type", content_type)
+		}
+
+		// The files of the disk support ranges, the others are streamed
+		if seeker, ok := content.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, asset.Filename(), object.LastModified, seeker)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+
+		if _, err = io.Copy(w, content); err != nil {
+			log.Warnf("download of asset=%v failed: %v", asset.ID, err)
+		}
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type LoginLockController struct {
+	sessions        *gosession.SessionStore
+	lock_repository sm.LoginLockRepository
+	lock_service    sm.LoginLockService
+}
+
+func (this *LoginLockController) GetLocks(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	locks, err := this.lock_repository.GetLocked(time.Now())
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	locks_json := make([]map[string]interface{}, 0, len(locks))
+	for _, lock := range locks {
+		locks_json = append(locks_json, map[string]interface{}{
+			"key":          lock.Key,
+			"failures":     lock.Failures,
+			"last_failure": lock.LastFailure.Unix(),
+			"locked_until": lock.LockedUntil.Unix(),
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the locked usernames and addresses",
+		"locks":       locks_json})
+}
+
+// Unlocks the "username" or the "address" of the body
+func (this *LoginLockController) PostUnlock(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	var key string
+
+	if username, ok := data["username"].(string); ok && len(username) > 0 {
+		key = sm.UsernameLockKey(username)
+	} else if address, ok := data["address"].(string); ok && len(address) > 0 {
+		key = sm.AddressLockKey(address)
+	} else {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"username\" or \"address\" string parameter"})
+		return
+	}
+
+	admin, _ := session.Data["username"].(string)
+
+	err := this.lock_service.Unlock(key, admin)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The logins were unlocked"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "There are no failed logins to unlock"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *LoginLockController) GetAudit(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	limit, err := strconv.ParseInt(chi.URLParam(r, "limit"), 10, 64)
+	if err != nil {
+		limit = -1
+	}
+
+	before_id, err := strconv.ParseInt(chi.URLParam(r, "event_id"), 10, 64)
+	if err != nil {
+		before_id = -1
+	}
+
+	events, err := this.lock_repository.GetAuditEvents(limit, before_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	events_json := make([]map[string]interface{}, 0, len(events))
+	for _, event := range events {
+		events_json = append(events_json, map[string]interface{}{
+			"id":      event.ID,
+			"date":    event.Date.Unix(),
+			"event":   event.Event,
+			"key":     event.Key,
+			"address": event.Address,
+			"detail":  event.Detail,
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the login audit log",
+		"events":      events_json})
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"gitlab.arx.net/easytv/sm/db"
+	"gitlab.arx.net/easytv/sm/store"
 
 	"gitlab.arx.net/arx/gosession"
 	"gitlab.arx.net/arx/httpio"
@@ -63,13 +70,6 @@ func main() {
 	defer f.Close()
 	log.SetOutput(os.Stdout)
 
-	// setup sessions store
-
-	sessions := gosession.NewHeaderBasedSessionStore(
-		&gosession.MemcachedProvider{Connection: memcache.New("service_manager_cache:11211"), KeyPrefix: "sm"},
-		sm.EasyTVSessionHeader,
-		false)
-
 	// Setup DB connection pool
 	pool, err := db.Open()
 
@@ -77,6 +77,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	asset_store, err := store.Open()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	MAX_CONNECTION, err := strconv.Atoi(os.Getenv("MAX_CONNECTIONS"))
 	if err != nil {
 		MAX_CONNECTION = 0
@@ -92,6 +98,82 @@ func main() {
 		MAX_CONN_LIFETIME = 60
 	}
 
+	JOB_WORKERS, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil {
+		JOB_WORKERS = 4
+	}
+
+	JOB_LEASE, err := strconv.Atoi(os.Getenv("JOB_LEASE"))
+	if err != nil {
+		JOB_LEASE = 60 // seconds
+	} else if time.Duration(JOB_LEASE)*time.Second <= sm.ModuleRequestTimeout {
+		// The lease has to outlast the requests to the modules
+		log.Warnf("JOB_LEASE=%v is shorter than the module request timeout, using %v",
+			JOB_LEASE, 2*sm.ModuleRequestTimeout)
+		JOB_LEASE = int(2 * sm.ModuleRequestTimeout / time.Second)
+	}
+
+	WEBHOOK_WORKERS, err := strconv.Atoi(os.Getenv("WEBHOOK_WORKERS"))
+	if err != nil {
+		WEBHOOK_WORKERS = 2
+	}
+
+	// The key that signs the access tokens, shared by all the api instances
+	JWT_SECRET := []byte(os.Getenv("JWT_SECRET"))
+	if len(JWT_SECRET) == 0 {
+		if JWT_SECRET, err = ioutil.ReadFile(os.Getenv("JWT_SECRET_FILE")); err != nil {
+			log.Warn("JWT_SECRET is not set, the access tokens won't survive a restart")
+			JWT_SECRET = make([]byte, 32)
+			rand.Read(JWT_SECRET)
+		}
+	}
+
+	// The emails are written in MAIL_DIR unless MAILER is smtp
+	var mailer sm.Mailer
+	MAIL_FROM := os.Getenv("MAIL_FROM")
+	if MAIL_FROM == "" {
+		MAIL_FROM = "EasyTV <no-reply@easytv.local>"
+	}
+
+	if os.Getenv("MAILER") == "smtp" {
+		SMTP_PORT, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			SMTP_PORT = 587
+		}
+
+		SMTP_PASSWORD := os.Getenv("SMTP_PASSWORD")
+		if SMTP_PASSWORD == "" && os.Getenv("SMTP_PASSWORD_FILE") != "" {
+			password, err := ioutil.ReadFile(os.Getenv("SMTP_PASSWORD_FILE"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			SMTP_PASSWORD = strings.TrimSpace(string(password))
+		}
+
+		mailer = sm.NewSmtpMailer(os.Getenv("SMTP_HOST"), SMTP_PORT,
+			os.Getenv("SMTP_USERNAME"), SMTP_PASSWORD, MAIL_FROM)
+	} else {
+		mailer = sm.NewFileMailer(os.Getenv("MAIL_DIR"), MAIL_FROM)
+	}
+
+	// The page of the ui that the reset token is appended to
+	PASSWORD_RESET_URL := os.Getenv("PASSWORD_RESET_URL")
+	if PASSWORD_RESET_URL == "" {
+		PASSWORD_RESET_URL = "http://localhost/#!/reset_password?token="
+	}
+
+	// The url that the modules download the assets from,
+	// the download links are signed with the key of the access tokens
+	ASSET_BASE_URL := os.Getenv("ASSET_BASE_URL")
+
+	// The api is behind the reverse proxy of the ui
+	TRUST_PROXY := os.Getenv("TRUST_PROXY") == "true"
+
+	var WORKER_ID string
+	if WORKER_ID = os.Getenv("WORKER_ID"); WORKER_ID == "" {
+		WORKER_ID, _ = os.Hostname()
+	}
+
 	pool.DB.SetMaxOpenConns(MAX_CONNECTION)
 	pool.DB.SetMaxIdleConns(IDLE_CONNECTIONS)
 	pool.DB.SetConnMaxLifetime(time.Duration(MAX_CONN_LIFETIME) * time.Minute)
@@ -103,15 +185,71 @@ func main() {
 	owner_repository := &db.ContentOwnerRepository{Pool: pool}
 	asset_repository := &db.AssetRepository{Pool: pool}
 	admin_repository := &db.AdminRepository{Pool: pool}
+	queue_repository := &db.JobQueueRepository{Pool: pool}
+	template_repository := &db.JobTemplateRepository{Pool: pool}
+	webhook_repository := &db.WebhookRepository{Pool: pool}
+	role_repository := &db.RoleRepository{Pool: pool}
+	organisation_repository := &db.OrganisationRepository{Pool: pool}
+	client_repository := &db.OAuthClientRepository{Pool: pool}
+	token_repository := &db.PersonalAccessTokenRepository{Pool: pool}
+	lock_repository := &db.LoginLockRepository{Pool: pool}
+	totp_repository := &db.TotpRepository{Pool: pool}
+	reset_repository := &db.PasswordResetRepository{Pool: pool}
 
 	// services
 	task_service := sm.NewTaskService(task_repository, job_repository)
+	webhook_service := sm.NewWebhookService(webhook_repository, organisation_repository)
+	asset_linker := sm.NewAssetLinker(JWT_SECRET, ASSET_BASE_URL)
+	// The content owners download through the proxy of the ui, their links are relative
+	owner_asset_linker := sm.NewAssetLinker(JWT_SECRET, "")
 	job_service := sm.NewJobService(
-		job_repository, task_repository, module_repository, owner_repository)
+		job_repository, task_repository, module_repository, owner_repository,
+		asset_repository, webhook_service, asset_linker)
 	module_service := sm.NewModuleService(module_repository)
-	owner_service := sm.NewContentOwnerService(owner_repository)
+	owner_service := sm.NewContentOwnerService(owner_repository, organisation_repository)
 	admin_service := sm.NewAdminService(admin_repository)
-	asset_service := sm.NewAssetService(asset_repository, job_repository, task_repository)
+	asset_service := sm.NewAssetService(
+		asset_repository, job_repository, task_repository, asset_store)
+	template_service := sm.NewJobTemplateService(template_repository, task_repository, job_service)
+	role_service := sm.NewRoleService(role_repository, admin_repository, owner_repository)
+	organisation_service := sm.NewOrganisationService(
+		organisation_repository, owner_repository, owner_service, role_repository)
+	oauth_service := sm.NewOAuthService(client_repository, owner_repository, JWT_SECRET)
+	token_service := sm.NewPersonalAccessTokenService(token_repository, owner_repository, role_service)
+	lock_service := sm.NewLoginLockService(lock_repository)
+	totp_service := sm.NewTotpService(totp_repository)
+	reset_service := sm.NewPasswordResetService(
+		reset_repository, owner_repository, owner_service, token_repository, client_repository,
+		mailer, PASSWORD_RESET_URL)
+
+	// setup sessions store, the access tokens are accepted as sessions
+	sessions := gosession.NewHeaderBasedSessionStore(
+		&TokenSessionProvider{
+			provider: &gosession.MemcachedProvider{
+				Connection: memcache.New("service_manager_cache:11211"), KeyPrefix: "sm"},
+			oauth_service:    oauth_service,
+			token_service:    token_service,
+			owner_repository: owner_repository,
+		},
+		sm.EasyTVSessionHeader,
+		false)
+
+	// job workers
+	worker_pool := sm.NewJobWorkerPool(
+		queue_repository,
+		job_service,
+		WORKER_ID,
+		JOB_WORKERS,
+		time.Duration(JOB_LEASE)*time.Second)
+	worker_pool.Start()
+
+	// webhook deliveries
+	webhook_dispatcher := sm.NewWebhookDispatcher(
+		webhook_repository,
+		webhook_service,
+		WEBHOOK_WORKERS,
+		time.Minute)
+	webhook_dispatcher.Start()
 
 	// controllers
 
@@ -122,6 +260,40 @@ func main() {
 		job_repository:    job_repository,
 		owner_repository:  owner_repository,
 		job_service:       job_service,
+		template_service:  template_service,
+		asset_linker:      owner_asset_linker,
+	}
+
+	template_controller := TemplateController{
+		sessions:            sessions,
+		template_repository: template_repository,
+		template_service:    template_service,
+	}
+
+	webhook_controller := WebhookController{
+		sessions:                sessions,
+		organisation_repository: organisation_repository,
+		webhook_repository:      webhook_repository,
+		webhook_service:         webhook_service,
+	}
+
+	token_controller := AccessTokenController{
+		sessions:         sessions,
+		token_repository: token_repository,
+		token_service:    token_service,
+	}
+
+	oauth_controller := OAuthController{
+		sessions:          sessions,
+		client_repository: client_repository,
+		oauth_service:     oauth_service,
+	}
+
+	organisation_controller := OrganisationController{
+		sessions:                sessions,
+		organisation_repository: organisation_repository,
+		organisation_service:    organisation_service,
+		role_repository:         role_repository,
 	}
 
 	user_controller := UserController{
@@ -130,6 +302,29 @@ func main() {
 		admin_repository: admin_repository,
 		owner_service:    owner_service,
 		admin_service:    admin_service,
+		lock_service:     lock_service,
+		totp_service:     totp_service,
+		reset_service:    reset_service,
+		trust_proxy:      TRUST_PROXY,
+	}
+
+	asset_controller := AssetController{
+		sessions:         sessions,
+		asset_repository: asset_repository,
+		asset_service:    asset_service,
+		asset_linker:     owner_asset_linker,
+	}
+
+	totp_controller := TotpController{
+		sessions:        sessions,
+		totp_repository: totp_repository,
+		totp_service:    totp_service,
+	}
+
+	lock_controller := LoginLockController{
+		sessions:        sessions,
+		lock_repository: lock_repository,
+		lock_service:    lock_service,
 	}
 
 	adm_controller := AdminController{
@@ -138,8 +333,25 @@ func main() {
 		owner_repository:  owner_repository,
 		module_service:    module_service,
 		owner_service:     owner_service,
+		role_service:      role_service,
+		job_service:       job_service,
 	}
 
+	role_controller := RoleController{
+		sessions:         sessions,
+		role_repository:  role_repository,
+		role_service:     role_service,
+		admin_repository: admin_repository,
+		owner_repository: owner_repository,
+	}
+
+	// checks the permissions of the session roles
+	permissions := PermissionChecker{
+		sessions:     sessions,
+		role_service: role_service,
+	}
+	can := permissions.Require
+
 	internal_controller := InternalController{
 		module_repository: module_repository,
 		job_repository:    job_repository,
@@ -149,6 +361,8 @@ func main() {
 		task_service:      task_service,
 		job_service:       job_service,
 		asset_service:     asset_service,
+		asset_store:       asset_store,
+		asset_linker:      asset_linker,
 	}
 
 	// Register routes
@@ -168,13 +382,41 @@ func main() {
 	 *	Admin API
 	 */
 	router.Route("/adm", func(r chi.Router) {
-		r.Post("/service", adm_controller.CreateService)
-		r.Get("/service", adm_controller.GetServices)
-		r.Put("/service/{service_id}", adm_controller.SetAvailability)
-		r.Get("/service/{service_id}", adm_controller.GetService)
-		r.Post("/user/register", adm_controller.RegisterOwner)
-		r.Post("/srt", adm_controller.SrtCommand)
-		r.Get("/log", adm_controller.GetLog)
+		r.With(can(sm.PermServiceWrite)).Post("/service", adm_controller.CreateService)
+		r.With(can(sm.PermServiceRead)).Get("/service", adm_controller.GetServices)
+		r.With(can(sm.PermServiceWrite)).Put("/service/{service_id}", adm_controller.SetAvailability)
+		r.With(can(sm.PermServiceRead)).Get("/service/{service_id}", adm_controller.GetService)
+		r.With(can(sm.PermServiceRead)).Get("/service/{service_id}/keys", adm_controller.GetApiKeys)
+		r.With(can(sm.PermServiceWrite)).Post("/service/{service_id}/keys", adm_controller.PostApiKey)
+		r.With(can(sm.PermServiceWrite)).Post("/service/{service_id}/keys/rotate", adm_controller.RotateApiKeys)
+		r.With(can(sm.PermServiceWrite)).Delete("/service/{service_id}/keys/{key_id}", adm_controller.DeleteApiKey)
+		r.With(can(sm.PermOwnerWrite)).Post("/user/register", adm_controller.RegisterOwner)
+		r.With(can(sm.PermJobCancel)).Delete("/job/{job_id}", adm_controller.CancelJob)
+		r.With(can(sm.PermSrtExec)).Post("/srt", adm_controller.SrtCommand)
+		r.With(can(sm.PermLogRead)).Get("/log", adm_controller.GetLog)
+
+		r.With(can(sm.PermLoginManage)).Get("/login/lock", lock_controller.GetLocks)
+		r.With(can(sm.PermLoginManage)).Post("/login/unlock", lock_controller.PostUnlock)
+		r.With(can(sm.PermLogRead)).Get("/login/audit", lock_controller.GetAudit)
+		r.With(can(sm.PermLogRead)).Get("/login/audit/limit/{limit}", lock_controller.GetAudit)
+		r.With(can(sm.PermLogRead)).Get("/login/audit/limit/{limit}/before/{event_id}", lock_controller.GetAudit)
+
+		r.With(can(sm.PermOwnerRead)).Get("/owner", role_controller.GetOwners)
+		r.With(can(sm.PermOwnerRead)).Get("/organisation", organisation_controller.GetOrganisations)
+		r.With(can(sm.PermOwnerWrite)).Post("/organisation", organisation_controller.PostOrganisation)
+		r.With(can(sm.PermOwnerWrite)).Post("/organisation/{organisation_id}/invitation",
+			organisation_controller.PostAdminInvitation)
+		r.With(can(sm.PermRoleManage)).Put("/owner/{owner_id}/role", role_controller.PutOwnerRole)
+		r.With(can(sm.PermRoleManage)).Get("/user", role_controller.GetAdmins)
+		r.With(can(sm.PermRoleManage)).Put("/user/{admin_id}/role", role_controller.PutAdminRole)
+
+		r.Route("/role", func(r chi.Router) {
+			r.Use(can(sm.PermRoleManage))
+			r.Get("/", role_controller.GetRoles)
+			r.Post("/", role_controller.PostRole)
+			r.Put("/{role_id}", role_controller.PutRole)
+			r.Delete("/{role_id}", role_controller.DeleteRole)
+		})
 	})
 
 	/*
@@ -202,6 +444,11 @@ func main() {
 				r.Route("/asset", func(r chi.Router) {
 					r.Get("/", internal_controller.GetAssets)
 					r.Post("/", internal_controller.UploadAsset)
+
+					r.Post("/upload", internal_controller.CreateUpload)
+					r.Get("/upload/{upload_id}", internal_controller.GetUpload)
+					r.Put("/upload/{upload_id}", internal_controller.PutUploadChunk)
+					r.Post("/upload/{upload_id}/finish", internal_controller.FinishUpload)
 				})
 			})
 		})
@@ -212,26 +459,91 @@ func main() {
 	 *	Routes for the public api
 	 */
 	router.Route("/api", func(r chi.Router) {
+		r.Use(BearerTokenMiddleware)
+
+		r.Route("/oauth", func(r chi.Router) {
+			r.Post("/token", oauth_controller.Token)
+
+			r.With(can(sm.PermClientRead)).Get("/client", oauth_controller.GetClients)
+			r.With(can(sm.PermClientWrite)).Post("/client", oauth_controller.PostClient)
+			r.With(can(sm.PermClientWrite)).Delete("/client/{client_id}", oauth_controller.DeleteClient)
+		})
+
 		r.Route("/user", func(r chi.Router) {
 			r.HandleFunc("/login", user_controller.Login)
+			r.HandleFunc("/admin/login", user_controller.AdminLogin)
+			r.Post("/login/totp", user_controller.LoginTotp)
 			r.HandleFunc("/ping", user_controller.Ping)
 			r.HandleFunc("/logout", user_controller.Logout)
 			r.Post("/change_password", user_controller.ChangePassword)
+			r.Post("/forgot_password", user_controller.ForgotPassword)
+			r.Post("/reset_password", user_controller.ResetPassword)
+
+			r.Get("/tokens", token_controller.GetTokens)
+			r.Post("/tokens", token_controller.PostToken)
+			r.Delete("/tokens/{token_id}", token_controller.DeleteToken)
+
+			r.Get("/totp", totp_controller.GetTotp)
+			r.Post("/totp", totp_controller.PostTotp)
+			r.Post("/totp/confirm", totp_controller.PostTotpConfirm)
+			r.Post("/totp/recovery_codes", totp_controller.PostRecoveryCodes)
+			r.Delete("/totp", totp_controller.DeleteTotp)
+		})
+
+		r.Route("/organisation", func(r chi.Router) {
+			// The invited users don't have a session yet
+			r.Post("/join", organisation_controller.Join)
+
+			r.With(can(sm.PermMemberRead)).Get("/", organisation_controller.GetOrganisation)
+			r.With(can(sm.PermMemberRead)).Get("/invitation", organisation_controller.GetInvitations)
+			r.With(can(sm.PermMemberWrite)).Post("/invitation", organisation_controller.PostInvitation)
+			r.With(can(sm.PermMemberWrite)).Delete("/invitation/{invitation_id}", organisation_controller.DeleteInvitation)
+			r.With(can(sm.PermMemberWrite)).Put("/member/{owner_id}/role", organisation_controller.PutMemberRole)
+			r.With(can(sm.PermMemberWrite)).Delete("/member/{owner_id}", organisation_controller.DeleteMember)
 		})
 
 		r.Route("/service", func(r chi.Router) {
+			r.Use(can(sm.PermServiceRead))
 			r.Get("/", public_controller.GetServices)
 			r.Get("/{service_id}", public_controller.GetService)
 		})
 
 		r.Route("/job", func(r chi.Router) {
-			r.Get("/", public_controller.GetJobs)
-			r.Get("/limit/{limit}", public_controller.GetJobs)
-			r.Get("/limit/{limit}/before/{job_id}", public_controller.GetJobs)
+			r.With(can(sm.PermJobRead)).Get("/", public_controller.GetJobs)
+			r.With(can(sm.PermJobRead)).Get("/limit/{limit}", public_controller.GetJobs)
+			r.With(can(sm.PermJobRead)).Get("/limit/{limit}/before/{job_id}", public_controller.GetJobs)
+
+			r.With(can(sm.PermJobRead)).Get("/{job_id}", public_controller.GetJob)
+			r.With(can(sm.PermJobCreate)).Post("/", public_controller.PostJob)
+			r.With(can(sm.PermJobCancel)).Delete("/{job_id}", public_controller.CancelJob)
+		})
+
+		r.Route("/asset", func(r chi.Router) {
+			r.With(can(sm.PermAssetRead)).Get("/", asset_controller.GetAssets)
+			r.With(can(sm.PermAssetWrite)).Post("/", asset_controller.PostAsset)
+			r.With(can(sm.PermAssetRead)).Get("/{asset_id}", asset_controller.GetAsset)
+			r.With(can(sm.PermAssetWrite)).Delete("/{asset_id}", asset_controller.DeleteAsset)
 
-			r.Get("/{job_id}", public_controller.GetJob)
-			r.Post("/", public_controller.PostJob)
-			r.Delete("/{job_id}", public_controller.CancelJob)
+			r.With(can(sm.PermAssetWrite)).Post("/upload", asset_controller.CreateUpload)
+			r.With(can(sm.PermAssetWrite)).Put("/upload/{upload_id}", asset_controller.PutUploadChunk)
+			r.With(can(sm.PermAssetWrite)).Post("/upload/{upload_id}/finish", asset_controller.FinishUpload)
+		})
+
+		r.Route("/template", func(r chi.Router) {
+			r.With(can(sm.PermTemplateRead)).Get("/", template_controller.GetTemplates)
+			r.With(can(sm.PermTemplateWrite)).Post("/", template_controller.PostTemplate)
+			r.With(can(sm.PermTemplateRead)).Get("/{template_id}", template_controller.GetTemplate)
+			r.With(can(sm.PermTemplateWrite)).Put("/{template_id}", template_controller.PutTemplate)
+			r.With(can(sm.PermTemplateWrite)).Delete("/{template_id}", template_controller.DeleteTemplate)
+		})
+
+		r.Route("/webhook", func(r chi.Router) {
+			r.With(can(sm.PermWebhookRead)).Get("/", webhook_controller.GetWebhook)
+			r.With(can(sm.PermWebhookWrite)).Put("/", webhook_controller.PutWebhook)
+
+			r.With(can(sm.PermWebhookRead)).Get("/delivery", webhook_controller.GetDeliveries)
+			r.With(can(sm.PermWebhookRead)).Get("/delivery/limit/{limit}", webhook_controller.GetDeliveries)
+			r.With(can(sm.PermWebhookRead)).Get("/delivery/limit/{limit}/before/{delivery_id}", webhook_controller.GetDeliveries)
 		})
 	})
 
@@ -241,6 +553,39 @@ func main() {
 		PORT = "3000"
 	}
 
+	server := &http.Server{Addr: ":" + PORT, Handler: router}
+
+	// On deploy the requests and the steps in progress are finished
+	// before the server exits, the leases aren't left to expire
+	shut_down := make(chan struct{})
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		<-signals
+
+		log.Infof("Shutting down the server")
+
+		ctx, cancel := context.WithTimeout(context.Background(), sm.ModuleRequestTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Failed to finish the requests err=%v", err)
+		}
+		close(shut_down)
+	}()
+
 	log.Infof("Starting server at port %v", PORT)
-	http.ListenAndServe(":"+PORT, router)
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	<-shut_down
+
+	// The workers finish their steps and webhooks
+	worker_pool.Stop()
+	webhook_dispatcher.Stop()
+
+	log.Infof("Server stopped")
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type OAuthController struct {
+	sessions          *gosession.SessionStore
+	client_repository sm.OAuthClientRepository
+	oauth_service     sm.OAuthService
+}
+
+// The token endpoint responds with the errors of RFC 6749 so that
+// the oauth libraries of the clients understand them
+func write_oauth_error(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	httpio.WriteJSON(w, status, map[string]interface{}{
+		"error":             code,
+		"error_description": description})
+}
+
+func describe_oauth_client(client *sm.OAuthClient) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            client.ID,
+		"name":          client.Name,
+		"client_id":     client.ClientID,
+		"owner_id":      client.OwnerID,
+		"scopes":        client.Scopes,
+		"creation_date": client.CreationDate.Unix(),
+		"revoked":       client.Revoked,
+	}
+}
+
+// Token issues access tokens with the client credentials grant.
+// The credentials are read from basic auth or from the form.
+func (this *OAuthController) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		write_oauth_error(w, http.StatusBadRequest, "invalid_request", "The body should be form encoded")
+		return
+	}
+
+	if grant_type := r.PostForm.Get("grant_type"); grant_type != "client_credentials" {
+		write_oauth_error(w, http.StatusBadRequest, "unsupported_grant_type",
+			"Only the client_credentials grant is supported")
+		return
+	}
+
+	client_id, secret, basic_auth := r.BasicAuth()
+
+	if !basic_auth {
+		client_id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	token, claims, err := this.oauth_service.IssueToken(
+		client_id, secret, strings.Fields(r.PostForm.Get("scope")))
+
+	if err == sm.ErrInvalidClient {
+		if basic_auth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="easytv"`)
+		}
+		write_oauth_error(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	} else if err == sm.ErrInvalidScope {
+		write_oauth_error(w, http.StatusBadRequest, "invalid_scope",
+			"The client doesn't have the requested scope")
+		return
+	} else if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   claims.ExpiresAt - claims.IssuedAt,
+		"scope":        claims.Scope})
+}
+
+func (this *OAuthController) GetClients(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	clients, err := this.client_repository.GetForOrganisation(SessionOrganisationID(session))
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	clients_json := make([]map[string]interface{}, 0, len(clients))
+	for _, client := range clients {
+		clients_json = append(clients_json, describe_oauth_client(client))
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the oauth clients of the organisation",
+		"clients":     clients_json,
+		"scopes":      sm.OAuthScopes})
+}
+
+func (this *OAuthController) PostClient(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	name, _ := data["name"].(string)
+	values, _ := data["scopes"].([]interface{})
+
+	scopes := make([]string, 0, len(values))
+	for _, value := range values {
+		if scope, ok := value.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	client, secret, err := this.oauth_service.CreateClient(
+		SessionOrganisationID(session), user_id, name, scopes)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":          sm.OK,
+			"description":   "The client was created, the secret won't be shown again",
+			"client":        describe_oauth_client(client),
+			"client_secret": secret})
+	} else if err == sm.ErrOAuthClientNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"name\" string parameter"})
+	} else if err == sm.ErrInvalidScope {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidScope,
+			"description": "\"scopes\" should be a non empty array of the known scopes",
+			"scopes":      sm.OAuthScopes})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *OAuthController) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "client_id")
+	if !ok {
+		return
+	}
+
+	err := this.oauth_service.RevokeClient(SessionOrganisationID(session), id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The client was revoked, its tokens work until they expire"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The client was not found"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
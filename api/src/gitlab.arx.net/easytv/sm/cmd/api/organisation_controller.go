@@ -0,0 +1,384 @@
+package main
+
+import (
+	"net/http"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type OrganisationController struct {
+	sessions                *gosession.SessionStore
+	organisation_repository sm.OrganisationRepository
+	organisation_service    sm.OrganisationService
+	role_repository         sm.RoleRepository
+}
+
+func write_organisation_error(w http.ResponseWriter, err error) {
+	if err == sm.ErrOrganisationNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"name\" string parameter"})
+	} else if err == sm.ErrOrganisationNameExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeOrganisationNameExists,
+			"description": "An organisation with this name already exists"})
+	} else if err == sm.ErrOwnerNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"name\" string parameter"})
+	} else if err == sm.ErrOwnerUsernameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"username\" string parameter"})
+	} else if err == sm.ErrOwnerEmailTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"email\" string parameter"})
+	} else if err == sm.ErrOwnerNameExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeContentOwnerNameExists,
+			"description": "A content owner with this name already exists"})
+	} else if err == sm.ErrOwnerUsernameExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeContentOwnerUsernameExists,
+			"description": "A content owner with this username already exists"})
+	} else if err == sm.ErrOwnerEmailExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeContentOwnerEmailExists,
+			"description": "A content owner with this email already exists"})
+	} else if err == sm.ErrPasswordTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodePasswordIsTooShort,
+			"description": "The password should have at least 8 characters"})
+	} else if err == sm.ErrInvalidInvitation {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidInvitation,
+			"description": "The invitation doesn't exist or has expired"})
+	} else if err == sm.ErrInvalidMemberRole {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidMemberRole,
+			"description": "The role grants permissions that members can't have"})
+	} else if err == sm.ErrNotAMember {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotAMember,
+			"description": "The user isn't a member of the organisation"})
+	} else if err == sm.ErrLastMember {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeLastMember,
+			"description": "The last member can't leave the organisation"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The organisation, the member or the role was not found"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func describe_invitation(invitation *sm.OrganisationInvitation,
+	roles []*sm.Role) map[string]interface{} {
+
+	var accept_date *int64
+	if invitation.AcceptDate != nil {
+		accept_date = new(int64)
+		*accept_date = invitation.AcceptDate.Unix()
+	}
+
+	return map[string]interface{}{
+		"id":              invitation.ID,
+		"email":           invitation.Email,
+		"role":            role_name(roles, invitation.RoleID, sm.RoleContentOwner),
+		"invited_by":      invitation.InvitedBy,
+		"creation_date":   invitation.CreationDate.Unix(),
+		"expiration_date": invitation.ExpirationDate.Unix(),
+		"accept_date":     accept_date,
+		"revoked":         invitation.Revoked,
+	}
+}
+
+func (this *OrganisationController) GetOrganisation(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	organisation, err := this.organisation_repository.GetByID(SessionOrganisationID(session))
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if organisation == nil {
+		write_organisation_error(w, sm.ErrNotFound)
+		return
+	}
+
+	members, err := this.organisation_repository.GetMembers(organisation.ID)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	roles, err := this.role_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	members_json := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		members_json = append(members_json, map[string]interface{}{
+			"id":       member.ID,
+			"username": member.Username,
+			"name":     member.Name,
+			"email":    member.Email,
+			"role":     role_name(roles, member.RoleID, sm.RoleContentOwner),
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"organisation": map[string]interface{}{
+			"id":            organisation.ID,
+			"name":          organisation.Name,
+			"creation_date": organisation.CreationDate.Unix(),
+			"members":       members_json,
+		}})
+}
+
+func (this *OrganisationController) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	invitations, err := this.organisation_repository.GetInvitations(SessionOrganisationID(session))
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	roles, err := this.role_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	invitations_json := make([]map[string]interface{}, 0, len(invitations))
+	for _, invitation := range invitations {
+		invitations_json = append(invitations_json, describe_invitation(invitation, roles))
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the invitations of the organisation",
+		"invitations": invitations_json})
+}
+
+// Responds with the token of a new invitation
+func (this *OrganisationController) invite(w http.ResponseWriter, r *http.Request,
+	organisation_id int64, invited_by *int64) {
+
+	data, _ := httpio.ReadJSON(r)
+
+	email, _ := data["email"].(string)
+	role, _ := data["role"].(string)
+
+	invitation, token, err := this.organisation_service.Invite(
+		organisation_id, invited_by, email, role)
+
+	if err == nil {
+		// The token isn't stored, the inviter passes it to the invited user
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":            sm.OK,
+			"description":     "The invitation was created",
+			"invitation_id":   invitation.ID,
+			"token":           token,
+			"expiration_date": invitation.ExpirationDate.Unix()})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+func (this *OrganisationController) PostInvitation(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	user_id, _ := session.Data["user_id"].(int64)
+
+	this.invite(w, r, SessionOrganisationID(session), &user_id)
+}
+
+func (this *OrganisationController) DeleteInvitation(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	invitation_id, ok := read_url_id(w, r, "invitation_id")
+	if !ok {
+		return
+	}
+
+	err := this.organisation_service.RevokeInvitation(SessionOrganisationID(session), invitation_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The invitation was revoked"})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+func (this *OrganisationController) PutMemberRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	owner_id, ok := read_url_id(w, r, "owner_id")
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	// An empty role restores the default one
+	role, _ := data["role"].(string)
+
+	err := this.organisation_service.SetMemberRole(SessionOrganisationID(session), owner_id, role)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role of the member was set, it applies on the next login"})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+func (this *OrganisationController) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	owner_id, ok := read_url_id(w, r, "owner_id")
+	if !ok {
+		return
+	}
+
+	err := this.organisation_service.RemoveMember(SessionOrganisationID(session), owner_id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The member was removed"})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+// Join creates the account of an invited user, it doesn't need a session
+func (this *OrganisationController) Join(w http.ResponseWriter, r *http.Request) {
+	data, _ := httpio.ReadJSON(r)
+
+	token, _ := data["token"].(string)
+	name, _ := data["name"].(string)
+	username, _ := data["username"].(string)
+	password, _ := data["password"].(string)
+
+	owner, err := this.organisation_service.AcceptInvitation(token, name, username, password)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":             sm.OK,
+			"description":      "Welcome to the organisation, you can login now",
+			"content_owner_id": owner.ID})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+func (this *OrganisationController) GetOrganisations(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	organisations, err := this.organisation_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	organisations_json := make([]map[string]interface{}, 0, len(organisations))
+	for _, organisation := range organisations {
+		organisations_json = append(organisations_json, map[string]interface{}{
+			"id":            organisation.ID,
+			"name":          organisation.Name,
+			"creation_date": organisation.CreationDate.Unix(),
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":          sm.OK,
+		"description":   "Returning the list of organisations",
+		"organisations": organisations_json})
+}
+
+func (this *OrganisationController) PostOrganisation(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	name, _ := data["name"].(string)
+
+	organisation, err := this.organisation_service.CreateOrganisation(name)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":            sm.OK,
+			"description":     "The organisation was created",
+			"organisation_id": organisation.ID})
+	} else {
+		write_organisation_error(w, err)
+	}
+}
+
+// Invites a user to an organisation as an admin
+func (this *OrganisationController) PostAdminInvitation(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	organisation_id, ok := read_url_id(w, r, "organisation_id")
+	if !ok {
+		return
+	}
+
+	this.invite(w, r, organisation_id, nil)
+}
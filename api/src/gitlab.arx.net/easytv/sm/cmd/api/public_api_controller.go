@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-//	"time"
+	"time"
 
 	"github.com/go-chi/chi"
 	"gitlab.arx.net/arx/gosession"
@@ -19,6 +19,26 @@ type PublicApiController struct {
 	job_repository    sm.JobRepository
 	owner_repository  sm.ContentOwnerRepository
 	job_service       sm.JobService
+	template_service  sm.JobTemplateService
+	asset_linker      *sm.AssetLinker
+}
+
+// Returns the signed links of the asset outputs of a job,
+// they are revoked when the job expires
+func (this *PublicApiController) outputDownloadUrls(job *sm.Job, step *sm.JobStep) map[string]interface{} {
+	expires := time.Now().Add(sm.AssetLinkExpiration * time.Second)
+	if job.ExpirationDate.Before(expires) {
+		expires = job.ExpirationDate
+	}
+
+	download_urls := make(map[string]interface{})
+	for name, param := range step.Output {
+		if url, ok := param.Value.(string); ok && param.DataType == sm.AssetParam {
+			download_urls[name] = this.asset_linker.Link(
+				url, sm.OrganisationScope(job.OrganisationID), expires)
+		}
+	}
+	return download_urls
 }
 
 func (this *PublicApiController) GetServices(w http.ResponseWriter, r *http.Request) {
@@ -55,25 +75,18 @@ func (this *PublicApiController) GetServices(w http.ResponseWriter, r *http.Requ
 		tasks_json_array := make([]map[string]interface{}, 0)
 
 		for _, task := range tasks {
-			input := make(map[string]string)
-
-			for name, param_type := range task.Input {
-				input[name] = sm.ParamTypeStr(param_type)
-			}
-
-			output := make(map[string]string)
-
-			for name, param_type := range task.Output {
-				output[name] = sm.ParamTypeStr(param_type)
-			}
+			input := sm.DescribeParams(task.Input)
+			output := sm.DescribeParams(task.Output)
 
 			tasks_json_array = append(tasks_json_array, map[string]interface{}{
-				"name":        task.Name,
-				"id":          task.ID,
-				"description": task.Description,
-				"enabled":     task.Enabled,
-				"input":       input,
-				"output":      output})
+				"name":          task.Name,
+				"id":            task.ID,
+				"description":   task.Description,
+				"enabled":       task.Enabled,
+				"input":         input,
+				"output":        output,
+				"input_schema":  task.InputSchema,
+				"output_schema": task.OutputSchema})
 		}
 
 		modules_json_array = append(modules_json_array, map[string]interface{}{
@@ -133,25 +146,18 @@ func (this *PublicApiController) GetService(w http.ResponseWriter, r *http.Reque
 	tasks_json_array := make([]map[string]interface{}, 0)
 
 	for _, task := range tasks {
-		input := make(map[string]string)
-
-		for name, param_type := range task.Input {
-			input[name] = sm.ParamTypeStr(param_type)
-		}
-
-		output := make(map[string]string)
-
-		for name, param_type := range task.Output {
-			output[name] = sm.ParamTypeStr(param_type)
-		}
+		input := sm.DescribeParams(task.Input)
+		output := sm.DescribeParams(task.Output)
 
 		tasks_json_array = append(tasks_json_array, map[string]interface{}{
-			"name":        task.Name,
-			"id":          task.ID,
-			"description": task.Description,
-			"enabled":     task.Enabled,
-			"input":       input,
-			"output":      output})
+			"name":          task.Name,
+			"id":            task.ID,
+			"description":   task.Description,
+			"enabled":       task.Enabled,
+			"input":         input,
+			"output":        output,
+			"input_schema":  task.InputSchema,
+			"output_schema": task.OutputSchema})
 	}
 
 	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -187,8 +193,8 @@ func (this *PublicApiController) GetJobs(w http.ResponseWriter, r *http.Request)
 		before_job_id = -1
 	}
 
-	uid, _ := session.Data["user_id"].(int64)
-	jobs, err := this.job_repository.GetJobsForContentOwner(uid, limit, before_job_id)
+	organisation_id := SessionOrganisationID(session)
+	jobs, err := this.job_repository.GetJobsForOrganisation(organisation_id, limit, before_job_id)
 
 	if err != nil {
 		InternalServerError(w, err)
@@ -205,8 +211,9 @@ func (this *PublicApiController) GetJobs(w http.ResponseWriter, r *http.Request)
 		}
 
 		var completion_date *int64
-		var output map[string]interface{}
+		var output, download_urls map[string]interface{}
 		var current_step *int
+		current_steps := make([]int, 0)
 
 		if job.IsCompleted {
 			completion_date = new(int64)
@@ -226,10 +233,17 @@ func (this *PublicApiController) GetJobs(w http.ResponseWriter, r *http.Request)
 				for name, param := range last_step.Output {
 					output[name] = param.Value
 				}
+				download_urls = this.outputDownloadUrls(job, last_step)
 			}
 
 		} else {
-			current_step = &job.CurrentStep
+			for _, step := range job.ActiveSteps() {
+				current_steps = append(current_steps, step.Order)
+			}
+
+			if len(current_steps) > 0 {
+				current_step = &current_steps[0]
+			}
 		}
 
 		tasks := make([]map[string]interface{}, len(job.Steps))
@@ -241,8 +255,11 @@ func (this *PublicApiController) GetJobs(w http.ResponseWriter, r *http.Request)
 				return
 			}
 			tasks[index] = map[string]interface{}{
-				"task_id":   task.ID,
-				"task_name": task.Name,
+				"task_id":      task.ID,
+				"task_version": step.TaskVersion,
+				"task_name":    task.Name,
+				"depends_on":   step.DependsOn,
+				"status":       sm.StepStatusStr(step.Status),
 			}
 		}
 
@@ -257,7 +274,9 @@ func (this *PublicApiController) GetJobs(w http.ResponseWriter, r *http.Request)
 			"expiration_date":  job.ExpirationDate.Unix(),
 			"tasks":            tasks,
 			"current_task":     current_step,
-			"output":           output})
+			"current_tasks":    current_steps,
+			"output":           output,
+			"download_urls":    download_urls})
 	}
 
 	var next_url *string
@@ -298,12 +317,12 @@ func (this *PublicApiController) GetJob(w http.ResponseWriter, r *http.Request)
 
 	job, err := this.job_repository.GetJobByID(job_id)
 
-	uid, _ := session.Data["user_id"].(int64)
+	organisation_id := SessionOrganisationID(session)
 
 	if err != nil {
 		InternalServerError(w, err)
 		return
-	} else if job == nil || job.Owner.ID != uid {
+	} else if job == nil || job.OrganisationID != organisation_id {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeNotFound,
 			"description": fmt.Sprintf("A job with id=%d doesn't exist", job_id)})
@@ -317,8 +336,9 @@ func (this *PublicApiController) GetJob(w http.ResponseWriter, r *http.Request)
 	}
 
 	var completion_date *int64
-	var output map[string]interface{}
+	var output, download_urls map[string]interface{}
 	var current_step *int
+	current_steps := make([]int, 0)
 
 	if job.IsCompleted {
 		completion_date = new(int64)
@@ -338,9 +358,16 @@ func (this *PublicApiController) GetJob(w http.ResponseWriter, r *http.Request)
 			for name, param := range last_step.Output {
 				output[name] = param.Value
 			}
+			download_urls = this.outputDownloadUrls(job, last_step)
 		}
 	} else {
-		current_step = &job.CurrentStep
+		for _, step := range job.ActiveSteps() {
+			current_steps = append(current_steps, step.Order)
+		}
+
+		if len(current_steps) > 0 {
+			current_step = &current_steps[0]
+		}
 	}
 
 	tasks := make([]map[string]interface{}, len(job.Steps))
@@ -351,9 +378,30 @@ func (this *PublicApiController) GetJob(w http.ResponseWriter, r *http.Request)
 			InternalServerError(w, err)
 			return
 		}
+
+		attempts, err := this.job_repository.GetStepAttempts(step.ID)
+		if err != nil {
+			InternalServerError(w, err)
+			return
+		}
+
+		attempts_json := make([]map[string]interface{}, len(attempts))
+		for i, attempt := range attempts {
+			attempts_json[i] = map[string]interface{}{
+				"attempt":     attempt.Attempt,
+				"date":        attempt.Date.Unix(),
+				"status_code": attempt.StatusCode,
+				"error":       attempt.Error,
+			}
+		}
+
 		tasks[index] = map[string]interface{}{
-			"task_id":   task.ID,
-			"task_name": task.Name,
+			"task_id":      task.ID,
+			"task_version": step.TaskVersion,
+			"task_name":    task.Name,
+			"depends_on":   step.DependsOn,
+			"status":       sm.StepStatusStr(step.Status),
+			"attempts":     attempts_json,
 		}
 	}
 
@@ -371,7 +419,9 @@ func (this *PublicApiController) GetJob(w http.ResponseWriter, r *http.Request)
 			"expiration_date":  job.ExpirationDate.Unix(),
 			"tasks":            tasks,
 			"current_task":     current_step,
-			"output":           output}})
+			"current_tasks":    current_steps,
+			"output":           output,
+			"download_urls":    download_urls}})
 }
 
 func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request) {
@@ -389,21 +439,27 @@ func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request)
 	data, _ := httpio.ReadJSON(r)
 
 	user_id, _ := session.Data["user_id"].(int64)
+	organisation_id := SessionOrganisationID(session)
 	publication_date, _ := data["publication_date"].(float64)
 	expiration_date, _ := data["expiration_date"].(float64)
-	tasks_i, ok := data["tasks"].([]interface{})
+	callback_url, _ := data["callback_url"].(string)
 
-	if !ok {
-		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"code":        sm.CodeInvalidInput,
-			"description": "Missing valid \"tasks\" array",
-		})
-		return
-	}
+	var job *sm.Job
+
+	if template_id, ok := data["template_id"].(float64); ok {
+		// The tasks are taken from the template
+		variables, _ := data["variables"].(map[string]interface{})
+
+		job, err = this.template_service.CreateJob(organisation_id,
+			user_id,
+			int64(template_id),
+			int64(publication_date),
+			int64(expiration_date),
+			callback_url,
+			variables)
+	} else {
+		tasks, ok := read_tasks(data)
 
-	tasks := make([]map[string]interface{}, len(tasks_i))
-	for i, task := range tasks_i {
-		tasks[i], ok = task.(map[string]interface{})
 		if !ok {
 			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 				"code":        sm.CodeInvalidInput,
@@ -411,9 +467,14 @@ func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request)
 			})
 			return
 		}
-	}
 
-	job, err := this.job_service.CreateJob(user_id, int64(publication_date), int64(expiration_date), tasks)
+		job, err = this.job_service.CreateJob(organisation_id,
+			user_id,
+			int64(publication_date),
+			int64(expiration_date),
+			callback_url,
+			tasks)
+	}
 
 	if err == nil {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -421,6 +482,16 @@ func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request)
 			"description": "Job created",
 			"job_id":      job.ID,
 		})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The template doesn't exist",
+		})
+	} else if e, ok := err.(*sm.ErrMissingTemplateVariable); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingTemplateVariable,
+			"description": e.Error(),
+		})
 	} else if err == sm.ErrInvalidPublicationDate {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeMissingInput,
@@ -436,6 +507,16 @@ func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request)
 			"code":        sm.CodeMissingInput,
 			"description": "No tasks where given",
 		})
+	} else if err == sm.ErrTooManyTasks {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidInput,
+			"description": err.Error(),
+		})
+	} else if err == sm.ErrInvalidCallbackUrl {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidCallbackUrl,
+			"description": "Invalid \"callback_url\", it should be an http(s) url of a public host",
+		})
 	} else if err == sm.ErrMissingTaskID {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.CodeMissingInput,
@@ -471,6 +552,16 @@ func (this *PublicApiController) PostJob(w http.ResponseWriter, r *http.Request)
 			"code":        sm.CodeLinkedParameterNotTheSameType,
 			"description": e.Error(),
 		})
+	} else if e, ok := err.(*sm.ErrInvalidDependency); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidDependency,
+			"description": e.Error(),
+		})
+	} else if e, ok := err.(*sm.ErrInvalidLinkedStep); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidLinkedStep,
+			"description": e.Error(),
+		})
 	} else {
 		InternalServerError(w, err)
 	}
@@ -496,9 +587,9 @@ func (this *PublicApiController) CancelJob(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user_id, _ := session.Data["user_id"].(int64)
+	organisation_id := SessionOrganisationID(session)
 
-	err = this.job_service.CancelJobAsOwner(user_id, job_id)
+	err = this.job_service.CancelJobAsOwner(organisation_id, job_id)
 	if err == nil {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"code":        sm.OK,
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+// PermissionChecker allows the requests of the sessions
+// whose role grants a permission
+type PermissionChecker struct {
+	sessions     *gosession.SessionStore
+	role_service sm.RoleService
+}
+
+// Require returns a middleware that responds with CodeForbidden
+// when the role of the session doesn't grant the permission,
+// or when the session is an access token without the permission in its scope
+func (this *PermissionChecker) Require(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, _ := this.sessions.Get(r, w)
+
+			if !VerifySession(session, w) {
+				return
+			}
+
+			user_role, _ := session.Data["role"].(int)
+			role_id, _ := session.Data["role_id"].(int64)
+
+			role, err := this.role_service.GetSessionRole(user_role, role_id)
+
+			if err != nil {
+				InternalServerError(w, err)
+				return
+			} else if role == nil || !role.Allows(permission) {
+				httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+					"code":        sm.CodeForbidden,
+					"description": fmt.Sprintf("Permission denied, \"%v\" is required", permission)})
+				return
+			}
+
+			if scopes, ok := session.Data["scopes"].([]string); ok && !sm.HasScope(scopes, permission) {
+				httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+					"code":        sm.CodeForbidden,
+					"description": fmt.Sprintf("Permission denied, the token doesn't have the \"%v\" scope", permission)})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
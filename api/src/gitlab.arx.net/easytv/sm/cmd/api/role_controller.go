@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type RoleController struct {
+	sessions         *gosession.SessionStore
+	role_repository  sm.RoleRepository
+	role_service     sm.RoleService
+	admin_repository sm.AdminRepository
+	owner_repository sm.ContentOwnerRepository
+}
+
+func describe_role(role *sm.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          role.ID,
+		"name":        role.Name,
+		"description": role.Description,
+		"permissions": role.Permissions,
+		"built_in":    role.BuiltIn,
+	}
+}
+
+// The name of the role with the id, the default role for nil
+func role_name(roles []*sm.Role, role_id *int64, user_role int) string {
+	if role_id == nil {
+		return sm.DefaultRoleName(user_role)
+	}
+	for _, role := range roles {
+		if role.ID == *role_id {
+			return role.Name
+		}
+	}
+	return ""
+}
+
+// Reads the "permissions" array of strings
+func read_permissions(w http.ResponseWriter, data map[string]interface{}) ([]string, bool) {
+	values, ok := data["permissions"].([]interface{})
+
+	if !ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"permissions\" array parameter"})
+		return nil, false
+	}
+
+	permissions := make([]string, 0, len(values))
+
+	for _, value := range values {
+		permission, ok := value.(string)
+
+		if !ok {
+			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"code":        sm.CodeMissingInput,
+				"description": "\"permissions\" should contain strings"})
+			return nil, false
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, true
+}
+
+func write_role_error(w http.ResponseWriter, err error) {
+	if err == sm.ErrRoleNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid \"name\" string parameter"})
+	} else if err == sm.ErrRoleNameExists {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeRoleNameExists,
+			"description": "A role with this name already exists"})
+	} else if err == sm.ErrInvalidPermission {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidPermission,
+			"description": "Unknown permission",
+			"permissions": sm.Permissions})
+	} else if err == sm.ErrRoleIsBuiltIn {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeRoleIsBuiltIn,
+			"description": "The built in roles can't change"})
+	} else if err == sm.ErrRoleIsInUse {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeRoleIsInUse,
+			"description": "The role is assigned to users"})
+	} else if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The role or the user was not found"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func read_url_id(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": fmt.Sprintf("Missing valid \"%s\" parameter", name)})
+		return 0, false
+	}
+	return id, true
+}
+
+func (this *RoleController) GetRoles(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	roles, err := this.role_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(roles))
+	for _, role := range roles {
+		result = append(result, describe_role(role))
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the list of roles",
+		"roles":       result,
+		"permissions": sm.Permissions})
+}
+
+func (this *RoleController) PostRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	name, _ := data["name"].(string)
+	description, _ := data["description"].(string)
+
+	permissions, ok := read_permissions(w, data)
+	if !ok {
+		return
+	}
+
+	role, err := this.role_service.CreateRole(name, description, permissions)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role was created",
+			"role":        describe_role(role)})
+	} else {
+		write_role_error(w, err)
+	}
+}
+
+func (this *RoleController) PutRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "role_id")
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	description, _ := data["description"].(string)
+
+	permissions, ok := read_permissions(w, data)
+	if !ok {
+		return
+	}
+
+	err := this.role_service.UpdateRole(id, description, permissions)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role was updated"})
+	} else {
+		write_role_error(w, err)
+	}
+}
+
+func (this *RoleController) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "role_id")
+	if !ok {
+		return
+	}
+
+	err := this.role_service.DeleteRole(id)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role was deleted"})
+	} else {
+		write_role_error(w, err)
+	}
+}
+
+func (this *RoleController) GetAdmins(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	admins, err := this.admin_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	roles, err := this.role_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(admins))
+	for _, admin := range admins {
+		result = append(result, map[string]interface{}{
+			"id":       admin.ID,
+			"username": admin.Username,
+			"role":     role_name(roles, admin.RoleID, sm.RoleAdmin),
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Returning the list of admins",
+		"users":       result})
+}
+
+func (this *RoleController) GetOwners(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	owners, err := this.owner_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	roles, err := this.role_repository.GetAll()
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(owners))
+	for _, owner := range owners {
+		result = append(result, map[string]interface{}{
+			"id":              owner.ID,
+			"username":        owner.Username,
+			"name":            owner.Name,
+			"email":           owner.Email,
+			"organisation_id": owner.OrganisationID,
+			"role":            role_name(roles, owner.RoleID, sm.RoleContentOwner),
+		})
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":           sm.OK,
+		"description":    "Returning the list of content owners",
+		"content_owners": result})
+}
+
+func (this *RoleController) PutAdminRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "admin_id")
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	// An empty role restores the default one
+	role, _ := data["role"].(string)
+
+	err := this.role_service.SetAdminRole(id, role)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role of the admin was set, it applies on the next login"})
+	} else {
+		write_role_error(w, err)
+	}
+}
+
+func (this *RoleController) PutOwnerRole(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySessionWithRole(session, w, sm.RoleAdmin) {
+		return
+	}
+
+	id, ok := read_url_id(w, r, "owner_id")
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	// An empty role restores the default one
+	role, _ := data["role"].(string)
+
+	err := this.role_service.SetOwnerRole(id, role)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "The role of the content owner was set, it applies on the next login"})
+	} else {
+		write_role_error(w, err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type TemplateController struct {
+	sessions            *gosession.SessionStore
+	template_repository sm.JobTemplateRepository
+	template_service    sm.JobTemplateService
+}
+
+func template_json(template *sm.JobTemplate) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            template.ID,
+		"name":          template.Name,
+		"description":   template.Description,
+		"creation_date": template.CreationDate.Unix(),
+		"tasks":         template.Tasks,
+		"variables":     template.Variables(),
+	}
+}
+
+// Reads the "tasks" array of a job or a template from the request
+func read_tasks(data map[string]interface{}) ([]map[string]interface{}, bool) {
+	tasks_i, ok := data["tasks"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	tasks := make([]map[string]interface{}, len(tasks_i))
+	for i, task := range tasks_i {
+		if tasks[i], ok = task.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	return tasks, true
+}
+
+func write_template_error(w http.ResponseWriter, err error) {
+	if err == sm.ErrNotFound {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The template doesn't exist"})
+	} else if err == sm.ErrTemplateNameTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "A valid \"name\" string parameter is missing"})
+	} else if err == sm.ErrEmptyTasks {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "No tasks where given"})
+	} else if err == sm.ErrTooManyTasks {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidInput,
+			"description": err.Error()})
+	} else if err == sm.ErrMissingTaskID {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid task object"})
+	} else if e, ok := err.(*sm.ErrTaskNotFound); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": fmt.Sprintf("Task (%v) doesn't exist", e.TaskID)})
+	} else if e, ok := err.(*sm.ErrInvalidTemplate); ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidTemplate,
+			"description": e.Message})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+func (this *TemplateController) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	organisation_id := SessionOrganisationID(session)
+
+	templates, err := this.template_repository.GetTemplatesForOrganisation(organisation_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	templates_json := make([]map[string]interface{}, len(templates))
+	for i, template := range templates {
+		templates_json[i] = template_json(template)
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"templates":   templates_json})
+}
+
+func (this *TemplateController) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	template_id, err := strconv.ParseInt(chi.URLParam(r, "template_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid template id"})
+		return
+	}
+
+	organisation_id := SessionOrganisationID(session)
+
+	template, err := this.template_repository.GetTemplate(template_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if template == nil || template.OrganisationID != organisation_id {
+		write_template_error(w, sm.ErrNotFound)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"template":    template_json(template)})
+}
+
+func (this *TemplateController) PostTemplate(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	organisation_id := SessionOrganisationID(session)
+	name, _ := data["name"].(string)
+	description, _ := data["description"].(string)
+	tasks, ok := read_tasks(data)
+
+	if !ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidInput,
+			"description": "Missing valid \"tasks\" array"})
+		return
+	}
+
+	template, err := this.template_service.CreateTemplate(organisation_id, name, description, tasks)
+
+	if err != nil {
+		write_template_error(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Template created",
+		"template_id": template.ID})
+}
+
+func (this *TemplateController) PutTemplate(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	template_id, err := strconv.ParseInt(chi.URLParam(r, "template_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid template id"})
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	organisation_id := SessionOrganisationID(session)
+	name, _ := data["name"].(string)
+	description, _ := data["description"].(string)
+	tasks, ok := read_tasks(data)
+
+	if !ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidInput,
+			"description": "Missing valid \"tasks\" array"})
+		return
+	}
+
+	template, err := this.template_service.UpdateTemplate(
+		organisation_id, template_id, name, description, tasks)
+
+	if err != nil {
+		write_template_error(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Template updated",
+		"template":    template_json(template)})
+}
+
+func (this *TemplateController) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	template_id, err := strconv.ParseInt(chi.URLParam(r, "template_id"), 10, 64)
+	if err != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "Missing valid template id"})
+		return
+	}
+
+	organisation_id := SessionOrganisationID(session)
+
+	if err = this.template_service.DeleteTemplate(organisation_id, template_id); err != nil {
+		write_template_error(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Template deleted"})
+}
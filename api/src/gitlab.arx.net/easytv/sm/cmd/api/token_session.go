@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/easytv/sm"
+)
+
+// TokenSessionProvider lets the access tokens be used in place of a session id.
+// The oauth tokens are verified from their signature, the personal access
+// tokens from the database and the session ids are passed to the wrapped provider.
+// The sessions and the oauth tokens of the content owners that started before
+// their sessions were revoked (e.g. by a password reset) are refused.
+// Their organisation and role are read again on every request, so the members
+// that are removed or get another role don't keep the access of their login.
+type TokenSessionProvider struct {
+	provider         gosession.SessionProvider
+	oauth_service    sm.OAuthService
+	token_service    sm.PersonalAccessTokenService
+	owner_repository sm.ContentOwnerRepository
+}
+
+func is_token(id string) bool {
+	return sm.IsAccessToken(id) || sm.IsPersonalAccessToken(id)
+}
+
+func (this *TokenSessionProvider) Save(id string, data map[string]interface{}) error {
+	// The data of a token session aren't kept between the requests
+	if is_token(id) {
+		return nil
+	}
+	return this.provider.Save(id, data)
+}
+
+func (this *TokenSessionProvider) Delete(id string) error {
+	// The tokens are revoked or expire instead
+	if is_token(id) {
+		return nil
+	}
+	return this.provider.Delete(id)
+}
+
+func (this *TokenSessionProvider) Get(id string, data *map[string]interface{}) (bool, error) {
+	if sm.IsPersonalAccessToken(id) {
+		return this.getPersonalAccessToken(id, data)
+	} else if !sm.IsAccessToken(id) {
+		return this.getSession(id, data)
+	}
+
+	claims, err := this.oauth_service.VerifyToken(id)
+
+	if err != nil {
+		return false, nil
+	}
+
+	revoked_date, err := this.owner_repository.GetSessionsRevokedDate(claims.OwnerID)
+
+	if err != nil {
+		return false, err
+	} else if revoked_date != nil && claims.IssuedAt <= revoked_date.Unix() {
+		return false, nil
+	}
+
+	role_id, err := this.memberRoleID(claims.OwnerID, claims.OrganisationID)
+
+	if err == sm.ErrNotAMember {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	(*data)["user_id"] = claims.OwnerID
+	(*data)["role"] = sm.RoleContentOwner
+	(*data)["role_id"] = role_id
+	(*data)["organisation_id"] = claims.OrganisationID
+	(*data)["client_id"] = claims.ClientID
+	(*data)["scopes"] = claims.Scopes()
+	// The expiration of the token applies instead of SessionExpiration
+	(*data)["last_accessed"] = time.Now().Unix()
+
+	return true, nil
+}
+
+func (this *TokenSessionProvider) getSession(id string, data *map[string]interface{}) (bool, error) {
+	found, err := this.provider.Get(id, data)
+
+	if err != nil || !found {
+		return found, err
+	}
+
+	// Only the logged in content owners are checked
+	owner_id, ok := (*data)["user_id"].(int64)
+	if role, _ := (*data)["role"].(int); !ok || role != sm.RoleContentOwner {
+		return true, nil
+	}
+
+	revoked_date, err := this.owner_repository.GetSessionsRevokedDate(owner_id)
+
+	if err != nil {
+		return false, err
+	}
+
+	login_date, _ := (*data)["login_date"].(int64)
+
+	if revoked_date != nil && login_date <= revoked_date.Unix() {
+		this.provider.Delete(id)
+		return false, nil
+	}
+
+	organisation_id, _ := (*data)["organisation_id"].(int64)
+	role_id, err := this.memberRoleID(owner_id, organisation_id)
+
+	if err == sm.ErrNotAMember {
+		this.provider.Delete(id)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	(*data)["role_id"] = role_id
+
+	return true, nil
+}
+
+// Returns the current role of a member of the organisation,
+// ErrNotAMember if the content owner was removed from it
+func (this *TokenSessionProvider) memberRoleID(owner_id, organisation_id int64) (int64, error) {
+	owner := sm.ContentOwner{ID: owner_id}
+
+	if err := this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return 0, err
+	} else if len(owner.Username) == 0 ||
+		owner.OrganisationID == nil ||
+		*owner.OrganisationID != organisation_id {
+		return 0, sm.ErrNotAMember
+	}
+
+	return session_role_id(owner.RoleID), nil
+}
+
+func (this *TokenSessionProvider) getPersonalAccessToken(
+	id string, data *map[string]interface{}) (bool, error) {
+
+	token, owner, err := this.token_service.Authenticate(id)
+
+	if err == sm.ErrInvalidAccessToken || err == sm.ErrNotAMember {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	(*data)["user_id"] = owner.ID
+	(*data)["username"] = owner.Username
+	(*data)["role"] = sm.RoleContentOwner
+	(*data)["role_id"] = session_role_id(owner.RoleID)
+	(*data)["organisation_id"] = *owner.OrganisationID
+	(*data)["token_id"] = token.ID
+	(*data)["scopes"] = token.Scopes
+	(*data)["last_accessed"] = time.Now().Unix()
+
+	return true, nil
+}
+
+// IsTokenSession checks if the session comes from an access token,
+// the tokens can't change the credentials of their owner
+func IsTokenSession(session *gosession.Session) bool {
+	_, ok := session.Data["scopes"]
+	return ok
+}
+
+// BearerTokenMiddleware passes an "Authorization: Bearer" token
+// as the session of the request
+func BearerTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+
+		if r.Header.Get(sm.EasyTVSessionHeader) == "" && strings.HasPrefix(authorization, "Bearer ") {
+			r.Header.Set(sm.EasyTVSessionHeader, strings.TrimPrefix(authorization, "Bearer "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
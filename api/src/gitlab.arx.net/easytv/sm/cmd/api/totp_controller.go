@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+// TotpController manages the two factor authentication
+// of the admin or the content owner of the session
+type TotpController struct {
+	sessions        *gosession.SessionStore
+	totp_repository sm.TotpRepository
+	totp_service    sm.TotpService
+}
+
+func write_totp_error(w http.ResponseWriter, err error) {
+	if err == sm.ErrInvalidTotpCode {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidTotpCode,
+			"description": "The code is not correct"})
+	} else if err == sm.ErrTotpAlreadyEnabled {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeTotpAlreadyEnabled,
+			"description": "Two factor authentication is already enabled"})
+	} else if err == sm.ErrTotpNotEnrolled {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeTotpNotEnrolled,
+			"description": "Two factor authentication isn't enrolled"})
+	} else {
+		InternalServerError(w, err)
+	}
+}
+
+// Returns the role and the id of the user, the access tokens can't be used
+func (this *TotpController) verifySession(w http.ResponseWriter,
+	r *http.Request) (*gosession.Session, int, int64, bool) {
+
+	session, _ := this.sessions.Get(r, w)
+
+	if !VerifySession(session, w) {
+		return nil, 0, 0, false
+	} else if IsTokenSession(session) {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeForbidden,
+			"description": "Two factor authentication can't change with an access token"})
+		return nil, 0, 0, false
+	}
+
+	user_role, _ := session.Data["role"].(int)
+	user_id, _ := session.Data["user_id"].(int64)
+
+	return session, user_role, user_id, true
+}
+
+func (this *TotpController) GetTotp(w http.ResponseWriter, r *http.Request) {
+	_, user_role, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := this.totp_repository.Get(user_role, user_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	recovery_codes, err := this.totp_repository.CountRecoveryCodes(user_role, user_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":           sm.OK,
+		"description":    "Returning the status of the two factor authentication",
+		"enabled":        secret != nil && secret.Enabled,
+		"recovery_codes": recovery_codes})
+}
+
+// Starts the enrollment, the secret is enabled by PostTotpConfirm
+func (this *TotpController) PostTotp(w http.ResponseWriter, r *http.Request) {
+	session, user_role, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	username, _ := session.Data["username"].(string)
+
+	secret, uri, err := this.totp_service.Enroll(user_role, user_id, username)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":             sm.OK,
+			"description":      "Add the secret to your authenticator app and confirm a code",
+			"secret":           secret,
+			"provisioning_uri": uri})
+	} else {
+		write_totp_error(w, err)
+	}
+}
+
+func (this *TotpController) PostTotpConfirm(w http.ResponseWriter, r *http.Request) {
+	_, user_role, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	code, _ := data["code"].(string)
+
+	recovery_codes, err := this.totp_service.Confirm(user_role, user_id, code)
+
+	if err == nil {
+		// The recovery codes are only stored hashed
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":           sm.OK,
+			"description":    "Two factor authentication is enabled, keep the recovery codes safe",
+			"recovery_codes": recovery_codes})
+	} else {
+		write_totp_error(w, err)
+	}
+}
+
+func (this *TotpController) PostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	_, user_role, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	code, _ := data["code"].(string)
+
+	recovery_codes, err := this.totp_service.RegenerateRecoveryCodes(user_role, user_id, code)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":           sm.OK,
+			"description":    "The previous recovery codes don't work anymore",
+			"recovery_codes": recovery_codes})
+	} else {
+		write_totp_error(w, err)
+	}
+}
+
+func (this *TotpController) DeleteTotp(w http.ResponseWriter, r *http.Request) {
+	_, user_role, user_id, ok := this.verifySession(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	code, _ := data["code"].(string)
+
+	err := this.totp_service.Disable(user_role, user_id, code)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "Two factor authentication is disabled"})
+	} else {
+		write_totp_error(w, err)
+	}
+}
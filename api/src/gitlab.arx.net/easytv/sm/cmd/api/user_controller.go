@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
@@ -16,6 +17,11 @@ type UserController struct {
 	owner_service    sm.ContentOwnerService
 	admin_repository sm.AdminRepository
 	admin_service    sm.AdminService
+	lock_service     sm.LoginLockService
+	totp_service     sm.TotpService
+	reset_service    sm.PasswordResetService
+	// Trust the address that the reverse proxy sets in X-Real-IP
+	trust_proxy bool
 }
 
 func VerifySession(session *gosession.Session, w http.ResponseWriter) bool {
@@ -60,11 +66,109 @@ func VerifySessionWithRole(session *gosession.Session, w http.ResponseWriter, ro
 	return false
 }
 
-func (this *UserController) Login(w http.ResponseWriter, r *http.Request) {
-	data, _ := httpio.ReadJSON(r)
+// SessionOrganisationID returns the organisation whose jobs the session works on
+func SessionOrganisationID(session *gosession.Session) int64 {
+	organisation_id, _ := session.Data["organisation_id"].(int64)
+	return organisation_id
+}
 
-	var username, password string
-	var ok bool
+// The role id stored in the session, 0 for the default role
+func session_role_id(role_id *int64) int64 {
+	if role_id == nil {
+		return 0
+	}
+	return *role_id
+}
+
+// The address of the client that the failed logins are counted for
+func (this *UserController) clientAddress(r *http.Request) string {
+	if real_ip := r.Header.Get("X-Real-IP"); this.trust_proxy && len(real_ip) > 0 {
+		return real_ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Responds with CodeLoginLocked when the username or the address are locked
+func (this *UserController) isLocked(w http.ResponseWriter, username, address string) bool {
+	locked_until, err := this.lock_service.Check(username, address)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return true
+	} else if locked_until != nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeLoginLocked,
+			"description": "Too many failed logins, try again later",
+			"retry_after": int64(time.Until(*locked_until).Seconds()) + 1})
+		return true
+	}
+	return false
+}
+
+func admin_session(session *gosession.Session, admin *sm.AdminUser) {
+	session.Data["user_id"] = admin.ID
+	session.Data["username"] = admin.Username
+	session.Data["role"] = sm.RoleAdmin
+	session.Data["role_id"] = session_role_id(admin.RoleID)
+	session.Data["last_accessed"] = time.Now().Unix()
+}
+
+func owner_session(session *gosession.Session, owner *sm.ContentOwner) {
+	session.Data["user_id"] = owner.ID
+	session.Data["username"] = owner.Username
+	session.Data["role"] = sm.RoleContentOwner
+	session.Data["role_id"] = session_role_id(owner.RoleID)
+	session.Data["organisation_id"] = *owner.OrganisationID
+	session.Data["login_date"] = time.Now().Unix()
+	session.Data["last_accessed"] = time.Now().Unix()
+}
+
+// Responds with a pending session if the user has two factor authentication,
+// the session has no "user_id" so it is refused until LoginTotp.
+// Returns false if the login can continue.
+func (this *UserController) requireTotp(w http.ResponseWriter,
+	user_role int, user_id int64, username string) bool {
+
+	enabled, err := this.totp_service.IsEnabled(user_role, user_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return true
+	} else if !enabled {
+		return false
+	}
+
+	session, err := this.sessions.New(w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return true
+	}
+
+	session.Data["pending_user_id"] = user_id
+	session.Data["pending_role"] = user_role
+	session.Data["pending_since"] = time.Now().Unix()
+	session.Data["username"] = username
+	session.Save()
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":          sm.CodeTotpRequired,
+		"description":   "Send the code of your authenticator app to /api/user/login/totp",
+		"session_token": session.ID})
+	return true
+}
+
+// Reads the credentials of a login and responds with CodeLoginLocked when
+// the username or the address are locked. Returns false if the login can't continue.
+func (this *UserController) readCredentials(w http.ResponseWriter,
+	r *http.Request) (username, password, address string, ok bool) {
+
+	data, _ := httpio.ReadJSON(r)
 
 	if username, ok = data["username"].(string); !ok {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -80,42 +184,58 @@ func (this *UserController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	owner, err := this.owner_service.Login(username, password)
+	// The same username is checked for the content owners and the admins
+	address = this.clientAddress(r)
 
-	if err != nil {
+	if this.isLocked(w, username, address) {
+		return username, password, address, false
+	}
+	return username, password, address, true
+}
+
+// Counts the failed login and responds that the credentials are wrong
+func (this *UserController) failLogin(w http.ResponseWriter, username, address string) {
+	if err := this.lock_service.Fail(username, address); err != nil {
 		InternalServerError(w, err)
 		return
-	} else if owner == nil {
-		// user is not a content onwer
-		// check if he is an admin
-		admin, err := this.admin_service.Login(username, password)
+	}
 
-		if err != nil {
-			InternalServerError(w, err)
-			return
-		} else if admin != nil {
-			session, err := this.sessions.New(w)
-			if err != nil {
-				InternalServerError(w, err)
-				return
-			}
-			session.Data["user_id"] = admin.ID
-			session.Data["username"] = username
-			session.Data["role"] = sm.RoleAdmin
-			session.Data["last_accessed"] = time.Now().Unix()
-			session.Save()
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.CodeNoSession,
+		"description": "Username or password are not correct"})
+}
 
-			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-				"code":          sm.OK,
-				"description":   fmt.Sprintf("Success, hello %s", username),
-				"session_token": session.ID,
-				"is_admin":      true})
-			return
-		}
+// Login logs in the content owners, the admins log in with AdminLogin
+func (this *UserController) Login(w http.ResponseWriter, r *http.Request) {
+	username, password, address, ok := this.readCredentials(w, r)
 
+	if !ok {
+		return
+	}
+
+	owner, err := this.owner_service.Login(username, password)
+
+	if err == sm.ErrNotAMember {
 		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"code":        sm.CodeNoSession,
-			"description": "Username or password are not correct"})
+			"code":        sm.CodeNotAMember,
+			"description": "The user isn't a member of an organisation"})
+		return
+	} else if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if owner == nil {
+		this.failLogin(w, username, address)
+		return
+	}
+
+	// The failures are only forgotten when the login is complete,
+	// the codes are guessed like the passwords
+	if this.requireTotp(w, sm.RoleContentOwner, owner.ID, username) {
+		return
+	}
+
+	if err = this.lock_service.Succeed(username); err != nil {
+		InternalServerError(w, err)
 		return
 	}
 
@@ -126,10 +246,7 @@ func (this *UserController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session.Data["user_id"] = owner.ID
-	session.Data["role"] = sm.RoleContentOwner
-	session.Data["last_accessed"] = time.Now().Unix()
-
+	owner_session(session, owner)
 	session.Save()
 
 	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -138,6 +255,155 @@ func (this *UserController) Login(w http.ResponseWriter, r *http.Request) {
 		"session_token": session.ID})
 }
 
+// AdminLogin logs in the admins, the content owners can't log in with it
+// and the admins can't log in with Login
+func (this *UserController) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	username, password, address, ok := this.readCredentials(w, r)
+
+	if !ok {
+		return
+	}
+
+	admin, err := this.admin_service.Login(username, password)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if admin == nil {
+		this.failLogin(w, username, address)
+		return
+	}
+
+	if this.requireTotp(w, sm.RoleAdmin, admin.ID, username) {
+		return
+	}
+
+	if err = this.lock_service.Succeed(username); err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	session, err := this.sessions.New(w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	admin_session(session, admin)
+	session.Save()
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":          sm.OK,
+		"description":   fmt.Sprintf("Success, hello %s", username),
+		"session_token": session.ID,
+		"is_admin":      true})
+}
+
+// LoginTotp completes the login of a pending session with a code
+// of the authenticator app or a recovery code
+func (this *UserController) LoginTotp(w http.ResponseWriter, r *http.Request) {
+	session, _ := this.sessions.Get(r, w)
+
+	var user_id int64
+	var user_role int
+	var ok bool
+
+	if session != nil {
+		user_id, ok = session.Data["pending_user_id"].(int64)
+		user_role, _ = session.Data["pending_role"].(int)
+		since, _ := session.Data["pending_since"].(int64)
+
+		if ok && time.Now().Unix()-since > sm.TotpPendingExpiration {
+			session.Destroy(w)
+			ok = false
+		}
+	}
+
+	if !ok {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNoSession,
+			"description": "No pending login, login with the password first"})
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	code, _ := data["code"].(string)
+	username, _ := session.Data["username"].(string)
+	address := this.clientAddress(r)
+
+	// The codes are guessed like the passwords
+	if this.isLocked(w, username, address) {
+		return
+	}
+
+	valid, err := this.totp_service.Verify(user_role, user_id, code)
+
+	if err != nil && err != sm.ErrTotpNotEnrolled {
+		InternalServerError(w, err)
+		return
+	} else if !valid {
+		if err = this.lock_service.Fail(username, address); err != nil {
+			InternalServerError(w, err)
+			return
+		}
+
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidTotpCode,
+			"description": "The code is not correct"})
+		return
+	}
+
+	if err = this.lock_service.Succeed(username); err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	delete(session.Data, "pending_user_id")
+	delete(session.Data, "pending_role")
+	delete(session.Data, "pending_since")
+
+	if user_role == sm.RoleAdmin {
+		admin, err := this.admin_repository.GetByID(user_id)
+
+		if err != nil {
+			InternalServerError(w, err)
+			return
+		} else if admin == nil {
+			session.Destroy(w)
+			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"code":        sm.CodeNoSession,
+				"description": "The user doesn't exist"})
+			return
+		}
+		admin_session(session, admin)
+	} else {
+		owner := sm.ContentOwner{ID: user_id}
+
+		if err = this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+			InternalServerError(w, err)
+			return
+		} else if len(owner.Username) == 0 || owner.OrganisationID == nil {
+			session.Destroy(w)
+			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"code":        sm.CodeNotAMember,
+				"description": "The user isn't a member of an organisation"})
+			return
+		}
+		owner_session(session, &owner)
+	}
+
+	// The full session gets a new id, the pending one stops working
+	session.RenewID(w)
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":          sm.OK,
+		"description":   fmt.Sprintf("Success, hello %s", username),
+		"session_token": session.ID,
+		"is_admin":      user_role == sm.RoleAdmin})
+}
+
 func (this *UserController) Ping(w http.ResponseWriter, r *http.Request) {
 	session, _ := this.sessions.Get(r, w)
 
@@ -174,6 +440,11 @@ func (this *UserController) ChangePassword(w http.ResponseWriter, r *http.Reques
 
 	if !VerifySession(session, w) {
 		return
+	} else if IsTokenSession(session) {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeForbidden,
+			"description": "The password can't change with an access token"})
+		return
 	}
 
 	data, _ := httpio.ReadJSON(r)
@@ -226,3 +497,56 @@ func (this *UserController) ChangePassword(w http.ResponseWriter, r *http.Reques
 		InternalServerError(w, err)
 	}
 }
+
+// ForgotPassword emails a reset token, the response is the same
+// for the unknown emails
+func (this *UserController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data, _ := httpio.ReadJSON(r)
+
+	email, _ := data["email"].(string)
+
+	if len(email) == 0 {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "email is required"})
+		return
+	}
+
+	if err := this.reset_service.RequestReset(email); err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "If the email belongs to a user, a reset link was sent to it"})
+}
+
+// ResetPassword sets a new password with the token of the email
+func (this *UserController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	data, _ := httpio.ReadJSON(r)
+
+	token, _ := data["token"].(string)
+	password, _ := data["password"].(string)
+
+	err := this.reset_service.Reset(token, password)
+
+	if err == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.OK,
+			"description": "password is changed",
+		})
+	} else if err == sm.ErrPasswordTooShort {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodePasswordIsTooShort,
+			"description": "Password is too short",
+		})
+	} else if err == sm.ErrInvalidResetToken {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeInvalidResetToken,
+			"description": "The reset link is invalid or expired",
+		})
+	} else {
+		InternalServerError(w, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"gitlab.arx.net/arx/gosession"
+	"gitlab.arx.net/arx/httpio"
+	"gitlab.arx.net/easytv/sm"
+)
+
+type WebhookController struct {
+	sessions                *gosession.SessionStore
+	organisation_repository sm.OrganisationRepository
+	webhook_repository      sm.WebhookRepository
+	webhook_service         sm.WebhookService
+}
+
+func (this *WebhookController) writeWebhook(w http.ResponseWriter, organisation_id int64, description string) {
+	organisation, err := this.organisation_repository.GetByID(organisation_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	} else if organisation == nil {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeNotFound,
+			"description": "The organisation was not found"})
+		return
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": description,
+		"webhook": map[string]interface{}{
+			"url":    organisation.CallbackUrl,
+			"secret": organisation.WebhookSecret,
+		}})
+}
+
+func (this *WebhookController) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	organisation_id := SessionOrganisationID(session)
+
+	this.writeWebhook(w, organisation_id, "Success")
+}
+
+// Sets the callback url ("url", empty to disable the webhook)
+// and/or generates a new secret ("rotate_secret")
+func (this *WebhookController) PutWebhook(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	data, _ := httpio.ReadJSON(r)
+
+	organisation_id := SessionOrganisationID(session)
+	url, has_url := data["url"].(string)
+	rotate_secret, _ := data["rotate_secret"].(bool)
+
+	if !has_url && !rotate_secret {
+		httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"code":        sm.CodeMissingInput,
+			"description": "A \"url\" string or \"rotate_secret\" parameter is missing"})
+		return
+	}
+
+	if has_url {
+		err = this.webhook_service.SetCallbackUrl(organisation_id, url)
+
+		if err == sm.ErrInvalidCallbackUrl {
+			httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"code":        sm.CodeInvalidCallbackUrl,
+				"description": "Invalid \"url\", it should be an http(s) url of a public host"})
+			return
+		} else if err != nil {
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	if rotate_secret {
+		if _, err = this.webhook_service.RotateSecret(organisation_id); err != nil {
+			InternalServerError(w, err)
+			return
+		}
+	}
+
+	this.writeWebhook(w, organisation_id, "Webhook updated")
+}
+
+func (this *WebhookController) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	session, err := this.sessions.Get(r, w)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	if !VerifySessionWithRole(session, w, sm.RoleContentOwner) {
+		return
+	}
+
+	limit, err := strconv.ParseInt(chi.URLParam(r, "limit"), 10, 64)
+	if err != nil {
+		limit = -1
+	}
+
+	before_id, err := strconv.ParseInt(chi.URLParam(r, "delivery_id"), 10, 64)
+	if err != nil {
+		before_id = -1
+	}
+
+	organisation_id := SessionOrganisationID(session)
+	deliveries, err := this.webhook_repository.GetDeliveriesForOrganisation(organisation_id, limit, before_id)
+
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+
+	deliveries_json := make([]map[string]interface{}, len(deliveries))
+
+	for i, delivery := range deliveries {
+		var last_attempt *int64
+		if delivery.LastAttempt != nil {
+			last_attempt = new(int64)
+			*last_attempt = delivery.LastAttempt.Unix()
+		}
+
+		var next_attempt *int64
+		if delivery.Status == sm.DeliveryPending {
+			next_attempt = new(int64)
+			*next_attempt = delivery.NextAttempt.Unix()
+		}
+
+		deliveries_json[i] = map[string]interface{}{
+			"id":            delivery.ID,
+			"job_id":        delivery.JobID,
+			"url":           delivery.Url,
+			"event":         delivery.Event,
+			"payload":       delivery.Payload,
+			"status":        sm.DeliveryStatusStr(delivery.Status),
+			"attempts":      delivery.Attempts,
+			"status_code":   delivery.StatusCode,
+			"error":         delivery.Error,
+			"creation_date": delivery.CreationDate.Unix(),
+			"last_attempt":  last_attempt,
+			"next_attempt":  next_attempt,
+		}
+	}
+
+	var next_url *string
+	if limit != -1 && int64(len(deliveries)) == limit {
+		next_url = new(string)
+		*next_url = fmt.Sprintf("/api/webhook/delivery/limit/%d/before/%d",
+			limit,
+			deliveries[len(deliveries)-1].ID)
+	}
+
+	httpio.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"code":        sm.OK,
+		"description": "Success",
+		"next":        next_url,
+		"deliveries":  deliveries_json})
+}
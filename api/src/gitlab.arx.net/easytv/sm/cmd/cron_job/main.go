@@ -3,12 +3,14 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"gitlab.arx.net/easytv/sm"
 
 	"gitlab.arx.net/easytv/sm/db"
+	"gitlab.arx.net/easytv/sm/store"
 )
 
 const BATCH_LIMIT = 10000
@@ -35,18 +37,47 @@ func main() {
 		log.Fatal(err)
 	}
 
+	asset_store, err := store.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	job_repository := &db.JobRepository{Pool: pool}
 	task_repository := &db.TaskRepository{Pool: pool}
 	module_repository := &db.ModuleRepository{Pool: pool}
 	asset_repository := &db.AssetRepository{Pool: pool}
 	owner_repository := &db.ContentOwnerRepository{Pool: pool}
+	webhook_repository := &db.WebhookRepository{Pool: pool}
+	reset_repository := &db.PasswordResetRepository{Pool: pool}
 
+	// The events are sent by the dispatcher of the api
+	webhook_service := sm.NewWebhookService(webhook_repository,
+		&db.OrganisationRepository{Pool: pool})
+
+	// The cron doesn't send start requests, it has no asset links to sign
 	job_service := sm.NewJobService(job_repository,
 		task_repository,
 		module_repository,
-		owner_repository)
+		owner_repository,
+		asset_repository,
+		webhook_service,
+		nil)
+
+	asset_service := sm.NewAssetService(
+		asset_repository, job_repository, task_repository, asset_store)
+
+	task_service := sm.NewTaskService(task_repository, job_repository)
+
+	// The timeouts are checked more often than the cleanup
+	if len(os.Args) > 1 && os.Args[1] == "timeouts" {
+		log.Print("Check for timed out job steps...")
+		if err = job_service.TimeoutPendingSteps(); err != nil {
+			log.Fatal(err)
+		}
 
-	asset_service := sm.NewAssetService(asset_repository, job_repository, task_repository)
+		log.Print("Completed")
+		return
+	}
 
 	log.Print("Started periodic cleanup of jobs")
 	log.Print("Cancel jobs exceeding publication date...")
@@ -60,5 +91,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	log.Print("Retire unused task versions...")
+	if err = task_service.RetireVersions(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("Delete expired password reset tokens...")
+	if err = reset_repository.DeleteExpired(time.Now()); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Print("Completed")
 }
@@ -10,20 +10,91 @@ import (
 
 func init_db(pool *db.DatabasePool) {
 	pool.DB.Query("DROP TABLE IF EXISTS admin_user;")
+	pool.DB.Query("DROP TABLE IF EXISTS webhook_delivery;")
+	pool.DB.Query("DROP TABLE IF EXISTS job_queue;")
+	pool.DB.Query("DROP TABLE IF EXISTS job_template;")
+	pool.DB.Query("DROP TABLE IF EXISTS job_step_attempt;")
 	pool.DB.Query("DROP TABLE IF EXISTS job_param;")
+	pool.DB.Query("DROP TABLE IF EXISTS job_step_dependency;")
 	pool.DB.Query("DROP TABLE IF EXISTS job_step;")
 	pool.DB.Query("DROP TABLE IF EXISTS asset;")
 	pool.DB.Query("DROP TABLE IF EXISTS job;")
+	pool.DB.Query("DROP TABLE IF EXISTS organisation_invitation;")
+	pool.DB.Query("DROP TABLE IF EXISTS oauth_client;")
+	pool.DB.Query("DROP TABLE IF EXISTS personal_access_token;")
+	pool.DB.Query("DROP TABLE IF EXISTS password_reset_token;")
 	pool.DB.Query("DROP TABLE IF EXISTS content_owner;")
+	pool.DB.Query("DROP TABLE IF EXISTS organisation;")
 	pool.DB.Query("DROP TABLE IF EXISTS task_parameter;")
+	pool.DB.Query("DROP TABLE IF EXISTS task_version;")
 	pool.DB.Query("DROP TABLE IF EXISTS task;")
+	pool.DB.Query("DROP TABLE IF EXISTS module_api_key;")
 	pool.DB.Query("DROP TABLE IF EXISTS module;")
+	pool.DB.Query("DROP TABLE IF EXISTS role;")
+	pool.DB.Query("DROP TABLE IF EXISTS login_lock;")
+	pool.DB.Query("DROP TABLE IF EXISTS login_audit;")
+	pool.DB.Query("DROP TABLE IF EXISTS totp_recovery_code;")
+	pool.DB.Query("DROP TABLE IF EXISTS totp;")
+
+	create_table("Role", `
+	create table if not exists role (
+		id serial primary key not null,
+		name varchar unique not null,
+		description varchar not null,
+		permissions text not null,
+		built_in boolean not null default false
+	)
+	`, pool.DB)
+
+	create_table("LoginLock", `
+	create table if not exists login_lock (
+		key varchar primary key not null,
+		failures integer not null,
+		last_failure timestamp not null,
+		locked_until timestamp
+	)
+	`, pool.DB)
+
+	create_table("LoginAudit", `
+	create table if not exists login_audit (
+		id serial primary key not null,
+		date timestamp not null,
+		event varchar not null,
+		key varchar not null,
+		address varchar not null,
+		detail varchar not null
+	)
+	`, pool.DB)
+
+	// The secrets of both the admins and the content owners, user_role tells them apart
+	create_table("Totp", `
+	create table if not exists totp (
+		user_role smallint not null,
+		user_id integer not null,
+		secret varchar not null,
+		enabled boolean not null,
+		creation_date timestamp not null,
+		last_step bigint not null,
+		primary key (user_role, user_id)
+	)
+	`, pool.DB)
+
+	create_table("TotpRecoveryCode", `
+	create table if not exists totp_recovery_code (
+		id serial primary key not null,
+		user_role smallint not null,
+		user_id integer not null,
+		hashed_code varchar not null,
+		used_date timestamp
+	)
+	`, pool.DB)
 
 	create_table("AdminUser", `
 	create table if not exists admin_user (
 		id serial primary key not null,
 		username varchar unique not null,
-		password varchar unique not null
+		password varchar unique not null,
+		role_id integer references role(id)
 	)
 	`, pool.DB)
 
@@ -32,13 +103,26 @@ func init_db(pool *db.DatabasePool) {
 			id serial primary key not null,
 			name varchar unique not null,
 			description varchar not null,
-			api_key varchar unique not null,
-			enabled boolean not null
+			enabled boolean not null,
+			signing_secret varchar not null,
+			previous_signing_secret varchar not null,
+			previous_secret_expiration timestamp,
+			legacy_key varchar not null,
+			previous_legacy_key varchar not null,
+			previous_legacy_key_expiration timestamp
 		)`, pool.DB)
 
-	create_table("ModuleIndex", `
-		CREATE INDEX api_key_idx ON module (api_key)
-		`, pool.DB)
+	create_table("ModuleApiKey", `
+		create table if not exists module_api_key (
+			id serial primary key not null,
+			module_id serial references module(id) not null,
+			label varchar not null,
+			hashed_key varchar unique not null,
+			creation_date timestamp not null,
+			expiration_date timestamp,
+			last_used timestamp,
+			revoked boolean not null
+		)`, pool.DB)
 
 	create_table("Task", `
 		create table if not exists task (
@@ -49,16 +133,54 @@ func init_db(pool *db.DatabasePool) {
 			start_url varchar not null,
 			cancel_url varchar not null,
 			enabled boolean not null,
-			deleted boolean not null
+			deleted boolean not null,
+			max_attempts integer not null,
+			retry_backoff integer not null,
+			retry_status_codes varchar not null,
+			max_async_duration integer not null,
+			input_schema text,
+			output_schema text,
+			version integer not null
+		)`, pool.DB)
+
+	create_table("TaskVersion", `
+		create table if not exists task_version (
+			id serial primary key not null,
+			task_id serial references task(id) not null,
+			version integer not null,
+			start_url varchar not null,
+			cancel_url varchar not null,
+			max_attempts integer not null,
+			retry_backoff integer not null,
+			retry_status_codes varchar not null,
+			max_async_duration integer not null,
+			input_schema text,
+			output_schema text,
+			creation_date timestamp not null,
+			retired boolean not null,
+			unique (task_id, version)
 		)`, pool.DB)
 
 	create_table("TaskParameter", `
 		create table if not exists task_parameter (
 			id serial primary key not null,
 			task_id serial references task(id) not null,
+			version integer not null,
 			name varchar not null,
 			data_type smallint not null,
-			is_input boolean not null
+			is_input boolean not null,
+			enum_values text,
+			required boolean not null default true,
+			default_value text
+		)`, pool.DB)
+
+	create_table("Organisation", `
+		create table if not exists organisation (
+			id serial primary key not null,
+			name varchar unique not null,
+			creation_date timestamp not null,
+			callback_url varchar not null,
+			webhook_secret varchar not null
 		)`, pool.DB)
 
 	create_table("ContentOwner", `
@@ -67,7 +189,70 @@ func init_db(pool *db.DatabasePool) {
 			username varchar unique not null,
 			password varchar not null,
 			email varchar unique not null,
-			name varchar unique not null
+			name varchar unique not null,
+			role_id integer references role(id),
+			organisation_id integer references organisation(id),
+			sessions_revoked_date timestamp
+		)`, pool.DB)
+
+	create_table("OrganisationInvitation", `
+		create table if not exists organisation_invitation (
+			id serial primary key not null,
+			organisation_id integer references organisation(id) not null,
+			email varchar not null,
+			role_id integer references role(id),
+			hashed_token varchar unique not null,
+			invited_by integer references content_owner(id),
+			creation_date timestamp not null,
+			expiration_date timestamp not null,
+			accept_date timestamp,
+			revoked boolean not null
+		)`, pool.DB)
+
+	create_table("OAuthClient", `
+		create table if not exists oauth_client (
+			id serial primary key not null,
+			organisation_id integer references organisation(id) not null,
+			owner_id integer references content_owner(id) not null,
+			name varchar not null,
+			client_id varchar unique not null,
+			hashed_secret varchar not null,
+			scopes text not null,
+			creation_date timestamp not null,
+			revoked boolean not null
+		)`, pool.DB)
+
+	create_table("PersonalAccessToken", `
+		create table if not exists personal_access_token (
+			id serial primary key not null,
+			owner_id integer references content_owner(id) not null,
+			name varchar not null,
+			hashed_token varchar unique not null,
+			scopes text not null,
+			creation_date timestamp not null,
+			expiration_date timestamp,
+			last_used timestamp,
+			revoked boolean not null
+		)`, pool.DB)
+
+	create_table("PasswordResetToken", `
+		create table if not exists password_reset_token (
+			id serial primary key not null,
+			owner_id integer references content_owner(id) not null,
+			hashed_token varchar unique not null,
+			creation_date timestamp not null,
+			expiration_date timestamp not null,
+			used_date timestamp
+		)`, pool.DB)
+
+	create_table("JobTemplate", `
+		create table if not exists job_template (
+			id serial primary key not null,
+			organisation_id integer references organisation(id) not null,
+			name varchar not null,
+			description varchar not null,
+			creation_date timestamp not null,
+			tasks text not null
 		)`, pool.DB)
 
 	create_table("Job", `
@@ -80,18 +265,37 @@ func init_db(pool *db.DatabasePool) {
 			completion_date timestamp,
 			publication_date timestamp,
 			expiration_date timestamp,
-			current_step smallint not null,
 			owner_id serial references content_owner(id) not null,
-			status varchar not null
+			organisation_id integer references organisation(id) not null,
+			status varchar not null,
+			callback_url varchar
 		)`, pool.DB)
 
+	create_table("JobOrganisationIndex", `
+		CREATE INDEX job_organisation_idx ON job (organisation_id, id)
+		`, pool.DB)
+
 	create_table("Asset", `
 		create table if not exists asset (
 			id serial primary key not null,
-			url_param varchar unique not null,
-			job_id serial references job(id) not null,
-			path varchar unique not null,
-			size int
+			url_param varchar not null,
+			job_id integer references job(id),
+			organisation_id integer references organisation(id),
+			owner_id integer references content_owner(id),
+			path varchar not null,
+			size bigint,
+			checksum varchar not null default '',
+			complete boolean not null default true,
+			uploaded_size bigint not null default 0,
+			original_name varchar not null default '',
+			version integer not null default 1,
+			mime_type varchar not null default '',
+			module_id integer references module(id),
+			step_id integer,
+			duplicate_policy varchar not null default 'reject',
+			creation_date timestamp not null default now(),
+			unique (url_param, complete),
+			unique (path, complete)
 		)`, pool.DB)
 
 	create_table("JobStep", `
@@ -99,7 +303,17 @@ func init_db(pool *db.DatabasePool) {
 			id serial primary key not null,
 			job_id serial references job(id) not null,
 			task_id serial references task(id) not null,
-			step_order integer not null
+			task_version integer not null,
+			step_order integer not null,
+			status smallint not null,
+			pending_since timestamp
+		)`, pool.DB)
+
+	create_table("JobStepDependency", `
+		create table if not exists job_step_dependency (
+			job_step_id serial references job_step(id) not null,
+			depends_on_step_id serial references job_step(id) not null,
+			primary key (job_step_id, depends_on_step_id)
 		)`, pool.DB)
 
 	create_table("JobParam", `
@@ -109,10 +323,66 @@ func init_db(pool *db.DatabasePool) {
 			is_input boolean not null,
 			data_type smallint not null,
 			value text,
+			linked_step_id integer references job_step(id),
 			linked_output_name varchar,
 			primary key (job_step_id, name, is_input)
 		)`, pool.DB)
 
+	create_table("JobStepAttempt", `
+		create table if not exists job_step_attempt (
+			id serial primary key not null,
+			job_step_id serial references job_step(id) not null,
+			attempt integer not null,
+			date timestamp not null,
+			status_code integer,
+			error varchar not null
+		)`, pool.DB)
+
+	create_table("JobQueue", `
+		create table if not exists job_queue (
+			id serial primary key not null,
+			job_id serial references job(id) not null,
+			job_step_id serial references job_step(id) unique not null,
+			available_at timestamp not null,
+			lease_owner varchar,
+			lease_expiration timestamp,
+			attempts integer not null
+		)`, pool.DB)
+
+	create_table("JobQueueIndex", `
+		CREATE INDEX job_queue_available_idx ON job_queue (available_at)
+		`, pool.DB)
+
+	create_table("WebhookDelivery", `
+		create table if not exists webhook_delivery (
+			id serial primary key not null,
+			organisation_id integer references organisation(id) not null,
+			job_id serial references job(id) not null,
+			url varchar not null,
+			event varchar not null,
+			payload text not null,
+			status smallint not null,
+			attempts integer not null,
+			status_code integer,
+			error varchar not null,
+			creation_date timestamp not null,
+			last_attempt timestamp,
+			next_attempt timestamp not null
+		)`, pool.DB)
+
+	create_table("WebhookDeliveryIndex", `
+		CREATE INDEX webhook_delivery_next_attempt_idx ON webhook_delivery (status, next_attempt)
+		`, pool.DB)
+
+	fmt.Println("Create built in roles")
+	roles := db.RoleRepository{Pool: pool}
+	for _, role := range sm.BuiltInRoles {
+		role := role
+		if err := roles.Insert(&role); err != nil {
+			fmt.Println(err)
+		}
+	}
+
 	fmt.Println("Create admin user")
 	service := sm.NewAdminService(&db.AdminRepository{Pool: pool})
 	_, err := service.CreateAdminUser("admin", "admin")
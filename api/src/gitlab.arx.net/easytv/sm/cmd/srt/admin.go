@@ -2,17 +2,121 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 
+	"github.com/olekukonko/tablewriter"
 	"gitlab.arx.net/easytv/sm"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
-func NewAdminCommand(service sm.AdminService) cli.Command {
+func NewAdminCommand(
+	service sm.AdminService,
+	repository sm.AdminRepository,
+	role_service sm.RoleService,
+	role_repository sm.RoleRepository) cli.Command {
+
 	return cli.Command{
 		Name:    "admin",
 		Aliases: []string{"a"},
 		Usage:   "actions about the admin user",
 		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Action: func(c *cli.Context) error {
+					users, err := repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					roles, err := role_repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Username", "Role"})
+					table.SetFooter([]string{"", "Total", strconv.Itoa(len(users))})
+					table.SetBorder(false)
+					for _, user := range users {
+						table.Append([]string{
+							strconv.FormatInt(user.ID, 10),
+							user.Username,
+							role_name(roles, user.RoleID, sm.RoleAdmin)})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:    "create",
+				Aliases: []string{"c"},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "username",
+						Usage: "The username of the admin",
+					},
+					cli.StringFlag{
+						Name:  "password",
+						Usage: "The password of the admin",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "(Optional) The name of the role, admin by default",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("username") || !c.IsSet("password") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					user, err := service.CreateAdminUser(c.String("username"), c.String("password"))
+
+					if err == nil && c.IsSet("role") {
+						err = role_service.SetAdminRole(user.ID, c.String("role"))
+					}
+
+					if err != nil {
+						fmt.Printf("Failed to create admin err='%v'\n", err)
+					} else {
+						fmt.Printf("Created admin id=%v username=%v\n", user.ID, user.Username)
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "set-role",
+				Aliases: []string{"sr"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the admin",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "The name of the role, empty for the default one",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := role_service.SetAdminRole(c.Int64("id"), c.String("role")); err != nil {
+						fmt.Printf("Failed to set role err='%v'\n", err)
+					} else {
+						fmt.Println("Role was set, it applies on the next login")
+					}
+					return nil
+				},
+			},
 			{
 				Name:    "change-password",
 				Aliases: []string{"cw"},
@@ -11,7 +11,10 @@ import (
 )
 
 func NewContentOwnerCommand(
-	service sm.ContentOwnerService, repository sm.ContentOwnerRepository) cli.Command {
+	service sm.ContentOwnerService,
+	repository sm.ContentOwnerRepository,
+	role_service sm.RoleService,
+	role_repository sm.RoleRepository) cli.Command {
 
 	return cli.Command{
 		Name:    "content-owner",
@@ -73,6 +76,14 @@ func NewContentOwnerCommand(
 						Name:  "password",
 						Usage: "(Optional) The new password",
 					},
+					cli.Int64Flag{
+						Name:  "organisation",
+						Usage: "(Optional) The id of the organisation to join, a new one by default",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "(Optional) The name of the role, content_owner by default",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					if !c.IsSet("name") || !c.IsSet("username") || !c.IsSet("email") {
@@ -86,11 +97,27 @@ func NewContentOwnerCommand(
 						password = service.GenerateRandomPassword(12)
 					}
 
-					user, err := service.CreateContentOwner(
-						c.String("name"),
-						c.String("username"),
-						c.String("email"),
-						password)
+					var user *sm.ContentOwner
+					var err error
+
+					if c.IsSet("organisation") {
+						user, err = service.CreateMember(
+							c.Int64("organisation"),
+							c.String("name"),
+							c.String("username"),
+							c.String("email"),
+							password)
+					} else {
+						user, err = service.CreateContentOwner(
+							c.String("name"),
+							c.String("username"),
+							c.String("email"),
+							password)
+					}
+
+					if err == nil && c.IsSet("role") {
+						err = role_service.SetOwnerRole(user.ID, c.String("role"))
+					}
 
 					if err != nil {
 						fmt.Printf("Failed to create user err='%v'\n", err)
@@ -112,23 +139,63 @@ func NewContentOwnerCommand(
 						return nil
 					}
 
+					roles, err := role_repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
 					table := tablewriter.NewWriter(os.Stdout)
 
-					table.SetHeader([]string{"ID", "Name", "Username", "Email"})
-					table.SetFooter([]string{"", "", "Total", strconv.Itoa(len(users))})
+					table.SetHeader([]string{"ID", "Name", "Username", "Email", "Organisation", "Role"})
+					table.SetFooter([]string{"", "", "", "", "Total", strconv.Itoa(len(users))})
 					table.SetBorder(false)
 					for _, user := range users {
+						organisation := ""
+						if user.OrganisationID != nil {
+							organisation = strconv.FormatInt(*user.OrganisationID, 10)
+						}
+
 						table.Append([]string{
 							strconv.FormatInt(user.ID, 10),
 							user.Name,
 							user.Username,
-							user.Email})
+							user.Email,
+							organisation,
+							role_name(roles, user.RoleID, sm.RoleContentOwner)})
 					}
 					table.Render()
 
 					return nil
 				},
 			},
+			{
+				Name:    "set-role",
+				Aliases: []string{"sr"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the content owner",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "The name of the role, empty for the default one",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := role_service.SetOwnerRole(c.Int64("id"), c.String("role")); err != nil {
+						fmt.Printf("Failed to set role err='%v'\n", err)
+					} else {
+						fmt.Println("Role was set, it applies on the next login")
+					}
+					return nil
+				},
+			},
 			{
 				Name:    "update",
 				Aliases: []string{"u"},
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"gitlab.arx.net/easytv/sm"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func NewLoginCommand(service sm.LoginLockService, repository sm.LoginLockRepository) cli.Command {
+	return cli.Command{
+		Name:    "login",
+		Aliases: []string{"l"},
+		Usage:   "actions about the failed logins and their lockouts",
+		Subcommands: []cli.Command{
+			{
+				Name:    "locks",
+				Aliases: []string{"ls"},
+				Usage:   "list the locked usernames and addresses",
+				Action: func(c *cli.Context) error {
+					locks, err := repository.GetLocked(time.Now())
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"Key", "Failures", "LastFailure", "LockedUntil"})
+					table.SetFooter([]string{"", "", "Total", strconv.Itoa(len(locks))})
+					table.SetBorder(false)
+					for _, lock := range locks {
+						table.Append([]string{
+							lock.Key,
+							strconv.Itoa(lock.Failures),
+							lock.LastFailure.Format(time.RFC3339),
+							lock.LockedUntil.Format(time.RFC3339)})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:    "unlock",
+				Aliases: []string{"u"},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "username",
+						Usage: "The username to unlock",
+					},
+					cli.StringFlag{
+						Name:  "address",
+						Usage: "The ip address to unlock",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var key string
+
+					if c.IsSet("username") {
+						key = sm.UsernameLockKey(c.String("username"))
+					} else if c.IsSet("address") {
+						key = sm.AddressLockKey(c.String("address"))
+					} else {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := service.Unlock(key, "srt"); err != nil {
+						fmt.Printf("Failed to unlock err='%v'\n", err)
+					} else {
+						fmt.Println("Logins were unlocked")
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "audit",
+				Aliases: []string{"a"},
+				Usage:   "show the latest events of the login audit log",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "limit",
+						Value: 50,
+						Usage: "(Optional) The number of events",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					events, err := repository.GetAuditEvents(c.Int64("limit"), -1)
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Date", "Event", "Key", "Address", "Detail"})
+					table.SetBorder(false)
+					for _, event := range events {
+						table.Append([]string{
+							strconv.FormatInt(event.ID, 10),
+							event.Date.Format(time.RFC3339),
+							event.Event,
+							event.Key,
+							event.Address,
+							event.Detail})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -34,11 +34,20 @@ func main() {
 	task_repo := &db.TaskRepository{Pool: pool}
 	job_repo := &db.JobRepository{Pool: pool}
 	owner_repo := &db.ContentOwnerRepository{Pool: pool}
+	role_repo := &db.RoleRepository{Pool: pool}
+	organisation_repo := &db.OrganisationRepository{Pool: pool}
+	lock_repo := &db.LoginLockRepository{Pool: pool}
+	totp_repo := &db.TotpRepository{Pool: pool}
 
 	admin_service := sm.NewAdminService(admin_repo)
 	module_service := sm.NewModuleService(module_repo)
 	task_service := sm.NewTaskService(task_repo, job_repo)
-	owner_service := sm.NewContentOwnerService(owner_repo)
+	owner_service := sm.NewContentOwnerService(owner_repo, organisation_repo)
+	role_service := sm.NewRoleService(role_repo, admin_repo, owner_repo)
+	organisation_service := sm.NewOrganisationService(
+		organisation_repo, owner_repo, owner_service, role_repo)
+	lock_service := sm.NewLoginLockService(lock_repo)
+	totp_service := sm.NewTotpService(totp_repo)
 
 	app := cli.NewApp()
 	app.Name = "srt"
@@ -54,10 +63,14 @@ func main() {
 	}
 
 	app.Commands = []cli.Command{
-		NewAdminCommand(admin_service),
+		NewAdminCommand(admin_service, admin_repo, role_service, role_repo),
 		NewServiceCommand(module_repo, module_service),
 		NewTaskCommand(task_service, task_repo),
-		NewContentOwnerCommand(owner_service, owner_repo),
+		NewContentOwnerCommand(owner_service, owner_repo, role_service, role_repo),
+		NewRoleCommand(role_service, role_repo),
+		NewOrganisationCommand(organisation_service, organisation_repo, role_repo),
+		NewLoginCommand(lock_service, lock_repo),
+		NewTotpCommand(totp_service),
 	}
 
 	app.Run(os.Args)
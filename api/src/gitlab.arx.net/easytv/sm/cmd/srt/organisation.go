@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"gitlab.arx.net/easytv/sm"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func NewOrganisationCommand(
+	service sm.OrganisationService,
+	repository sm.OrganisationRepository,
+	role_repository sm.RoleRepository) cli.Command {
+
+	return cli.Command{
+		Name:    "organisation",
+		Aliases: []string{"o"},
+		Usage:   "actions about the organisations and their members",
+		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Action: func(c *cli.Context) error {
+					organisations, err := repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Name", "Created", "Callback URL"})
+					table.SetFooter([]string{"", "", "Total", strconv.Itoa(len(organisations))})
+					table.SetBorder(false)
+					for _, organisation := range organisations {
+						table.Append([]string{
+							strconv.FormatInt(organisation.ID, 10),
+							organisation.Name,
+							organisation.CreationDate.Format("2006-01-02 15:04"),
+							organisation.CallbackUrl})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:    "create",
+				Aliases: []string{"c"},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "The name of the organisation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("name") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					organisation, err := service.CreateOrganisation(c.String("name"))
+
+					if err != nil {
+						fmt.Printf("Failed to create organisation err='%v'\n", err)
+					} else {
+						fmt.Printf("Created organisation id=%v name=%v\n", organisation.ID, organisation.Name)
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "members",
+				Aliases: []string{"m"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the organisation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					members, err := repository.GetMembers(c.Int64("id"))
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					roles, err := role_repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Name", "Username", "Email", "Role"})
+					table.SetFooter([]string{"", "", "", "Total", strconv.Itoa(len(members))})
+					table.SetBorder(false)
+					for _, member := range members {
+						table.Append([]string{
+							strconv.FormatInt(member.ID, 10),
+							member.Name,
+							member.Username,
+							member.Email,
+							role_name(roles, member.RoleID, sm.RoleContentOwner)})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:    "invite",
+				Aliases: []string{"i"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the organisation",
+					},
+					cli.StringFlag{
+						Name:  "email",
+						Usage: "The email of the invited user",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "(Optional) The name of the role, content_owner by default",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("email") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					invitation, token, err := service.Invite(
+						c.Int64("id"), nil, c.String("email"), c.String("role"))
+
+					if err != nil {
+						fmt.Printf("Failed to invite user err='%v'\n", err)
+					} else {
+						fmt.Printf("Created invitation id=%v with token=%v expiring at %v (Last time you're going to see this as plain text)\n",
+							invitation.ID, token, invitation.ExpirationDate.Format("2006-01-02 15:04"))
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "remove-member",
+				Aliases: []string{"rm"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the organisation",
+					},
+					cli.Int64Flag{
+						Name:  "member",
+						Usage: "The id of the content owner",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("member") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := service.RemoveMember(c.Int64("id"), c.Int64("member")); err != nil {
+						fmt.Printf("Failed to remove member err='%v'\n", err)
+					} else {
+						fmt.Println("Member was removed")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"gitlab.arx.net/easytv/sm"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// Splits a comma separated list of permissions
+func split_permissions(value string) []string {
+	permissions := make([]string, 0)
+	for _, permission := range strings.Split(value, ",") {
+		if permission = strings.TrimSpace(permission); len(permission) > 0 {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// The name of the role with the id, the default role for nil
+func role_name(roles []*sm.Role, role_id *int64, user_role int) string {
+	if role_id == nil {
+		return sm.DefaultRoleName(user_role)
+	}
+	for _, role := range roles {
+		if role.ID == *role_id {
+			return role.Name
+		}
+	}
+	return ""
+}
+
+func NewRoleCommand(service sm.RoleService, repository sm.RoleRepository) cli.Command {
+	return cli.Command{
+		Name:    "role",
+		Aliases: []string{"r"},
+		Usage:   "actions about the roles and their permissions",
+		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Action: func(c *cli.Context) error {
+					roles, err := repository.GetAll()
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Name", "BuiltIn", "Permissions", "Description"})
+					table.SetFooter([]string{"", "", "", "Total", strconv.Itoa(len(roles))})
+					table.SetBorder(false)
+					for _, role := range roles {
+						table.Append([]string{
+							strconv.FormatInt(role.ID, 10),
+							role.Name,
+							strconv.FormatBool(role.BuiltIn),
+							strings.Join(role.Permissions, ","),
+							role.Description})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:    "permissions",
+				Aliases: []string{"p"},
+				Usage:   "list the known permissions",
+				Action: func(c *cli.Context) error {
+					for _, permission := range sm.Permissions {
+						fmt.Println(permission)
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "create",
+				Aliases: []string{"c"},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "The name of the role",
+					},
+					cli.StringFlag{
+						Name:  "description",
+						Usage: "(Optional) The description of the role",
+					},
+					cli.StringFlag{
+						Name:  "permissions",
+						Usage: "Comma separated list of permissions, e.g. job:read,job:cancel",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("name") || !c.IsSet("permissions") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					role, err := service.CreateRole(
+						c.String("name"),
+						c.String("description"),
+						split_permissions(c.String("permissions")))
+
+					if err != nil {
+						fmt.Printf("Failed to create role err='%v'\n", err)
+					} else {
+						fmt.Printf("Created role id=%v name=%v\n", role.ID, role.Name)
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "update",
+				Aliases: []string{"u"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the role",
+					},
+					cli.StringFlag{
+						Name:  "description",
+						Usage: "(Optional) The description of the role",
+					},
+					cli.StringFlag{
+						Name:  "permissions",
+						Usage: "Comma separated list of permissions, replaces the previous ones",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("permissions") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					err := service.UpdateRole(
+						c.Int64("id"),
+						c.String("description"),
+						split_permissions(c.String("permissions")))
+
+					if err != nil {
+						fmt.Printf("Failed to update role err='%v'\n", err)
+					} else {
+						fmt.Println("Role was updated")
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "delete",
+				Aliases: []string{"d"},
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the role",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := service.DeleteRole(c.Int64("id")); err != nil {
+						fmt.Printf("Failed to delete role err='%v'\n", err)
+					} else {
+						fmt.Println("Role was deleted")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 
@@ -33,15 +34,30 @@ func NewServiceCommand(
 
 					table := tablewriter.NewWriter(os.Stdout)
 
-					table.SetHeader([]string{"ID", "Name", "Description", "ApiKey(hashed)", "Enabled"})
+					table.SetHeader([]string{"ID", "Name", "Description", "ActiveApiKeys", "Enabled"})
 					table.SetFooter([]string{"", "", "", "Total", strconv.Itoa(len(modules))})
 					table.SetBorder(false)
+					now := time.Now()
 					for _, module := range modules {
+						keys, err := repo.GetApiKeys(module.ID)
+
+						if err != nil {
+							fmt.Println(err)
+							return nil
+						}
+
+						active_keys := 0
+						for _, key := range keys {
+							if key.IsActive(now) {
+								active_keys++
+							}
+						}
+
 						table.Append([]string{
 							strconv.FormatInt(module.ID, 10),
 							module.Name,
 							module.Description,
-							module.ApiKey,
+							strconv.Itoa(active_keys),
 							strconv.FormatBool(module.Enabled)})
 					}
 					table.Render()
@@ -72,8 +88,8 @@ func NewServiceCommand(
 					if err != nil {
 						fmt.Printf("Failed to create service err='%v'\n", err)
 					} else {
-						fmt.Printf("Service created with id=%v and api_key=%v\n",
-							module.ID, module.ApiKey)
+						fmt.Printf("Service created with id=%v api_key=%v and signing_secret=%v\n",
+							module.ID, module.ApiKey, module.SigningSecret)
 					}
 					return nil
 				},
@@ -161,7 +177,8 @@ func NewServiceCommand(
 				},
 			},
 			{
-				Name: "renew-api-key",
+				Name:  "keys",
+				Usage: "List the api keys of the service",
 				Flags: []cli.Flag{
 					cli.Int64Flag{
 						Name:  "id",
@@ -173,7 +190,131 @@ func NewServiceCommand(
 						return cli.ShowSubcommandHelp(c)
 					}
 
-					module, err := service.RenewApiKey(c.Int64("id"))
+					keys, err := repo.GetApiKeys(c.Int64("id"))
+
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					format_date := func(date *time.Time) string {
+						if date == nil {
+							return "-"
+						}
+						return date.Format(time.RFC3339)
+					}
+
+					table := tablewriter.NewWriter(os.Stdout)
+
+					table.SetHeader([]string{"ID", "Label", "Created", "Expires", "LastUsed", "Revoked", "Active"})
+					table.SetFooter([]string{"", "", "", "", "", "Total", strconv.Itoa(len(keys))})
+					table.SetBorder(false)
+					now := time.Now()
+					for _, key := range keys {
+						table.Append([]string{
+							strconv.FormatInt(key.ID, 10),
+							key.Label,
+							key.CreationDate.Format(time.RFC3339),
+							format_date(key.ExpirationDate),
+							format_date(key.LastUsed),
+							strconv.FormatBool(key.Revoked),
+							strconv.FormatBool(key.IsActive(now))})
+					}
+					table.Render()
+
+					return nil
+				},
+			},
+			{
+				Name:  "add-key",
+				Usage: "Create an api key for the service",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+					cli.StringFlag{
+						Name:  "label",
+						Usage: "What the key is used for",
+					},
+					cli.IntFlag{
+						Name:  "expires-in",
+						Usage: "Seconds until the key expires, it doesn't expire if it is omitted",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("label") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					var expiration *time.Time
+					if c.IsSet("expires-in") {
+						expiration = new(time.Time)
+						*expiration = time.Now().Add(time.Duration(c.Int("expires-in")) * time.Second)
+					}
+
+					module, key, err := service.CreateApiKey(c.Int64("id"), c.String("label"), expiration)
+
+					if err != nil {
+						fmt.Printf("Failed to create api key err='%v'\n", err)
+					} else {
+						fmt.Printf("Service id=%v api-key id=%v created '%v'\n",
+							module.ID, key.ID, module.ApiKey)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "revoke-key",
+				Usage: "Revoke an api key of the service",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+					cli.Int64Flag{
+						Name:  "key",
+						Usage: "The id of the api key",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("key") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					err := service.RevokeApiKey(c.Int64("id"), c.Int64("key"))
+
+					if err != nil {
+						fmt.Printf("Failed to revoke api key err='%v'\n", err)
+					} else {
+						fmt.Println("The api key was revoked")
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "renew-api-key",
+				Usage: "Create a new api key, the previous keys expire after the grace period",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+					cli.IntFlag{
+						Name:  "grace",
+						Usage: "Seconds that the previous keys keep working",
+						Value: int(sm.DefaultApiKeyGracePeriod / time.Second),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					module, err := service.RenewApiKey(c.Int64("id"),
+						time.Duration(c.Int("grace"))*time.Second)
 
 					if err != nil {
 						fmt.Printf("Failed to update service err='%v'\n", err)
@@ -182,6 +323,93 @@ func NewServiceCommand(
 							module.ID, module.ApiKey)
 					}
 
+					return nil
+				},
+			},
+			{
+				Name:  "renew-signing-secret",
+				Usage: "Change the secret that the requests to the service are signed with",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+					cli.IntFlag{
+						Name:  "grace",
+						Usage: "Seconds that the requests are also signed with the previous secret",
+						Value: int(sm.DefaultSigningSecretGracePeriod / time.Second),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					module, err := service.RenewSigningSecret(c.Int64("id"),
+						time.Duration(c.Int("grace"))*time.Second)
+
+					if err != nil {
+						fmt.Printf("Failed to update service err='%v'\n", err)
+					} else {
+						fmt.Printf("Service id=%v signing-secret changed to '%v'\n",
+							module.ID, module.SigningSecret)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "renew-legacy-key",
+				Usage: "Send a new key to a service that doesn't verify the signatures",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+					cli.IntFlag{
+						Name:  "grace",
+						Usage: "The seconds that the previous key is sent",
+						Value: int(sm.DefaultSigningSecretGracePeriod / time.Second),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					module, err := service.RenewLegacyKey(c.Int64("id"),
+						time.Duration(c.Int("grace"))*time.Second)
+
+					if err != nil {
+						fmt.Printf("Failed to update service err='%v'\n", err)
+					} else {
+						fmt.Printf("Service id=%v legacy-key changed to '%v'\n",
+							module.ID, module.LegacyKey)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "stop-legacy-key",
+				Usage: "Stop sending the api key to a service that verifies the signatures",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the service",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := service.StopLegacyKey(c.Int64("id")); err != nil {
+						fmt.Printf("Failed to update service err='%v'\n", err)
+					} else {
+						fmt.Println("The legacy key is no longer sent to the service")
+					}
+
 					return nil
 				},
 			},
@@ -5,12 +5,55 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"gitlab.arx.net/easytv/sm"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
+var retryFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "max-attempts",
+		Usage: "How many times the start request is sent before the job is aborted",
+	},
+	cli.Float64Flag{
+		Name:  "retry-backoff",
+		Usage: "Seconds to wait before the first retry, it doubles after every attempt",
+	},
+	cli.StringFlag{
+		Name:  "retry-status-codes",
+		Usage: "Comma separated http status codes that can be retried",
+	},
+}
+
+// Overrides the fields of the policy with the retry flags that are set
+func parse_retry_flags(c *cli.Context, retry *sm.RetryPolicy) error {
+	if c.IsSet("max-attempts") {
+		retry.MaxAttempts = c.Int("max-attempts")
+	}
+
+	if c.IsSet("retry-backoff") {
+		retry.BackoffBase = time.Duration(c.Float64("retry-backoff") * float64(time.Second))
+	}
+
+	if c.IsSet("retry-status-codes") {
+		retry.StatusCodes = make([]int, 0)
+		for _, str_code := range strings.Split(c.String("retry-status-codes"), ",") {
+			if str_code = strings.TrimSpace(str_code); str_code == "" {
+				continue
+			}
+			code, err := strconv.Atoi(str_code)
+			if err != nil {
+				return fmt.Errorf("\"%s\" is not a status code", str_code)
+			}
+			retry.StatusCodes = append(retry.StatusCodes, code)
+		}
+	}
+	return nil
+}
+
 func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Command {
 	return cli.Command{
 		Name:    "task",
@@ -21,7 +64,7 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 				Name:    "create",
 				Aliases: []string{"c"},
 				Usage:   "Create a new task",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					cli.Int64Flag{
 						Name:  "service",
 						Usage: "The id of the service this task should belong to",
@@ -50,7 +93,19 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 						Name:  "output",
 						Usage: "The output of the task encoded in JSON",
 					},
-				},
+					cli.Float64Flag{
+						Name:  "max-async-duration",
+						Usage: "Seconds that an asynchronous step can wait for the service to finish it, 0 for no limit",
+					},
+					cli.StringFlag{
+						Name:  "input-schema",
+						Usage: "A JSON Schema that the input is validated against",
+					},
+					cli.StringFlag{
+						Name:  "output-schema",
+						Usage: "A JSON Schema that the output is validated against",
+					},
+				}, retryFlags...),
 				Action: func(c *cli.Context) error {
 					if !c.IsSet("name") ||
 						!c.IsSet("description") ||
@@ -69,15 +124,10 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 						return nil
 					}
 
-					input := make(map[string]sm.ParamType)
+					input := make(map[string]sm.TaskParam)
 
 					for name, value := range input_json {
-						str_val, ok := value.(string)
-						if !ok {
-							fmt.Printf("\"%s\" should have a string value\n", name)
-							return nil
-						}
-						input[name] = sm.GetParamTypeFromSting(str_val)
+						input[name] = sm.ParseTaskParam(value)
 					}
 
 					var output_json map[string]interface{}
@@ -87,15 +137,30 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 						return nil
 					}
 
-					output := make(map[string]sm.ParamType)
+					output := make(map[string]sm.TaskParam)
 
 					for name, value := range output_json {
-						str_val, ok := value.(string)
-						if !ok {
-							fmt.Printf("\"%s\" should have a string value\n", name)
+						output[name] = sm.ParseTaskParam(value)
+					}
+
+					retry := sm.DefaultRetryPolicy
+					if err = parse_retry_flags(c, &retry); err != nil {
+						fmt.Printf("Failed to parse retry policy err='%v'\n", err)
+						return nil
+					}
+
+					var input_schema, output_schema sm.Schema
+					if c.IsSet("input-schema") {
+						if input_schema, err = sm.ParseSchema(c.String("input-schema")); err != nil {
+							fmt.Printf("Failed to parse 'input-schema' err='%v'\n", err)
+							return nil
+						}
+					}
+					if c.IsSet("output-schema") {
+						if output_schema, err = sm.ParseSchema(c.String("output-schema")); err != nil {
+							fmt.Printf("Failed to parse 'output-schema' err='%v'\n", err)
 							return nil
 						}
-						output[name] = sm.GetParamTypeFromSting(str_val)
 					}
 
 					task, err := service.RegisterTask(
@@ -106,6 +171,10 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 						c.String("cancel-url"),
 						input,
 						output,
+						input_schema,
+						output_schema,
+						retry,
+						time.Duration(c.Float64("max-async-duration")*float64(time.Second)),
 					)
 
 					if err != nil {
@@ -142,22 +211,28 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 
 					table := tablewriter.NewWriter(os.Stdout)
 
-					table.SetHeader([]string{"ID", "Name", "Description", "StartUrl", "CancelUrl", "Enabled", "Input", "Output"})
-					table.SetFooter([]string{"", "", "", "", "", "", "Total", strconv.Itoa(len(tasks))})
+					table.SetHeader([]string{"ID", "Version", "Name", "Description", "StartUrl", "CancelUrl", "Enabled", "Input", "Output", "Retry", "MaxAsyncDuration"})
+					table.SetFooter([]string{"", "", "", "", "", "", "", "", "", "Total", strconv.Itoa(len(tasks))})
 					table.SetBorder(false)
 					for _, task := range tasks {
-						input_json, _ := json.Marshal(task.Input)
-						output_json, _ := json.Marshal(task.Output)
+						input_json, _ := json.Marshal(sm.DescribeParams(task.Input))
+						output_json, _ := json.Marshal(sm.DescribeParams(task.Output))
 
 						table.Append([]string{
 							strconv.FormatInt(task.ID, 10),
+							strconv.Itoa(task.Version),
 							task.Name,
 							task.Description,
 							task.StartUrl,
 							task.CancelUrl,
 							strconv.FormatBool(task.Enabled),
 							string(input_json),
-							string(output_json)})
+							string(output_json),
+							fmt.Sprintf("%d attempts, %v backoff, codes %v",
+								task.Retry.MaxAttempts,
+								task.Retry.BackoffBase,
+								task.Retry.StatusCodes),
+							task.MaxAsyncDuration.String()})
 					}
 					table.Render()
 
@@ -303,9 +378,78 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 					return nil
 				},
 			},
+			{
+				Name:  "set-retry",
+				Usage: "Change the retry policy of the task",
+				Flags: append([]cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the task",
+					},
+				}, retryFlags...),
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") ||
+						(!c.IsSet("max-attempts") && !c.IsSet("retry-backoff") && !c.IsSet("retry-status-codes")) {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					task, err := repository.GetTask(c.Int64("id"))
+
+					if err != nil {
+						fmt.Printf("Failed to get task err='%v'\n", err)
+						return nil
+					} else if task == nil {
+						fmt.Println("The task doesn't exist")
+						return nil
+					}
+
+					retry := task.Retry
+					if err = parse_retry_flags(c, &retry); err != nil {
+						fmt.Printf("Failed to parse retry policy err='%v'\n", err)
+						return nil
+					}
+
+					err = service.UpdateRetryPolicy(task.ID, retry)
+					if err != nil {
+						fmt.Printf("Failed to update retry policy err='%v'\n", err)
+					} else {
+						fmt.Println("The retry policy was updated")
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "set-timeout",
+				Usage: "Change how long an asynchronous step of the task can take",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the task",
+					},
+					cli.Float64Flag{
+						Name:  "max-async-duration",
+						Usage: "Seconds that an asynchronous step can wait for the service to finish it, 0 for no limit",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || !c.IsSet("max-async-duration") {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					err := service.UpdateMaxAsyncDuration(c.Int64("id"),
+						time.Duration(c.Float64("max-async-duration")*float64(time.Second)))
+
+					if err != nil {
+						fmt.Printf("Failed to update max async duration err='%v'\n", err)
+					} else {
+						fmt.Println("The max async duration was updated")
+					}
+					return nil
+				},
+			},
 			{
 				Name:  "set-vars",
-				Usage: "Change the input or output of the task",
+				Usage: "Change the input or output of the task, a disabled task is required unless the change is compatible",
 				Flags: []cli.Flag{
 					cli.Int64Flag{
 						Name:  "id",
@@ -333,15 +477,10 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 							return nil
 						}
 
-						input := make(map[string]sm.ParamType)
+						input := make(map[string]sm.TaskParam)
 
 						for name, value := range input_json {
-							str_val, ok := value.(string)
-							if !ok {
-								fmt.Printf("\"%s\" should have a string value\n", name)
-								return nil
-							}
-							input[name] = sm.GetParamTypeFromSting(str_val)
+							input[name] = sm.ParseTaskParam(value)
 						}
 
 						err = service.UpdateVars(c.Int64("id"), input, true)
@@ -360,15 +499,10 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 							return nil
 						}
 
-						output := make(map[string]sm.ParamType)
+						output := make(map[string]sm.TaskParam)
 
 						for name, value := range output_json {
-							str_val, ok := value.(string)
-							if !ok {
-								fmt.Printf("\"%s\" should have a string value\n", name)
-								return nil
-							}
-							output[name] = sm.GetParamTypeFromSting(str_val)
+							output[name] = sm.ParseTaskParam(value)
 						}
 
 						err = service.UpdateVars(c.Int64("id"), output, false)
@@ -381,6 +515,58 @@ func NewTaskCommand(service sm.TaskService, repository sm.TaskRepository) cli.Co
 
 					fmt.Println("The task was updated")
 
+					return nil
+				},
+			},
+			{
+				Name:  "set-schema",
+				Usage: "Change the JSON Schema of the input or output of the task",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "id",
+						Usage: "The id of the task",
+					},
+					cli.StringFlag{
+						Name:  "input",
+						Usage: "The schema of the input, an empty string removes it",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Usage: "The schema of the output, an empty string removes it",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("id") || (!c.IsSet("input") && !c.IsSet("output")) {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					for _, is_input := range []bool{true, false} {
+						flag := "output"
+						if is_input {
+							flag = "input"
+						}
+
+						if !c.IsSet(flag) {
+							continue
+						}
+
+						var schema sm.Schema
+						var err error
+						if len(c.String(flag)) > 0 {
+							if schema, err = sm.ParseSchema(c.String(flag)); err != nil {
+								fmt.Printf("Failed to parse '%s' err='%v'\n", flag, err)
+								return nil
+							}
+						}
+
+						if err = service.UpdateSchema(c.Int64("id"), schema, is_input); err != nil {
+							fmt.Printf("Failed to update %s schema err='%v'\n", flag, err)
+							return nil
+						}
+					}
+
+					fmt.Println("The task was updated")
+
 					return nil
 				},
 			},
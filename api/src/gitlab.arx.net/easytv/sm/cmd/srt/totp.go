@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"gitlab.arx.net/easytv/sm"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func NewTotpCommand(service sm.TotpService) cli.Command {
+	return cli.Command{
+		Name:  "totp",
+		Usage: "actions about the two factor authentication",
+		Subcommands: []cli.Command{
+			{
+				Name:  "reset",
+				Usage: "remove the two factor authentication of a user that lost the app and the recovery codes",
+				Flags: []cli.Flag{
+					cli.Int64Flag{
+						Name:  "admin",
+						Usage: "The id of the admin",
+					},
+					cli.Int64Flag{
+						Name:  "owner",
+						Usage: "The id of the content owner",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var user_role int
+					var user_id int64
+
+					if c.IsSet("admin") {
+						user_role, user_id = sm.RoleAdmin, c.Int64("admin")
+					} else if c.IsSet("owner") {
+						user_role, user_id = sm.RoleContentOwner, c.Int64("owner")
+					} else {
+						return cli.ShowSubcommandHelp(c)
+					}
+
+					if err := service.Reset(user_role, user_id); err != nil {
+						fmt.Printf("Failed to reset err='%v'\n", err)
+					} else {
+						fmt.Println("Two factor authentication was reset")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -10,6 +10,12 @@ const (
 	EasyTVApiKeyHeader  = "X-Easytv-Key"
 	EasyTVSessionHeader = "X-Easytv-Session"
 
+	// Headers of the webhook deliveries
+	EasyTVEventHeader     = "X-Easytv-Event"
+	EasyTVDeliveryHeader  = "X-Easytv-Delivery"
+	EasyTVTimestampHeader = "X-Easytv-Timestamp"
+	EasyTVSignatureHeader = "X-Easytv-Signature"
+
 	SessionExpiration = 60 * 20 // 20 minutes
 
 	RoleAdmin        = 0
@@ -20,6 +26,7 @@ const (
 	// Generic errors
 	CodeMissingInput        = -400
 	CodeNoSession           = -401
+	CodeForbidden           = -403
 	CodeNotFound            = -404
 	CodeInternalServerError = -500
 
@@ -51,4 +58,38 @@ const (
 	CodePasswordIsTooShort                 = -27
 	CodeInvalidCredentials                 = -28
 	CodeNewPasswordDoesntMatchVerification = -29
+	CodeInvalidRetryPolicy                 = -30
+	CodeInvalidMaxAsyncDuration            = -31
+	CodeInvalidDependency                  = -32
+	CodeInvalidLinkedStep                  = -33
+	CodeInvalidTemplate                    = -34
+	CodeMissingTemplateVariable            = -35
+	CodeInvalidCallbackUrl                 = -36
+	CodeInvalidSchema                      = -37
+	CodeInvalidApiKeyExpiration            = -38
+	CodeInvalidGracePeriod                 = -39
+	CodeRoleNameExists                     = -40
+	CodeInvalidPermission                  = -41
+	CodeRoleIsBuiltIn                      = -42
+	CodeRoleIsInUse                        = -43
+	CodeNotAMember                         = -44
+	CodeOrganisationNameExists             = -45
+	CodeInvalidInvitation                  = -46
+	CodeLastMember                         = -47
+	CodeInvalidMemberRole                  = -48
+	CodeInvalidScope                       = -49
+	CodeInvalidTokenExpiration             = -50
+	CodeLoginLocked                        = -51
+	CodeTotpRequired                       = -52
+	CodeInvalidTotpCode                    = -53
+	CodeTotpAlreadyEnabled                 = -54
+	CodeTotpNotEnrolled                    = -55
+	CodeInvalidResetToken                  = -56
+	CodeUploadOffsetMismatch               = -57
+	CodeUploadIncomplete                   = -58
+	CodeChecksumMismatch                   = -59
+	CodeInvalidAssetLink                   = -60
+	CodeAssetLinkExpired                   = -61
+	CodeAssetExists                        = -62
+	CodeAssetInUse                         = -63
 )
@@ -2,6 +2,7 @@ package sm
 
 import (
 	"errors"
+	"time"
 )
 
 type ContentOwner struct {
@@ -10,6 +11,11 @@ type ContentOwner struct {
 	Password string
 	Email    string
 	Name     string
+	// The role of the user in its organisation, nil for the default role
+	RoleID *int64
+	// The organisation that owns the jobs of the user,
+	// nil if the user was removed from it
+	OrganisationID *int64
 }
 
 type ContentOwnerRepository interface {
@@ -21,12 +27,20 @@ type ContentOwnerRepository interface {
 
 	GetContentOwnerByUsername(username string) (*ContentOwner, error)
 
+	GetContentOwnerByEmail(email string) (*ContentOwner, error)
+
 	GetContentOwnerByID(owner *ContentOwner) error
 
 	Insert(owner *ContentOwner) error
 
 	SavePassword(owner *ContentOwner) error
 
+	// The sessions that started before the date are refused
+	RevokeSessions(owner_id int64, date time.Time) error
+
+	// Returns when the sessions were revoked last, nil if they never were
+	GetSessionsRevokedDate(owner_id int64) (*time.Time, error)
+
 	Save(owner *ContentOwner) error
 
 	GetAll() ([]*ContentOwner, error)
@@ -43,10 +57,15 @@ var ErrOwnerUsernameExists = errors.New("Owner username exists")
 
 // service
 type ContentOwnerService interface {
+	// Creates the owner with an organisation of the same name
 	CreateContentOwner(name, username, email, password string) (*ContentOwner, error)
 
+	// Creates a user in an existing organisation
+	CreateMember(organisation_id int64, name, username, email, password string) (*ContentOwner, error)
+
 	GenerateRandomPassword(n int) string
 
+	// Fails with ErrNotAMember for the users without an organisation
 	Login(username, password string) (*ContentOwner, error)
 
 	ChangePassword(user_id int64, old_password, new_password string) error
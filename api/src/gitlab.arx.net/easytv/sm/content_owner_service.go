@@ -17,14 +17,17 @@ const (
 )
 
 type coservice struct {
-	repository ContentOwnerRepository
-	rand_src   rand.Source
+	repository              ContentOwnerRepository
+	organisation_repository OrganisationRepository
+	rand_src                rand.Source
 }
 
-func NewContentOwnerService(repo ContentOwnerRepository) ContentOwnerService {
+func NewContentOwnerService(repo ContentOwnerRepository,
+	organisation_repository OrganisationRepository) ContentOwnerService {
 	return &coservice{
-		repository: repo,
-		rand_src:   rand.NewSource(time.Now().UnixNano()),
+		repository:              repo,
+		organisation_repository: organisation_repository,
+		rand_src:                rand.NewSource(time.Now().UnixNano()),
 	}
 }
 
@@ -50,31 +53,69 @@ func (this *coservice) GenerateRandomPassword(n int) string {
 func (this *coservice) CreateContentOwner(
 	name, username, email, password string) (*ContentOwner, error) {
 
+	if err := this.check(name, username, email); err != nil {
+		return nil, err
+	}
+
+	organisation, err := create_organisation(this.organisation_repository, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this.create(name, username, email, password, organisation.ID)
+}
+
+func (this *coservice) CreateMember(organisation_id int64,
+	name, username, email, password string) (*ContentOwner, error) {
+
+	organisation, err := this.organisation_repository.GetByID(organisation_id)
+
+	if err != nil {
+		return nil, err
+	} else if organisation == nil {
+		return nil, ErrNotFound
+	}
+
+	if err = this.check(name, username, email); err != nil {
+		return nil, err
+	}
+
+	return this.create(name, username, email, password, organisation.ID)
+}
+
+// Checks that the fields of a new owner are valid and not in use
+func (this *coservice) check(name, username, email string) error {
 	if len(name) < 2 {
-		return nil, ErrOwnerNameTooShort
+		return ErrOwnerNameTooShort
 	} else if len(username) < 2 {
-		return nil, ErrOwnerUsernameTooShort
+		return ErrOwnerUsernameTooShort
 	} else if len(email) < 3 {
-		return nil, ErrOwnerEmailTooShort
+		return ErrOwnerEmailTooShort
 	}
 
 	if exists, err := this.repository.NameExists(name); err != nil {
-		return nil, err
+		return err
 	} else if exists {
-		return nil, ErrOwnerNameExists
+		return ErrOwnerNameExists
 	}
 
 	if exists, err := this.repository.EmailExists(email); err != nil {
-		return nil, err
+		return err
 	} else if exists {
-		return nil, ErrOwnerEmailExists
+		return ErrOwnerEmailExists
 	}
 
 	if exists, err := this.repository.UsernameExists(username); err != nil {
-		return nil, err
+		return err
 	} else if exists {
-		return nil, ErrOwnerUsernameExists
+		return ErrOwnerUsernameExists
 	}
+	return nil
+}
+
+func (this *coservice) create(
+	name, username, email, password string, organisation_id int64) (*ContentOwner, error) {
 
 	hashed_password, err := bcrypt.GenerateFromPassword(
 		[]byte(password), bcrypt.DefaultCost)
@@ -84,13 +125,15 @@ func (this *coservice) CreateContentOwner(
 	}
 
 	owner := ContentOwner{
-		Name:     name,
-		Username: username,
-		Email:    email,
-		Password: string(hashed_password),
+		Name:           name,
+		Username:       username,
+		Email:          email,
+		Password:       string(hashed_password),
+		OrganisationID: &organisation_id,
 	}
 
-	log.Infof("creating content owner username=%v name=%v email=%v", username, name, email)
+	log.Infof("creating content owner username=%v name=%v email=%v organisation=%v",
+		username, name, email, organisation_id)
 
 	err = this.repository.Insert(&owner)
 	if err != nil {
@@ -119,6 +162,10 @@ func (this *coservice) Login(username, password string) (*ContentOwner, error) {
 		return nil, nil
 	}
 
+	if user.OrganisationID == nil {
+		return nil, ErrNotAMember
+	}
+
 	return user, nil
 }
 
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type PersonalAccessTokenRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *PersonalAccessTokenRepository) Insert(token *sm.PersonalAccessToken) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into personal_access_token (
+			owner_id,
+			name,
+			hashed_token,
+			scopes,
+			creation_date,
+			expiration_date,
+			revoked)
+		values ($1, $2, $3, $4, $5, $6, false)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	// The scopes are stored like the permissions of the roles
+	row := stmt.QueryRow(
+		token.OwnerID,
+		token.Name,
+		token.HashedToken,
+		strings.Join(token.Scopes, ","),
+		token.CreationDate,
+		token.ExpirationDate)
+
+	return row.Scan(&token.ID)
+}
+
+func (this *PersonalAccessTokenRepository) GetByID(id int64) (*sm.PersonalAccessToken, error) {
+	stmt, err := this.Pool.Prepare(`
+		select owner_id, name, hashed_token, scopes, creation_date,
+			expiration_date, last_used, revoked
+		from personal_access_token
+		where id=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := sm.PersonalAccessToken{ID: id}
+	var scopes string
+
+	err = stmt.QueryRow(id).Scan(
+		&token.OwnerID,
+		&token.Name,
+		&token.HashedToken,
+		&scopes,
+		&token.CreationDate,
+		&token.ExpirationDate,
+		&token.LastUsed,
+		&token.Revoked)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = parse_permissions(scopes)
+
+	return &token, nil
+}
+
+func (this *PersonalAccessTokenRepository) GetForOwner(
+	owner_id int64) ([]*sm.PersonalAccessToken, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		select id, name, hashed_token, scopes, creation_date,
+			expiration_date, last_used, revoked
+		from personal_access_token
+		where owner_id=$1
+		order by id desc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(owner_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := make([]*sm.PersonalAccessToken, 0)
+
+	for rows.Next() {
+		token := sm.PersonalAccessToken{OwnerID: owner_id}
+		var scopes string
+
+		err = rows.Scan(
+			&token.ID,
+			&token.Name,
+			&token.HashedToken,
+			&scopes,
+			&token.CreationDate,
+			&token.ExpirationDate,
+			&token.LastUsed,
+			&token.Revoked)
+
+		if err != nil {
+			return nil, err
+		}
+
+		token.Scopes = parse_permissions(scopes)
+
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, nil
+}
+
+func (this *PersonalAccessTokenRepository) Use(hashed_token string) (*sm.PersonalAccessToken, error) {
+	stmt, err := this.Pool.Prepare(`
+		update personal_access_token set
+			last_used=$2
+		where
+			hashed_token=$1 and
+			not revoked and
+			(expiration_date is null or expiration_date>$2)
+		returning id, owner_id, name, scopes, creation_date, expiration_date, last_used
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := sm.PersonalAccessToken{HashedToken: hashed_token}
+	var scopes string
+
+	err = stmt.QueryRow(hashed_token, time.Now()).Scan(
+		&token.ID,
+		&token.OwnerID,
+		&token.Name,
+		&scopes,
+		&token.CreationDate,
+		&token.ExpirationDate,
+		&token.LastUsed)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = parse_permissions(scopes)
+
+	return &token, nil
+}
+
+func (this *PersonalAccessTokenRepository) RevokeForOwner(owner_id int64) error {
+	stmt, err := this.Pool.Prepare(`
+		update personal_access_token set
+		revoked=true
+		where owner_id=$1 and not revoked
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id)
+
+	return err
+}
+
+func (this *PersonalAccessTokenRepository) Save(token *sm.PersonalAccessToken) error {
+	stmt, err := this.Pool.Prepare(`
+		update personal_access_token set
+		revoked=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(token.ID, token.Revoked)
+
+	return err
+}
@@ -12,7 +12,7 @@ type AdminRepository struct {
 
 func (this *AdminRepository) GetByID(id int64) (*sm.AdminUser, error) {
 	stmt, err := this.Pool.Prepare(`
-		select username, password
+		select username, password, role_id
 		from admin_user
 		where id=$1
 	`)
@@ -25,7 +25,7 @@ func (this *AdminRepository) GetByID(id int64) (*sm.AdminUser, error) {
 
 	user := sm.AdminUser{ID: id}
 
-	err = row.Scan(&user.Username, &user.Password)
+	err = row.Scan(&user.Username, &user.Password, &user.RoleID)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -37,7 +37,7 @@ func (this *AdminRepository) GetByID(id int64) (*sm.AdminUser, error) {
 
 func (this *AdminRepository) GetByUsername(username string) (*sm.AdminUser, error) {
 	stmt, err := this.Pool.Prepare(`
-		select id, password
+		select id, password, role_id
 		from admin_user
 		where username=$1
 	`)
@@ -50,7 +50,7 @@ func (this *AdminRepository) GetByUsername(username string) (*sm.AdminUser, erro
 
 	user := sm.AdminUser{Username: username}
 
-	err = row.Scan(&user.ID, &user.Password)
+	err = row.Scan(&user.ID, &user.Password, &user.RoleID)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -91,3 +91,31 @@ func (this *AdminRepository) SavePassword(admin *sm.AdminUser) error {
 
 	return err
 }
+
+func (this *AdminRepository) GetAll() ([]*sm.AdminUser, error) {
+	rows, err := this.Pool.DB.Query(`
+		select id, username, role_id
+		from admin_user
+		order by id asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := make([]*sm.AdminUser, 0)
+
+	for rows.Next() {
+		user := sm.AdminUser{}
+
+		if err = rows.Scan(&user.ID, &user.Username, &user.RoleID); err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
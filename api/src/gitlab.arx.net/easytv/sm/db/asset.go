@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	"gitlab.arx.net/easytv/sm"
 )
@@ -10,103 +11,265 @@ type AssetRepository struct {
 	Pool *DatabasePool
 }
 
-func (this *AssetRepository) Create(asset *sm.Asset) error {
-	stmt, err := this.Pool.Prepare(`
-		insert into asset (job_id, path, size, url_param)
-		values ($1, $2, $3, $4)
-		returning id
-	`)
+// The columns that scan_asset reads
+const asset_columns = `
+	id, coalesce(job_id, 0), coalesce(organisation_id, 0), coalesce(owner_id, 0),
+	path, size, url_param, checksum, complete, uploaded_size,
+	original_name, version, mime_type, coalesce(module_id, 0), coalesce(step_id, 0),
+	duplicate_policy`
+
+type asset_scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan_asset(row asset_scanner) (*sm.Asset, error) {
+	asset := sm.Asset{}
+
+	err := row.Scan(
+		&asset.ID,
+		&asset.JobID,
+		&asset.OrganisationID,
+		&asset.OwnerID,
+		&asset.Path,
+		&asset.Size,
+		&asset.UrlParam,
+		&asset.Checksum,
+		&asset.Complete,
+		&asset.UploadedSize,
+		&asset.OriginalName,
+		&asset.Version,
+		&asset.MimeType,
+		&asset.ModuleID,
+		&asset.StepID,
+		&asset.DuplicatePolicy)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &asset, nil
+}
+
+func (this *AssetRepository) query(query string, args ...interface{}) ([]*sm.Asset, error) {
+	stmt, err := this.Pool.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	assets := make([]*sm.Asset, 0)
+
+	for rows.Next() {
+		asset, err := scan_asset(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+func (this *AssetRepository) queryRow(query string, args ...interface{}) (*sm.Asset, error) {
+	stmt, err := this.Pool.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := scan_asset(stmt.QueryRow(args...))
 
-	row := stmt.QueryRow(
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// An asset that takes a name of another asset in the same state isn't inserted
+const insert_asset = `
+	insert into asset (job_id, organisation_id, owner_id, path, size, url_param,
+		checksum, complete, uploaded_size, original_name, version, mime_type,
+		module_id, step_id, duplicate_policy)
+	values (nullif($1, 0), nullif($2, 0), nullif($3, 0), $4, $5, $6, $7, $8, $9,
+		$10, $11, $12, nullif($13, 0), nullif($14, 0), $15)
+	on conflict do nothing
+	returning id`
+
+func insert_asset_args(asset *sm.Asset) []interface{} {
+	return []interface{}{
 		asset.JobID,
+		asset.OrganisationID,
+		asset.OwnerID,
 		asset.Path,
 		asset.Size,
-		asset.UrlParam)
-
-	return row.Scan(&asset.ID)
+		asset.UrlParam,
+		asset.Checksum,
+		asset.Complete,
+		asset.UploadedSize,
+		asset.OriginalName,
+		asset.Version,
+		asset.MimeType,
+		asset.ModuleID,
+		asset.StepID,
+		asset.DuplicatePolicy,
+	}
 }
 
-func (this *AssetRepository) GetAssetsForJob(job_id int64, assets *[]*sm.Asset) error {
-	stmt, err := this.Pool.Prepare(`
-		select id, path, size, url_param
-		from asset
-		where job_id=$1
-	`)
+func (this *AssetRepository) Create(asset *sm.Asset) error {
+	stmt, err := this.Pool.Prepare(insert_asset)
 
 	if err != nil {
 		return err
 	}
 
-	rows, err := stmt.Query(job_id)
+	err = stmt.QueryRow(insert_asset_args(asset)...).Scan(&asset.ID)
+
+	if err == sql.ErrNoRows {
+		return sm.ErrAssetExists
+	}
+	return err
+}
+
+func (this *AssetRepository) Replace(replaced_id int64, asset *sm.Asset) error {
+	tx, err := this.Pool.DB.Begin()
 
 	if err != nil {
 		return err
 	}
 
-	defer rows.Close()
+	result, err := tx.Exec(`delete from asset where id=$1 and complete`, replaced_id)
 
-	for rows.Next() {
-		asset := sm.Asset{
-			JobID: job_id,
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if count, err := result.RowsAffected(); err != nil || count != 1 {
+		// Another asset replaced it first
+		tx.Rollback()
+		if err == nil {
+			err = sm.ErrAssetExists
 		}
-		err = rows.Scan(
-			&asset.ID,
-			&asset.Path,
-			&asset.Size,
-			&asset.UrlParam)
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	err = tx.QueryRow(insert_asset, insert_asset_args(asset)...).Scan(&asset.ID)
 
-		*assets = append(*assets, &asset)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			err = sm.ErrAssetExists
+		}
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (this *AssetRepository) GetAssetsForUser(content_owner_id int64, assets *[]*sm.Asset) error {
-	stmt, err := this.Pool.Prepare(`
-		select asset.id, asset.path, asset.job_id, asset.size, asset.url_param
+func (this *AssetRepository) GetAssetsForJob(job_id int64, assets *[]*sm.Asset) error {
+	result, err := this.query(`
+		select `+asset_columns+`
 		from asset
-		inner join job
-		on job.id=asset.job_id
-		where job.owner_id=$1
-	`)
+		where job_id=$1 and complete
+		order by id
+	`, job_id)
 
 	if err != nil {
 		return err
 	}
 
-	rows, err := stmt.Query(content_owner_id)
+	*assets = append(*assets, result...)
+
+	return nil
+}
+
+func (this *AssetRepository) GetAssetsForOrganisation(organisation_id int64, assets *[]*sm.Asset) error {
+	result, err := this.query(`
+		select `+asset_columns+`
+		from asset
+		where job_id in (select id from job where organisation_id=$1) and complete
+	`, organisation_id)
 
 	if err != nil {
 		return err
 	}
 
-	defer rows.Close()
+	*assets = append(*assets, result...)
 
-	for rows.Next() {
-		asset := sm.Asset{}
-		err = rows.Scan(
-			&asset.ID,
-			&asset.Path,
-			&asset.JobID,
-			&asset.Size,
-			&asset.UrlParam)
+	return nil
+}
 
-		if err != nil {
-			return err
-		}
+func (this *AssetRepository) GetOwnerAssets(organisation_id int64) ([]*sm.Asset, error) {
+	return this.query(`
+		select `+asset_columns+`
+		from asset
+		where organisation_id=$1 and job_id is null and complete
+		order by id desc
+	`, organisation_id)
+}
+
+// The condition that the asset url is in the input of a job that isn't
+// finished, $1 is the type of the asset parameters
+func asset_in_active_job(url string) string {
+	return `
+	exists (
+		select 1 from job_param p
+		inner join job_step s
+			on s.id=p.job_step_id
+		inner join job j
+			on j.id=s.job_id
+		where
+			p.is_input and
+			p.data_type=$1 and
+			p.value=` + url + ` and
+			not j.is_completed and
+			not j.is_canceled
+	)`
+}
+
+func (this *AssetRepository) GetExpiredOwnerAssets(before time.Time) ([]*sm.Asset, error) {
+	return this.query(`
+		select `+asset_columns+`
+		from asset a
+		where
+			job_id is null and
+			creation_date<$2 and
+			not `+asset_in_active_job(`'`+sm.AssetUrlPrefix+`' || a.url_param`)+`
+		order by id
+	`, sm.AssetParam, before)
+}
 
-		*assets = append(*assets, &asset)
+func (this *AssetRepository) IsUsedByActiveJob(url string) (bool, error) {
+	stmt, err := this.Pool.Prepare(`select ` + asset_in_active_job("$2"))
+
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	var in_use bool
+	err = stmt.QueryRow(sm.AssetParam, url).Scan(&in_use)
+
+	return in_use, err
+}
+
+func (this *AssetRepository) GetLatestVersion(job_id, organisation_id int64, name string) (*sm.Asset, error) {
+	return this.queryRow(`
+		select `+asset_columns+`
+		from asset
+		where original_name=$3 and complete and
+			(job_id=$1 or ($1=0 and job_id is null and organisation_id=$2))
+		order by version desc
+		limit 1
+	`, job_id, organisation_id, name)
 }
 
 func (this *AssetRepository) DeleteAssetsForJob(job_id int64) error {
@@ -125,63 +288,137 @@ func (this *AssetRepository) DeleteAssetsForJob(job_id int64) error {
 }
 
 func (this *AssetRepository) GetAsset(asset_id int64) (*sm.Asset, error) {
-	stmt, err := this.Pool.Prepare(`
-		select path, job_id, size, url_param
+	return this.queryRow(`
+		select `+asset_columns+`
 		from asset
 		where id=$1
+	`, asset_id)
+}
+
+func (this *AssetRepository) GetAssetByUrlParam(url_param string) (*sm.Asset, error) {
+	return this.queryRow(`
+		select `+asset_columns+`
+		from asset
+		where url_param=$1 and complete
+	`, url_param)
+}
+
+func (this *AssetRepository) GetUploadByUrlParam(url_param string) (*sm.Asset, error) {
+	return this.queryRow(`
+		select `+asset_columns+`
+		from asset
+		where url_param=$1 and not complete
+	`, url_param)
+}
+
+func (this *AssetRepository) AdvanceUpload(asset_id, offset, new_offset int64) (bool, error) {
+	stmt, err := this.Pool.Prepare(`
+		update asset set
+			uploaded_size=$3
+		where id=$1 and uploaded_size=$2 and not complete
 	`)
 
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	row := stmt.QueryRow(asset_id)
+	result, err := stmt.Exec(asset_id, offset, new_offset)
 
-	asset := sm.Asset{
-		ID: asset_id,
+	if err != nil {
+		return false, err
 	}
 
-	err = row.Scan(
-		&asset.Path,
-		&asset.JobID,
-		&asset.Size,
-		&asset.UrlParam)
+	count, err := result.RowsAffected()
 
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	return count == 1, err
+}
+
+func (this *AssetRepository) CompleteUpload(asset *sm.Asset, replaced_id int64) (bool, error) {
+	tx, err := this.Pool.DB.Begin()
+
+	if err != nil {
+		return false, err
 	}
-	return &asset, nil
+
+	if replaced_id != 0 {
+		result, err := tx.Exec(`delete from asset where id=$1 and complete`, replaced_id)
+
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		} else if count, err := result.RowsAffected(); err != nil || count != 1 {
+			// Another asset replaced it first
+			tx.Rollback()
+			if err == nil {
+				err = sm.ErrAssetExists
+			}
+			return false, err
+		}
+	}
+
+	// The name can't be taken by another complete asset
+	var taken bool
+
+	err = tx.QueryRow(`
+		select exists (select 1 from asset where url_param=$1 and complete)
+	`, asset.UrlParam).Scan(&taken)
+
+	if err != nil || taken {
+		tx.Rollback()
+		if err == nil {
+			err = sm.ErrAssetExists
+		}
+		return false, err
+	}
+
+	result, err := tx.Exec(`
+		update asset set
+			checksum=$2,
+			mime_type=$3,
+			complete=true
+		where id=$1 and not complete
+	`, asset.ID, asset.Checksum, asset.MimeType)
+
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if count, err := result.RowsAffected(); err != nil || count != 1 {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
 }
 
-func (this *AssetRepository) GetAssetByUrlParam(url_param string) (*sm.Asset, error) {
+func (this *AssetRepository) ResetUpload(asset_id int64) error {
 	stmt, err := this.Pool.Prepare(`
-		select id, path, job_id, size
-		from asset
-		where url_param=$1
+		update asset set
+			uploaded_size=0
+		where id=$1 and not complete
 	`)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	row := stmt.QueryRow(url_param)
+	_, err = stmt.Exec(asset_id)
 
-	asset := sm.Asset{
-		UrlParam: url_param,
-	}
+	return err
+}
 
-	err = row.Scan(
-		&asset.ID,
-		&asset.Path,
-		&asset.JobID,
-		&asset.Size)
+func (this *AssetRepository) DeleteAsset(asset_id int64) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from asset
+		where id=$1
+	`)
 
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	if err != nil {
+		return err
 	}
-	return &asset, nil
+
+	_, err = stmt.Exec(asset_id)
+
+	return err
 }
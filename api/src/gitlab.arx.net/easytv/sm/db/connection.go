@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	_ "github.com/lib/pq"
 )
@@ -12,6 +13,8 @@ import (
 type DatabasePool struct {
 	DB         *sql.DB
 	stmt_cache map[string]*sql.Stmt
+	// The pool is shared by the http handlers and the job workers
+	cache_lock sync.Mutex
 }
 
 func Open() (*DatabasePool, error) {
@@ -52,6 +55,9 @@ func Open() (*DatabasePool, error) {
 }
 
 func (this *DatabasePool) Prepare(query string) (*sql.Stmt, error) {
+	this.cache_lock.Lock()
+	defer this.cache_lock.Unlock()
+
 	if stmt, ok := this.stmt_cache[query]; ok {
 		return stmt, nil
 	}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"gitlab.arx.net/easytv/sm"
 )
@@ -13,7 +14,7 @@ type ContentOwnerRepository struct {
 
 func (this *ContentOwnerRepository) GetContentOwnerByID(owner *sm.ContentOwner) error {
 	stmt, err := this.Pool.Prepare(`
-		select username, email, name, password
+		select username, email, name, password, role_id, organisation_id
 		from content_owner
 		where id=$1
 	`)
@@ -28,14 +29,21 @@ func (this *ContentOwnerRepository) GetContentOwnerByID(owner *sm.ContentOwner)
 		&owner.Username,
 		&owner.Email,
 		&owner.Name,
-		&owner.Password)
+		&owner.Password,
+		&owner.RoleID,
+		&owner.OrganisationID)
+
+	// The owner is left empty when it doesn't exist
+	if err == sql.ErrNoRows {
+		return nil
+	}
 
 	return err
 }
 
 func (this *ContentOwnerRepository) GetAll() ([]*sm.ContentOwner, error) {
 	rows, err := this.Pool.DB.Query(`
-		select id, username, email, name, password
+		select id, username, email, name, password, role_id, organisation_id
 		from content_owner
 	`)
 
@@ -53,7 +61,9 @@ func (this *ContentOwnerRepository) GetAll() ([]*sm.ContentOwner, error) {
 			&user.Username,
 			&user.Email,
 			&user.Name,
-			&user.Password)
+			&user.Password,
+			&user.RoleID,
+			&user.OrganisationID)
 
 		if err != nil {
 			return nil, err
@@ -68,7 +78,7 @@ func (this *ContentOwnerRepository) GetAll() ([]*sm.ContentOwner, error) {
 func (this *ContentOwnerRepository) GetContentOwnerByUsername(
 	username string) (*sm.ContentOwner, error) {
 	stmt, err := this.Pool.Prepare(`
-		select id, email, name, password
+		select id, email, name, password, role_id, organisation_id
 		from content_owner
 		where username=$1
 	`)
@@ -85,7 +95,41 @@ func (this *ContentOwnerRepository) GetContentOwnerByUsername(
 		&owner.ID,
 		&owner.Email,
 		&owner.Name,
-		&owner.Password)
+		&owner.Password,
+		&owner.RoleID,
+		&owner.OrganisationID)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+func (this *ContentOwnerRepository) GetContentOwnerByEmail(
+	email string) (*sm.ContentOwner, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, username, name, password, role_id, organisation_id
+		from content_owner
+		where email=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRow(email)
+
+	owner := sm.ContentOwner{Email: email}
+
+	err = row.Scan(
+		&owner.ID,
+		&owner.Username,
+		&owner.Name,
+		&owner.Password,
+		&owner.RoleID,
+		&owner.OrganisationID)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -134,8 +178,8 @@ func (this *ContentOwnerRepository) EmailExists(email string) (bool, error) {
 
 func (this *ContentOwnerRepository) Insert(owner *sm.ContentOwner) error {
 	stmt, err := this.Pool.Prepare(`
-		insert into content_owner (username, name, password, email)
-		values ($1, $2, $3, $4)
+		insert into content_owner (username, name, password, email, organisation_id)
+		values ($1, $2, $3, $4, $5)
 		returning id
 	`)
 
@@ -146,7 +190,8 @@ func (this *ContentOwnerRepository) Insert(owner *sm.ContentOwner) error {
 	row := stmt.QueryRow(owner.Username,
 		owner.Name,
 		owner.Password,
-		owner.Email)
+		owner.Email,
+		owner.OrganisationID)
 
 	err = row.Scan(&owner.ID)
 
@@ -169,6 +214,43 @@ func (this *ContentOwnerRepository) SavePassword(owner *sm.ContentOwner) error {
 	return err
 }
 
+func (this *ContentOwnerRepository) RevokeSessions(owner_id int64, date time.Time) error {
+	stmt, err := this.Pool.Prepare(`
+		update content_owner set
+		sessions_revoked_date=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id, date)
+
+	return err
+}
+
+func (this *ContentOwnerRepository) GetSessionsRevokedDate(owner_id int64) (*time.Time, error) {
+	stmt, err := this.Pool.Prepare(`
+		select sessions_revoked_date
+		from content_owner
+		where id=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var date *time.Time
+
+	err = stmt.QueryRow(owner_id).Scan(&date)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return date, err
+}
+
 func (this *ContentOwnerRepository) Save(owner *sm.ContentOwner) error {
 	stmt, err := this.Pool.Prepare(`
 		update content_owner set
@@ -2,6 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 
@@ -15,6 +18,56 @@ type JobRepository struct {
 	Pool *DatabasePool
 }
 
+// The values of the job parameters are stored as text,
+// linked parameters don't have a value
+func format_param_value(param sm.JobParam) (*string, error) {
+	var text string
+
+	switch value := param.Value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		text = value
+	case int64:
+		text = strconv.FormatInt(value, 10)
+	case float64:
+		text = strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		text = strconv.FormatBool(value)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	default:
+		return nil, fmt.Errorf("Unsupported parameter value %v", param.Value)
+	}
+
+	return &text, nil
+}
+
+func parse_param_value(data_type sm.ParamType, text sql.NullString) (interface{}, error) {
+	if !text.Valid {
+		return nil, nil
+	}
+
+	switch data_type {
+	case sm.IntParam:
+		return strconv.ParseInt(text.String, 10, 64)
+	case sm.DoubleParam:
+		return strconv.ParseFloat(text.String, 64)
+	case sm.BoolParam:
+		return strconv.ParseBool(text.String)
+	case sm.JsonParam, sm.ArrayParam:
+		var value interface{}
+		err := json.Unmarshal([]byte(text.String), &value)
+		return value, err
+	}
+
+	return text.String, nil
+}
+
 func (this *JobRepository) TaskHasActiveJobs(task_id int64) (bool, error) {
 	stmt, err := this.Pool.Prepare(`
 		select job_step.id from job
@@ -22,7 +75,7 @@ func (this *JobRepository) TaskHasActiveJobs(task_id int64) (bool, error) {
 			on job_step.job_id = job.id
 		where 
 			job_step.task_id=$1 and 
-			job_step.step_order>=job.current_step and 
+			job_step.status<>$2 and
 			not job.is_canceled and 
 			not job.is_completed
 		limit 1
@@ -32,7 +85,7 @@ func (this *JobRepository) TaskHasActiveJobs(task_id int64) (bool, error) {
 		return false, nil
 	}
 
-	row := stmt.QueryRow(task_id)
+	row := stmt.QueryRow(task_id, sm.StepCompleted)
 
 	var job_step_id int64
 	err = row.Scan(&job_step_id)
@@ -50,7 +103,7 @@ func (this *JobRepository) GetJobStepForModule(
 
 	stmt, err := this.Pool.Prepare(`
 		select
-			(j.is_completed or s.step_order < j.current_step) as is_completed,
+			(j.is_completed or s.status=$3) as is_completed,
 			j.is_canceled,
 			j.status,
 			j.creation_date,
@@ -67,14 +120,14 @@ func (this *JobRepository) GetJobStepForModule(
 			on t.id=s.task_id
 		where 
 			s.id=$1 and t.module_id=$2 and
-			(j.current_step is null or s.step_order <= j.current_step)
+			s.status<>$4
 		`)
 
 	if err != nil {
 		return nil, err
 	}
 
-	row := stmt.QueryRow(step_id, module_id)
+	row := stmt.QueryRow(step_id, module_id, sm.StepCompleted, sm.StepWaiting)
 
 	var status, owner_name string
 	var is_canceled, is_completed bool
@@ -126,7 +179,7 @@ func (this *JobRepository) GetJobsStepsForModule(
 	query_builder.WriteString(`
 		select
 			s.id,
-			(j.is_completed or s.step_order < j.current_step) as is_completed,
+			(j.is_completed or s.status=$2) as is_completed,
 			j.is_canceled,
 			j.status,
 			j.creation_date,
@@ -143,19 +196,19 @@ func (this *JobRepository) GetJobsStepsForModule(
 			on t.id=s.task_id
 		where 
 			t.module_id=$1 and
-			(j.current_step is null or s.step_order <= j.current_step)
+			s.status<>$3
 	`)
 
 	if limit != -1 && before_step_id != -1 {
 		query_builder.WriteString(`
-			and s.id<$3
+			and s.id<$5
 			order by s.id desc
-			limit $2
+			limit $4
 		`)
 	} else if limit != -1 {
 		query_builder.WriteString(`
 			order by s.id desc
-			limit $2
+			limit $4
 		`)
 	} else {
 		query_builder.WriteString(`
@@ -172,11 +225,11 @@ func (this *JobRepository) GetJobsStepsForModule(
 	var rows *sql.Rows
 
 	if limit != -1 && before_step_id != -1 {
-		rows, err = stmt.Query(module_id, limit, before_step_id)
+		rows, err = stmt.Query(module_id, sm.StepCompleted, sm.StepWaiting, limit, before_step_id)
 	} else if limit != -1 {
-		rows, err = stmt.Query(module_id, limit)
+		rows, err = stmt.Query(module_id, sm.StepCompleted, sm.StepWaiting, limit)
 	} else {
-		rows, err = stmt.Query(module_id)
+		rows, err = stmt.Query(module_id, sm.StepCompleted, sm.StepWaiting)
 	}
 
 	if err != nil {
@@ -240,8 +293,8 @@ func (this *JobRepository) GetJobByID(job_id int64) (*sm.Job, error) {
 			completion_date,
 			publication_date,
 			expiration_date,
-			current_step,
 			owner_id,
+			organisation_id,
 			status
 		from job
 		where id=$1
@@ -262,8 +315,8 @@ func (this *JobRepository) GetJobByID(job_id int64) (*sm.Job, error) {
 		&job.CompletionDate,
 		&job.PublicationDate,
 		&job.ExpirationDate,
-		&job.CurrentStep,
 		&job.Owner.ID,
+		&job.OrganisationID,
 		&job.Status)
 
 	if err == sql.ErrNoRows {
@@ -285,8 +338,8 @@ func (this *JobRepository) GetJobByStepID(step_id int64) (*sm.Job, error) {
 			j.completion_date,
 			j.publication_date,
 			j.expiration_date,
-			j.current_step,
 			j.owner_id,
+			j.organisation_id,
 			j.status
 		from job j
 		inner join job_step s
@@ -310,8 +363,8 @@ func (this *JobRepository) GetJobByStepID(step_id int64) (*sm.Job, error) {
 		&job.CompletionDate,
 		&job.PublicationDate,
 		&job.ExpirationDate,
-		&job.CurrentStep,
 		&job.Owner.ID,
+		&job.OrganisationID,
 		&job.Status)
 
 	if err == sql.ErrNoRows {
@@ -323,8 +376,8 @@ func (this *JobRepository) GetJobByStepID(step_id int64) (*sm.Job, error) {
 	return &job, nil
 }
 
-func (this *JobRepository) GetJobsForContentOwner(
-	owner_id, limit, before_job_id int64) ([]*sm.Job, error) {
+func (this *JobRepository) GetJobsForOrganisation(
+	organisation_id, limit, before_job_id int64) ([]*sm.Job, error) {
 
 	var stmt *sql.Stmt
 	var err error
@@ -339,10 +392,10 @@ func (this *JobRepository) GetJobsForContentOwner(
 				completion_date,
 				publication_date,
 				expiration_date,
-				current_step,
+				owner_id,
 				status
 			from job
-			where owner_id=$1 and job.id<$3
+			where organisation_id=$1 and job.id<$3
 			order by id desc
 			LIMIT $2
 		`)
@@ -356,10 +409,10 @@ func (this *JobRepository) GetJobsForContentOwner(
 				completion_date,
 				publication_date,
 				expiration_date,
-				current_step,
+				owner_id,
 				status
 			from job
-			where owner_id=$1
+			where organisation_id=$1
 			order by id desc
 			LIMIT $2
 		`)
@@ -373,10 +426,10 @@ func (this *JobRepository) GetJobsForContentOwner(
 				completion_date,
 				publication_date,
 				expiration_date,
-				current_step,
+				owner_id,
 				status
 			from job
-			where owner_id=$1
+			where organisation_id=$1
 			order by id desc
 		`)
 	}
@@ -388,11 +441,11 @@ func (this *JobRepository) GetJobsForContentOwner(
 	var rows *sql.Rows
 
 	if limit != -1 && before_job_id != -1 {
-		rows, err = stmt.Query(owner_id, limit, before_job_id)
+		rows, err = stmt.Query(organisation_id, limit, before_job_id)
 	} else if limit != -1 {
-		rows, err = stmt.Query(owner_id, limit)
+		rows, err = stmt.Query(organisation_id, limit)
 	} else {
-		rows, err = stmt.Query(owner_id)
+		rows, err = stmt.Query(organisation_id)
 	}
 
 	if err != nil {
@@ -403,9 +456,8 @@ func (this *JobRepository) GetJobsForContentOwner(
 
 	jobs := make([]*sm.Job, 0)
 
-	owner := sm.ContentOwner{ID: owner_id}
 	for rows.Next() {
-		job := sm.Job{Owner: owner}
+		job := sm.Job{OrganisationID: organisation_id}
 		err = rows.Scan(
 			&job.ID,
 			&job.IsCompleted,
@@ -414,7 +466,7 @@ func (this *JobRepository) GetJobsForContentOwner(
 			&job.CompletionDate,
 			&job.PublicationDate,
 			&job.ExpirationDate,
-			&job.CurrentStep,
+			&job.Owner.ID,
 			&job.Status)
 
 		if err != nil {
@@ -429,7 +481,7 @@ func (this *JobRepository) GetJobsForContentOwner(
 
 func (this *JobRepository) GetJobSteps(job_id int64, steps *[]*sm.JobStep) error {
 	stmt, err := this.Pool.Prepare(`
-		select id, task_id
+		select id, task_id, task_version, step_order, status
 		from job_step
 		where job_id=$1
 		order by step_order asc
@@ -448,9 +500,9 @@ func (this *JobRepository) GetJobSteps(job_id int64, steps *[]*sm.JobStep) error
 	defer rows.Close()
 
 	for rows.Next() {
-		step := sm.JobStep{}
+		step := sm.JobStep{DependsOn: make([]int, 0)}
 
-		err = rows.Scan(&step.ID, &step.TaskID)
+		err = rows.Scan(&step.ID, &step.TaskID, &step.TaskVersion, &step.Order, &step.Status)
 
 		if err != nil {
 			steps = nil
@@ -460,14 +512,52 @@ func (this *JobRepository) GetJobSteps(job_id int64, steps *[]*sm.JobStep) error
 		*steps = append(*steps, &step)
 	}
 
+	dependency_stmt, err := this.Pool.Prepare(`
+		select s.step_order, d.step_order
+		from job_step_dependency
+		inner join job_step s
+			on s.id=job_step_dependency.job_step_id
+		inner join job_step d
+			on d.id=job_step_dependency.depends_on_step_id
+		where s.job_id=$1
+		order by d.step_order asc
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	dependency_rows, err := dependency_stmt.Query(job_id)
+
+	if err != nil {
+		return err
+	}
+
+	defer dependency_rows.Close()
+
+	for dependency_rows.Next() {
+		var order, dependency int
+
+		err = dependency_rows.Scan(&order, &dependency)
+
+		if err != nil {
+			return err
+		}
+
+		step := (*steps)[order]
+		step.DependsOn = append(step.DependsOn, dependency)
+	}
+
 	return nil
 }
 
 func (this *JobRepository) GetParamsForStep(step *sm.JobStep) error {
 	stmt, err := this.Pool.Prepare(`
-		select is_input, name, data_type, value, linked_output_name
-		from job_param
-		where job_step_id=$1
+		select p.is_input, p.name, p.data_type, p.value, l.step_order, p.linked_output_name
+		from job_param p
+		left join job_step l
+			on l.id=p.linked_step_id
+		where p.job_step_id=$1
 	`)
 
 	if err != nil {
@@ -483,20 +573,26 @@ func (this *JobRepository) GetParamsForStep(step *sm.JobStep) error {
 
 	var is_input bool
 	var name string
-	var param sm.JobParam
+	var value sql.NullString
 
 	step.Input = make(map[string]sm.JobParam)
 	step.Output = make(map[string]sm.JobParam)
 
 	for rows.Next() {
+		var param sm.JobParam
 
 		err = rows.Scan(
 			&is_input,
 			&name,
 			&param.DataType,
-			&param.Value,
+			&value,
+			&param.LinkedStep,
 			&param.LinkedOutputName)
 
+		if err == nil {
+			param.Value, err = parse_param_value(param.DataType, value)
+		}
+
 		if err != nil {
 			step.Output = nil
 			step.Input = nil
@@ -529,68 +625,290 @@ func (this *JobRepository) SaveStatus(job *sm.Job) error {
 	return err
 }
 
-//	SaveStepProgress updates the current_step and also saves the output of the previous task to the database
-//
-func (this *JobRepository) SaveStepProgress(job *sm.Job) error {
+func (this *JobRepository) SavePendingStep(job *sm.Job, step *sm.JobStep, since time.Time) error {
 	tx, err := this.Pool.DB.Begin()
 
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.Prepare(`
+	_, err = tx.Exec(`
 		update job
-		set current_step=$1
+		set status=$1
 		where id=$2
-	`)
+	`, job.Status, job.ID)
 
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	defer stmt.Close()
-
-	param_stmt, err := tx.Prepare(`
-		insert into job_param
-			(job_step_id, name, is_input, data_type, value, linked_output_name)
-		values
-			($1, $2, false, $3, $4, NULL)
-	`)
+	_, err = tx.Exec(`
+		update job_step
+		set pending_since=$1
+		where id=$2
+	`, since, step.ID)
 
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	defer param_stmt.Close()
+	return tx.Commit()
+}
 
-	_, err = stmt.Exec(job.CurrentStep, job.ID)
+//	CompleteStep saves the output of the step and marks it as completed.
+//	The waiting steps whose dependencies are all completed become active
+//	and they are queued in the same transaction.
+//
+//	The job row is locked, so the last of two parallel branches that
+//	complete at the same time always sees the other one as completed.
+//
+func (this *JobRepository) CompleteStep(job *sm.Job, step *sm.JobStep) (bool, error) {
+	tx, err := this.Pool.DB.Begin()
+
+	if err != nil {
+		return false, err
+	}
+
+	var is_completed bool
+
+	err = tx.QueryRow(`
+		select is_completed
+		from job
+		where id=$1
+		for update
+	`, job.ID).Scan(&is_completed)
 
 	if err != nil {
 		tx.Rollback()
-		return err
+		return false, err
+	} else if is_completed {
+		tx.Rollback()
+		return false, sm.ErrJobIsCompleted
 	}
 
-	if job.CurrentStep > 0 {
-		step := job.Steps[job.CurrentStep-1]
+	res, err := tx.Exec(`
+		update job_step
+		set status=$1, pending_since=NULL
+		where id=$2 and status=$3
+	`, sm.StepCompleted, step.ID, sm.StepActive)
 
-		for name, param := range step.Output {
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	} else if rows, _ := res.RowsAffected(); rows == 0 {
+		tx.Rollback()
+		return false, sm.ErrJobIsCompleted
+	}
+
+	param_stmt, err := tx.Prepare(`
+		insert into job_param
+			(job_step_id, name, is_input, data_type, value, linked_step_id, linked_output_name)
+		values
+			($1, $2, false, $3, $4, NULL, NULL)
+	`)
+
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	defer param_stmt.Close()
+
+	for name, param := range step.Output {
+		value, err := format_param_value(param)
+
+		if err == nil {
 			_, err = param_stmt.Exec(
 				step.ID,
 				name,
 				param.DataType,
-				param.Value,
+				value,
 			)
+		}
 
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
+		if err != nil {
+			tx.Rollback()
+			return false, err
 		}
 	}
 
+	rows, err := tx.Query(`
+		update job_step s
+		set status=$1
+		where
+			s.job_id=$2 and
+			s.status=$3 and
+			not exists (
+				select 1 from job_step_dependency d
+				inner join job_step p
+					on p.id=d.depends_on_step_id
+				where d.job_step_id=s.id and p.status<>$4
+			)
+		returning s.id
+	`, sm.StepActive, job.ID, sm.StepWaiting, sm.StepCompleted)
+
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	ready := make([]int64, 0)
+
+	for rows.Next() {
+		var step_id int64
+
+		if err = rows.Scan(&step_id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return false, err
+		}
+
+		ready = append(ready, step_id)
+	}
+
+	rows.Close()
+
+	for _, step_id := range ready {
+		if err = enqueue_step(tx, job.ID, step_id, time.Now()); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	var remaining int
+
+	err = tx.QueryRow(`
+		select count(*)
+		from job_step
+		where job_id=$1 and status<>$2
+	`, job.ID, sm.StepCompleted).Scan(&remaining)
+
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	step.Status = sm.StepCompleted
+
+	return remaining == 0, tx.Commit()
+}
+
+//	RetryStep saves the status of the job and queues the step
+//	to be performed again at `available_at`
+//
+func (this *JobRepository) RetryStep(job *sm.Job, step *sm.JobStep, available_at time.Time) error {
+	tx, err := this.Pool.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	// The job can have been canceled or the step finished since the attempt,
+	// the job is locked until the step is queued
+	result, err := tx.Exec(`
+		update job
+		set status=$1
+		where id=$2 and not is_completed and not is_canceled
+	`, job.Status, job.ID)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if count, err := result.RowsAffected(); err != nil || count != 1 {
+		tx.Rollback()
+		return err
+	}
+
+	result, err = tx.Exec(`
+		update job_step
+		set pending_since=NULL
+		where id=$1 and status=$2
+	`, step.ID, sm.StepActive)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if count, err := result.RowsAffected(); err != nil || count != 1 {
+		tx.Rollback()
+		return err
+	}
+
+	err = enqueue_step(tx, job.ID, step.ID, available_at)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
+func (this *JobRepository) AddStepAttempt(attempt *sm.JobStepAttempt) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into job_step_attempt
+			(job_step_id, attempt, date, status_code, error)
+		values ($1, $2, $3, $4, $5)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(
+		attempt.StepID,
+		attempt.Attempt,
+		attempt.Date,
+		attempt.StatusCode,
+		attempt.Error)
+
+	return row.Scan(&attempt.ID)
+}
+
+func (this *JobRepository) GetStepAttempts(step_id int64) ([]*sm.JobStepAttempt, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, attempt, date, status_code, error
+		from job_step_attempt
+		where job_step_id=$1
+		order by attempt asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(step_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attempts := make([]*sm.JobStepAttempt, 0)
+
+	for rows.Next() {
+		attempt := sm.JobStepAttempt{StepID: step_id}
+
+		err = rows.Scan(
+			&attempt.ID,
+			&attempt.Attempt,
+			&attempt.Date,
+			&attempt.StatusCode,
+			&attempt.Error)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, &attempt)
+	}
+
+	return attempts, nil
+}
+
 func (this *JobRepository) CreateJob(job *sm.Job) error {
 	tx, err := this.Pool.DB.Begin()
 	if err != nil {
@@ -605,11 +923,12 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 			completion_date,
 			publication_date,
 			expiration_date,
-			current_step,
 			owner_id,
+			organisation_id,
 			status,
+			callback_url,
 			is_expiration_processed)
-		values (false, false, $1, null, $2, $3, 0, $4, $5, false)
+		values (false, false, $1, null, $2, $3, $4, $5, $6, $7, false)
 		returning id
 	`)
 
@@ -624,7 +943,9 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 		job.PublicationDate,
 		job.ExpirationDate,
 		job.Owner.ID,
+		job.OrganisationID,
 		job.Status,
+		job.CallbackUrl,
 	)
 
 	err = row.Scan(&job.ID)
@@ -634,8 +955,8 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 	}
 
 	step_stmt, err := tx.Prepare(`
-		insert into job_step (job_id, task_id, step_order)
-		values ($1, $2, $3)
+		insert into job_step (job_id, task_id, task_version, step_order, status)
+		values ($1, $2, $3, $4, $5)
 		returning id
 	`)
 
@@ -644,6 +965,16 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 	}
 	defer step_stmt.Close()
 
+	dependency_stmt, err := tx.Prepare(`
+		insert into job_step_dependency (job_step_id, depends_on_step_id)
+		values ($1, $2)
+	`)
+
+	if err != nil {
+		return err
+	}
+	defer dependency_stmt.Close()
+
 	param_stmt, err := tx.Prepare(`
 		insert into job_param (
 			job_step_id, 
@@ -651,8 +982,9 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 			is_input, 
 			data_type, 
 			value, 
+			linked_step_id,
 			linked_output_name)
-		values ($1, $2, $3, $4, $5, $6)
+		values ($1, $2, $3, $4, $5, $6, $7)
 	`)
 
 	if err != nil {
@@ -660,21 +992,43 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 	}
 	defer param_stmt.Close()
 
+	// A step can only depend on the steps before it,
+	// so their ids are known when it is inserted.
 	for i, step := range job.Steps {
-		row = step_stmt.QueryRow(job.ID, step.TaskID, i)
+		row = step_stmt.QueryRow(job.ID, step.TaskID, step.TaskVersion, i, step.Status)
 
 		err = row.Scan(&step.ID)
 		if err != nil {
 			return err
 		}
 
+		for _, dependency := range step.DependsOn {
+			_, err = dependency_stmt.Exec(step.ID, job.Steps[dependency].ID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
 		for name, param := range step.Input {
+			var linked_step_id *int64
+			if param.LinkedStep != nil {
+				linked_step_id = &job.Steps[*param.LinkedStep].ID
+			}
+
+			value, err := format_param_value(param)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
 			_, err = param_stmt.Exec(
 				step.ID,
 				name,
 				true,
 				param.DataType,
-				param.Value,
+				value,
+				linked_step_id,
 				param.LinkedOutputName,
 			)
 			if err != nil {
@@ -683,12 +1037,19 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 		}
 
 		for name, param := range step.Output {
+			value, err := format_param_value(param)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
 			_, err = param_stmt.Exec(
 				step.ID,
 				name,
 				false,
 				param.DataType,
-				param.Value,
+				value,
+				nil,
 				nil,
 			)
 			if err != nil {
@@ -697,6 +1058,16 @@ func (this *JobRepository) CreateJob(job *sm.Job) error {
 		}
 	}
 
+	// The steps without dependencies are queued in the same transaction,
+	// so the job can't get lost if the api stops right after.
+	for _, step := range job.ActiveSteps() {
+		err = enqueue_step(tx, job.ID, step.ID, job.CreationDate)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
@@ -739,8 +1110,8 @@ func (this *JobRepository) GetJobsExceedingPublicationDate(
 			completion_date,
 			publication_date,
 			expiration_date,
-			current_step,
 			owner_id,
+			organisation_id,
 			status
 		from job
 		where 
@@ -776,8 +1147,8 @@ func (this *JobRepository) GetJobsExceedingPublicationDate(
 			&job.CompletionDate,
 			&job.PublicationDate,
 			&job.ExpirationDate,
-			&job.CurrentStep,
 			&job.Owner.ID,
+			&job.OrganisationID,
 			&job.Status)
 
 		if err != nil {
@@ -790,6 +1161,54 @@ func (this *JobRepository) GetJobsExceedingPublicationDate(
 	return jobs, nil
 }
 
+func (this *JobRepository) GetTimedOutSteps(
+	timestamp time.Time,
+	limit, offset_id int64) ([]int64, error) {
+
+	// The duration is the one of the task version that the step uses
+	stmt, err := this.Pool.Prepare(`
+		select s.id
+		from job_step s
+		inner join job j on j.id=s.job_id
+		inner join task_version v on v.task_id=s.task_id and v.version=s.task_version
+		where
+			(not j.is_completed) and
+			s.id>$1 and
+			s.status=$4 and
+			s.pending_since is not null and
+			v.max_async_duration>0 and
+			s.pending_since + v.max_async_duration * interval '1 second'<=$2
+		ORDER BY s.id ASC
+		LIMIT $3
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(offset_id, timestamp, limit, sm.StepActive)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	step_ids := make([]int64, 0)
+
+	for rows.Next() {
+		var step_id int64
+
+		if err = rows.Scan(&step_id); err != nil {
+			return nil, err
+		}
+
+		step_ids = append(step_ids, step_id)
+	}
+
+	return step_ids, nil
+}
+
 func (this *JobRepository) CancelJobsWithExceedingExpirationDate(
 	timestamp time.Time) error {
 	stmt, err := this.Pool.Prepare(`
@@ -830,8 +1249,8 @@ func (this *JobRepository) GetJobsExceedingExpirationDate(
 			completion_date,
 			publication_date,
 			expiration_date,
-			current_step,
 			owner_id,
+			organisation_id,
 			status
 		from job
 		where 
@@ -867,8 +1286,8 @@ func (this *JobRepository) GetJobsExceedingExpirationDate(
 			&job.CompletionDate,
 			&job.PublicationDate,
 			&job.ExpirationDate,
-			&job.CurrentStep,
 			&job.Owner.ID,
+			&job.OrganisationID,
 			&job.Status)
 
 		if err != nil {
@@ -922,8 +1341,8 @@ func (this *JobRepository) GetNewExpiredJobsAt(
 			completion_date,
 			publication_date,
 			expiration_date,
-			current_step,
 			owner_id,
+			organisation_id,
 			status
 		from job
 		where 
@@ -959,8 +1378,8 @@ func (this *JobRepository) GetNewExpiredJobsAt(
 			&job.CompletionDate,
 			&job.PublicationDate,
 			&job.ExpirationDate,
-			&job.CurrentStep,
 			&job.Owner.ID,
+			&job.OrganisationID,
 			&job.Status)
 
 		if err != nil {
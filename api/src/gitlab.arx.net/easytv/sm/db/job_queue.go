@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type JobQueueRepository struct {
+	Pool *DatabasePool
+}
+
+// Adds the step to the queue as part of a bigger transaction
+func enqueue_step(tx *sql.Tx, job_id, step_id int64, available_at time.Time) error {
+	_, err := tx.Exec(`
+		insert into job_queue (job_id, job_step_id, available_at, attempts)
+		values ($1, $2, $3, 0)
+		on conflict (job_step_id) do update set
+			available_at=excluded.available_at,
+			lease_owner=NULL,
+			lease_expiration=NULL,
+			attempts=0
+	`, job_id, step_id, available_at)
+
+	return err
+}
+
+func (this *JobQueueRepository) Lease(
+	owner string, duration time.Duration) (*sm.JobQueueEntry, error) {
+	stmt, err := this.Pool.Prepare(`
+		update job_queue set
+			lease_owner=$1,
+			lease_expiration=$2,
+			attempts=attempts+1
+		where id=(
+			select id from job_queue
+			where
+				available_at<=$3 and
+				(lease_expiration is null or lease_expiration<$3)
+			order by available_at asc
+			limit 1
+			for update skip locked
+		)
+		returning id, job_id, job_step_id, attempts
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	entry := sm.JobQueueEntry{
+		LeaseOwner:      owner,
+		LeaseExpiration: now.Add(duration),
+	}
+
+	err = stmt.QueryRow(owner, entry.LeaseExpiration, now).Scan(
+		&entry.ID,
+		&entry.JobID,
+		&entry.StepID,
+		&entry.Attempts)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (this *JobQueueRepository) ExtendLease(
+	entry *sm.JobQueueEntry, duration time.Duration) error {
+	stmt, err := this.Pool.Prepare(`
+		update job_queue
+		set lease_expiration=$1
+		where id=$2 and lease_owner=$3
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Now().Add(duration)
+
+	res, err := stmt.Exec(expiration, entry.ID, entry.LeaseOwner)
+
+	if err != nil {
+		return err
+	} else if rows, _ := res.RowsAffected(); rows == 0 {
+		return sm.ErrLeaseLost
+	}
+
+	entry.LeaseExpiration = expiration
+	return nil
+}
+
+func (this *JobQueueRepository) Complete(entry *sm.JobQueueEntry) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from job_queue
+		where id=$1 and lease_owner=$2
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(entry.ID, entry.LeaseOwner)
+
+	return err
+}
+
+func (this *JobQueueRepository) ReleaseLeases(owner_prefix string) error {
+	stmt, err := this.Pool.Prepare(`
+		update job_queue set
+			lease_owner=NULL,
+			lease_expiration=NULL
+		where left(lease_owner, length($1) + 1) = $1 || '/'
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_prefix)
+
+	return err
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type JobTemplateRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *JobTemplateRepository) Create(template *sm.JobTemplate) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into job_template (organisation_id, name, description, creation_date, tasks)
+		values ($1, $2, $3, $4, $5)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	tasks_json, err := json.Marshal(template.Tasks)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(template.OrganisationID,
+		template.Name,
+		template.Description,
+		template.CreationDate,
+		string(tasks_json))
+
+	return row.Scan(&template.ID)
+}
+
+func (this *JobTemplateRepository) GetTemplate(template_id int64) (*sm.JobTemplate, error) {
+	stmt, err := this.Pool.Prepare(`
+		select organisation_id, name, description, creation_date, tasks
+		from job_template
+		where id=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := sm.JobTemplate{ID: template_id}
+	var tasks_json string
+
+	err = stmt.QueryRow(template_id).Scan(
+		&template.OrganisationID,
+		&template.Name,
+		&template.Description,
+		&template.CreationDate,
+		&tasks_json)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(tasks_json), &template.Tasks); err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func (this *JobTemplateRepository) GetTemplatesForOrganisation(
+	organisation_id int64) ([]*sm.JobTemplate, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		select id, name, description, creation_date, tasks
+		from job_template
+		where organisation_id=$1
+		order by id desc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(organisation_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	templates := make([]*sm.JobTemplate, 0)
+
+	for rows.Next() {
+		template := sm.JobTemplate{OrganisationID: organisation_id}
+		var tasks_json string
+
+		err = rows.Scan(
+			&template.ID,
+			&template.Name,
+			&template.Description,
+			&template.CreationDate,
+			&tasks_json)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal([]byte(tasks_json), &template.Tasks); err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, &template)
+	}
+
+	return templates, nil
+}
+
+func (this *JobTemplateRepository) Save(template *sm.JobTemplate) error {
+	stmt, err := this.Pool.Prepare(`
+		update job_template set
+			name=$2, description=$3, tasks=$4
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	tasks_json, err := json.Marshal(template.Tasks)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(template.ID,
+		template.Name,
+		template.Description,
+		string(tasks_json))
+
+	return err
+}
+
+func (this *JobTemplateRepository) Delete(template_id int64) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from job_template
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(template_id)
+
+	return err
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type LoginLockRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *LoginLockRepository) Get(key string) (*sm.LoginLock, error) {
+	stmt, err := this.Pool.Prepare(`
+		select failures, last_failure, locked_until
+		from login_lock
+		where key=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lock := sm.LoginLock{Key: key}
+
+	err = stmt.QueryRow(key).Scan(
+		&lock.Failures,
+		&lock.LastFailure,
+		&lock.LockedUntil)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+func (this *LoginLockRepository) AddFailure(
+	key string, now, window_start time.Time) (*sm.LoginLock, error) {
+
+	// A single statement so that concurrent failures are all counted
+	stmt, err := this.Pool.Prepare(`
+		insert into login_lock (key, failures, last_failure)
+		values ($1, 1, $2)
+		on conflict (key) do update set
+			failures=case
+				when login_lock.last_failure<$3 then 1
+				else login_lock.failures+1
+			end,
+			last_failure=$2
+		returning failures, last_failure, locked_until
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lock := sm.LoginLock{Key: key}
+
+	err = stmt.QueryRow(key, now, window_start).Scan(
+		&lock.Failures,
+		&lock.LastFailure,
+		&lock.LockedUntil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+func (this *LoginLockRepository) Lock(key string, until time.Time) error {
+	stmt, err := this.Pool.Prepare(`
+		update login_lock set
+		locked_until=$2
+		where key=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(key, until)
+
+	return err
+}
+
+func (this *LoginLockRepository) Delete(key string) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from login_lock where key=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(key)
+
+	return err
+}
+
+func (this *LoginLockRepository) GetLocked(now time.Time) ([]*sm.LoginLock, error) {
+	stmt, err := this.Pool.Prepare(`
+		select key, failures, last_failure, locked_until
+		from login_lock
+		where locked_until>$1
+		order by locked_until desc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	locks := make([]*sm.LoginLock, 0)
+
+	for rows.Next() {
+		lock := sm.LoginLock{}
+
+		err = rows.Scan(
+			&lock.Key,
+			&lock.Failures,
+			&lock.LastFailure,
+			&lock.LockedUntil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		locks = append(locks, &lock)
+	}
+
+	return locks, nil
+}
+
+func (this *LoginLockRepository) InsertAuditEvent(event *sm.LoginAuditEvent) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into login_audit (date, event, key, address, detail)
+		values ($1, $2, $3, $4, $5)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(
+		event.Date,
+		event.Event,
+		event.Key,
+		event.Address,
+		event.Detail)
+
+	return row.Scan(&event.ID)
+}
+
+func (this *LoginLockRepository) GetAuditEvents(
+	limit, before_id int64) ([]*sm.LoginAuditEvent, error) {
+
+	query := `
+		select id, date, event, key, address, detail
+		from login_audit`
+	args := []interface{}{}
+
+	if before_id != -1 {
+		args = append(args, before_id)
+		query += fmt.Sprintf(" where id<$%d", len(args))
+	}
+
+	query += " order by id desc"
+
+	if limit != -1 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	stmt, err := this.Pool.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := make([]*sm.LoginAuditEvent, 0)
+
+	for rows.Next() {
+		event := sm.LoginAuditEvent{}
+
+		err = rows.Scan(
+			&event.ID,
+			&event.Date,
+			&event.Event,
+			&event.Key,
+			&event.Address,
+			&event.Detail)
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"time"
 
 	"gitlab.arx.net/easytv/sm"
 )
@@ -15,8 +16,9 @@ type ModuleRepository struct {
 
 func (this *ModuleRepository) CreateModule(module *sm.Module) error {
 	stmt, err := this.Pool.Prepare(`insert into module
-	(name, description, api_key, enabled)
-	values ($1, $2, $3, $4) returning id`)
+	(name, description, enabled, signing_secret, previous_signing_secret,
+		legacy_key, previous_legacy_key)
+	values ($1, $2, $3, $4, '', $5, '') returning id`)
 
 	if err != nil {
 		return err
@@ -24,8 +26,9 @@ func (this *ModuleRepository) CreateModule(module *sm.Module) error {
 
 	row := stmt.QueryRow(module.Name,
 		module.Description,
-		module.ApiKey,
-		module.Enabled)
+		module.Enabled,
+		module.SigningSecret,
+		module.LegacyKey)
 
 	return row.Scan(&module.ID)
 }
@@ -93,7 +96,9 @@ func (this *ModuleRepository) SetAvailability(id int64, enabled bool) error {
 func (this *ModuleRepository) Save(module *sm.Module) error {
 	stmt, err := this.Pool.Prepare(`
 		update module
-		set name=$1, description=$2, api_key=$4
+		set name=$1, description=$2, signing_secret=$4,
+			previous_signing_secret=$5, previous_secret_expiration=$6,
+			legacy_key=$7, previous_legacy_key=$8, previous_legacy_key_expiration=$9
 		where id=$3
 	`)
 
@@ -104,7 +109,12 @@ func (this *ModuleRepository) Save(module *sm.Module) error {
 	res, err := stmt.Exec(module.Name,
 		module.Description,
 		module.ID,
-		module.ApiKey)
+		module.SigningSecret,
+		module.PreviousSigningSecret,
+		module.PreviousSecretExpiration,
+		module.LegacyKey,
+		module.PreviousLegacyKey,
+		module.PreviousLegacyKeyExpiration)
 
 	if err != nil {
 		return err
@@ -117,7 +127,9 @@ func (this *ModuleRepository) Save(module *sm.Module) error {
 func (this *ModuleRepository) GetModulesOBJ(modules *[]*sm.Module) error {
 
 	rows, err := this.Pool.DB.Query(`
-		select id, api_key, name, description, enabled
+		select id, name, description, enabled, signing_secret,
+			previous_signing_secret, previous_secret_expiration,
+			legacy_key, previous_legacy_key, previous_legacy_key_expiration
 		from module`)
 
 	if err != nil {
@@ -129,10 +141,15 @@ func (this *ModuleRepository) GetModulesOBJ(modules *[]*sm.Module) error {
 
 		err := rows.Scan(
 			&module.ID,
-			&module.ApiKey,
 			&module.Name,
 			&module.Description,
-			&module.Enabled)
+			&module.Enabled,
+			&module.SigningSecret,
+			&module.PreviousSigningSecret,
+			&module.PreviousSecretExpiration,
+			&module.LegacyKey,
+			&module.PreviousLegacyKey,
+			&module.PreviousLegacyKeyExpiration)
 
 		if err != nil {
 			return err
@@ -146,8 +163,14 @@ func (this *ModuleRepository) GetModulesOBJ(modules *[]*sm.Module) error {
 
 func (this *ModuleRepository) GetModules(modules *[]map[string]interface{}) error {
 	rows, err := this.Pool.DB.Query(`
-	select id, api_key, name, description, enabled
-	from module`)
+	select m.id, m.name, m.description, m.enabled, (
+		select count(*) from module_api_key k
+		where
+			k.module_id=m.id and
+			not k.revoked and
+			(k.expiration_date is null or k.expiration_date>now())
+	)
+	from module m`)
 
 	if err != nil {
 		return err
@@ -157,13 +180,14 @@ func (this *ModuleRepository) GetModules(modules *[]map[string]interface{}) erro
 
 	module := sm.Module{}
 	for rows.Next() {
+		var active_keys int64
 
 		err := rows.Scan(
 			&module.ID,
-			&module.ApiKey,
 			&module.Name,
 			&module.Description,
-			&module.Enabled)
+			&module.Enabled,
+			&active_keys)
 
 		if err != nil {
 			return err
@@ -171,7 +195,7 @@ func (this *ModuleRepository) GetModules(modules *[]map[string]interface{}) erro
 
 		*modules = append(*modules, map[string]interface{}{
 			"id":          module.ID,
-			"api_key":     module.ApiKey,
+			"active_keys": active_keys,
 			"name":        module.Name,
 			"description": module.Description,
 			"enabled":     module.Enabled})
@@ -182,7 +206,9 @@ func (this *ModuleRepository) GetModules(modules *[]map[string]interface{}) erro
 
 func (this *ModuleRepository) GetModuleByID(id int64) (*sm.Module, error) {
 	stmt, err := this.Pool.Prepare(`
-		select api_key, name, description, enabled
+		select name, description, enabled, signing_secret,
+			previous_signing_secret, previous_secret_expiration,
+			legacy_key, previous_legacy_key, previous_legacy_key_expiration
 		from module
 		where id=$1
 	`)
@@ -196,10 +222,15 @@ func (this *ModuleRepository) GetModuleByID(id int64) (*sm.Module, error) {
 	module := sm.Module{ID: id}
 
 	err = row.Scan(
-		&module.ApiKey,
 		&module.Name,
 		&module.Description,
-		&module.Enabled)
+		&module.Enabled,
+		&module.SigningSecret,
+		&module.PreviousSigningSecret,
+		&module.PreviousSecretExpiration,
+		&module.LegacyKey,
+		&module.PreviousLegacyKey,
+		&module.PreviousLegacyKeyExpiration)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -208,26 +239,172 @@ func (this *ModuleRepository) GetModuleByID(id int64) (*sm.Module, error) {
 	return &module, err
 }
 
-func (this *ModuleRepository) GetModuleByKey(api_key string) (*sm.Module, error) {
+func (this *ModuleRepository) GetModuleByKey(hashed_key string) (*sm.Module, error) {
 	stmt, err := this.Pool.Prepare(`
-		select id, name, description, enabled
-		from module
-		where api_key=$1
+		with key as (
+			update module_api_key set
+				last_used=$2
+			where
+				hashed_key=$1 and
+				not revoked and
+				(expiration_date is null or expiration_date>$2)
+			returning module_id
+		)
+		select m.id, m.name, m.description, m.enabled, m.signing_secret,
+			m.previous_signing_secret, m.previous_secret_expiration,
+			m.legacy_key, m.previous_legacy_key, m.previous_legacy_key_expiration
+		from module m
+		inner join key k
+			on k.module_id=m.id
 	`)
 
 	if err != nil {
 		return nil, err
 	}
 
-	row := stmt.QueryRow(api_key)
+	row := stmt.QueryRow(hashed_key, time.Now())
 
-	module := sm.Module{ApiKey: api_key}
+	module := sm.Module{}
 
 	err = row.Scan(
 		&module.ID,
 		&module.Name,
 		&module.Description,
-		&module.Enabled)
+		&module.Enabled,
+		&module.SigningSecret,
+		&module.PreviousSigningSecret,
+		&module.PreviousSecretExpiration,
+		&module.LegacyKey,
+		&module.PreviousLegacyKey,
+		&module.PreviousLegacyKeyExpiration)
 
 	return &module, err
 }
+
+func (this *ModuleRepository) CreateApiKey(key *sm.ModuleApiKey) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into module_api_key (
+			module_id,
+			label,
+			hashed_key,
+			creation_date,
+			expiration_date,
+			revoked)
+		values ($1, $2, $3, $4, $5, false)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(
+		key.ModuleID,
+		key.Label,
+		key.HashedKey,
+		key.CreationDate,
+		key.ExpirationDate)
+
+	return row.Scan(&key.ID)
+}
+
+func (this *ModuleRepository) GetApiKey(id int64) (*sm.ModuleApiKey, error) {
+	stmt, err := this.Pool.Prepare(`
+		select module_id, label, hashed_key, creation_date,
+			expiration_date, last_used, revoked
+		from module_api_key
+		where id=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := sm.ModuleApiKey{ID: id}
+
+	err = stmt.QueryRow(id).Scan(
+		&key.ModuleID,
+		&key.Label,
+		&key.HashedKey,
+		&key.CreationDate,
+		&key.ExpirationDate,
+		&key.LastUsed,
+		&key.Revoked)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (this *ModuleRepository) GetApiKeys(module_id int64) ([]*sm.ModuleApiKey, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, label, hashed_key, creation_date,
+			expiration_date, last_used, revoked
+		from module_api_key
+		where module_id=$1
+		order by id desc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(module_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := make([]*sm.ModuleApiKey, 0)
+
+	for rows.Next() {
+		key := sm.ModuleApiKey{ModuleID: module_id}
+
+		err = rows.Scan(
+			&key.ID,
+			&key.Label,
+			&key.HashedKey,
+			&key.CreationDate,
+			&key.ExpirationDate,
+			&key.LastUsed,
+			&key.Revoked)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+func (this *ModuleRepository) SaveApiKey(key *sm.ModuleApiKey) error {
+	stmt, err := this.Pool.Prepare(`
+		update module_api_key
+		set label=$2, expiration_date=$3, revoked=$4
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(key.ID,
+		key.Label,
+		key.ExpirationDate,
+		key.Revoked)
+
+	if err != nil {
+		return err
+	} else if row, _ := res.RowsAffected(); row == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type OAuthClientRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *OAuthClientRepository) Insert(client *sm.OAuthClient) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into oauth_client (
+			organisation_id,
+			owner_id,
+			name,
+			client_id,
+			hashed_secret,
+			scopes,
+			creation_date,
+			revoked)
+		values ($1, $2, $3, $4, $5, $6, $7, false)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	// The scopes are stored like the permissions of the roles
+	row := stmt.QueryRow(
+		client.OrganisationID,
+		client.OwnerID,
+		client.Name,
+		client.ClientID,
+		client.HashedSecret,
+		strings.Join(client.Scopes, ","),
+		client.CreationDate)
+
+	return row.Scan(&client.ID)
+}
+
+func (this *OAuthClientRepository) get(column string, value interface{}) (*sm.OAuthClient, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, organisation_id, owner_id, name, client_id, hashed_secret,
+			scopes, creation_date, revoked
+		from oauth_client
+		where ` + column + `=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := sm.OAuthClient{}
+	var scopes string
+
+	err = stmt.QueryRow(value).Scan(
+		&client.ID,
+		&client.OrganisationID,
+		&client.OwnerID,
+		&client.Name,
+		&client.ClientID,
+		&client.HashedSecret,
+		&scopes,
+		&client.CreationDate,
+		&client.Revoked)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	client.Scopes = parse_permissions(scopes)
+
+	return &client, nil
+}
+
+func (this *OAuthClientRepository) GetByID(id int64) (*sm.OAuthClient, error) {
+	return this.get("id", id)
+}
+
+func (this *OAuthClientRepository) GetByClientID(client_id string) (*sm.OAuthClient, error) {
+	return this.get("client_id", client_id)
+}
+
+func (this *OAuthClientRepository) GetForOrganisation(
+	organisation_id int64) ([]*sm.OAuthClient, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		select id, owner_id, name, client_id, hashed_secret,
+			scopes, creation_date, revoked
+		from oauth_client
+		where organisation_id=$1
+		order by id asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(organisation_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	clients := make([]*sm.OAuthClient, 0)
+
+	for rows.Next() {
+		client := sm.OAuthClient{OrganisationID: organisation_id}
+		var scopes string
+
+		err = rows.Scan(
+			&client.ID,
+			&client.OwnerID,
+			&client.Name,
+			&client.ClientID,
+			&client.HashedSecret,
+			&scopes,
+			&client.CreationDate,
+			&client.Revoked)
+
+		if err != nil {
+			return nil, err
+		}
+
+		client.Scopes = parse_permissions(scopes)
+
+		clients = append(clients, &client)
+	}
+
+	return clients, nil
+}
+
+func (this *OAuthClientRepository) Save(client *sm.OAuthClient) error {
+	stmt, err := this.Pool.Prepare(`
+		update oauth_client set
+		revoked=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(client.ID, client.Revoked)
+
+	return err
+}
+
+func (this *OAuthClientRepository) RevokeForOwner(owner_id int64) error {
+	stmt, err := this.Pool.Prepare(`
+		update oauth_client set
+		revoked=true
+		where owner_id=$1 and not revoked
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id)
+
+	return err
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type OrganisationRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *OrganisationRepository) Insert(organisation *sm.Organisation) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into organisation (name, creation_date, callback_url, webhook_secret)
+		values ($1, $2, $3, $4)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(organisation.Name,
+		organisation.CreationDate,
+		organisation.CallbackUrl,
+		organisation.WebhookSecret)
+
+	return row.Scan(&organisation.ID)
+}
+
+func (this *OrganisationRepository) GetByID(id int64) (*sm.Organisation, error) {
+	stmt, err := this.Pool.Prepare(`
+		select name, creation_date, callback_url, webhook_secret
+		from organisation
+		where id=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	organisation := sm.Organisation{ID: id}
+
+	err = stmt.QueryRow(id).Scan(
+		&organisation.Name,
+		&organisation.CreationDate,
+		&organisation.CallbackUrl,
+		&organisation.WebhookSecret)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &organisation, nil
+}
+
+func (this *OrganisationRepository) GetAll() ([]*sm.Organisation, error) {
+	rows, err := this.Pool.DB.Query(`
+		select id, name, creation_date, callback_url, webhook_secret
+		from organisation
+		order by id asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	organisations := make([]*sm.Organisation, 0)
+
+	for rows.Next() {
+		organisation := sm.Organisation{}
+
+		err = rows.Scan(
+			&organisation.ID,
+			&organisation.Name,
+			&organisation.CreationDate,
+			&organisation.CallbackUrl,
+			&organisation.WebhookSecret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		organisations = append(organisations, &organisation)
+	}
+
+	return organisations, nil
+}
+
+func (this *OrganisationRepository) NameExists(name string) (bool, error) {
+	stmt, err := this.Pool.Prepare(`
+		select exists (select id from organisation where name=$1)
+	`)
+
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = stmt.QueryRow(name).Scan(&exists)
+
+	return exists, err
+}
+
+func (this *OrganisationRepository) Save(organisation *sm.Organisation) error {
+	stmt, err := this.Pool.Prepare(`
+		update organisation set
+		name=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(organisation.ID, organisation.Name)
+
+	return err
+}
+
+func (this *OrganisationRepository) SaveWebhook(organisation *sm.Organisation) error {
+	stmt, err := this.Pool.Prepare(`
+		update organisation set
+		callback_url=$2, webhook_secret=$3
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(organisation.ID,
+		organisation.CallbackUrl,
+		organisation.WebhookSecret)
+
+	return err
+}
+
+func (this *OrganisationRepository) GetMembers(id int64) ([]*sm.ContentOwner, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, username, email, name, role_id
+		from content_owner
+		where organisation_id=$1
+		order by id asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := make([]*sm.ContentOwner, 0)
+
+	for rows.Next() {
+		member := sm.ContentOwner{OrganisationID: &id}
+
+		err = rows.Scan(
+			&member.ID,
+			&member.Username,
+			&member.Email,
+			&member.Name,
+			&member.RoleID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+
+	return members, nil
+}
+
+func (this *OrganisationRepository) SetMemberOrganisation(
+	owner_id int64, organisation_id *int64) error {
+
+	stmt, err := this.Pool.Prepare(`
+		update content_owner set
+		organisation_id=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id, organisation_id)
+
+	return err
+}
+
+func (this *OrganisationRepository) CreateInvitation(invitation *sm.OrganisationInvitation) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into organisation_invitation (
+			organisation_id,
+			email,
+			role_id,
+			hashed_token,
+			invited_by,
+			creation_date,
+			expiration_date,
+			revoked)
+		values ($1, $2, $3, $4, $5, $6, $7, false)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(
+		invitation.OrganisationID,
+		invitation.Email,
+		invitation.RoleID,
+		invitation.HashedToken,
+		invitation.InvitedBy,
+		invitation.CreationDate,
+		invitation.ExpirationDate)
+
+	return row.Scan(&invitation.ID)
+}
+
+func (this *OrganisationRepository) getInvitation(
+	column string, value interface{}) (*sm.OrganisationInvitation, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		select id, organisation_id, email, role_id, hashed_token, invited_by,
+			creation_date, expiration_date, accept_date, revoked
+		from organisation_invitation
+		where ` + column + `=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := sm.OrganisationInvitation{}
+
+	err = stmt.QueryRow(value).Scan(
+		&invitation.ID,
+		&invitation.OrganisationID,
+		&invitation.Email,
+		&invitation.RoleID,
+		&invitation.HashedToken,
+		&invitation.InvitedBy,
+		&invitation.CreationDate,
+		&invitation.ExpirationDate,
+		&invitation.AcceptDate,
+		&invitation.Revoked)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+func (this *OrganisationRepository) GetInvitation(id int64) (*sm.OrganisationInvitation, error) {
+	return this.getInvitation("id", id)
+}
+
+func (this *OrganisationRepository) GetInvitationByToken(
+	hashed_token string) (*sm.OrganisationInvitation, error) {
+	return this.getInvitation("hashed_token", hashed_token)
+}
+
+func (this *OrganisationRepository) GetInvitations(
+	organisation_id int64) ([]*sm.OrganisationInvitation, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		select id, email, role_id, hashed_token, invited_by,
+			creation_date, expiration_date, accept_date, revoked
+		from organisation_invitation
+		where organisation_id=$1
+		order by id desc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(organisation_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := make([]*sm.OrganisationInvitation, 0)
+
+	for rows.Next() {
+		invitation := sm.OrganisationInvitation{OrganisationID: organisation_id}
+
+		err = rows.Scan(
+			&invitation.ID,
+			&invitation.Email,
+			&invitation.RoleID,
+			&invitation.HashedToken,
+			&invitation.InvitedBy,
+			&invitation.CreationDate,
+			&invitation.ExpirationDate,
+			&invitation.AcceptDate,
+			&invitation.Revoked)
+
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	return invitations, nil
+}
+
+func (this *OrganisationRepository) SaveInvitation(invitation *sm.OrganisationInvitation) error {
+	stmt, err := this.Pool.Prepare(`
+		update organisation_invitation set
+		accept_date=$2, revoked=$3
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(invitation.ID, invitation.AcceptDate, invitation.Revoked)
+
+	return err
+}
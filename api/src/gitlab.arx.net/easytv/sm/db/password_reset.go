@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type PasswordResetRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *PasswordResetRepository) Insert(token *sm.PasswordResetToken) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into password_reset_token (owner_id, hashed_token, creation_date, expiration_date)
+		values ($1, $2, $3, $4)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	return stmt.QueryRow(
+		token.OwnerID,
+		token.HashedToken,
+		token.CreationDate,
+		token.ExpirationDate).Scan(&token.ID)
+}
+
+func (this *PasswordResetRepository) GetLatest(owner_id int64) (*sm.PasswordResetToken, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, hashed_token, creation_date, expiration_date, used_date
+		from password_reset_token
+		where owner_id=$1
+		order by creation_date desc
+		limit 1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := sm.PasswordResetToken{OwnerID: owner_id}
+
+	err = stmt.QueryRow(owner_id).Scan(
+		&token.ID,
+		&token.HashedToken,
+		&token.CreationDate,
+		&token.ExpirationDate,
+		&token.UsedDate)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (this *PasswordResetRepository) Use(hashed_token string,
+	now time.Time) (*sm.PasswordResetToken, error) {
+
+	// A single update so the token can't be used twice concurrently
+	stmt, err := this.Pool.Prepare(`
+		update password_reset_token set
+			used_date=$2
+		where hashed_token=$1 and used_date is null and expiration_date>$2
+		returning id, owner_id, creation_date, expiration_date
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := sm.PasswordResetToken{HashedToken: hashed_token, UsedDate: &now}
+
+	err = stmt.QueryRow(hashed_token, now).Scan(
+		&token.ID,
+		&token.OwnerID,
+		&token.CreationDate,
+		&token.ExpirationDate)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (this *PasswordResetRepository) Invalidate(owner_id int64, now time.Time) error {
+	stmt, err := this.Pool.Prepare(`
+		update password_reset_token set
+			used_date=$2
+		where owner_id=$1 and used_date is null
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id, now)
+
+	return err
+}
+
+func (this *PasswordResetRepository) DeleteExpired(before time.Time) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from password_reset_token where expiration_date<$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(before)
+
+	return err
+}
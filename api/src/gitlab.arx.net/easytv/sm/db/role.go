@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type RoleRepository struct {
+	Pool *DatabasePool
+}
+
+// The permissions of a role are stored as a comma separated list
+func parse_permissions(value string) []string {
+	permissions := make([]string, 0)
+	for _, permission := range strings.Split(value, ",") {
+		if len(permission) > 0 {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+func (this *RoleRepository) Insert(role *sm.Role) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into role (name, description, permissions, built_in)
+		values ($1, $2, $3, $4)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(role.Name,
+		role.Description,
+		strings.Join(role.Permissions, ","),
+		role.BuiltIn)
+
+	return row.Scan(&role.ID)
+}
+
+func (this *RoleRepository) get(column string, value interface{}) (*sm.Role, error) {
+	stmt, err := this.Pool.Prepare(`
+		select id, name, description, permissions, built_in
+		from role
+		where ` + column + `=$1
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var role sm.Role
+	var permissions string
+
+	err = stmt.QueryRow(value).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&permissions,
+		&role.BuiltIn)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = parse_permissions(permissions)
+
+	return &role, nil
+}
+
+func (this *RoleRepository) GetByID(id int64) (*sm.Role, error) {
+	return this.get("id", id)
+}
+
+func (this *RoleRepository) GetByName(name string) (*sm.Role, error) {
+	return this.get("name", name)
+}
+
+func (this *RoleRepository) GetAll() ([]*sm.Role, error) {
+	rows, err := this.Pool.DB.Query(`
+		select id, name, description, permissions, built_in
+		from role
+		order by id asc
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := make([]*sm.Role, 0)
+
+	for rows.Next() {
+		var role sm.Role
+		var permissions string
+
+		err = rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&permissions,
+			&role.BuiltIn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		role.Permissions = parse_permissions(permissions)
+		roles = append(roles, &role)
+	}
+
+	return roles, nil
+}
+
+func (this *RoleRepository) Save(role *sm.Role) error {
+	stmt, err := this.Pool.Prepare(`
+		update role set
+			description=$2, permissions=$3
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(role.ID,
+		role.Description,
+		strings.Join(role.Permissions, ","))
+
+	return err
+}
+
+func (this *RoleRepository) Delete(id int64) error {
+	stmt, err := this.Pool.Prepare(`
+		delete from role
+		where id=$1 and not built_in
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+func (this *RoleRepository) IsInUse(id int64) (bool, error) {
+	stmt, err := this.Pool.Prepare(`
+		select
+			exists (select id from admin_user where role_id=$1) or
+			exists (select id from content_owner where role_id=$1)
+	`)
+
+	if err != nil {
+		return false, err
+	}
+
+	var in_use bool
+	err = stmt.QueryRow(id).Scan(&in_use)
+
+	return in_use, err
+}
+
+func (this *RoleRepository) SetAdminRole(admin_id int64, role_id *int64) error {
+	stmt, err := this.Pool.Prepare(`
+		update admin_user set
+		role_id=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(admin_id, role_id)
+
+	return err
+}
+
+func (this *RoleRepository) SetOwnerRole(owner_id int64, role_id *int64) error {
+	stmt, err := this.Pool.Prepare(`
+		update content_owner set
+		role_id=$2
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(owner_id, role_id)
+
+	return err
+}
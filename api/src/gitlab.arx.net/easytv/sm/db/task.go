@@ -2,6 +2,10 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
 	"gitlab.arx.net/easytv/sm"
 )
@@ -10,6 +14,201 @@ type TaskRepository struct {
 	Pool *DatabasePool
 }
 
+// The status codes of a retry policy are stored as a comma separated list
+func format_status_codes(codes []int) string {
+	str_codes := make([]string, len(codes))
+	for i, code := range codes {
+		str_codes[i] = strconv.Itoa(code)
+	}
+	return strings.Join(str_codes, ",")
+}
+
+func parse_status_codes(value string) []int {
+	codes := make([]int, 0)
+	for _, str_code := range strings.Split(value, ",") {
+		if code, err := strconv.Atoi(str_code); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// The values of an enum parameter are stored as a JSON array
+func format_enum_values(param sm.TaskParam) *string {
+	if len(param.Values) == 0 {
+		return nil
+	}
+	values, _ := json.Marshal(param.Values)
+	str_values := string(values)
+	return &str_values
+}
+
+// The default value of a parameter is stored as JSON
+func format_default_value(param sm.TaskParam) *string {
+	if param.Default == nil {
+		return nil
+	}
+	value, _ := json.Marshal(param.Default)
+	str_value := string(value)
+	return &str_value
+}
+
+// Reads the rows of (name, data_type, is_input, enum_values, required,
+// default_value) into the task
+func scan_task_params(rows *sql.Rows, task *sm.Task) error {
+	task.Input = make(map[string]sm.TaskParam)
+	task.Output = make(map[string]sm.TaskParam)
+
+	for rows.Next() {
+		var name string
+		var param sm.TaskParam
+		var is_input bool
+		var enum_values, default_value sql.NullString
+
+		err := rows.Scan(&name, &param.Type, &is_input, &enum_values, &param.Required, &default_value)
+		if err != nil {
+			return err
+		}
+
+		if enum_values.Valid {
+			if err = json.Unmarshal([]byte(enum_values.String), &param.Values); err != nil {
+				return err
+			}
+		}
+
+		if default_value.Valid {
+			var value interface{}
+			if err = json.Unmarshal([]byte(default_value.String), &value); err != nil {
+				return err
+			}
+			// Converts the numbers of int parameters
+			param.Default, _ = param.Check(value)
+		}
+
+		if is_input {
+			task.Input[name] = param
+		} else {
+			task.Output[name] = param
+		}
+	}
+
+	return rows.Err()
+}
+
+// The schemas are stored as JSON, NULL if the task doesn't have one
+func format_schema(schema sm.Schema) *string {
+	if schema == nil {
+		return nil
+	}
+	str_schema := schema.String()
+	return &str_schema
+}
+
+func parse_schema(value sql.NullString) (sm.Schema, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var schema sm.Schema
+	err := json.Unmarshal([]byte(value.String), &schema)
+	return schema, err
+}
+
+// Inserts the current contract and retry policy of the task as its version
+func insert_task_version(tx *sql.Tx, task *sm.Task) error {
+	version_stmt, err := tx.Prepare(`
+		insert into task_version (
+			task_id,
+			version,
+			start_url,
+			cancel_url,
+			max_attempts,
+			retry_backoff,
+			retry_status_codes,
+			max_async_duration,
+			input_schema,
+			output_schema,
+			creation_date,
+			retired)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, false)
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	defer version_stmt.Close()
+
+	_, err = version_stmt.Exec(
+		task.ID,
+		task.Version,
+		task.StartUrl,
+		task.CancelUrl,
+		task.Retry.MaxAttempts,
+		int64(task.Retry.BackoffBase/time.Millisecond),
+		format_status_codes(task.Retry.StatusCodes),
+		int64(task.MaxAsyncDuration/time.Second),
+		format_schema(task.InputSchema),
+		format_schema(task.OutputSchema),
+		time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	param_stmt, err := tx.Prepare(`
+		insert into task_parameter (task_id, version, name, data_type, is_input,
+			enum_values, required, default_value)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	defer param_stmt.Close()
+
+	for name, param := range task.Input {
+		_, err = param_stmt.Exec(task.ID, task.Version, name, param.Type, true,
+			format_enum_values(param), param.Required, format_default_value(param))
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, param := range task.Output {
+		_, err = param_stmt.Exec(task.ID, task.Version, name, param.Type, false,
+			format_enum_values(param), param.Required, format_default_value(param))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reads the parameters of the version of the task
+func (this *TaskRepository) load_params(task *sm.Task) error {
+	stmt, err := this.Pool.Prepare(`
+		select name, data_type, is_input, enum_values, required, default_value
+		from task_parameter
+		where task_id=$1 and version=$2
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := stmt.Query(task.ID, task.Version)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	return scan_task_params(rows, task)
+}
+
 func (this *TaskRepository) CreateTask(task *sm.Task) error {
 	tx, err := this.Pool.DB.Begin()
 
@@ -24,8 +223,15 @@ func (this *TaskRepository) CreateTask(task *sm.Task) error {
 			start_url,
 			cancel_url,
 			enabled,
-			deleted)
-		values ($1, $2, $3, $4, $5, false, false)
+			deleted,
+			max_attempts,
+			retry_backoff,
+			retry_status_codes,
+			max_async_duration,
+			input_schema,
+			output_schema,
+			version)
+		values ($1, $2, $3, $4, $5, false, false, $6, $7, $8, $9, $10, $11, 1)
 		returning id
 	`)
 
@@ -40,7 +246,13 @@ func (this *TaskRepository) CreateTask(task *sm.Task) error {
 		task.Name,
 		task.Description,
 		task.StartUrl,
-		task.CancelUrl)
+		task.CancelUrl,
+		task.Retry.MaxAttempts,
+		int64(task.Retry.BackoffBase/time.Millisecond),
+		format_status_codes(task.Retry.StatusCodes),
+		int64(task.MaxAsyncDuration/time.Second),
+		format_schema(task.InputSchema),
+		format_schema(task.OutputSchema))
 
 	err = row.Scan(&task.ID)
 
@@ -49,47 +261,32 @@ func (this *TaskRepository) CreateTask(task *sm.Task) error {
 		return err
 	}
 
-	stmt, query_err = tx.Prepare(`
-		insert into task_parameter (task_id, name, data_type, is_input)
-		values ($1, $2, $3, $4)
-	`)
+	task.Version = 1
 
-	if query_err != nil {
+	if err = insert_task_version(tx, task); err != nil {
 		tx.Rollback()
-		return query_err
-	}
-
-	defer stmt.Close()
-
-	for name, parameter_type := range task.Input {
-		_, err = stmt.Exec(task.ID, name, parameter_type, true)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	for name, parameter_type := range task.Output {
-		_, err = stmt.Exec(task.ID, name, parameter_type, false)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+		return err
 	}
 
 	tx.Commit()
 	return nil
 }
 
-func (this *TaskRepository) SaveVars(task *sm.Task, is_input bool) error {
+func (this *TaskRepository) SaveVersion(task *sm.Task) error {
 	tx, err := this.Pool.DB.Begin()
 
 	if err != nil {
 		return err
 	}
 
-	delete_stmt, err := tx.Prepare(`
-		delete from task_parameter where task_id=$1 and is_input=$2
+	stmt, err := tx.Prepare(`
+		update task set
+			version=version+1, start_url=$2, cancel_url=$3,
+			input_schema=$4, output_schema=$5,
+			max_attempts=$6, retry_backoff=$7, retry_status_codes=$8,
+			max_async_duration=$9
+		where id=$1
+		returning version
 	`)
 
 	if err != nil {
@@ -97,43 +294,29 @@ func (this *TaskRepository) SaveVars(task *sm.Task, is_input bool) error {
 		return err
 	}
 
-	insert_stmt, err := tx.Prepare(`
-		insert into task_parameter (task_id, name, data_type, is_input)
-		values ($1, $2, $3, $4)
-	`)
+	defer stmt.Close()
+
+	err = stmt.QueryRow(task.ID,
+		task.StartUrl,
+		task.CancelUrl,
+		format_schema(task.InputSchema),
+		format_schema(task.OutputSchema),
+		task.Retry.MaxAttempts,
+		int64(task.Retry.BackoffBase/time.Millisecond),
+		format_status_codes(task.Retry.StatusCodes),
+		int64(task.MaxAsyncDuration/time.Second)).Scan(&task.Version)
 
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	_, err = delete_stmt.Exec(task.ID, is_input)
-
-	if err != nil {
+	if err = insert_task_version(tx, task); err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	if is_input {
-		for name, parameter_type := range task.Input {
-			_, err = insert_stmt.Exec(task.ID, name, parameter_type, true)
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
-	} else {
-		for name, parameter_type := range task.Output {
-			_, err = insert_stmt.Exec(task.ID, name, parameter_type, false)
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
-	}
-
-	tx.Commit()
-	return nil
+	return tx.Commit()
 }
 
 func (this *TaskRepository) NameExists(name string) (bool, error) {
@@ -162,7 +345,9 @@ func (this *TaskRepository) NameExists(name string) (bool, error) {
 
 func (this *TaskRepository) GetTask(id int64) (*sm.Task, error) {
 	stmt, err := this.Pool.Prepare(`
-		select module_id, name, description, start_url, cancel_url, enabled, deleted
+		select module_id, name, description, start_url, cancel_url, enabled, deleted,
+			max_attempts, retry_backoff, retry_status_codes, max_async_duration,
+			input_schema, output_schema, version
 		from task
 		where id=$1
 	`)
@@ -175,13 +360,24 @@ func (this *TaskRepository) GetTask(id int64) (*sm.Task, error) {
 
 	task := sm.Task{ID: id}
 
+	var retry_backoff, max_async_duration int64
+	var retry_status_codes string
+	var input_schema, output_schema sql.NullString
+
 	err = row.Scan(&task.ModuleID,
 		&task.Name,
 		&task.Description,
 		&task.StartUrl,
 		&task.CancelUrl,
 		&task.Enabled,
-		&task.Deleted)
+		&task.Deleted,
+		&task.Retry.MaxAttempts,
+		&retry_backoff,
+		&retry_status_codes,
+		&max_async_duration,
+		&input_schema,
+		&output_schema,
+		&task.Version)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -189,58 +385,208 @@ func (this *TaskRepository) GetTask(id int64) (*sm.Task, error) {
 		return nil, err
 	}
 
-	var param_stmt *sql.Stmt
-	param_stmt, err = this.Pool.Prepare(`
-		select name, data_type, is_input
-		from task_parameter
+	task.Retry.BackoffBase = time.Duration(retry_backoff) * time.Millisecond
+	task.Retry.StatusCodes = parse_status_codes(retry_status_codes)
+	task.MaxAsyncDuration = time.Duration(max_async_duration) * time.Second
+
+	if task.InputSchema, err = parse_schema(input_schema); err != nil {
+		return nil, err
+	} else if task.OutputSchema, err = parse_schema(output_schema); err != nil {
+		return nil, err
+	}
+
+	if err = this.load_params(&task); err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (this *TaskRepository) GetTaskVersion(id int64, version int) (*sm.Task, error) {
+	task, err := this.GetTask(id)
+
+	if err != nil || task == nil || task.Version == version {
+		return task, err
+	}
+
+	stmt, err := this.Pool.Prepare(`
+		select start_url, cancel_url,
+			max_attempts, retry_backoff, retry_status_codes, max_async_duration,
+			input_schema, output_schema, retired
+		from task_version
+		where task_id=$1 and version=$2
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var retry_backoff, max_async_duration int64
+	var retry_status_codes string
+	var input_schema, output_schema sql.NullString
+
+	err = stmt.QueryRow(id, version).Scan(&task.StartUrl,
+		&task.CancelUrl,
+		&task.Retry.MaxAttempts,
+		&retry_backoff,
+		&retry_status_codes,
+		&max_async_duration,
+		&input_schema,
+		&output_schema,
+		&task.Retired)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	task.Version = version
+	task.Retry.BackoffBase = time.Duration(retry_backoff) * time.Millisecond
+	task.Retry.StatusCodes = parse_status_codes(retry_status_codes)
+	task.MaxAsyncDuration = time.Duration(max_async_duration) * time.Second
+
+	if task.InputSchema, err = parse_schema(input_schema); err != nil {
+		return nil, err
+	} else if task.OutputSchema, err = parse_schema(output_schema); err != nil {
+		return nil, err
+	}
+
+	if err = this.load_params(task); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (this *TaskRepository) GetTaskVersions(id int64) ([]*sm.TaskVersion, error) {
+	stmt, err := this.Pool.Prepare(`
+		select version, start_url, cancel_url,
+			max_attempts, retry_backoff, retry_status_codes, max_async_duration,
+			input_schema, output_schema, creation_date, retired
+		from task_version
 		where task_id=$1
+		order by version desc
 	`)
 
 	if err != nil {
 		return nil, err
 	}
 
-	param_rows, param_err := param_stmt.Query(task.ID)
+	rows, err := stmt.Query(id)
 
-	if param_err != nil {
-		return nil, param_err
+	if err != nil {
+		return nil, err
 	}
 
-	defer param_rows.Close()
+	defer rows.Close()
 
-	task.Input = make(map[string]sm.ParamType)
-	task.Output = make(map[string]sm.ParamType)
+	versions := make([]*sm.TaskVersion, 0)
+
+	for rows.Next() {
+		version := sm.TaskVersion{TaskID: id}
+		var retry_backoff, max_async_duration int64
+		var retry_status_codes string
+		var input_schema, output_schema sql.NullString
+
+		err = rows.Scan(&version.Version,
+			&version.StartUrl,
+			&version.CancelUrl,
+			&version.Retry.MaxAttempts,
+			&retry_backoff,
+			&retry_status_codes,
+			&max_async_duration,
+			&input_schema,
+			&output_schema,
+			&version.CreationDate,
+			&version.Retired)
 
-	for param_rows.Next() {
-		var name string
-		var parameter_type sm.ParamType
-		var is_input bool
-		err = param_rows.Scan(&name, &parameter_type, &is_input)
 		if err != nil {
 			return nil, err
 		}
 
-		if is_input {
-			task.Input[name] = parameter_type
-		} else {
-			task.Output[name] = parameter_type
+		version.Retry.BackoffBase = time.Duration(retry_backoff) * time.Millisecond
+		version.Retry.StatusCodes = parse_status_codes(retry_status_codes)
+		version.MaxAsyncDuration = time.Duration(max_async_duration) * time.Second
+
+		if version.InputSchema, err = parse_schema(input_schema); err != nil {
+			return nil, err
+		} else if version.OutputSchema, err = parse_schema(output_schema); err != nil {
+			return nil, err
 		}
+
+		versions = append(versions, &version)
 	}
 
-	return &task, nil
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The parameters are read after the versions, a connection is used by the rows
+	for _, version := range versions {
+		task := sm.Task{ID: id, Version: version.Version}
+
+		if err = this.load_params(&task); err != nil {
+			return nil, err
+		}
+
+		version.Input = task.Input
+		version.Output = task.Output
+	}
+
+	return versions, nil
+}
+
+func (this *TaskRepository) RetireVersions() (int64, error) {
+	stmt, err := this.Pool.Prepare(`
+		update task_version v set
+			retired=true
+		from task t
+		where
+			t.id=v.task_id and
+			v.version<t.version and
+			not v.retired and
+			not exists (
+				select s.id from job_step s
+				inner join job j
+					on j.id=s.job_id
+				where
+					s.task_id=v.task_id and
+					s.task_version=v.version and
+					s.status<>$1 and
+					not j.is_canceled and
+					not j.is_completed
+			)
+	`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := stmt.Exec(sm.StepCompleted)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
 }
 
 func (this *TaskRepository) GetTasks(module_id int64, fetch_deleted bool) ([]*sm.Task, error) {
 	var select_task_query string
 	if fetch_deleted {
 		select_task_query = `
-							select id, name, description, start_url, cancel_url, enabled, deleted
+							select id, name, description, start_url, cancel_url, enabled, deleted,
+								max_attempts, retry_backoff, retry_status_codes, max_async_duration,
+								input_schema, output_schema, version
 							from task
 							where module_id=$1
 							`
 	} else {
 		select_task_query = `
-							select id, name, description, start_url, cancel_url, enabled, deleted
+							select id, name, description, start_url, cancel_url, enabled, deleted,
+								max_attempts, retry_backoff, retry_status_codes, max_async_duration,
+								input_schema, output_schema, version
 							from task
 							where deleted=false and module_id=$1
 							`
@@ -253,9 +599,9 @@ func (this *TaskRepository) GetTasks(module_id int64, fetch_deleted bool) ([]*sm
 	}
 
 	param_stmt, err := this.Pool.Prepare(`
-							select name, data_type, is_input
+							select name, data_type, is_input, enum_values, required, default_value
 							from task_parameter
-							where task_id=$1
+							where task_id=$1 and version=$2
 						`)
 
 	if err != nil {
@@ -275,43 +621,49 @@ func (this *TaskRepository) GetTasks(module_id int64, fetch_deleted bool) ([]*sm
 	for rows.Next() {
 		task := sm.Task{ModuleID: module_id}
 
+		var retry_backoff, max_async_duration int64
+		var retry_status_codes string
+		var input_schema, output_schema sql.NullString
+
 		err = rows.Scan(&task.ID,
 			&task.Name,
 			&task.Description,
 			&task.StartUrl,
 			&task.CancelUrl,
 			&task.Enabled,
-			&task.Deleted)
+			&task.Deleted,
+			&task.Retry.MaxAttempts,
+			&retry_backoff,
+			&retry_status_codes,
+			&max_async_duration,
+			&input_schema,
+			&output_schema,
+			&task.Version)
 
 		if err != nil {
 			return nil, err
 		}
 
-		param_rows, err := param_stmt.Query(task.ID)
+		task.Retry.BackoffBase = time.Duration(retry_backoff) * time.Millisecond
+		task.Retry.StatusCodes = parse_status_codes(retry_status_codes)
+		task.MaxAsyncDuration = time.Duration(max_async_duration) * time.Second
 
-		if err != nil {
+		if task.InputSchema, err = parse_schema(input_schema); err != nil {
+			return nil, err
+		} else if task.OutputSchema, err = parse_schema(output_schema); err != nil {
 			return nil, err
 		}
 
-		defer param_rows.Close()
+		param_rows, err := param_stmt.Query(task.ID, task.Version)
 
-		task.Input = make(map[string]sm.ParamType)
-		task.Output = make(map[string]sm.ParamType)
+		if err != nil {
+			return nil, err
+		}
 
-		for param_rows.Next() {
-			var name string
-			var parameter_type sm.ParamType
-			var is_input bool
-			err = param_rows.Scan(&name, &parameter_type, &is_input)
-			if err != nil {
-				return nil, err
-			}
+		defer param_rows.Close()
 
-			if is_input {
-				task.Input[name] = parameter_type
-			} else {
-				task.Output[name] = parameter_type
-			}
+		if err = scan_task_params(param_rows, &task); err != nil {
+			return nil, err
 		}
 
 		tasks = append(tasks, &task)
@@ -339,8 +691,10 @@ func (this *TaskRepository) SetAvailability(id int64, enabled bool) error {
 func (this *TaskRepository) Save(task *sm.Task) error {
 	stmt, err := this.Pool.Prepare(`
 		update task set
-			name=$1, description=$2, start_url=$3, cancel_url=$4
-		where id=$5
+			name=$1, description=$2,
+			max_attempts=$4, retry_backoff=$5, retry_status_codes=$6,
+			max_async_duration=$7
+		where id=$3
 	`)
 
 	if err != nil {
@@ -349,9 +703,11 @@ func (this *TaskRepository) Save(task *sm.Task) error {
 
 	res, err := stmt.Exec(task.Name,
 		task.Description,
-		task.StartUrl,
-		task.CancelUrl,
-		task.ID)
+		task.ID,
+		task.Retry.MaxAttempts,
+		int64(task.Retry.BackoffBase/time.Millisecond),
+		format_status_codes(task.Retry.StatusCodes),
+		int64(task.MaxAsyncDuration/time.Second))
 
 	if err != nil {
 		return err
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type TotpRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *TotpRepository) Get(user_role int, user_id int64) (*sm.TotpSecret, error) {
+	stmt, err := this.Pool.Prepare(`
+		select secret, enabled, creation_date, last_step
+		from totp
+		where user_role=$1 and user_id=$2
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret := sm.TotpSecret{UserRole: user_role, UserID: user_id}
+
+	err = stmt.QueryRow(user_role, user_id).Scan(
+		&secret.Secret,
+		&secret.Enabled,
+		&secret.CreationDate,
+		&secret.LastStep)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
+
+func (this *TotpRepository) Save(secret *sm.TotpSecret) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into totp (user_role, user_id, secret, enabled, creation_date, last_step)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (user_role, user_id) do update set
+			secret=$3, enabled=$4, creation_date=$5, last_step=$6
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(
+		secret.UserRole,
+		secret.UserID,
+		secret.Secret,
+		secret.Enabled,
+		secret.CreationDate,
+		secret.LastStep)
+
+	return err
+}
+
+func (this *TotpRepository) Delete(user_role int, user_id int64) error {
+	tx, err := this.Pool.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		delete from totp_recovery_code where user_role=$1 and user_id=$2
+	`, user_role, user_id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`
+		delete from totp where user_role=$1 and user_id=$2
+	`, user_role, user_id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (this *TotpRepository) UseStep(user_role int, user_id int64, step int64) (bool, error) {
+	stmt, err := this.Pool.Prepare(`
+		update totp set
+			last_step=$3
+		where user_role=$1 and user_id=$2 and last_step<$3
+	`)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, err := stmt.Exec(user_role, user_id, step)
+
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+
+	return count == 1, err
+}
+
+func (this *TotpRepository) SetRecoveryCodes(user_role int, user_id int64,
+	hashed_codes []string) error {
+
+	tx, err := this.Pool.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		delete from totp_recovery_code where user_role=$1 and user_id=$2
+	`, user_role, user_id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, hashed_code := range hashed_codes {
+		_, err = tx.Exec(`
+			insert into totp_recovery_code (user_role, user_id, hashed_code)
+			values ($1, $2, $3)
+		`, user_role, user_id, hashed_code)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (this *TotpRepository) UseRecoveryCode(user_role int, user_id int64,
+	hashed_code string) (bool, error) {
+
+	stmt, err := this.Pool.Prepare(`
+		update totp_recovery_code set
+			used_date=$4
+		where user_role=$1 and user_id=$2 and hashed_code=$3 and used_date is null
+	`)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, err := stmt.Exec(user_role, user_id, hashed_code, time.Now())
+
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+
+	return count == 1, err
+}
+
+func (this *TotpRepository) CountRecoveryCodes(user_role int, user_id int64) (int, error) {
+	stmt, err := this.Pool.Prepare(`
+		select count(*)
+		from totp_recovery_code
+		where user_role=$1 and user_id=$2 and used_date is null
+	`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = stmt.QueryRow(user_role, user_id).Scan(&count)
+
+	return count, err
+}
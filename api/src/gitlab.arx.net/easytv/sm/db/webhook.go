@@ -0,0 +1,230 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+type WebhookRepository struct {
+	Pool *DatabasePool
+}
+
+func (this *WebhookRepository) GetJobEndpoint(job_id int64) (string, string, error) {
+	stmt, err := this.Pool.Prepare(`
+		select coalesce(j.callback_url, o.callback_url), o.webhook_secret
+		from job j
+		join organisation o on o.id=j.organisation_id
+		where j.id=$1
+	`)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	var url, secret string
+
+	err = stmt.QueryRow(job_id).Scan(&url, &secret)
+
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+
+	return url, secret, err
+}
+
+func (this *WebhookRepository) CreateDelivery(delivery *sm.WebhookDelivery) error {
+	stmt, err := this.Pool.Prepare(`
+		insert into webhook_delivery (
+			organisation_id,
+			job_id,
+			url,
+			event,
+			payload,
+			status,
+			attempts,
+			error,
+			creation_date,
+			next_attempt)
+		values ($1, $2, $3, $4, $5, $6, 0, '', $7, $8)
+		returning id
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	row := stmt.QueryRow(
+		delivery.OrganisationID,
+		delivery.JobID,
+		delivery.Url,
+		delivery.Event,
+		delivery.Payload,
+		delivery.Status,
+		delivery.CreationDate,
+		delivery.NextAttempt)
+
+	return row.Scan(&delivery.ID)
+}
+
+func (this *WebhookRepository) Lease(duration time.Duration) (*sm.WebhookDelivery, error) {
+	stmt, err := this.Pool.Prepare(`
+		update webhook_delivery d set
+			next_attempt=$1
+		from organisation o
+		where
+			o.id=d.organisation_id and
+			d.id=(
+				select id from webhook_delivery
+				where status=$2 and next_attempt<=$3
+				order by next_attempt asc
+				limit 1
+				for update skip locked
+			)
+		returning
+			d.id,
+			d.organisation_id,
+			d.job_id,
+			d.url,
+			d.event,
+			d.payload,
+			d.status,
+			d.attempts,
+			d.creation_date,
+			o.webhook_secret
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	delivery := sm.WebhookDelivery{NextAttempt: now.Add(duration)}
+
+	err = stmt.QueryRow(delivery.NextAttempt, sm.DeliveryPending, now).Scan(
+		&delivery.ID,
+		&delivery.OrganisationID,
+		&delivery.JobID,
+		&delivery.Url,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.CreationDate,
+		&delivery.Secret)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (this *WebhookRepository) SaveAttempt(delivery *sm.WebhookDelivery) error {
+	stmt, err := this.Pool.Prepare(`
+		update webhook_delivery set
+			status=$2,
+			attempts=$3,
+			status_code=$4,
+			error=$5,
+			last_attempt=$6,
+			next_attempt=$7
+		where id=$1
+	`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.LastAttempt,
+		delivery.NextAttempt)
+
+	return err
+}
+
+func (this *WebhookRepository) GetDeliveriesForOrganisation(
+	organisation_id, limit, before_id int64) ([]*sm.WebhookDelivery, error) {
+
+	query := `
+		select
+			id,
+			job_id,
+			url,
+			event,
+			payload,
+			status,
+			attempts,
+			status_code,
+			error,
+			creation_date,
+			last_attempt,
+			next_attempt
+		from webhook_delivery
+		where organisation_id=$1`
+	args := []interface{}{organisation_id}
+
+	if before_id != -1 {
+		args = append(args, before_id)
+		query += fmt.Sprintf(" and id<$%d", len(args))
+	}
+
+	query += " order by id desc"
+
+	if limit != -1 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	stmt, err := this.Pool.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := make([]*sm.WebhookDelivery, 0)
+
+	for rows.Next() {
+		delivery := sm.WebhookDelivery{OrganisationID: organisation_id}
+
+		err = rows.Scan(
+			&delivery.ID,
+			&delivery.JobID,
+			&delivery.Url,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.StatusCode,
+			&delivery.Error,
+			&delivery.CreationDate,
+			&delivery.LastAttempt,
+			&delivery.NextAttempt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, nil
+}
@@ -1,6 +1,7 @@
 package sm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -9,18 +10,63 @@ import (
 type JobParam struct {
 	DataType ParamType
 	Value    interface{}
-	// can be linked with an output param of the previous step
+	// can be linked with an output param of an ancestor step,
+	// LinkedStep is the index of that step in Job.Steps
+	LinkedStep       *int
 	LinkedOutputName *string
 }
 
+// StepStatus is the progress of a single step of a job
+type StepStatus int
+
+const (
+	// The step waits for the steps it depends on
+	StepWaiting StepStatus = 0
+	// The step is queued or performed by its service
+	StepActive    StepStatus = 1
+	StepCompleted StepStatus = 2
+)
+
+// StepStatusStr returns a string name of the given StepStatus
+func StepStatusStr(status StepStatus) string {
+	switch status {
+	case StepWaiting:
+		return "waiting"
+	case StepActive:
+		return "active"
+	case StepCompleted:
+		return "completed"
+	}
+	return ""
+}
+
 type JobStep struct {
 	ID     int64
 	TaskID int64
+	// The version of the task the step was created with
+	TaskVersion int
+	// The index of the step in Job.Steps
+	Order int
+	// The indexes of the steps that have to be completed before this one,
+	// a step can only depend on steps that come before it.
+	DependsOn []int
+	Status    StepStatus
 	// The `key` of the map is the name of the parameter
 	Input  map[string]JobParam
 	Output map[string]JobParam
 }
 
+// JobStepAttempt is a record of a start request sent for a step
+type JobStepAttempt struct {
+	ID      int64
+	StepID  int64
+	Attempt int
+	Date    time.Time
+	// nil if the request didn't get a response
+	StatusCode *int
+	Error      string
+}
+
 type Job struct {
 	ID              int64
 	IsCompleted     bool
@@ -29,10 +75,68 @@ type Job struct {
 	CompletionDate  *time.Time
 	PublicationDate time.Time
 	ExpirationDate  time.Time
-	Owner           ContentOwner
-	Status          string
-	CurrentStep     int
-	Steps           []*JobStep
+	// The member that created the job
+	Owner ContentOwner
+	// The organisation that owns the job
+	OrganisationID int64
+	Status         string
+	// Overrides the callback url of the organisation for this job
+	CallbackUrl *string
+	// The steps of the job form a graph through JobStep.DependsOn,
+	// independent steps are performed concurrently.
+	Steps []*JobStep
+
+	// The ancestors of the steps by index, computed by IsAncestor in step order
+	ancestors [][]bool
+}
+
+// MaxJobSteps is the most steps that a job can have
+const MaxJobSteps = 100
+
+// StepByID returns the step of the job with the given id or nil
+func (this *Job) StepByID(step_id int64) *JobStep {
+	for _, step := range this.Steps {
+		if step.ID == step_id {
+			return step
+		}
+	}
+	return nil
+}
+
+// ActiveSteps returns the steps that are queued or performed by their service
+func (this *Job) ActiveSteps() []*JobStep {
+	steps := make([]*JobStep, 0)
+	for _, step := range this.Steps {
+		if step.Status == StepActive {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// IsAncestor checks if the step at index `ancestor` has to be completed
+// before the step at index `order`. The ancestors of a step are computed
+// once from the ones of its dependencies, the DependsOn of the steps
+// shouldn't change after the first call.
+func (this *Job) IsAncestor(ancestor, order int) bool {
+	for index := len(this.ancestors); index <= order; index++ {
+		ancestors := make([]bool, index)
+
+		for _, dependency := range this.Steps[index].DependsOn {
+			if dependency < 0 || dependency >= index {
+				continue
+			}
+
+			ancestors[dependency] = true
+			for step, is_ancestor := range this.ancestors[dependency] {
+				ancestors[step] = ancestors[step] || is_ancestor
+			}
+		}
+
+		this.ancestors = append(this.ancestors, ancestors)
+	}
+
+	return ancestor >= 0 && ancestor < order && this.ancestors[order][ancestor]
 }
 
 type JobRepository interface {
@@ -46,8 +150,8 @@ type JobRepository interface {
 	GetJobStepForModule(
 		step_id, module_id int64) (map[string]interface{}, error)
 
-	GetJobsForContentOwner(
-		owner_id, limit, before_job_id int64) ([]*Job, error)
+	GetJobsForOrganisation(
+		organisation_id, limit, before_job_id int64) ([]*Job, error)
 
 	GetJobByStepID(step_id int64) (*Job, error)
 
@@ -59,9 +163,26 @@ type JobRepository interface {
 
 	SaveStatus(job *Job) error
 
+	// Saves the status of the job and the time that the step
+	// started waiting for the `finish` request of the service
+	SavePendingStep(job *Job, step *JobStep, since time.Time) error
+
+	// Creates the job and queues the steps without dependencies
 	CreateJob(job *Job) error
 
-	SaveStepProgress(job *Job) error
+	// Saves the output of an active step, marks it as completed and queues
+	// the steps whose dependencies are now completed.
+	// Returns true if all the steps of the job are completed and
+	// ErrJobIsCompleted if the step is not active.
+	CompleteStep(job *Job, step *JobStep) (bool, error)
+
+	// Saves the status and queues the step again, nothing is done if
+	// the step isn't active or the job was canceled or completed meanwhile
+	RetryStep(job *Job, step *JobStep, available_at time.Time) error
+
+	AddStepAttempt(attempt *JobStepAttempt) error
+
+	GetStepAttempts(step_id int64) ([]*JobStepAttempt, error)
 
 	SaveFinishedState(job *Job) error
 
@@ -71,6 +192,12 @@ type JobRepository interface {
 
 	CancelJobsWithExceedingPublicatinDate(timestamp time.Time) error
 
+	// The ids of the active steps that are pending longer than the
+	// MaxAsyncDuration of their task
+	GetTimedOutSteps(
+		timestamp time.Time,
+		limit, offset_id int64) ([]int64, error)
+
 	GetJobsExceedingExpirationDate(
 		timestamp time.Time,
 		limit, offset_id int64) ([]*Job, error)
@@ -90,22 +217,36 @@ type JobRepository interface {
 type JobService interface {
 	SetJobStatusForStep(step_id int64, status string) error
 
-	CreateJob(user_id, publication_date, expiration_date int64,
+	// An empty callback_url means that the events are sent
+	// to the callback url of the organisation
+	CreateJob(organisation_id, user_id, publication_date, expiration_date int64,
+		callback_url string,
 		tasks []map[string]interface{}) (*Job, error)
 
 	CancelJobsWithExceedingPublicationDate() error
 
 	CancelJobsWithExceedingExpirationDate() error
 
+	TimeoutPendingSteps() error
+
 	CancelJobAsModule(module *Module, step_id int64) error
 
-	CancelJobAsOwner(owner_id, job_id int64) error
+	// Cancel the job as a member of the organisation that owns it
+	CancelJobAsOwner(organisation_id, job_id int64) error
+
+	// Cancel any job, for the admins with the job:cancel permission
+	CancelJobAsOperator(job_id int64) error
+
+	SendCancelRequest(job *Job, step *JobStep, task *Task, module *Module) error
 
-	SendCancelRequest(job *Job, task *Task, module *Module) error
+	// Performs a step that was taken from the job queue.
+	// confirm is called right before the start request is sent and the step
+	// isn't started if it fails, the request is canceled with ctx.
+	// An error means that the step should be performed again.
+	PerformJobStep(ctx context.Context, step_id int64, confirm func() error) error
 
-	// The job is passed by value in order to make the function
-	// safe to call as a goroutine
-	PerformNextStepOfJob(job Job)
+	// Aborts the job of a step that can't be performed
+	AbortJobStep(step_id int64, reason string) error
 
 	FinishJobStep(step_id int64, module *Module, output map[string]interface{}) error
 }
@@ -120,6 +261,7 @@ var ErrJobStatusNotUpdatable = errors.New(
 var ErrInvalidPublicationDate = errors.New("Publication date should be in the future")
 var ErrInvalidExpirationDate = errors.New("Expiration date should be after publication date")
 var ErrEmptyTasks = errors.New("There should be at least on task for a job")
+var ErrTooManyTasks = fmt.Errorf("A job can't have more than %d tasks", MaxJobSteps)
 var ErrMissingTaskID = errors.New("The task_id is missing in the input")
 
 type ErrTaskNotFound struct{ TaskID int64 }
@@ -152,11 +294,44 @@ func (e *ErrInvalidTaskOutput) Error() string {
 	return e.Message
 }
 
-type ErrTaskOutputNotFound struct{ Name string }
+type ErrTaskOutputNotFound struct {
+	Name string
+	// The index of the linked step, nil when the output was searched
+	// in all the ancestors
+	Step *int
+	// The task of the linked step
+	TaskID int64
+}
 
 func (e *ErrTaskOutputNotFound) Error() string {
+	if e.Step != nil {
+		return fmt.Sprintf(
+			"Step %d (task %d) doesn't have an output named %s", *e.Step, e.TaskID, e.Name)
+	}
+	return fmt.Sprintf(
+		"No ancestor task has an output named %s", e.Name)
+}
+
+type ErrInvalidDependency struct {
+	Step       int
+	Dependency int
+}
+
+func (e *ErrInvalidDependency) Error() string {
+	return fmt.Sprintf(
+		"Task %d can't depend on task %d, a task can only depend on the tasks before it",
+		e.Step, e.Dependency)
+}
+
+type ErrInvalidLinkedStep struct {
+	Step       int
+	LinkedStep int
+}
+
+func (e *ErrInvalidLinkedStep) Error() string {
 	return fmt.Sprintf(
-		"Previous task doesn't have an output named %s", e.Name)
+		"Task %d can't link its input with task %d, it has to be completed before it",
+		e.Step, e.LinkedStep)
 }
 
 type ErrLinkedParameterNotTheSameType struct {
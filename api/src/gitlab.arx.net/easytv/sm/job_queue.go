@@ -0,0 +1,46 @@
+package sm
+
+import (
+	"errors"
+	"time"
+)
+
+// JobQueueEntry is a job step that waits to be performed by a worker
+type JobQueueEntry struct {
+	ID     int64
+	JobID  int64
+	StepID int64
+	// How many times the entry has been leased, an entry that is leased more
+	// than once was interrupted (e.g. the api restarted while performing it)
+	Attempts        int
+	LeaseOwner      string
+	LeaseExpiration time.Time
+}
+
+// JobQueueRepository is the persistent queue of the job steps.
+//
+// Entries are added by the JobRepository in the same transaction that
+// creates the job or saves its progress, so a step can't get lost.
+type JobQueueRepository interface {
+	// Lease reserves the next available entry for the given duration.
+	// Entries with an expired lease are available again.
+	// Returns nil if there is nothing to do.
+	Lease(owner string, duration time.Duration) (*JobQueueEntry, error)
+
+	// ExtendLease keeps the entry reserved while its step is performed
+	ExtendLease(entry *JobQueueEntry, duration time.Duration) error
+
+	// Complete removes the entry from the queue
+	Complete(entry *JobQueueEntry) error
+
+	// ReleaseLeases makes available all the entries leased by the workers
+	// whose name starts with "<owner_prefix>/"
+	ReleaseLeases(owner_prefix string) error
+}
+
+// MaxLeaseAttempts is how many times an entry can be leased before
+// its job is aborted, a step that keeps interrupting its workers isn't
+// performed forever
+const MaxLeaseAttempts = 5
+
+var ErrLeaseLost = errors.New("The lease of the queue entry has been lost")
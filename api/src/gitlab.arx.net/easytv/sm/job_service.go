@@ -2,33 +2,152 @@ package sm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"gitlab.arx.net/easytv/sm/signature"
 
 	"net/http"
 	"time"
 )
 
+// ModuleRequestTimeout is how long the modules have to respond to the
+// start and cancel requests, it should be shorter than the job lease
+const ModuleRequestTimeout = 30 * time.Second
+
 type jservice struct {
 	repository        JobRepository
 	task_repository   TaskRepository
 	module_repository ModuleRepository
 	owner_repository  ContentOwnerRepository
+	asset_repository  AssetRepository
+	notifier          JobEventNotifier
+	// Signs the asset urls of the start requests, nil to send them unsigned
+	asset_linker *AssetLinker
 }
 
+// NewJobService creates the service, the modules get the assets of
+// their input through the links of asset_linker
 func NewJobService(repository JobRepository,
 	task_repository TaskRepository,
 	module_repository ModuleRepository,
-	owner_repository ContentOwnerRepository) JobService {
+	owner_repository ContentOwnerRepository,
+	asset_repository AssetRepository,
+	notifier JobEventNotifier,
+	asset_linker *AssetLinker) JobService {
 	return &jservice{
 		repository:        repository,
 		task_repository:   task_repository,
 		module_repository: module_repository,
 		owner_repository:  owner_repository,
+		asset_repository:  asset_repository,
+		notifier:          notifier,
+		asset_linker:      asset_linker,
+	}
+}
+
+// Returns the signed url that the module of the step downloads an asset from,
+// the link expires with the job
+func (this jservice) assetDownloadUrl(job *Job, step *JobStep, value interface{}) interface{} {
+	url, ok := value.(string)
+	if !ok || this.asset_linker == nil {
+		return value
+	}
+
+	expires := time.Now().Add(ModuleAssetLinkExpiration * time.Second)
+	if job.ExpirationDate.Before(expires) {
+		expires = job.ExpirationDate
+	}
+
+	return this.asset_linker.Link(url, StepScope(step.ID), expires)
+}
+
+// Checks the value of a task parameter and converts it to the type of the
+// parameter. If the value is invalid a message describes the problem.
+// Assets have to belong to a job or to a content owner of the given organisation,
+// they are given by their url or as {"asset_id": id}.
+func (this jservice) checkParamValue(organisation_id int64, name string, param TaskParam,
+	value interface{}) (interface{}, string, error) {
+
+	if reference, ok := value.(map[string]interface{}); ok && param.Type == AssetParam {
+		asset_id, _ := reference["asset_id"].(float64)
+
+		asset, err := this.asset_repository.GetAsset(int64(asset_id))
+		if err != nil {
+			return nil, "", err
+		} else if asset == nil || !asset.Complete {
+			return nil, fmt.Sprintf("The asset of parameter %s doesn't exist", name), nil
+		}
+
+		value = AssetUrlPrefix + asset.UrlParam
+	}
+
+	converted, ok := param.Check(value)
+
+	if !ok && param.Type == EnumParam {
+		return nil, fmt.Sprintf("Parameter %s should be one of (%s)",
+			name, strings.Join(param.Values, ", ")), nil
+	} else if !ok && param.Type == AssetParam {
+		return nil, fmt.Sprintf("Parameter %s should be an asset url (%s...)",
+			name, AssetUrlPrefix), nil
+	} else if !ok {
+		return nil, fmt.Sprintf("Parameter %s should be of type %s",
+			name, ParamTypeStr(param.Type)), nil
+	}
+
+	if param.Type != AssetParam {
+		return converted, "", nil
+	}
+
+	asset, err := this.asset_repository.GetAssetByUrlParam(AssetUrlParam(converted.(string)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The uploads of the content owners have no job
+	var asset_organisation_id int64
+	if asset != nil && asset.IsOwnerAsset() {
+		asset_organisation_id = asset.OrganisationID
+	} else if asset != nil {
+		asset_job, err := this.repository.GetJobByID(asset.JobID)
+		if err != nil {
+			return nil, "", err
+		} else if asset_job != nil {
+			asset_organisation_id = asset_job.OrganisationID
+		}
+	}
+
+	if asset_organisation_id == 0 || asset_organisation_id != organisation_id {
+		return nil, fmt.Sprintf("The asset of parameter %s doesn't exist", name), nil
+	}
+
+	return converted, "", nil
+}
+
+// Validates the output of the step against the schema of its task
+func validate_output(task *Task, step *JobStep) []string {
+	if task.OutputSchema == nil {
+		return nil
+	}
+
+	values := make(map[string]interface{})
+	for name, param := range step.Output {
+		values[name] = param.Value
+	}
+
+	return task.OutputSchema.Validate("output", values)
+}
+
+// The data of the events about a step
+func step_event_data(step *JobStep) map[string]interface{} {
+	return map[string]interface{}{
+		"step":         step.Order,
+		"task_id":      step.TaskID,
+		"task_version": step.TaskVersion,
 	}
 }
 
@@ -49,7 +168,7 @@ func (this *jservice) SetJobStatusForStep(step_id int64, status string) error {
 		return err
 	}
 
-	if job.Steps[job.CurrentStep].ID != step_id {
+	if step := job.StepByID(step_id); step == nil || step.Status != StepActive {
 		return ErrJobStatusNotUpdatable
 	}
 
@@ -58,26 +177,38 @@ func (this *jservice) SetJobStatusForStep(step_id int64, status string) error {
 	return this.repository.SaveStatus(job)
 }
 
-// Sends a cancel request for the current job step
+// Signs a request to a module, the modules that opted in
+// get their legacy key too
+func sign_module_request(req *http.Request, module *Module, body []byte) {
+	now := time.Now()
+
+	signature.SignRequestWithSecrets(req, module.SigningSecrets(now), body)
+
+	if legacy_key := module.SentLegacyKey(now); len(legacy_key) > 0 {
+		req.Header.Set(EasyTVApiKeyHeader, legacy_key)
+	}
+}
+
+// Sends a cancel request for a step of the job
 func (this *jservice) SendCancelRequest(
-	job *Job, task *Task, module *Module) error {
+	job *Job, step *JobStep, task *Task, module *Module) error {
 	log.Infof("job=%v step=%v send cancel request url=%v",
 		job.ID,
-		job.Steps[job.CurrentStep].ID,
+		step.ID,
 		task.CancelUrl)
 	json_data, _ := json.Marshal(map[string]interface{}{
-		"job_id": job.Steps[job.CurrentStep].ID,
+		"job_id": step.ID,
 		"action": "cancel",
 	})
 
-	client := http.Client{}
+	client := http.Client{Timeout: ModuleRequestTimeout}
 
 	var req *http.Request
 	var err error
 
 	if strings.HasPrefix(task.CancelUrl, "REST") {
 		// For rest endpoints send a DELETE request
-		url := fmt.Sprintf("%v/%v", task.CancelUrl[5:], job.Steps[job.CurrentStep].ID)
+		url := fmt.Sprintf("%v/%v", task.CancelUrl[5:], step.ID)
 		req, err = http.NewRequest("DELETE", url, bytes.NewBuffer(json_data))
 	} else {
 		req, err = http.NewRequest("POST", task.CancelUrl, bytes.NewBuffer(json_data))
@@ -87,8 +218,8 @@ func (this *jservice) SendCancelRequest(
 		return err
 	}
 
-	req.Header.Add(EasyTVApiKeyHeader, module.ApiKey)
 	req.Header.Add("Content-Type", "application/json")
+	sign_module_request(req, module, json_data)
 
 	resp, err := client.Do(req)
 
@@ -123,6 +254,36 @@ func (this *jservice) SendCancelRequest(
 	return nil
 }
 
+// Sends a cancel request for every active step of the job except `skip`,
+// the errors are only logged.
+func (this *jservice) sendCancelRequests(job *Job, skip *JobStep) {
+	for _, step := range job.ActiveSteps() {
+		if step == skip {
+			continue
+		}
+
+		task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
+
+		if err != nil || task == nil {
+			log.Printf("Failed to fetch task=%d for step=%d err='%s'", step.TaskID, step.ID, err)
+			continue
+		}
+
+		module, err := this.module_repository.GetModuleByID(task.ModuleID)
+
+		if err != nil || module == nil {
+			log.Printf("Failed to fetch module=%d for task=%d err='%s'", task.ModuleID, task.ID, err)
+			continue
+		}
+
+		err = this.SendCancelRequest(job, step, task, module)
+
+		if err != nil {
+			log.Printf("Failed to send cancel request for job=%d step=%d err=%s", job.ID, step.ID, err)
+		}
+	}
+}
+
 // CancelJob as a specific module
 func (this *jservice) CancelJobAsModule(module *Module, step_id int64) error {
 	log.Infof("step=%v cancel as module=%v,'%v'", step_id, module.ID, module.Name)
@@ -139,30 +300,30 @@ func (this *jservice) CancelJobAsModule(module *Module, step_id int64) error {
 		return ErrJobIsCanceled
 	}
 
-	// Get the task for the current job step
+	// Get the task for the job step
 	err = this.repository.GetJobSteps(job.ID, &job.Steps)
 
 	if err != nil {
 		return err
 	}
 
-	task_id := job.Steps[job.CurrentStep].TaskID
-	task, err := this.task_repository.GetTask(task_id)
+	step := job.StepByID(step_id)
+	task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
 	if err != nil {
 		return err
 	} else if task == nil {
 		return fmt.Errorf(
 			"A task with id (%d) doesn't exist job:%d, step:%d",
-			task_id, job.ID, job.CurrentStep)
+			step.TaskID, job.ID, step.ID)
 	}
 
-	// Check if the current module is responsible for the current job step
+	// Check if the module is responsible for an active job step
 	if task.ModuleID != module.ID {
 		// As far as this module is concerned, the job doesn't exist
 		return ErrNotFound
-	} else if step_id > job.Steps[job.CurrentStep].ID {
+	} else if step.Status == StepWaiting {
 		return ErrNotFound
-	} else if step_id < job.Steps[job.CurrentStep].ID {
+	} else if step.Status == StepCompleted {
 		return ErrJobIsCompleted
 	}
 
@@ -178,45 +339,60 @@ func (this *jservice) CancelJobAsModule(module *Module, step_id int64) error {
 		return err
 	}
 
+	this.retireTaskVersions()
+
 	log.Infof("job=%v step=%v saved canceled state", job.ID, step_id)
 
-	return this.SendCancelRequest(job, task, module)
+	this.notifier.Notify(job, EventJobCanceled, map[string]interface{}{
+		"reason": job.Status,
+	})
+
+	this.sendCancelRequests(job, step)
+
+	return this.SendCancelRequest(job, step, task, module)
+}
+
+// Cancel the job as a member of the organisation that owns it
+func (this *jservice) CancelJobAsOwner(organisation_id, job_id int64) error {
+	log.Infof("job=%v cancel as organisation=%v", job_id, organisation_id)
+	job, err := this.repository.GetJobByID(job_id)
+
+	if err != nil {
+		return err
+	} else if job == nil || job.OrganisationID != organisation_id {
+		return ErrNotFound
+	}
+
+	return this.cancelJob(job)
 }
 
-// Cancel the job as the content owner that created it
-func (this *jservice) CancelJobAsOwner(owner_id, job_id int64) error {
-	log.Infof("job=%v cancel as user=%v", job_id, owner_id)
+// Cancel the job as an operator of the manager
+func (this *jservice) CancelJobAsOperator(job_id int64) error {
+	log.Infof("job=%v cancel as operator", job_id)
 	job, err := this.repository.GetJobByID(job_id)
 
 	if err != nil {
 		return err
-	} else if job == nil || job.Owner.ID != owner_id {
+	} else if job == nil {
 		return ErrNotFound
 	}
 
+	return this.cancelJob(job)
+}
+
+func (this *jservice) cancelJob(job *Job) error {
 	if job.IsCanceled {
 		return ErrJobIsCanceled
 	} else if job.IsCompleted {
 		return ErrJobIsCompleted
 	}
 
-	// Get the task for the current job step
-	err = this.repository.GetJobSteps(job.ID, &job.Steps)
+	err := this.repository.GetJobSteps(job.ID, &job.Steps)
 
 	if err != nil {
 		return err
 	}
 
-	task_id := job.Steps[job.CurrentStep].TaskID
-	task, err := this.task_repository.GetTask(task_id)
-	if err != nil {
-		return err
-	} else if task == nil {
-		return fmt.Errorf(
-			"A task with id (%d) doesn't exist job:%d, step:%d",
-			task_id, job.ID, job.CurrentStep)
-	}
-
 	// Set and save the finished state of the job
 	job.IsCompleted = true
 	job.IsCanceled = true
@@ -229,19 +405,17 @@ func (this *jservice) CancelJobAsOwner(owner_id, job_id int64) error {
 		return err
 	}
 
+	this.retireTaskVersions()
+
 	log.Infof("job=%d saved canceled state", job.ID)
 
-	module, err := this.module_repository.GetModuleByID(task.ModuleID)
+	this.notifier.Notify(job, EventJobCanceled, map[string]interface{}{
+		"reason": job.Status,
+	})
 
-	if err != nil {
-		return err
-	} else if module == nil {
-		return fmt.Errorf("Module id(%d) was not found even thought task %d claims it exists",
-			task.ModuleID,
-			task.ID)
-	}
+	this.sendCancelRequests(job, nil)
 
-	return this.SendCancelRequest(job, task, module)
+	return nil
 }
 
 func (this jservice) CancelJobsWithExceedingExpirationDate() error {
@@ -259,7 +433,7 @@ func (this jservice) CancelJobsWithExceedingExpirationDate() error {
 	for len(jobs) > 0 {
 		log.Printf("Batch of %d jobs", len(jobs))
 		for _, job := range jobs {
-			log.Printf("Canceling job=%d at current_step=%d", job.ID, job.CurrentStep)
+			log.Printf("Canceling job=%d", job.ID)
 
 			err = this.repository.GetJobSteps(job.ID, &job.Steps)
 
@@ -268,27 +442,12 @@ func (this jservice) CancelJobsWithExceedingExpirationDate() error {
 				continue
 			}
 
-			step := job.Steps[job.CurrentStep]
-
-			task, err := this.task_repository.GetTask(step.TaskID)
-
-			if err != nil || task == nil {
-				log.Printf("Failed to fetch task=%d for step=%d err='%s'", step.TaskID, step.ID, err)
-				continue
-			}
+			this.sendCancelRequests(job, nil)
 
-			module, err := this.module_repository.GetModuleByID(task.ModuleID)
-
-			if err != nil || module == nil {
-				log.Printf("Failed to fetch module=%d for task=%d err='%s'", task.ModuleID, task.ID, err)
-				continue
-			}
-
-			err = this.SendCancelRequest(job, task, module)
-
-			if err != nil {
-				log.Printf("Failed to send cancel request for job=%d err=%s", job.ID, err)
-			}
+			job.Status = "Expired"
+			this.notifier.Notify(job, EventJobCanceled, map[string]interface{}{
+				"reason": job.Status,
+			})
 		}
 
 		jobs, err = this.repository.GetJobsExceedingExpirationDate(
@@ -320,7 +479,60 @@ func (this jservice) CancelJobsWithExceedingPublicationDate() error {
 	for len(jobs) > 0 {
 		log.Printf("Batch of %d jobs", len(jobs))
 		for _, job := range jobs {
-			log.Printf("Canceling job=%d at current_step=%d", job.ID, job.CurrentStep)
+			log.Printf("Canceling job=%d", job.ID)
+
+			err = this.repository.GetJobSteps(job.ID, &job.Steps)
+
+			if err != nil {
+				log.Printf("Failed to get steps for job=%v err='%v'", job.ID, err)
+				continue
+			}
+
+			this.sendCancelRequests(job, nil)
+
+			job.Status = "Expired"
+			this.notifier.Notify(job, EventJobCanceled, map[string]interface{}{
+				"reason": job.Status,
+			})
+		}
+
+		jobs, err = this.repository.GetJobsExceedingPublicationDate(
+			now,
+			BATCH_LIMIT,
+			jobs[len(jobs)-1].ID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return this.repository.CancelJobsWithExceedingPublicatinDate(now)
+}
+
+// Handles the asynchronous steps that have been waiting for the `finish`
+// request of their service longer than the MaxAsyncDuration of their task.
+// A cancel request is sent to the service and the step is retried according
+// to the retry policy of the task, or the job is aborted.
+func (this jservice) TimeoutPendingSteps() error {
+	now := time.Now()
+
+	BATCH_LIMIT := int64(10000)
+
+	step_ids, err := this.repository.GetTimedOutSteps(now, BATCH_LIMIT, 0)
+
+	if err != nil {
+		return err
+	}
+
+	for len(step_ids) > 0 {
+		log.Printf("Batch of %d steps", len(step_ids))
+		for _, step_id := range step_ids {
+			job, err := this.repository.GetJobByStepID(step_id)
+
+			if err != nil || job == nil {
+				log.Printf("Failed to get job for step=%v err='%v'", step_id, err)
+				continue
+			}
 
 			err = this.repository.GetJobSteps(job.ID, &job.Steps)
 
@@ -329,9 +541,9 @@ func (this jservice) CancelJobsWithExceedingPublicationDate() error {
 				continue
 			}
 
-			step := job.Steps[job.CurrentStep]
+			step := job.StepByID(step_id)
 
-			task, err := this.task_repository.GetTask(step.TaskID)
+			task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
 
 			if err != nil || task == nil {
 				log.Printf("Failed to fetch task=%d for step=%d err='%s'", step.TaskID, step.ID, err)
@@ -345,24 +557,38 @@ func (this jservice) CancelJobsWithExceedingPublicationDate() error {
 				continue
 			}
 
-			err = this.SendCancelRequest(job, task, module)
+			log.Warnf("job=%v step=%v timed out after max_async_duration=%v",
+				job.ID, step.ID, task.MaxAsyncDuration)
 
-			if err != nil {
+			// The service may still be working on the step
+			if err = this.SendCancelRequest(job, step, task, module); err != nil {
 				log.Printf("Failed to send cancel request for job=%d err=%s", job.ID, err)
 			}
+
+			attempts, err := this.repository.GetStepAttempts(step.ID)
+
+			if err != nil {
+				log.Printf("Failed to get attempts for step=%d err='%s'", step.ID, err)
+				continue
+			}
+
+			this.scheduleRetry(job, step, task,
+				len(attempts),
+				fmt.Sprintf("no response in %v", task.MaxAsyncDuration),
+				fmt.Sprintf("Task \"%v\" timed out", task.Name))
 		}
 
-		jobs, err = this.repository.GetJobsExceedingPublicationDate(
+		step_ids, err = this.repository.GetTimedOutSteps(
 			now,
 			BATCH_LIMIT,
-			jobs[len(jobs)-1].ID)
+			step_ids[len(step_ids)-1])
 
 		if err != nil {
 			return err
 		}
 	}
 
-	return this.repository.CancelJobsWithExceedingPublicatinDate(now)
+	return nil
 }
 
 // Creates a new Job for this user
@@ -371,12 +597,16 @@ func (this jservice) CancelJobsWithExceedingPublicationDate() error {
 // expiration_date: the time that the job assets and parameters will be deleted
 // tasks: a list of task as a map that contains
 //		"id": the id of the task
+//		"depends_on": the indexes of the tasks that should be completed before
+//			this one, when it is missing the task depends on the previous one
 //		"input": the input of the task in the form of "name":"value"
-//		"linked_input": the linked input of the task in the form of "name":"previous_output_name"
-func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
+//		"linked_input": the linked input of the task in the form of "name":"ancestor_output_name"
+//			or "name":{"step": ancestor_index, "output": "output_name"}
+func (this jservice) CreateJob(organisation_id, user_id, publication_date, expiration_date int64,
+	callback_url string,
 	tasks []map[string]interface{}) (*Job, error) {
-	log.Infof("create new job user_id=%v publication_date=%v expiration_date=%v tasks='%v'",
-		user_id, publication_date, expiration_date, tasks)
+	log.Infof("create new job organisation_id=%v user_id=%v publication_date=%v expiration_date=%v tasks='%v'",
+		organisation_id, user_id, publication_date, expiration_date, tasks)
 
 	if publication_date <= time.Now().Unix()+60 {
 		return nil, ErrInvalidPublicationDate
@@ -384,6 +614,10 @@ func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
 		return nil, ErrInvalidExpirationDate
 	} else if len(tasks) == 0 {
 		return nil, ErrEmptyTasks
+	} else if len(tasks) > MaxJobSteps {
+		return nil, ErrTooManyTasks
+	} else if len(callback_url) > 0 && !IsValidCallbackUrl(callback_url) {
+		return nil, ErrInvalidCallbackUrl
 	}
 
 	job := Job{
@@ -391,17 +625,45 @@ func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
 		IsCanceled:      false,
 		IsCompleted:     false,
 		Owner:           ContentOwner{ID: user_id},
+		OrganisationID:  organisation_id,
 		Status:          "Started",
-		CurrentStep:     0,
 		Steps:           make([]*JobStep, len(tasks)),
 		PublicationDate: time.Unix(publication_date, 0),
 		ExpirationDate:  time.Unix(expiration_date, 0),
 	}
 
-	var prev_task *Task
+	if len(callback_url) > 0 {
+		job.CallbackUrl = &callback_url
+	}
+
+	// The tasks of the steps, used to validate linked input
+	step_tasks := make([]*Task, len(tasks))
 
 	for order, step_data := range tasks {
-		step := JobStep{}
+		step := JobStep{Order: order, Status: StepWaiting}
+		job.Steps[order] = &step
+
+		// Parse the dependencies, a dependency that is repeated is kept once
+		if depends_on, ok := step_data["depends_on"].([]interface{}); ok {
+			step.DependsOn = make([]int, 0, len(depends_on))
+			seen := make(map[int]bool)
+			for _, value := range depends_on {
+				dependency, ok := value.(float64)
+				if !ok || int(dependency) < 0 || int(dependency) >= order {
+					return nil, &ErrInvalidDependency{Step: order, Dependency: int(dependency)}
+				} else if seen[int(dependency)] {
+					continue
+				}
+				seen[int(dependency)] = true
+				step.DependsOn = append(step.DependsOn, int(dependency))
+			}
+		} else if order > 0 {
+			step.DependsOn = []int{order - 1}
+		}
+
+		if len(step.DependsOn) == 0 {
+			step.Status = StepActive
+		}
 
 		// Fetch the task
 		task_id, ok := step_data["task_id"].(float64)
@@ -419,6 +681,9 @@ func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
 			return nil, &ErrTaskIsDisabled{TaskID: int64(task_id)}
 		}
 
+		// The step is performed with the current contract even if it changes
+		step.TaskVersion = task.Version
+
 		// Check if the service is enabled
 		module, err := this.module_repository.GetModuleByID(task.ModuleID)
 		if err != nil {
@@ -434,74 +699,107 @@ func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
 		input, _ := step_data["input"].(map[string]interface{})
 		linked_input, _ := step_data["linked_input"].(map[string]interface{})
 
-		// The correct number of input parameters must be given
-		// For the starting task there should be no linked_parameters
-		if (order == 0 && (len(input) != len(task.Input) || len(linked_input) != 0)) ||
-			(order > 0 && len(input)+len(linked_input) != len(task.Input)) {
+		// For the starting tasks there should be no linked_parameters
+		if len(step.DependsOn) == 0 && len(linked_input) != 0 {
 			return nil, &ErrInvalidTaskInput{Message: "Invalid number of input parameters"}
 		}
 
+		// Every parameter is given once, only optional parameters can be omitted
+		for name := range task.Input {
+			_, is_input := input[name]
+			_, is_linked := linked_input[name]
+
+			if is_input && is_linked {
+				return nil, &ErrInvalidTaskInput{
+					Message: fmt.Sprintf("Parameter %s is given as input and as linked input", name)}
+			} else if !is_input && !is_linked && !task.IsOptionalInput(name) {
+				return nil, &ErrInvalidTaskInput{
+					Message: fmt.Sprintf("Parameter %s of task %d is missing", name, task.ID)}
+			}
+		}
+
 		step.Input = make(map[string]JobParam)
 
 		// Parse regular input
 		for name, value := range input {
-			give_type := GetParamType(value)
-			if expected_type, ok := task.Input[name]; !ok {
+			param, ok := task.Input[name]
+			if !ok {
 				return nil, &ErrInvalidTaskInput{
 					Message: fmt.Sprintf("Task with id=%d doesn't have a parameter named %v",
 						task.ID, name)}
-			} else if (expected_type == IntParam && give_type == StringParam) ||
-				(expected_type != IntParam && expected_type != give_type) {
-				// For values that are supposed to be 'int', we also accept `float`
-				// and convert them afterwards.
-				return nil, &ErrInvalidTaskInput{
-					Message: fmt.Sprintf("Parameter %s should be of type %s",
-						name, ParamTypeStr(expected_type))}
-			} else {
-				if expected_type == IntParam {
-					value = int64(value.(float64))
-				}
-				step.Input[name] = JobParam{
-					DataType: expected_type,
-					Value:    value,
-				}
+			}
+
+			checked, problem, err := this.checkParamValue(organisation_id, name, param, value)
+			if err != nil {
+				return nil, err
+			} else if len(problem) > 0 {
+				return nil, &ErrInvalidTaskInput{Message: problem}
+			}
+
+			step.Input[name] = JobParam{
+				DataType: param.Type,
+				Value:    checked,
 			}
 		}
 
 		// Parse linked input
 		for name, value := range linked_input {
-			if output_name, ok := value.(string); !ok {
-				return nil, &ErrInvalidTaskInput{
-					Message: "Linked parameter name should be a string",
-				}
-			} else if in_type, ok := task.Input[name]; !ok {
+			if in_type, ok := task.Input[name]; !ok {
 				return nil, &ErrInvalidTaskInput{
 					Message: fmt.Sprintf(
 						"Task (%d) doesn't expect a parameter named %s",
 						task.ID, name),
 				}
-			} else if out_type, ok := prev_task.Output[output_name]; !ok {
-				return nil, &ErrTaskOutputNotFound{Name: output_name}
-			} else if in_type != out_type {
+			} else if linked_step, output_name, err := parse_linked_input(&job, step_tasks, order, value); err != nil {
+				return nil, err
+			} else if out_type := step_tasks[linked_step].Output[output_name]; !out_type.AssignableTo(in_type) {
 				return nil, &ErrLinkedParameterNotTheSameType{
 					InputName:  name,
 					OutputName: output_name,
 				}
 			} else {
 				step.Input[name] = JobParam{
-					DataType:         in_type,
+					DataType:         in_type.Type,
+					LinkedStep:       &linked_step,
 					LinkedOutputName: &output_name,
 				}
 			}
 		}
 
 		// Extra check, in case I missed a code path where this can happen
-		if len(step.Input) != len(task.Input) {
+		if len(step.Input) != len(input)+len(linked_input) {
 			return nil, &ErrInvalidTaskInput{Message: "Invalid number of input parameters"}
 		}
 
-		prev_task = task
-		job.Steps[order] = &step
+		// The values of the linked input are checked when the step starts
+		if task.InputSchema != nil {
+			values := make(map[string]interface{})
+			linked_names := make([]string, 0, len(linked_input))
+
+			for name, param := range step.Input {
+				if param.LinkedOutputName == nil {
+					values[name] = param.Value
+				} else {
+					linked_names = append(linked_names, name)
+				}
+			}
+
+			// The defaults are filled when the step starts
+			for name, value := range task.InputDefaults(values) {
+				if _, is_linked := step.Input[name]; !is_linked {
+					values[name] = value
+				}
+			}
+
+			problems := task.InputSchema.WithoutRequired(linked_names).Validate("input", values)
+			if len(problems) > 0 {
+				return nil, &ErrInvalidTaskInput{
+					Message: fmt.Sprintf("The input of task %d doesn't match its schema: %s",
+						task.ID, strings.Join(problems, "; "))}
+			}
+		}
+
+		step_tasks[order] = task
 	}
 
 	// Validation passed, store job in db
@@ -511,19 +809,88 @@ func (this jservice) CreateJob(user_id, publication_date, expiration_date int64,
 
 	log.Infof("job=%v created by user=%v", job.ID, user_id)
 
-	// Fill content owner information
-	// Is need for 'PerformNextStepOfJob'
-	if err := this.owner_repository.GetContentOwnerByID(&job.Owner); err != nil {
-		return nil, err
+	// The steps without dependencies have been queued with the job,
+	// the workers will pick them up.
+	return &job, nil
+}
+
+// Parses the value of a linked input of the step at index `order`, it is
+// either the name of an ancestor's output or {"step": index, "output": name}.
+// Returns the index of the linked step and the name of its output.
+func parse_linked_input(job *Job, step_tasks []*Task, order int, value interface{}) (int, string, error) {
+	if output_name, ok := value.(string); ok {
+		linked_step, err := find_linked_step(job, step_tasks, order, output_name)
+		return linked_step, output_name, err
 	}
 
-	go this.PerformNextStepOfJob(job)
+	link, _ := value.(map[string]interface{})
+	step_value, step_ok := link["step"].(float64)
+	output_name, output_ok := link["output"].(string)
 
-	return &job, nil
+	if !step_ok || !output_ok {
+		return -1, "", &ErrInvalidTaskInput{
+			Message: "Linked parameter should be an output name or an object with \"step\" and \"output\"",
+		}
+	}
+
+	linked_step := int(step_value)
+
+	if linked_step < 0 || linked_step >= order || !job.IsAncestor(linked_step, order) {
+		return -1, "", &ErrInvalidLinkedStep{Step: order, LinkedStep: linked_step}
+	} else if _, ok := step_tasks[linked_step].Output[output_name]; !ok {
+		return -1, "", &ErrTaskOutputNotFound{
+			Name:   output_name,
+			Step:   &linked_step,
+			TaskID: step_tasks[linked_step].ID,
+		}
+	}
+
+	return linked_step, output_name, nil
+}
+
+// Finds the nearest ancestor of the step at index `order` that has an output
+// with the given name, an ancestor hides the ones before it on its path.
+// In a linear job that is the closest previous step with the output.
+// The name is ambiguous if parallel branches each provide it.
+func find_linked_step(job *Job, step_tasks []*Task, order int, output_name string) (int, error) {
+	candidates := make([]int, 0)
+
+	for ancestor := 0; ancestor < order; ancestor++ {
+		if _, ok := step_tasks[ancestor].Output[output_name]; ok && job.IsAncestor(ancestor, order) {
+			candidates = append(candidates, ancestor)
+		}
+	}
+
+	nearest := make([]int, 0, len(candidates))
+
+	for _, candidate := range candidates {
+		hidden := false
+		for _, other := range candidates {
+			if job.IsAncestor(candidate, other) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			nearest = append(nearest, candidate)
+		}
+	}
+
+	if len(nearest) == 0 {
+		return -1, &ErrTaskOutputNotFound{Name: output_name}
+	} else if len(nearest) > 1 {
+		return -1, &ErrInvalidTaskInput{
+			Message: fmt.Sprintf("Parallel tasks %v have an output named %s, "+
+				"link it with {\"step\": index, \"output\": name}", nearest, output_name),
+		}
+	}
+
+	return nearest[0], nil
 }
 
-// Cancels a job because of an error that has occured
-func (this jservice) AbortJob(job *Job, reason string) {
+// Cancels a job because of an error that has occured at a step,
+// the other active steps of the job are canceled too.
+func (this jservice) AbortJob(job *Job, step *JobStep, reason string) {
 	log.Warnf("job=%v abortd reason=%v", job.ID, reason)
 	job.IsCompleted = true
 	job.IsCanceled = true
@@ -535,256 +902,464 @@ func (this jservice) AbortJob(job *Job, reason string) {
 
 	if err != nil {
 		log.Errorf("job=%v abort failed err=%v", job.ID, err)
+		return
 	}
+
+	this.retireTaskVersions()
+
+	this.notifier.Notify(job, EventJobAborted, map[string]interface{}{
+		"reason": reason,
+	})
+
+	this.sendCancelRequests(job, step)
 }
 
-// Perform the next step of the job starting from the Current job
-// It is meant to be executed as a goroutine
-func (this jservice) PerformNextStepOfJob(job Job) {
-	log.Infof("job=%v perform next steps", job.ID)
-	if job.IsCompleted || job.IsCanceled {
-		log.Infof("job=%v is already completed or canceled", job.ID)
+// Completes a step of the job.
+// The output of the step is saved and the steps that depend on it are queued
+// when all their dependencies are completed, if this was the last step the
+// job is marked as completed.
+func (this jservice) completeStep(job *Job, step *JobStep) error {
+	all_completed, err := this.repository.CompleteStep(job, step)
+
+	if err != nil {
+		return err
+	}
+
+	output := make(map[string]interface{})
+	for name, param := range step.Output {
+		output[name] = param.Value
+	}
+
+	data := step_event_data(step)
+	data["output"] = output
+	this.notifier.Notify(job, EventStepCompleted, data)
+
+	if !all_completed {
+		return nil
+	}
+
+	// All the steps of the Job have been completed.
+	job.IsCompleted = true
+	job.CompletionDate = new(time.Time)
+	*job.CompletionDate = time.Now()
+	job.Status = "Completed"
+
+	if err := this.repository.SaveFinishedState(job); err != nil {
+		return err
+	}
+
+	this.retireTaskVersions()
+
+	log.Infof("job=%d has been compelted", job.ID)
+
+	this.notifier.Notify(job, EventJobCompleted, nil)
+	return nil
+}
+
+// The previous task versions that only a finished job used are retired
+func (this jservice) retireTaskVersions() {
+	retired, err := this.task_repository.RetireVersions()
+
+	if err != nil {
+		log.Errorf("failed to retire task versions err=%v", err)
+	} else if retired > 0 {
+		log.Infof("%v task versions retired", retired)
+	}
+}
+
+func (this jservice) saveAttempt(attempt *JobStepAttempt) {
+	if err := this.repository.AddStepAttempt(attempt); err != nil {
+		log.Errorf("step=%v failed to save attempt=%v err=%v",
+			attempt.StepID, attempt.Attempt, err)
+	}
+}
+
+// Records a failed attempt to start a step of the job and queues
+// the step again according to the retry policy of the task.
+// When the attempts are used up the job is aborted with the given reason.
+func (this jservice) retryStep(job *Job, step *JobStep, task *Task, attempt *JobStepAttempt, reason string) {
+	this.saveAttempt(attempt)
+	this.scheduleRetry(job, step, task, attempt.Attempt, attempt.Error, reason)
+}
+
+// Queues a step of the job again after the given attempt failed,
+// or aborts the job if it was the last attempt of the retry policy.
+func (this jservice) scheduleRetry(job *Job, step *JobStep, task *Task, attempt int, failure, reason string) {
+	if attempt >= task.Retry.MaxAttempts {
+		this.AbortJob(job, step, fmt.Sprintf("%s after %d attempts", reason, attempt))
 		return
 	}
 
-	if len(job.Steps) == 0 {
-		log.Infof("job=%v get job steps", job.ID)
-		err := this.repository.GetJobSteps(job.ID, &job.Steps)
+	delay := task.Retry.Backoff(attempt)
+
+	log.Warnf("job=%v step=%v retry in %v attempt=%v/%v",
+		job.ID, step.ID, delay, attempt, task.Retry.MaxAttempts)
+
+	job.Status = fmt.Sprintf("Retrying task \"%s\" in %v, attempt %d/%d failed (%s)",
+		task.Name,
+		delay,
+		attempt,
+		task.Retry.MaxAttempts,
+		failure)
+
+	if err := this.repository.RetryStep(job, step, time.Now().Add(delay)); err != nil {
+		log.Errorf("job=%v failed to queue retry err=%v", job.ID, err)
+		this.AbortJob(job, step, "Internal Server Error")
+	}
+}
+
+// Abort the job of a step, the jobs that are already finished are left as they are
+func (this jservice) AbortJobStep(step_id int64, reason string) error {
+	job, err := this.repository.GetJobByStepID(step_id)
+	if err != nil {
+		return err
+	} else if job == nil || job.IsCompleted || job.IsCanceled {
+		return nil
+	}
+
+	if err = this.repository.GetJobSteps(job.ID, &job.Steps); err != nil {
+		return err
+	}
+
+	this.AbortJob(job, job.StepByID(step_id), reason)
+	return nil
+}
+
+// Perform a step of a job, it is called by the workers of the job queue.
+// If the step is no longer active it is skipped.
+func (this jservice) PerformJobStep(ctx context.Context, step_id int64, confirm func() error) error {
+	log.Infof("step=%v perform step", step_id)
+
+	job, err := this.repository.GetJobByStepID(step_id)
+	if err != nil {
+		return err
+	} else if job == nil {
+		log.Warnf("step=%v doesn't exist", step_id)
+		return nil
+	} else if job.IsCompleted || job.IsCanceled {
+		log.Infof("job=%v is already completed or canceled", job.ID)
+		return nil
+	}
+
+	if err = this.repository.GetJobSteps(job.ID, &job.Steps); err != nil {
+		return err
+	}
+
+	step := job.StepByID(step_id)
+
+	if step.Status != StepActive {
+		log.Infof("job=%v step=%v is not active", job.ID, step_id)
+		return nil
+	}
+
+	// Content owner information is sent with the start request
+	if err = this.owner_repository.GetContentOwnerByID(&job.Owner); err != nil {
+		return err
+	}
+
+	log.Infof("job=%v step_order=%v step=%v", job.ID, step.Order, step.ID)
+
+	if step.Input == nil {
+		err := this.repository.GetParamsForStep(step)
 		if err != nil {
-			log.Errorf("job=%v failed to get steps err:%v", job.ID, err)
-			return
+			log.Errorf("job=%v failed to fetch step parameters step=%v  err=%v", job.ID, step.ID, err)
+			this.AbortJob(job, step, fmt.Sprintf("Failed to fetch parameters for step %d", step.Order))
+			return nil
 		}
 	}
 
-	// Loop through ever step of the job starting from the current step
-	for job.CurrentStep < len(job.Steps) {
-		step := job.Steps[job.CurrentStep]
-		log.Infof("job=%v current_step=%v step=%v", job.ID, job.CurrentStep, step.ID)
+	// Get the task for this step
+	task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
+	if err != nil || task == nil {
+		log.Errorf("job=%v task=%v failed to get task err=%v",
+			job.ID,
+			step.TaskID,
+			err)
 
-		if step.Input == nil {
-			err := this.repository.GetParamsForStep(step)
-			if err != nil {
-				log.Errorf("job=%v failed to fetch step parameters step=%v  err=%v", job.ID, step.ID, err)
-				this.AbortJob(&job, fmt.Sprintf("Failed to fetch parameters for step %d", job.CurrentStep))
-				return
-			}
-		}
+		this.AbortJob(job, step, fmt.Sprintf("Couldn't fetch information for task %d", step.TaskID))
+		return nil
+	}
 
-		// Get the task for this step
-		task, err := this.task_repository.GetTask(step.TaskID)
-		if err != nil || task == nil {
-			log.Errorf("job=%v task=%v failed to get task err=%v",
-				job.ID,
-				step.TaskID,
-				err)
-
-			this.AbortJob(&job, fmt.Sprintf("Couldn't fetch information for task %d", step.TaskID))
-			return
-		}
-
-		// Get the service for this step
-		service, err := this.module_repository.GetModuleByID(task.ModuleID)
-		if err != nil || service == nil {
-			log.Errorf("job=%v failed to fetch service=%v error=%v",
-				job.ID,
-				task.ModuleID,
-				err)
-
-			this.AbortJob(&job, fmt.Sprintf("Couldn't fetch information for service %d", task.ModuleID))
-			return
-		}
-
-		// Prepare the input for the request to be sent to the service
-		input_json := make(map[string]interface{})
-
-		for name, param := range step.Input {
-			if param.LinkedOutputName == nil {
-				input_json[name] = param.Value
-			} else if job.CurrentStep == 0 {
-				// A linked input for the first step is not allowed
-				log.Errorf("job=%v step=%d first step can't have linked input", job.ID, step.ID)
-				this.AbortJob(&job, "Internal Server Error")
-				return
-			} else {
-				// Parameter is linked, the data should be retrieved from previous job's output
-				previous_step := job.Steps[job.CurrentStep-1]
-
-				if previous_step.Output == nil {
-					err := this.repository.GetParamsForStep(step)
-					if err != nil {
-						log.Errorf("job=%v step=%v failed to fetch parameters err=%v", job.ID, previous_step.ID, err)
-						this.AbortJob(&job, fmt.Sprintf("Failed to fetch output for step %d", job.CurrentStep-1))
-						return
-					}
-				}
+	// A retired version isn't used by any active step, this one can't start with it
+	if task.Retired {
+		log.Errorf("job=%v task=%v version=%v is retired", job.ID, task.ID, task.Version)
+		this.AbortJob(job, step, fmt.Sprintf("Version %d of task \"%v\" is retired", task.Version, task.Name))
+		return nil
+	}
 
-				output, ok := previous_step.Output[*param.LinkedOutputName]
-				if !ok {
-					log.Errorf("job=%v step=%v input=%v is linked with output=%v which doesn't exist",
-						job.ID, step.ID, name, *param.LinkedOutputName)
-					this.AbortJob(&job, "Internal Server Error")
-					return
+	// Get the service for this step
+	service, err := this.module_repository.GetModuleByID(task.ModuleID)
+	if err != nil || service == nil {
+		log.Errorf("job=%v failed to fetch service=%v error=%v",
+			job.ID,
+			task.ModuleID,
+			err)
+
+		this.AbortJob(job, step, fmt.Sprintf("Couldn't fetch information for service %d", task.ModuleID))
+		return nil
+	}
+
+	// Prepare the input for the request to be sent to the service
+	input_json := make(map[string]interface{})
+
+	for name, param := range step.Input {
+		if param.LinkedOutputName == nil {
+			input_json[name] = param.Value
+		} else if param.LinkedStep == nil || !job.IsAncestor(*param.LinkedStep, step.Order) {
+			// A linked input can only refer to a completed ancestor
+			log.Errorf("job=%v step=%d input=%v is not linked with an ancestor", job.ID, step.ID, name)
+			this.AbortJob(job, step, "Internal Server Error")
+			return nil
+		} else {
+			// Parameter is linked, the data should be retrieved from the ancestor's output
+			linked_step := job.Steps[*param.LinkedStep]
+
+			if linked_step.Output == nil {
+				err := this.repository.GetParamsForStep(linked_step)
+				if err != nil {
+					log.Errorf("job=%v step=%v failed to fetch parameters err=%v", job.ID, linked_step.ID, err)
+					this.AbortJob(job, step, fmt.Sprintf("Failed to fetch output for step %d", linked_step.Order))
+					return nil
 				}
-				input_json[name] = output.Value
 			}
-		}
 
-		// Create the json string for the request
-		json_data, _ := json.Marshal(map[string]interface{}{
-			"job_id":           step.ID,
-			"publication_date": job.PublicationDate.Unix(),
-			"expiration_date":  job.ExpirationDate.Unix(),
-			"content_owner":    job.Owner.Name,
-			"input":            input_json,
-		})
+			output, ok := linked_step.Output[*param.LinkedOutputName]
+			if !ok && task.IsOptionalInput(name) {
+				// The output is optional and it wasn't given,
+				// the input takes its default value if it has one
+				log.Infof("job=%v step=%v input=%v is linked with output=%v which wasn't given",
+					job.ID, step.ID, name, *param.LinkedOutputName)
+				continue
+			} else if !ok {
+				log.Errorf("job=%v step=%v input=%v is linked with output=%v which doesn't exist",
+					job.ID, step.ID, name, *param.LinkedOutputName)
+				this.AbortJob(job, step, fmt.Sprintf("The output %s of step %d is missing",
+					*param.LinkedOutputName, linked_step.Order))
+				return nil
+			}
+			input_json[name] = output.Value
+		}
+	}
 
-		// Send request and checking for errors
-		log.Infof("job=%v step=%v send start request url=%s input=%v",
-			job.ID, step.ID, task.StartUrl, string(json_data))
+	// Fill the optional input that was omitted
+	for name, value := range task.InputDefaults(input_json) {
+		input_json[name] = value
+	}
 
-		client := http.Client{}
-		req, err := http.NewRequest("POST", task.StartUrl, bytes.NewBuffer(json_data))
+	// The linked input is known now, the whole input can be validated
+	if task.InputSchema != nil {
+		if problems := task.InputSchema.Validate("input", input_json); len(problems) > 0 {
+			log.Errorf("job=%v step=%v input doesn't match the schema problems='%v'",
+				job.ID, step.ID, problems)
+			this.AbortJob(job, step, fmt.Sprintf("The input of task \"%s\" doesn't match its schema: %s",
+				task.Name, strings.Join(problems, "; ")))
+			return nil
+		}
+	}
 
-		if err != nil {
-			log.Errorf("job=%v failed to prepare request err=%v", job.ID, err)
-			this.AbortJob(&job, "Internal Server Error")
-			return
+	// The modules download the assets from the api
+	for name, param := range task.Input {
+		if value, ok := input_json[name]; ok && param.Type == AssetParam {
+			input_json[name] = this.assetDownloadUrl(job, step, value)
 		}
+	}
 
-		req.Header.Add(EasyTVApiKeyHeader, service.ApiKey)
-		req.Header.Add("Content-Type", "application/json")
+	// Create the json string for the request
+	json_data, _ := json.Marshal(map[string]interface{}{
+		"job_id":           step.ID,
+		"publication_date": job.PublicationDate.Unix(),
+		"expiration_date":  job.ExpirationDate.Unix(),
+		"content_owner":    job.Owner.Name,
+		"input":            input_json,
+	})
 
-		resp, err := client.Do(req)
+	// Send request and checking for errors
+	log.Infof("job=%v step=%v send start request url=%s input=%v",
+		job.ID, step.ID, task.StartUrl, string(json_data))
 
-		if err != nil {
-			log.Errorf("job=%v failed to send request err=%v", job.ID, err)
-			this.AbortJob(&job, fmt.Sprintf("Task \"%v\" was unreachable", task.Name))
-			return
-		} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-			log.Errorf("job=%v request failed with code=%v", job.ID, resp.StatusCode)
-			this.AbortJob(&job, fmt.Sprintf("Task \"%v\" was unreachable", task.Name))
-			return
-		}
+	previous_attempts, err := this.repository.GetStepAttempts(step.ID)
+	if err != nil {
+		return err
+	}
 
-		// Parse http resposne
-		json_data, err = ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
+	attempt := JobStepAttempt{
+		StepID:  step.ID,
+		Attempt: len(previous_attempts) + 1,
+		Date:    time.Now(),
+	}
 
-		if err != nil {
-			log.Errorf("job=%v failed to read response body err=%v", job.ID, err)
-			this.AbortJob(&job, "Internal Server Error")
-			return
-		}
+	client := http.Client{Timeout: ModuleRequestTimeout}
+	req, err := http.NewRequest("POST", task.StartUrl, bytes.NewBuffer(json_data))
+
+	if err != nil {
+		log.Errorf("job=%v failed to prepare request err=%v", job.ID, err)
+		this.AbortJob(job, step, "Internal Server Error")
+		return nil
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+	sign_module_request(req, service, json_data)
+
+	// The step is started only by the worker that still holds it
+	if err = confirm(); err != nil {
+		log.Warnf("job=%v step=%v not started err=%v", job.ID, step.ID, err)
+		return err
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil && ctx.Err() != nil {
+		// The step was taken by another worker
+		log.Warnf("job=%v step=%v start request canceled err=%v", job.ID, step.ID, err)
+		return ctx.Err()
+	} else if err != nil {
+		log.Errorf("job=%v failed to send request attempt=%v err=%v", job.ID, attempt.Attempt, err)
+		attempt.Error = err.Error()
+		this.retryStep(job, step, task, &attempt, fmt.Sprintf("Task \"%v\" was unreachable", task.Name))
+		return nil
+	}
+
+	defer resp.Body.Close()
+
+	attempt.StatusCode = &resp.StatusCode
 
-		var data map[string]interface{}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		log.Errorf("job=%v request failed with code=%v attempt=%v", job.ID, resp.StatusCode, attempt.Attempt)
+		attempt.Error = resp.Status
 
-		if err = json.Unmarshal(json_data, &data); err != nil {
-			log.Printf("job=%d failed to parse response json err=%s", job.ID, err)
-			this.AbortJob(&job, "Internal Server Error")
-			return
+		if task.Retry.ShouldRetry(resp.StatusCode) {
+			this.retryStep(job, step, task, &attempt, fmt.Sprintf("Task \"%v\" was unreachable", task.Name))
+		} else {
+			this.saveAttempt(&attempt)
+			this.AbortJob(job, step, fmt.Sprintf("Task \"%v\" was unreachable", task.Name))
 		}
+		return nil
+	}
+
+	this.saveAttempt(&attempt)
+
+	this.notifier.Notify(job, EventStepStarted, step_event_data(step))
+
+	// Parse http resposne
+	json_data, err = ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		log.Errorf("job=%v failed to read response body err=%v", job.ID, err)
+		this.AbortJob(job, step, "Internal Server Error")
+		return nil
+	}
+
+	var data map[string]interface{}
+
+	if err = json.Unmarshal(json_data, &data); err != nil {
+		log.Printf("job=%d failed to parse response json err=%s", job.ID, err)
+		this.AbortJob(job, step, "Internal Server Error")
+		return nil
+	}
+
+	codef, ok := data["code"].(float64)
+	if !ok {
+		log.Errorf("job=%v cant find \"code\" in the response", job.ID)
+		this.AbortJob(job, step, fmt.Sprintf("Task %d sent a malformed response", task.ID))
+		return nil
+	}
+	code := int(codef)
+
+	description, ok := data["description"].(string)
+	if !ok {
+		log.Errorf("job=%v Cant find \"description\" in the response", job.ID)
+		this.AbortJob(job, step, fmt.Sprintf("Task %d sent a malformed response", task.ID))
+		return nil
+	}
+
+	switch code {
+	case 200:
+		output, ok := data["output"].(map[string]interface{})
 
-		codef, ok := data["code"].(float64)
 		if !ok {
-			log.Errorf("job=%v cant find \"code\" in the response", job.ID)
-			this.AbortJob(&job, fmt.Sprintf("Task %d sent a malformed response", task.ID))
-			return
+			log.Errorf("job=%d step=%d there is no output", job.ID, step.ID)
+			this.AbortJob(job, step, fmt.Sprintf("Task \"%v\" sent a malformed response", task.ID))
+			return nil
 		}
-		code := int(codef)
 
-		description, ok := data["description"].(string)
-		if !ok {
-			log.Errorf("job=%v Cant find \"description\" in the response", job.ID)
-			this.AbortJob(&job, fmt.Sprintf("Task %d sent a malformed response", task.ID))
-			return
+		log.Printf("job=%d step=%d completed synchronously with output=%v",
+			job.ID, step.ID, output)
+
+		if step.Output == nil {
+			step.Output = make(map[string]JobParam)
 		}
 
-		switch code {
-		case 200:
-			output, ok := data["output"].(map[string]interface{})
+		for name, value := range output {
+			param, ok := task.Output[name]
 
 			if !ok {
-				log.Errorf("job=%d step=%d there is no output", job.ID, step.ID)
-				this.AbortJob(&job, fmt.Sprintf("Task \"%v\" sent a malformed response", task.ID))
-				return
+				log.Errorf("job=%d step=%v module sent unregistered output=%s",
+					job.ID, step.ID, name)
+				continue
 			}
 
-			log.Printf("job=%d step=%d completed synchronously with output=%v",
-				job.ID, step.ID, output)
+			checked, problem, err := this.checkParamValue(job.OrganisationID, name, param, value)
 
-			if step.Output == nil {
-				step.Output = make(map[string]JobParam)
-			}
-
-			for name, value := range output {
-				given_type := GetParamType(value)
-				correct_type, ok := task.Output[name]
-
-				if !ok {
-					log.Errorf("job=%d step=%v module sent unregistered output=%s",
-						job.ID, step.ID, name)
-				} else if (correct_type == IntParam && given_type == StringParam) ||
-					(correct_type != IntParam && correct_type != given_type) {
-					log.Errorf("job=%d step=%v module sent wrong type=%v for output=%v output_type=%v)",
-						job.ID,
-						step.ID,
-						ParamTypeStr(given_type),
-						name,
-						ParamTypeStr(correct_type))
-				} else if correct_type == IntParam {
-					step.Output[name] = JobParam{
-						DataType: correct_type,
-						Value:    int64(value.(float64)),
-					}
-				} else {
-					step.Output[name] = JobParam{
-						DataType: correct_type,
-						Value:    value,
-					}
-				}
-			}
-			log.Infof("job=%v step=%v current_step=%v completed", job.ID, step.ID, job.CurrentStep)
-		case 202:
-			// Task will be completed synchronously
-			log.Infof("job=%d step=%v pending, it will be completed asynchronously", job.ID, step.ID)
-			job.Status = fmt.Sprintf("Pending at task \"%s\" %d/%d",
-				task.Name,
-				job.CurrentStep,
-				len(job.Steps))
-			err = this.repository.SaveStatus(&job)
 			if err != nil {
-				log.Errorf("job=%d failed to save status err=%v", job.ID, err)
+				log.Errorf("job=%d step=%v failed to check output=%v err=%v",
+					job.ID, step.ID, name, err)
+				this.AbortJob(job, step, "Internal Server Error")
+				return nil
+			} else if len(problem) > 0 {
+				log.Errorf("job=%d step=%v module sent invalid output=%v type=%v problem='%v'",
+					job.ID,
+					step.ID,
+					name,
+					ParamTypeStr(GetParamType(value)),
+					problem)
+			} else {
+				step.Output[name] = JobParam{
+					DataType: param.Type,
+					Value:    checked,
+				}
 			}
-			// Stop doing any more steps.
-			// It will resume the job when the service sends a `finish` request
-			return
-		default:
-			// Any other code results in an error
-			log.Warnf("job=%v task error code=%v description=%v", job.ID, code, description)
-			this.AbortJob(&job, fmt.Sprintf("Failed at task \"%v\" with code(%v)", task.Name, code))
-			return
 		}
 
-		job.CurrentStep++
-		err = this.repository.SaveStepProgress(&job)
+		if problems := validate_output(task, step); len(problems) > 0 {
+			log.Errorf("job=%d step=%v output doesn't match the schema problems='%v'",
+				job.ID, step.ID, problems)
+			this.AbortJob(job, step, fmt.Sprintf("The output of task \"%s\" doesn't match its schema: %s",
+				task.Name, strings.Join(problems, "; ")))
+			return nil
+		}
 
+		log.Infof("job=%v step=%v step_order=%v completed", job.ID, step.ID, step.Order)
+	case 202:
+		// Task will be completed asynchronously
+		log.Infof("job=%d step=%v pending, it will be completed asynchronously", job.ID, step.ID)
+		job.Status = fmt.Sprintf("Pending at task \"%s\" %d/%d",
+			task.Name,
+			step.Order,
+			len(job.Steps))
+		err = this.repository.SavePendingStep(job, step, time.Now())
 		if err != nil {
-			log.Errorf("job=%v failed to save step progress err=%v", job.ID, err)
-			this.AbortJob(&job, "Internal Server Error")
-			return
+			log.Errorf("job=%d failed to save status err=%v", job.ID, err)
 		}
+		// The job will resume when the service sends a `finish` request,
+		// or the step will time out after task.MaxAsyncDuration
+		return nil
+	default:
+		// Any other code results in an error
+		log.Warnf("job=%v task error code=%v description=%v", job.ID, code, description)
+		this.AbortJob(job, step, fmt.Sprintf("Failed at task \"%v\" with code(%v)", task.Name, code))
+		return nil
 	}
-	// If it got here all the steps of the Job have been completed.
-	job.IsCompleted = true
-	job.CompletionDate = new(time.Time)
-	*job.CompletionDate = time.Now()
-	job.Status = "Completed"
 
-	err := this.repository.SaveFinishedState(&job)
-	if err != nil {
-		log.Errorf("job=%d failed to save finished job err=%s", job.ID, err)
+	if err = this.completeStep(job, step); err == ErrJobIsCompleted {
+		// Another branch of the job failed meanwhile
+		log.Infof("job=%v step=%v completed after the job was finished", job.ID, step.ID)
+	} else if err != nil {
+		log.Errorf("job=%v failed to save step progress err=%v", job.ID, err)
+		this.AbortJob(job, step, "Internal Server Error")
 	}
-	log.Infof("job=%d has been compelted", job.ID)
+
+	return nil
 }
 
 func (this *jservice) FinishJobStep(step_id int64, module *Module, output map[string]interface{}) error {
@@ -811,14 +1386,17 @@ func (this *jservice) FinishJobStep(step_id int64, module *Module, output map[st
 		return err
 	}
 
-	step := job.Steps[job.CurrentStep]
+	step := job.StepByID(step_id)
 
-	if step.ID != step_id {
+	if step.Status == StepWaiting {
+		// the step hasn't started yet
+		return ErrNotFound
+	} else if step.Status == StepCompleted {
 		// as far as the service is concerned the job is completed
 		return ErrJobIsCompleted
 	}
 
-	task, err := this.task_repository.GetTask(step.TaskID)
+	task, err := this.task_repository.GetTaskVersion(step.TaskID, step.TaskVersion)
 	if err != nil {
 		return err
 	} else if task == nil {
@@ -829,67 +1407,55 @@ func (this *jservice) FinishJobStep(step_id int64, module *Module, output map[st
 		return ErrNotFound
 	}
 
-	if len(output) != len(task.Output) {
-		return &ErrInvalidTaskOutput{
-			Message: "Wrong number of output parameters",
+	for name := range task.Output {
+		if _, ok := output[name]; !ok && !task.IsOptionalOutput(name) {
+			return &ErrInvalidTaskOutput{
+				Message: fmt.Sprintf("Output parameter %s is missing", name),
+			}
 		}
 	}
 
 	// Parse the output
 	step.Output = make(map[string]JobParam)
 	for name, value := range output {
-		give_type := GetParamType(value)
-
-		if out_type, ok := task.Output[name]; !ok {
+		param, ok := task.Output[name]
+		if !ok {
 			return &ErrInvalidTaskOutput{
 				Message: fmt.Sprintf(
 					"Task with id=%d doesn't expect a parameter named %s",
 					task.ID, name),
 			}
-		} else if (out_type == IntParam && give_type == StringParam) ||
-			(out_type != IntParam && out_type != give_type) {
-			// For values that are supposed to be 'int', we also accept `float`
-			// and convert them afterwards.
-			return &ErrInvalidTaskOutput{
-				Message: fmt.Sprintf("Parameter %s should be a %s",
-					name, ParamTypeStr(out_type)),
-			}
-		} else {
-			if out_type == IntParam {
-				step.Output[name] = JobParam{
-					DataType: out_type,
-					Value:    int64(value.(float64)),
-				}
-			} else {
-				step.Output[name] = JobParam{
-					DataType: out_type,
-					Value:    value,
-				}
-			}
+		}
+
+		checked, problem, err := this.checkParamValue(job.OrganisationID, name, param, value)
+		if err != nil {
+			return err
+		} else if len(problem) > 0 {
+			return &ErrInvalidTaskOutput{Message: problem}
+		}
+
+		step.Output[name] = JobParam{
+			DataType: param.Type,
+			Value:    checked,
 		}
 	}
 
 	// Extra check, in case I missed a code path where this can happen
-	if len(step.Output) != len(task.Output) {
+	if len(step.Output) != len(output) {
 		return &ErrInvalidTaskOutput{
 			Message: "Wrong number of output parameters",
 		}
 	}
 
-	log.Infof("job=%v step=%v finished with output=%v",
-		job.ID, step_id, output)
-
-	job.CurrentStep++
-
-	if err = this.repository.SaveStepProgress(job); err != nil {
-		return err
-	}
-
-	if err = this.owner_repository.GetContentOwnerByID(&job.Owner); err != nil {
-		return err
+	if problems := validate_output(task, step); len(problems) > 0 {
+		return &ErrInvalidTaskOutput{
+			Message: fmt.Sprintf("The output doesn't match the schema of task %d: %s",
+				task.ID, strings.Join(problems, "; ")),
+		}
 	}
 
-	go this.PerformNextStepOfJob(*job)
+	log.Infof("job=%v step=%v finished with output=%v",
+		job.ID, step_id, output)
 
-	return nil
+	return this.completeStep(job, step)
 }
@@ -0,0 +1,116 @@
+package sm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobTemplate is a saved pipeline of an organisation.
+// Tasks have the same form as the tasks given to JobService.CreateJob,
+// except that an input value can be a placeholder in the form of
+// {"$variable": "name"} which is given every time a job is created.
+// The other objects are literal values, like the json input or
+// the {"asset_id": id} references.
+type JobTemplate struct {
+	ID             int64
+	OrganisationID int64
+	Name           string
+	Description    string
+	CreationDate   time.Time
+	Tasks          []map[string]interface{}
+}
+
+// Variables returns the names of the placeholders of the template
+func (this *JobTemplate) Variables() []string {
+	variables := make([]string, 0)
+	found := make(map[string]bool)
+
+	for _, task := range this.Tasks {
+		input, _ := task["input"].(map[string]interface{})
+
+		for _, value := range input {
+			if name, ok := TemplateVariable(value); ok && !found[name] {
+				found[name] = true
+				variables = append(variables, name)
+			}
+		}
+	}
+
+	return variables
+}
+
+// TemplateVariableKey is the only key of a placeholder,
+// a literal object of a template can't have it
+const TemplateVariableKey = "$variable"
+
+// IsTemplatePlaceholder checks if an input value is meant as a placeholder,
+// an object with the TemplateVariableKey
+func IsTemplatePlaceholder(value interface{}) bool {
+	placeholder, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	_, ok = placeholder[TemplateVariableKey]
+	return ok
+}
+
+// TemplateVariable checks if an input value is a valid placeholder
+// and returns the name of its variable
+func TemplateVariable(value interface{}) (string, bool) {
+	placeholder, ok := value.(map[string]interface{})
+	if !ok || len(placeholder) != 1 {
+		return "", false
+	}
+
+	name, ok := placeholder[TemplateVariableKey].(string)
+	return name, ok && len(name) > 0
+}
+
+type JobTemplateRepository interface {
+	Create(template *JobTemplate) error
+
+	// Returns nil if the template doesn't exist
+	GetTemplate(template_id int64) (*JobTemplate, error)
+
+	GetTemplatesForOrganisation(organisation_id int64) ([]*JobTemplate, error)
+
+	Save(template *JobTemplate) error
+
+	Delete(template_id int64) error
+}
+
+type JobTemplateService interface {
+	CreateTemplate(organisation_id int64,
+		name, description string,
+		tasks []map[string]interface{}) (*JobTemplate, error)
+
+	UpdateTemplate(organisation_id, template_id int64,
+		name, description string,
+		tasks []map[string]interface{}) (*JobTemplate, error)
+
+	DeleteTemplate(organisation_id, template_id int64) error
+
+	// Creates a job from the template, the placeholders
+	// are replaced with the given variables
+	CreateJob(organisation_id, user_id, template_id, publication_date, expiration_date int64,
+		callback_url string,
+		variables map[string]interface{}) (*Job, error)
+}
+
+// errors
+
+var ErrTemplateNameTooShort = errors.New("Template name is too short")
+
+type ErrInvalidTemplate struct{ Message string }
+
+func (e *ErrInvalidTemplate) Error() string {
+	return e.Message
+}
+
+type ErrMissingTemplateVariable struct{ Name string }
+
+func (e *ErrMissingTemplateVariable) Error() string {
+	return fmt.Sprintf("The value of the variable \"%s\" is missing", e.Name)
+}
@@ -0,0 +1,182 @@
+package sm
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type template_service struct {
+	repository      JobTemplateRepository
+	task_repository TaskRepository
+	job_service     JobService
+}
+
+func NewJobTemplateService(repository JobTemplateRepository,
+	task_repository TaskRepository,
+	job_service JobService) JobTemplateService {
+	return &template_service{
+		repository:      repository,
+		task_repository: task_repository,
+		job_service:     job_service,
+	}
+}
+
+// Checks the structure of the template tasks. The input is fully validated
+// when a job is created, since the tasks of the services can change.
+func (this *template_service) validateTasks(tasks []map[string]interface{}) error {
+	if len(tasks) == 0 {
+		return ErrEmptyTasks
+	} else if len(tasks) > MaxJobSteps {
+		return ErrTooManyTasks
+	}
+
+	for order, task_data := range tasks {
+		task_id, ok := task_data["task_id"].(float64)
+		if !ok {
+			return ErrMissingTaskID
+		}
+
+		task, err := this.task_repository.GetTask(int64(task_id))
+		if err != nil {
+			return err
+		} else if task == nil || task.Deleted {
+			return &ErrTaskNotFound{TaskID: int64(task_id)}
+		}
+
+		input, _ := task_data["input"].(map[string]interface{})
+
+		for name, value := range input {
+			if _, ok := task.Input[name]; !ok {
+				return &ErrInvalidTemplate{
+					Message: fmt.Sprintf("Task %d doesn't have a parameter named %s", order, name)}
+			}
+
+			// The other values are literal, they are checked when a job is created
+			if _, ok := TemplateVariable(value); IsTemplatePlaceholder(value) && !ok {
+				return &ErrInvalidTemplate{
+					Message: fmt.Sprintf(
+						"The placeholder of \"%s\" in task %d should be {\"%s\": name}",
+						name, order, TemplateVariableKey)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *template_service) CreateTemplate(organisation_id int64,
+	name, description string,
+	tasks []map[string]interface{}) (*JobTemplate, error) {
+
+	if len(name) < 2 {
+		return nil, ErrTemplateNameTooShort
+	} else if err := this.validateTasks(tasks); err != nil {
+		return nil, err
+	}
+
+	template := JobTemplate{
+		OrganisationID: organisation_id,
+		Name:           name,
+		Description:    description,
+		CreationDate:   time.Now(),
+		Tasks:          tasks,
+	}
+
+	if err := this.repository.Create(&template); err != nil {
+		return nil, err
+	}
+
+	log.Infof("template=%v created in organisation=%v", template.ID, organisation_id)
+
+	return &template, nil
+}
+
+func (this *template_service) getOwnedTemplate(organisation_id, template_id int64) (*JobTemplate, error) {
+	template, err := this.repository.GetTemplate(template_id)
+
+	if err != nil {
+		return nil, err
+	} else if template == nil || template.OrganisationID != organisation_id {
+		return nil, ErrNotFound
+	}
+
+	return template, nil
+}
+
+func (this *template_service) UpdateTemplate(organisation_id, template_id int64,
+	name, description string,
+	tasks []map[string]interface{}) (*JobTemplate, error) {
+
+	template, err := this.getOwnedTemplate(organisation_id, template_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(name) < 2 {
+		return nil, ErrTemplateNameTooShort
+	} else if err := this.validateTasks(tasks); err != nil {
+		return nil, err
+	}
+
+	template.Name = name
+	template.Description = description
+	template.Tasks = tasks
+
+	log.Infof("template=%v updated in organisation=%v", template.ID, organisation_id)
+
+	return template, this.repository.Save(template)
+}
+
+func (this *template_service) DeleteTemplate(organisation_id, template_id int64) error {
+	template, err := this.getOwnedTemplate(organisation_id, template_id)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("template=%v deleted in organisation=%v", template.ID, organisation_id)
+
+	return this.repository.Delete(template.ID)
+}
+
+func (this *template_service) CreateJob(
+	organisation_id, user_id, template_id, publication_date, expiration_date int64,
+	callback_url string,
+	variables map[string]interface{}) (*Job, error) {
+
+	template, err := this.getOwnedTemplate(organisation_id, template_id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the placeholders without changing the template
+	tasks := make([]map[string]interface{}, len(template.Tasks))
+
+	for i, task_data := range template.Tasks {
+		tasks[i] = make(map[string]interface{})
+		for key, value := range task_data {
+			tasks[i][key] = value
+		}
+
+		template_input, ok := task_data["input"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		input := make(map[string]interface{})
+		for name, value := range template_input {
+			if variable, ok := TemplateVariable(value); !ok {
+				input[name] = value
+			} else if input[name], ok = variables[variable]; !ok {
+				return nil, &ErrMissingTemplateVariable{Name: variable}
+			}
+		}
+		tasks[i]["input"] = input
+	}
+
+	log.Infof("create job from template=%v user=%v", template.ID, user_id)
+
+	return this.job_service.CreateJob(organisation_id, user_id,
+		publication_date, expiration_date, callback_url, tasks)
+}
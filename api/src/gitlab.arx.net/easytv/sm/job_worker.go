@@ -0,0 +1,176 @@
+package sm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JobWorkerPool performs the job steps of the JobQueueRepository
+// with a fixed number of worker goroutines.
+//
+// While a step is performed its lease is renewed periodically (heartbeat),
+// if the process dies the lease expires and another worker picks it up.
+// A worker that loses the lease stops performing the step, and the job of
+// an entry that was leased more than MaxLeaseAttempts times is aborted.
+type JobWorkerPool struct {
+	queue         JobQueueRepository
+	service       JobService
+	name          string
+	size          int
+	lease         time.Duration
+	poll_interval time.Duration
+	stop          chan struct{}
+	wait_group    sync.WaitGroup
+}
+
+// NewJobWorkerPool creates a pool of `size` workers.
+// name: identifies this pool, it should stay the same between restarts
+// lease: how long an entry is reserved by a worker without a heartbeat
+func NewJobWorkerPool(queue JobQueueRepository,
+	service JobService,
+	name string,
+	size int,
+	lease time.Duration) *JobWorkerPool {
+	return &JobWorkerPool{
+		queue:         queue,
+		service:       service,
+		name:          name,
+		size:          size,
+		lease:         lease,
+		poll_interval: time.Second,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start the workers
+func (this *JobWorkerPool) Start() {
+	// The steps that were interrupted when this pool stopped
+	// don't have to wait for their lease to expire.
+	if err := this.queue.ReleaseLeases(this.name); err != nil {
+		log.Errorf("worker_pool=%v failed to release leases err=%v", this.name, err)
+	}
+
+	log.Infof("worker_pool=%v starting %d workers", this.name, this.size)
+
+	for i := 0; i < this.size; i++ {
+		this.wait_group.Add(1)
+		go this.work(fmt.Sprintf("%s/%d", this.name, i))
+	}
+}
+
+// Stop the workers and wait for the steps in progress to finish
+func (this *JobWorkerPool) Stop() {
+	close(this.stop)
+	this.wait_group.Wait()
+}
+
+func (this *JobWorkerPool) work(worker string) {
+	defer this.wait_group.Done()
+
+	for {
+		select {
+		case <-this.stop:
+			return
+		default:
+		}
+
+		entry, err := this.queue.Lease(worker, this.lease)
+
+		if err != nil {
+			log.Errorf("worker=%v failed to lease queue entry err=%v", worker, err)
+		}
+
+		if err != nil || entry == nil {
+			// Nothing to do, wait before asking again
+			select {
+			case <-this.stop:
+				return
+			case <-time.After(this.poll_interval):
+			}
+			continue
+		}
+
+		this.perform(worker, entry)
+	}
+}
+
+func (this *JobWorkerPool) perform(worker string, entry *JobQueueEntry) {
+	if entry.Attempts > 1 {
+		log.Warnf("worker=%v job=%v step=%v resuming interrupted step attempt=%v",
+			worker, entry.JobID, entry.StepID, entry.Attempts)
+	} else {
+		log.Infof("worker=%v job=%v step=%v leased", worker, entry.JobID, entry.StepID)
+	}
+
+	if entry.Attempts > MaxLeaseAttempts {
+		log.Errorf("worker=%v job=%v step=%v aborting after %v attempts",
+			worker, entry.JobID, entry.StepID, entry.Attempts-1)
+
+		err := this.service.AbortJobStep(entry.StepID,
+			fmt.Sprintf("Step was interrupted %d times", entry.Attempts-1))
+		if err != nil {
+			log.Errorf("worker=%v job=%v step=%v failed to abort err=%v",
+				worker, entry.JobID, entry.StepID, err)
+			return
+		}
+
+		this.complete(worker, entry)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go this.heartbeat(worker, entry, ctx, cancel)
+
+	err := this.service.PerformJobStep(ctx, entry.StepID, func() error {
+		// A copy, the heartbeat updates the entry concurrently
+		lease := *entry
+		return this.queue.ExtendLease(&lease, this.lease)
+	})
+	cancel()
+
+	if err != nil {
+		// The entry stays in the queue and it will be retried
+		// when the lease expires
+		log.Errorf("worker=%v job=%v step=%v failed err=%v",
+			worker, entry.JobID, entry.StepID, err)
+		return
+	}
+
+	this.complete(worker, entry)
+}
+
+func (this *JobWorkerPool) complete(worker string, entry *JobQueueEntry) {
+	if err := this.queue.Complete(entry); err != nil {
+		log.Errorf("worker=%v job=%v step=%v failed to complete queue entry err=%v",
+			worker, entry.JobID, entry.StepID, err)
+	}
+}
+
+// Renews the lease until ctx is done, the step is canceled when the lease is lost
+func (this *JobWorkerPool) heartbeat(worker string, entry *JobQueueEntry,
+	ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(this.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := this.queue.ExtendLease(entry, this.lease)
+			if err == ErrLeaseLost {
+				log.Warnf("worker=%v job=%v step=%v lease lost, stopping the step",
+					worker, entry.JobID, entry.StepID)
+				cancel()
+				return
+			} else if err != nil {
+				log.Warnf("worker=%v job=%v step=%v failed to extend lease err=%v",
+					worker, entry.JobID, entry.StepID, err)
+			}
+		}
+	}
+}
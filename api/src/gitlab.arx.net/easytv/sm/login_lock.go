@@ -0,0 +1,119 @@
+package sm
+
+import (
+	"time"
+)
+
+const (
+	// The failed logins of a username before it is locked
+	MaxUsernameFailures = 5
+	// The failed logins of an address before it is locked,
+	// higher since many users can share an address
+	MaxAddressFailures = 20
+	// The failures are forgotten when there is none for that long
+	LoginFailureWindow = time.Hour
+	// The first lockout, it doubles with every failure after it
+	LoginLockoutBase = time.Minute
+	LoginLockoutMax  = time.Hour
+)
+
+// The events of the login audit log
+const (
+	LoginEventFailure = "failure"
+	LoginEventLockout = "lockout"
+	LoginEventUnlock  = "unlock"
+)
+
+// LoginLock counts the failed logins of a username or an address
+type LoginLock struct {
+	// UsernameLockKey or AddressLockKey
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	// nil if the logins aren't locked
+	LockedUntil *time.Time
+}
+
+// IsLocked checks if the logins are refused
+func (this *LoginLock) IsLocked(now time.Time) bool {
+	return this.LockedUntil != nil && this.LockedUntil.After(now)
+}
+
+// UsernameLockKey is the key of the failures of a username,
+// both the content owners and the admins are counted
+func UsernameLockKey(username string) string {
+	return "username:" + username
+}
+
+// AddressLockKey is the key of the failures of an ip address
+func AddressLockKey(address string) string {
+	return "address:" + address
+}
+
+// LoginLockout returns how long the logins are locked after the failures,
+// zero if they aren't
+func LoginLockout(failures, max_failures int) time.Duration {
+	if failures < max_failures {
+		return 0
+	}
+
+	lockout := LoginLockoutBase
+	for i := max_failures; i < failures && lockout < LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+
+	if lockout > LoginLockoutMax {
+		return LoginLockoutMax
+	}
+	return lockout
+}
+
+// LoginAuditEvent is an entry of the login audit log
+type LoginAuditEvent struct {
+	ID      int64
+	Date    time.Time
+	Event   string
+	Key     string
+	Address string
+	// e.g. the end of a lockout or the admin that unlocked
+	Detail string
+}
+
+type LoginLockRepository interface {
+	Get(key string) (*LoginLock, error)
+
+	// Counts a failure, the previous failures are reset
+	// if the last one was before window_start
+	AddFailure(key string, now, window_start time.Time) (*LoginLock, error)
+
+	Lock(key string, until time.Time) error
+
+	// Forgets the failures of the key
+	Delete(key string) error
+
+	// Returns the locks that haven't ended
+	GetLocked(now time.Time) ([]*LoginLock, error)
+
+	InsertAuditEvent(event *LoginAuditEvent) error
+
+	// Returns the newest events first, -1 for no limit or no before_id
+	GetAuditEvents(limit, before_id int64) ([]*LoginAuditEvent, error)
+}
+
+// service
+
+type LoginLockService interface {
+	// Returns the end of the lockout of the username or the address,
+	// nil if the login can be attempted
+	Check(username, address string) (*time.Time, error)
+
+	// Counts a failed login, the username and the address
+	// are locked when they have too many failures
+	Fail(username, address string) error
+
+	// Forgets the failures of the username after a login
+	Succeed(username string) error
+
+	// Unlocks a username or an address, unlocked_by is recorded in the audit log
+	Unlock(key, unlocked_by string) error
+}
@@ -0,0 +1,117 @@
+package sm
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type login_lock_service struct {
+	repository LoginLockRepository
+}
+
+func NewLoginLockService(repository LoginLockRepository) LoginLockService {
+	return &login_lock_service{
+		repository: repository,
+	}
+}
+
+func (this *login_lock_service) Check(username, address string) (*time.Time, error) {
+	now := time.Now()
+
+	var locked_until *time.Time
+
+	for _, key := range []string{UsernameLockKey(username), AddressLockKey(address)} {
+		lock, err := this.repository.Get(key)
+
+		if err != nil {
+			return nil, err
+		} else if lock != nil && lock.IsLocked(now) {
+			if locked_until == nil || lock.LockedUntil.After(*locked_until) {
+				locked_until = lock.LockedUntil
+			}
+		}
+	}
+
+	return locked_until, nil
+}
+
+func (this *login_lock_service) Fail(username, address string) error {
+	if err := this.fail(UsernameLockKey(username), address, MaxUsernameFailures); err != nil {
+		return err
+	}
+	return this.fail(AddressLockKey(address), address, MaxAddressFailures)
+}
+
+func (this *login_lock_service) fail(key, address string, max_failures int) error {
+	now := time.Now()
+
+	lock, err := this.repository.AddFailure(key, now, now.Add(-LoginFailureWindow))
+
+	if err != nil {
+		return err
+	}
+
+	err = this.repository.InsertAuditEvent(&LoginAuditEvent{
+		Date:    now,
+		Event:   LoginEventFailure,
+		Key:     key,
+		Address: address,
+		Detail:  fmt.Sprintf("%v failures", lock.Failures),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	lockout := LoginLockout(lock.Failures, max_failures)
+
+	if lockout == 0 {
+		return nil
+	}
+
+	locked_until := now.Add(lockout)
+
+	if err = this.repository.Lock(key, locked_until); err != nil {
+		return err
+	}
+
+	log.Warnf("login locked key='%v' address=%v failures=%v until=%v",
+		key, address, lock.Failures, locked_until)
+
+	return this.repository.InsertAuditEvent(&LoginAuditEvent{
+		Date:    now,
+		Event:   LoginEventLockout,
+		Key:     key,
+		Address: address,
+		Detail:  fmt.Sprintf("locked for %v after %v failures", lockout, lock.Failures),
+	})
+}
+
+func (this *login_lock_service) Succeed(username string) error {
+	return this.repository.Delete(UsernameLockKey(username))
+}
+
+func (this *login_lock_service) Unlock(key, unlocked_by string) error {
+	lock, err := this.repository.Get(key)
+
+	if err != nil {
+		return err
+	} else if lock == nil {
+		return ErrNotFound
+	}
+
+	if err = this.repository.Delete(key); err != nil {
+		return err
+	}
+
+	log.Infof("login unlocked key='%v' by='%v'", key, unlocked_by)
+
+	return this.repository.InsertAuditEvent(&LoginAuditEvent{
+		Date:   time.Now(),
+		Event:  LoginEventUnlock,
+		Key:    key,
+		Detail: fmt.Sprintf("unlocked by %v", unlocked_by),
+	})
+}
@@ -0,0 +1,113 @@
+package sm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mail is a plain text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails of the service manager
+type Mailer interface {
+	Send(mail *Mail) error
+}
+
+// message returns the RFC 5322 form of the mail
+func (this *Mail) message(from string, date time.Time) []byte {
+	var b bytes.Buffer
+
+	// The header values can't break the header
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(this.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(this.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(this.Body, "\n", "\r\n", -1))
+
+	return b.Bytes()
+}
+
+type smtp_mailer struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+// NewSmtpMailer sends the emails through an SMTP server,
+// the server should support STARTTLS if username is set
+func NewSmtpMailer(host string, port int, username, password, from string) Mailer {
+	var auth smtp.Auth
+	if len(username) > 0 {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtp_mailer{
+		address: net.JoinHostPort(host, strconv.Itoa(port)),
+		auth:    auth,
+		from:    from,
+	}
+}
+
+func (this *smtp_mailer) Send(mail *Mail) error {
+	err := smtp.SendMail(this.address, this.auth, this.from,
+		[]string{mail.To}, mail.message(this.from, time.Now()))
+
+	if err != nil {
+		log.Errorf("mailer failed to send to=%v err=%v", mail.To, err)
+	}
+	return err
+}
+
+type file_mailer struct {
+	directory string
+	from      string
+}
+
+// NewFileMailer writes the emails in a directory instead of sending them,
+// it is for development. The emails are only logged if directory is empty.
+func NewFileMailer(directory, from string) Mailer {
+	return &file_mailer{
+		directory: directory,
+		from:      from,
+	}
+}
+
+func (this *file_mailer) Send(mail *Mail) error {
+	now := time.Now()
+	message := mail.message(this.from, now)
+
+	if len(this.directory) == 0 {
+		log.Infof("mailer to=%v\n%s", mail.To, message)
+		return nil
+	}
+
+	if err := os.MkdirAll(this.directory, os.ModePerm); err != nil {
+		return err
+	}
+
+	filename := filepath.Join(this.directory,
+		fmt.Sprintf("%d-%s.eml", now.UnixNano(), filepath.Base(mail.To)))
+
+	log.Infof("mailer to=%v wrote %v", mail.To, filename)
+
+	return ioutil.WriteFile(filename, message, 0644)
+}
@@ -1,15 +1,92 @@
 package sm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"time"
 )
 
+// How long the previous keys of a module keep working after a rotation
+const DefaultApiKeyGracePeriod = 24 * time.Hour
+
+// How long the requests are also signed with the previous secret of a module
+const DefaultSigningSecretGracePeriod = 24 * time.Hour
+
 type Module struct {
 	ID          int64
 	Name        string
 	Description string
-	ApiKey      string
-	Enabled     bool
+	// The plain text of a new api key, it is only set when the key is created
+	ApiKey  string
+	Enabled bool
+	// The requests sent to the module are signed with it,
+	// see the signature package
+	SigningSecret string
+	// The secret before the last renewal, the requests are signed
+	// with it too until PreviousSecretExpiration
+	PreviousSigningSecret    string
+	PreviousSecretExpiration *time.Time
+	// The modules that don't verify the signatures yet can opt in to get
+	// a key in the EasyTVApiKeyHeader. It only authenticates the manager to
+	// the module, it isn't one of the api keys so they stay hashed.
+	// Empty if the module doesn't get it.
+	LegacyKey string
+	// The key before the last renewal, it is sent instead of LegacyKey
+	// until PreviousLegacyKeyExpiration
+	PreviousLegacyKey           string
+	PreviousLegacyKeyExpiration *time.Time
+}
+
+// SigningSecrets returns the secrets that the requests to the module are
+// signed with, the current one first
+func (this *Module) SigningSecrets(now time.Time) []string {
+	secrets := []string{this.SigningSecret}
+
+	if len(this.PreviousSigningSecret) > 0 &&
+		this.PreviousSecretExpiration != nil &&
+		this.PreviousSecretExpiration.After(now) {
+		secrets = append(secrets, this.PreviousSigningSecret)
+	}
+
+	return secrets
+}
+
+// SentLegacyKey returns the key that the requests to the module carry
+// in the EasyTVApiKeyHeader, empty if they don't carry one
+func (this *Module) SentLegacyKey(now time.Time) string {
+	if len(this.LegacyKey) > 0 &&
+		len(this.PreviousLegacyKey) > 0 &&
+		this.PreviousLegacyKeyExpiration != nil &&
+		this.PreviousLegacyKeyExpiration.After(now) {
+		return this.PreviousLegacyKey
+	}
+	return this.LegacyKey
+}
+
+// ModuleApiKey is one of the keys that a module authenticates with,
+// only the SHA-256 of the key is stored
+type ModuleApiKey struct {
+	ID           int64
+	ModuleID     int64
+	Label        string
+	HashedKey    string
+	CreationDate time.Time
+	// nil if the key doesn't expire
+	ExpirationDate *time.Time
+	LastUsed       *time.Time
+	Revoked        bool
+}
+
+// IsActive checks if the key can be used
+func (this *ModuleApiKey) IsActive(now time.Time) bool {
+	return !this.Revoked && (this.ExpirationDate == nil || this.ExpirationDate.After(now))
+}
+
+// HashApiKey returns the form an api key is stored in
+func HashApiKey(api_key string) string {
+	hashed_key := sha256.Sum256([]byte(api_key))
+	return hex.EncodeToString(hashed_key[:])
 }
 
 type ModuleRepository interface {
@@ -23,13 +100,24 @@ type ModuleRepository interface {
 
 	GetModulesOBJ(modules *[]*Module) error
 
-	GetModuleByKey(api_key string) (*Module, error)
+	// Returns the module of an active key and updates
+	// the last time the key was used
+	GetModuleByKey(hashed_key string) (*Module, error)
 
 	GetModuleByID(id int64) (*Module, error)
 
 	GenerateApiKey(module_name string) string
 
 	Save(module *Module) error
+
+	CreateApiKey(key *ModuleApiKey) error
+
+	GetApiKey(id int64) (*ModuleApiKey, error)
+
+	// Returns the keys of the module, the newest first
+	GetApiKeys(module_id int64) ([]*ModuleApiKey, error)
+
+	SaveApiKey(key *ModuleApiKey) error
 }
 
 // errors
@@ -37,6 +125,8 @@ type ModuleRepository interface {
 var ErrServiceNameTooShort = errors.New("Name is too short")
 var ErrServiceDescTooShort = errors.New("Desc is too short")
 var ErrServiceNameInUse = errors.New("Name is in use")
+var ErrInvalidApiKeyExpiration = errors.New("The expiration of the api key is in the past")
+var ErrInvalidGracePeriod = errors.New("The grace period can't be negative")
 
 // services
 
@@ -51,5 +141,25 @@ type ModuleService interface {
 
 	SetAvailability(id int64, enable bool) error
 
-	RenewApiKey(id int64) (*Module, error)
+	// Creates a new key, the key is returned in the ApiKey of the module.
+	// expiration: nil for a key that doesn't expire
+	CreateApiKey(id int64, label string, expiration *time.Time) (*Module, *ModuleApiKey, error)
+
+	// Creates a new key and the rest of the active keys
+	// expire after the grace period
+	RenewApiKey(id int64, grace_period time.Duration) (*Module, error)
+
+	RevokeApiKey(id, key_id int64) error
+
+	// Changes the signing secret, the requests are signed with
+	// the previous secret too for the grace period
+	RenewSigningSecret(id int64, grace_period time.Duration) (*Module, error)
+
+	// Creates the key that is sent to a module that doesn't verify the
+	// signatures, the key is returned in the LegacyKey of the module.
+	// A renewed key is sent after the grace period.
+	RenewLegacyKey(id int64, grace_period time.Duration) (*Module, error)
+
+	// Stops sending the legacy key to a module that verifies the signatures
+	StopLegacyKey(id int64) error
 }
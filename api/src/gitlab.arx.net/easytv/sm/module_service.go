@@ -1,10 +1,10 @@
 package sm
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"gitlab.arx.net/easytv/sm/signature"
 )
 
 type mservice struct {
@@ -34,15 +34,12 @@ func (this *mservice) CreateService(name, description string) (*Module, error) {
 		return nil, ErrServiceNameInUse
 	}
 
-	api_key := this.repository.GenerateApiKey(name)
-
-	hashed_api_key := sha256.Sum256([]byte(api_key))
-
 	module := Module{
 		Name:        name,
 		Description: description,
 		Enabled:     false,
-		ApiKey:      hex.EncodeToString(hashed_api_key[:]),
+
+		SigningSecret: signature.GenerateSecret(),
 	}
 
 	err = this.repository.CreateModule(&module)
@@ -51,14 +48,42 @@ func (this *mservice) CreateService(name, description string) (*Module, error) {
 		return nil, err
 	}
 
+	log.Printf("created service name=%v id=%v", name, module.ID)
+
 	// Return the key in plain text, after this it is lost.
-	module.ApiKey = api_key
+	module.ApiKey, _, err = this.createApiKey(&module, "default", nil)
 
-	log.Printf("created service name=%v id=%v", name, module.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	return &module, nil
 }
 
+// Creates a key for the module and returns it in plain text
+func (this *mservice) createApiKey(
+	module *Module, label string, expiration *time.Time) (string, *ModuleApiKey, error) {
+
+	api_key := this.repository.GenerateApiKey(module.Name)
+
+	key := ModuleApiKey{
+		ModuleID:       module.ID,
+		Label:          label,
+		HashedKey:      HashApiKey(api_key),
+		CreationDate:   time.Now(),
+		ExpirationDate: expiration,
+	}
+
+	if err := this.repository.CreateApiKey(&key); err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("service=%v,'%v' api key=%v,'%v' created expiration=%v",
+		module.ID, module.Name, key.ID, key.Label, expiration)
+
+	return api_key, &key, nil
+}
+
 func (this *mservice) SetAvailability(id int64, enable bool) error {
 	service, err := this.repository.GetModuleByID(id)
 	if err != nil {
@@ -124,7 +149,94 @@ func (this *mservice) Update(id int64, name string, description string) error {
 	return this.repository.Save(service)
 }
 
-func (this *mservice) RenewApiKey(id int64) (*Module, error) {
+func (this *mservice) CreateApiKey(
+	id int64, label string, expiration *time.Time) (*Module, *ModuleApiKey, error) {
+
+	if expiration != nil && !expiration.After(time.Now()) {
+		return nil, nil, ErrInvalidApiKeyExpiration
+	}
+
+	service, err := this.repository.GetModuleByID(id)
+
+	if err != nil {
+		return nil, nil, err
+	} else if service == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	// return in plain text in order to notify the user of the new key
+	// after that its lost
+	api_key, key, err := this.createApiKey(service, label, expiration)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service.ApiKey = api_key
+	return service, key, nil
+}
+
+func (this *mservice) RenewApiKey(id int64, grace_period time.Duration) (*Module, error) {
+	if grace_period < 0 {
+		return nil, ErrInvalidGracePeriod
+	}
+
+	service, key, err := this.CreateApiKey(id, "renewed", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := this.repository.GetApiKeys(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The clients keep working with the previous keys until they are updated
+	now := time.Now()
+	expiration := now.Add(grace_period)
+
+	for _, previous := range keys {
+		if previous.ID == key.ID || !previous.IsActive(now) {
+			continue
+		} else if previous.ExpirationDate != nil && previous.ExpirationDate.Before(expiration) {
+			continue
+		}
+
+		previous.ExpirationDate = &expiration
+
+		if err = this.repository.SaveApiKey(previous); err != nil {
+			return nil, err
+		}
+
+		log.Printf("service=%v api key=%v expires at %v", id, previous.ID, expiration)
+	}
+
+	return service, nil
+}
+
+func (this *mservice) RevokeApiKey(id, key_id int64) error {
+	key, err := this.repository.GetApiKey(key_id)
+
+	if err != nil {
+		return err
+	} else if key == nil || key.ModuleID != id {
+		return ErrNotFound
+	}
+
+	log.Printf("service=%v api key=%v,'%v' revoked", id, key.ID, key.Label)
+
+	key.Revoked = true
+
+	return this.repository.SaveApiKey(key)
+}
+
+func (this *mservice) RenewSigningSecret(id int64, grace_period time.Duration) (*Module, error) {
+	if grace_period < 0 {
+		return nil, ErrInvalidGracePeriod
+	}
+
 	service, err := this.repository.GetModuleByID(id)
 
 	if err != nil {
@@ -133,19 +245,70 @@ func (this *mservice) RenewApiKey(id int64) (*Module, error) {
 		return nil, ErrNotFound
 	}
 
-	api_key := this.repository.GenerateApiKey(service.Name)
+	log.Printf("service=%v,'%v' renew signing secret grace=%v",
+		service.ID, service.Name, grace_period)
 
-	hashed_key := sha256.Sum256([]byte(api_key))
+	// The module keeps accepting the requests until it gets the new secret
+	expiration := time.Now().Add(grace_period)
+	service.PreviousSigningSecret = service.SigningSecret
+	service.PreviousSecretExpiration = &expiration
 
-	service.ApiKey = hex.EncodeToString(hashed_key[:])
+	// The manager signs with it so it isn't hashed
+	service.SigningSecret = signature.GenerateSecret()
+
+	if err = this.repository.Save(service); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (this *mservice) RenewLegacyKey(id int64, grace_period time.Duration) (*Module, error) {
+	if grace_period < 0 {
+		return nil, ErrInvalidGracePeriod
+	}
+
+	service, err := this.repository.GetModuleByID(id)
 
-	err = this.repository.Save(service)
 	if err != nil {
 		return nil, err
+	} else if service == nil {
+		return nil, ErrNotFound
 	}
 
-	// return in plain text in order to notify the user of the new key
-	// after that its lost
-	service.ApiKey = api_key
+	log.Printf("service=%v,'%v' renew legacy key grace=%v",
+		service.ID, service.Name, grace_period)
+
+	// The module keeps getting the key it knows until it is updated
+	now := time.Now()
+	expiration := now.Add(grace_period)
+
+	if previous := service.SentLegacyKey(now); len(previous) > 0 {
+		service.PreviousLegacyKey = previous
+		service.PreviousLegacyKeyExpiration = &expiration
+	}
+
+	service.LegacyKey = signature.GenerateSecret()
+
+	if err = this.repository.Save(service); err != nil {
+		return nil, err
+	}
 	return service, nil
 }
+
+func (this *mservice) StopLegacyKey(id int64) error {
+	service, err := this.repository.GetModuleByID(id)
+
+	if err != nil {
+		return err
+	} else if service == nil {
+		return ErrNotFound
+	}
+
+	log.Printf("service=%v,'%v' stop sending the legacy key", service.ID, service.Name)
+
+	service.LegacyKey = ""
+	service.PreviousLegacyKey = ""
+	service.PreviousLegacyKeyExpiration = nil
+
+	return this.repository.Save(service)
+}
@@ -0,0 +1,200 @@
+package sm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AccessTokenExpiration is how long an access token can be used
+const AccessTokenExpiration = 60 * 15 // 15 minutes
+
+// AccessTokenIssuer is the "iss" claim of the access tokens
+const AccessTokenIssuer = "easytv-sm"
+
+// OAuthScopes are the permissions that an oauth client can be given,
+// the tokens only reach the job operations of their scope
+// and the uploads of the job input
+var OAuthScopes = []string{
+	PermJobRead,
+	PermJobCreate,
+	PermJobCancel,
+	PermAssetRead,
+	PermAssetWrite,
+}
+
+// IsValidScope checks that the scope is one of OAuthScopes
+func IsValidScope(scope string) bool {
+	for _, known := range OAuthScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope checks if the scopes contain the scope
+func HasScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthClient is the client credentials of a machine integration.
+// Its tokens act as the member that created it, within its scopes.
+type OAuthClient struct {
+	ID             int64
+	OrganisationID int64
+	// The member whose identity and role the tokens have
+	OwnerID int64
+	Name    string
+	// The public identifier of the client
+	ClientID string
+	// The sha256 of the secret, the secret is only known to the client
+	HashedSecret string
+	Scopes       []string
+	CreationDate time.Time
+	Revoked      bool
+}
+
+// AccessTokenClaims are the claims of the signed access tokens
+type AccessTokenClaims struct {
+	Issuer         string `json:"iss"`
+	ClientID       string `json:"sub"`
+	OrganisationID int64  `json:"org"`
+	OwnerID        int64  `json:"uid"`
+	// The role of the member when the token was issued, 0 for the default role
+	RoleID int64 `json:"rid"`
+	// Space separated, like the scope parameter of oauth
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Scopes returns the list of scopes of the token
+func (this *AccessTokenClaims) Scopes() []string {
+	return strings.Fields(this.Scope)
+}
+
+// GenerateClientCredentials returns a random client id and secret
+func GenerateClientCredentials() (string, string, error) {
+	b := make([]byte, 16+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:]), nil
+}
+
+// HashClientSecret returns the form of the secret that is stored
+func HashClientSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+var access_token_header = base64.RawURLEncoding.EncodeToString(
+	[]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func sign_access_token(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(access_token_header + "." + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignAccessToken encodes the claims as a JWT signed with HS256
+func SignAccessToken(claims *AccessTokenClaims, key []byte) (string, error) {
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(body)
+
+	return access_token_header + "." + payload + "." + sign_access_token(payload, key), nil
+}
+
+// ParseAccessToken verifies the signature and the expiration of a JWT.
+// Only the tokens signed by SignAccessToken are accepted.
+func ParseAccessToken(token string, key []byte, now time.Time) (*AccessTokenClaims, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 || parts[0] != access_token_header {
+		return nil, ErrInvalidAccessToken
+	}
+
+	signature := sign_access_token(parts[1], key)
+
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	claims := AccessTokenClaims{}
+
+	if err = json.Unmarshal(body, &claims); err != nil || claims.Issuer != AccessTokenIssuer {
+		return nil, ErrInvalidAccessToken
+	} else if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrAccessTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// IsAccessToken checks if a credential looks like a JWT instead of a session id
+func IsAccessToken(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+type OAuthClientRepository interface {
+	Insert(client *OAuthClient) error
+
+	GetByID(id int64) (*OAuthClient, error)
+
+	GetByClientID(client_id string) (*OAuthClient, error)
+
+	GetForOrganisation(organisation_id int64) ([]*OAuthClient, error)
+
+	// Saves the revoked flag
+	Save(client *OAuthClient) error
+
+	// Revokes all the clients that act as the content owner
+	RevokeForOwner(owner_id int64) error
+}
+
+// errors
+
+var ErrOAuthClientNameTooShort = errors.New("OAuth client name too short")
+var ErrInvalidScope = errors.New("Unknown scope or a scope the client doesn't have")
+var ErrInvalidClient = errors.New("Invalid client credentials")
+var ErrInvalidAccessToken = errors.New("Invalid access token")
+var ErrAccessTokenExpired = errors.New("The access token has expired")
+
+// service
+
+type OAuthService interface {
+	// Creates a client of the organisation that acts as owner_id,
+	// returns the secret that is only shown once
+	CreateClient(organisation_id, owner_id int64,
+		name string, scopes []string) (*OAuthClient, string, error)
+
+	RevokeClient(organisation_id, id int64) error
+
+	// Issues an access token for the client credentials grant,
+	// empty scopes for all the scopes of the client
+	IssueToken(client_id, secret string, scopes []string) (string, *AccessTokenClaims, error)
+
+	// Verifies an access token without a database lookup
+	VerifyToken(token string) (*AccessTokenClaims, error)
+}
@@ -0,0 +1,147 @@
+package sm
+
+import (
+	"crypto/hmac"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type oauth_service struct {
+	repository       OAuthClientRepository
+	owner_repository ContentOwnerRepository
+	signing_key      []byte
+}
+
+// NewOAuthService creates the service, signing_key signs and verifies the access tokens
+func NewOAuthService(repository OAuthClientRepository,
+	owner_repository ContentOwnerRepository,
+	signing_key []byte) OAuthService {
+	return &oauth_service{
+		repository:       repository,
+		owner_repository: owner_repository,
+		signing_key:      signing_key,
+	}
+}
+
+func (this *oauth_service) CreateClient(organisation_id, owner_id int64,
+	name string, scopes []string) (*OAuthClient, string, error) {
+
+	if len(name) < 2 {
+		return nil, "", ErrOAuthClientNameTooShort
+	} else if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	client_id, secret, err := GenerateClientCredentials()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := OAuthClient{
+		OrganisationID: organisation_id,
+		OwnerID:        owner_id,
+		Name:           name,
+		ClientID:       client_id,
+		HashedSecret:   HashClientSecret(secret),
+		Scopes:         scopes,
+		CreationDate:   time.Now(),
+	}
+
+	if err = this.repository.Insert(&client); err != nil {
+		return nil, "", err
+	}
+
+	log.Infof("organisation=%v created oauth client=%v,'%v' scopes=%v",
+		organisation_id, client.ID, client.Name, strings.Join(scopes, ","))
+
+	return &client, secret, nil
+}
+
+func (this *oauth_service) RevokeClient(organisation_id, id int64) error {
+	client, err := this.repository.GetByID(id)
+
+	if err != nil {
+		return err
+	} else if client == nil || client.OrganisationID != organisation_id {
+		return ErrNotFound
+	}
+
+	log.Infof("organisation=%v revoked oauth client=%v", organisation_id, client.ID)
+
+	client.Revoked = true
+
+	return this.repository.Save(client)
+}
+
+func (this *oauth_service) IssueToken(client_id, secret string,
+	scopes []string) (string, *AccessTokenClaims, error) {
+
+	client, err := this.repository.GetByClientID(client_id)
+
+	if err != nil {
+		return "", nil, err
+	} else if client == nil || client.Revoked {
+		return "", nil, ErrInvalidClient
+	} else if !hmac.Equal([]byte(client.HashedSecret), []byte(HashClientSecret(secret))) {
+		return "", nil, ErrInvalidClient
+	}
+
+	// The tokens stop being issued when the member leaves the organisation
+	owner := ContentOwner{ID: client.OwnerID}
+
+	if err = this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return "", nil, err
+	} else if owner.OrganisationID == nil || *owner.OrganisationID != client.OrganisationID {
+		return "", nil, ErrInvalidClient
+	}
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	for _, scope := range scopes {
+		if !HasScope(client.Scopes, scope) {
+			return "", nil, ErrInvalidScope
+		}
+	}
+
+	now := time.Now().Unix()
+
+	claims := AccessTokenClaims{
+		Issuer:         AccessTokenIssuer,
+		ClientID:       client.ClientID,
+		OrganisationID: client.OrganisationID,
+		OwnerID:        owner.ID,
+		Scope:          strings.Join(scopes, " "),
+		IssuedAt:       now,
+		ExpiresAt:      now + AccessTokenExpiration,
+	}
+
+	if owner.RoleID != nil {
+		claims.RoleID = *owner.RoleID
+	}
+
+	token, err := SignAccessToken(&claims, this.signing_key)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Infof("organisation=%v issued token to oauth client=%v scope='%v'",
+		client.OrganisationID, client.ID, claims.Scope)
+
+	return token, &claims, nil
+}
+
+func (this *oauth_service) VerifyToken(token string) (*AccessTokenClaims, error) {
+	return ParseAccessToken(token, this.signing_key, time.Now())
+}
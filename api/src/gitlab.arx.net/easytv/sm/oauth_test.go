@@ -0,0 +1,186 @@
+package sm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+)
+
+var test_token_key = []byte("test signing key")
+
+func test_claims(now time.Time) *AccessTokenClaims {
+	return &AccessTokenClaims{
+		Issuer:         AccessTokenIssuer,
+		ClientID:       "client",
+		OrganisationID: 7,
+		OwnerID:        3,
+		Scope:          PermJobRead + " " + PermJobCreate,
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      now.Unix() + AccessTokenExpiration,
+	}
+}
+
+func encode_test_part(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Signs a token with any header and hash, like a forged token would be
+func sign_test_token(header, payload string, hash_function func() hash.Hash, key []byte) string {
+	mac := hmac.New(hash_function, key)
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	claims := test_claims(now)
+
+	token, err := SignAccessToken(claims, test_token_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsAccessToken(token) {
+		t.Errorf("%s isn't recognized as an access token", token)
+	}
+
+	parsed, err := ParseAccessToken(token, test_token_key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *parsed != *claims {
+		t.Errorf("parsed %+v, expected %+v", parsed, claims)
+	}
+}
+
+func TestAccessTokenTampering(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	token, err := SignAccessToken(test_claims(now), test_token_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+
+	other_organisation := test_claims(now)
+	other_organisation.OrganisationID = 8
+
+	more_scopes := test_claims(now)
+	more_scopes.Scope += " " + PermJobCancel
+
+	flipped := []byte(parts[2])
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other organisation", parts[0] + "." + encode_test_part(t, other_organisation) + "." + parts[2]},
+		{"more scopes", parts[0] + "." + encode_test_part(t, more_scopes) + "." + parts[2]},
+		{"changed signature", parts[0] + "." + parts[1] + "." + string(flipped)},
+		{"no signature", parts[0] + "." + parts[1] + "."},
+		{"other key", sign_test_token(parts[0], parts[1], sha256.New, []byte("other key"))},
+		{"missing part", parts[0] + "." + parts[1]},
+		{"extra part", token + "." + parts[2]},
+		{"invalid payload", sign_test_token(parts[0], "not*base64", sha256.New, test_token_key)},
+		{"payload not json", sign_test_token(parts[0],
+			base64.RawURLEncoding.EncodeToString([]byte("claims")), sha256.New, test_token_key)},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseAccessToken(test.token, test_token_key, now); err != ErrInvalidAccessToken {
+			t.Errorf("%s: expected ErrInvalidAccessToken, got %v", test.name, err)
+		}
+	}
+}
+
+func TestAccessTokenIssuer(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	claims := test_claims(now)
+	claims.Issuer = "another-service"
+
+	token, err := SignAccessToken(claims, test_token_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ParseAccessToken(token, test_token_key, now); err != ErrInvalidAccessToken {
+		t.Errorf("expected ErrInvalidAccessToken, got %v", err)
+	}
+}
+
+func TestAccessTokenExpiration(t *testing.T) {
+	issued := time.Unix(1600000000, 0)
+
+	token, err := SignAccessToken(test_claims(issued), test_token_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := issued.Add(AccessTokenExpiration * time.Second)
+
+	tests := []struct {
+		now      time.Time
+		expected error
+	}{
+		{issued, nil},
+		{expires.Add(-time.Second), nil},
+		{expires, ErrAccessTokenExpired},
+		{expires.Add(time.Hour), ErrAccessTokenExpired},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseAccessToken(token, test_token_key, test.now); err != test.expected {
+			t.Errorf("at %v: expected %v, got %v", test.now.Sub(issued), test.expected, err)
+		}
+	}
+}
+
+func TestAccessTokenAlgorithmConfusion(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	payload := encode_test_part(t, test_claims(now))
+
+	header := func(value string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", header(`{"alg":"none","typ":"JWT"}`) + "." + payload + "."},
+		{"alg none signed", sign_test_token(header(`{"alg":"none","typ":"JWT"}`),
+			payload, sha256.New, test_token_key)},
+		{"alg HS512", sign_test_token(header(`{"alg":"HS512","typ":"JWT"}`),
+			payload, sha512.New, test_token_key)},
+		{"alg RS256 with the key as secret", sign_test_token(header(`{"alg":"RS256","typ":"JWT"}`),
+			payload, sha256.New, test_token_key)},
+		// Only the exact header of SignAccessToken is accepted
+		{"reordered header", sign_test_token(header(`{"typ":"JWT","alg":"HS256"}`),
+			payload, sha256.New, test_token_key)},
+		{"spaced header", sign_test_token(header(`{"alg": "HS256", "typ": "JWT"}`),
+			payload, sha256.New, test_token_key)},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseAccessToken(test.token, test_token_key, now); err != ErrInvalidAccessToken {
+			t.Errorf("%s: expected ErrInvalidAccessToken, got %v", test.name, err)
+		}
+	}
+}
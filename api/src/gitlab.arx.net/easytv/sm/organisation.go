@@ -0,0 +1,127 @@
+package sm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// InvitationExpiration is how long an invitation can be accepted
+const InvitationExpiration = 7 * 24 * time.Hour
+
+// Organisation owns the jobs, the templates and the webhook
+// that its member content owners share
+type Organisation struct {
+	ID           int64
+	Name         string
+	CreationDate time.Time
+	// The default url of the job events, empty if there is no webhook
+	CallbackUrl string
+	// The secret that signs the webhook deliveries
+	WebhookSecret string
+}
+
+// OrganisationInvitation lets a new user join an organisation
+type OrganisationInvitation struct {
+	ID             int64
+	OrganisationID int64
+	Email          string
+	// The role of the new member, nil for the default role
+	RoleID *int64
+	// The sha256 of the token, the token is only known to the invited user
+	HashedToken string
+	// The member that sent the invitation, nil if it was an admin
+	InvitedBy      *int64
+	CreationDate   time.Time
+	ExpirationDate time.Time
+	// nil until the invitation is accepted
+	AcceptDate *time.Time
+	Revoked    bool
+}
+
+// IsPending checks if the invitation can still be accepted
+func (this *OrganisationInvitation) IsPending(now time.Time) bool {
+	return this.AcceptDate == nil && !this.Revoked && this.ExpirationDate.After(now)
+}
+
+// GenerateInvitationToken returns a random token for an invitation
+func GenerateInvitationToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashInvitationToken returns the form of the token that is stored
+func HashInvitationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+type OrganisationRepository interface {
+	Insert(organisation *Organisation) error
+
+	GetByID(id int64) (*Organisation, error)
+
+	GetAll() ([]*Organisation, error)
+
+	NameExists(name string) (bool, error)
+
+	Save(organisation *Organisation) error
+
+	// Saves the callback url and the webhook secret
+	SaveWebhook(organisation *Organisation) error
+
+	GetMembers(id int64) ([]*ContentOwner, error)
+
+	// Sets the organisation of a content owner, nil removes the owner
+	// from its organisation
+	SetMemberOrganisation(owner_id int64, organisation_id *int64) error
+
+	CreateInvitation(invitation *OrganisationInvitation) error
+
+	GetInvitation(id int64) (*OrganisationInvitation, error)
+
+	GetInvitationByToken(hashed_token string) (*OrganisationInvitation, error)
+
+	// Returns the invitations of the organisation, newest first
+	GetInvitations(organisation_id int64) ([]*OrganisationInvitation, error)
+
+	// Saves the accept date and the revoked flag
+	SaveInvitation(invitation *OrganisationInvitation) error
+}
+
+// errors
+
+var ErrOrganisationNameTooShort = errors.New("Organisation name too short")
+var ErrOrganisationNameExists = errors.New("Organisation name exists")
+var ErrNotAMember = errors.New("The user isn't a member of the organisation")
+var ErrLastMember = errors.New("The last member can't leave the organisation")
+var ErrInvalidInvitation = errors.New("The invitation doesn't exist or has expired")
+var ErrInvalidMemberRole = errors.New("The role grants permissions that members can't have")
+
+// service
+
+type OrganisationService interface {
+	CreateOrganisation(name string) (*Organisation, error)
+
+	// Invites an email to the organisation, returns the token that
+	// the invited user accepts the invitation with.
+	// invited_by is nil for the admins and role_name empty for the default role.
+	Invite(organisation_id int64, invited_by *int64,
+		email, role_name string) (*OrganisationInvitation, string, error)
+
+	RevokeInvitation(organisation_id, invitation_id int64) error
+
+	// Creates the account of the invited user
+	AcceptInvitation(token, name, username, password string) (*ContentOwner, error)
+
+	// Sets the role of a member, an empty name for the default role
+	SetMemberRole(organisation_id, owner_id int64, role_name string) error
+
+	// The removed member can't login until it joins an organisation
+	RemoveMember(organisation_id, owner_id int64) error
+}
@@ -0,0 +1,253 @@
+package sm
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type organisation_service struct {
+	repository       OrganisationRepository
+	owner_repository ContentOwnerRepository
+	owner_service    ContentOwnerService
+	role_repository  RoleRepository
+}
+
+func NewOrganisationService(repository OrganisationRepository,
+	owner_repository ContentOwnerRepository,
+	owner_service ContentOwnerService,
+	role_repository RoleRepository) OrganisationService {
+	return &organisation_service{
+		repository:       repository,
+		owner_repository: owner_repository,
+		owner_service:    owner_service,
+		role_repository:  role_repository,
+	}
+}
+
+func (this *organisation_service) CreateOrganisation(name string) (*Organisation, error) {
+	return create_organisation(this.repository, name)
+}
+
+// The content owner service creates an organisation for each new owner too
+func create_organisation(repository OrganisationRepository, name string) (*Organisation, error) {
+	if len(name) < 2 {
+		return nil, ErrOrganisationNameTooShort
+	}
+
+	if exists, err := repository.NameExists(name); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, ErrOrganisationNameExists
+	}
+
+	webhook_secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	organisation := Organisation{
+		Name:          name,
+		CreationDate:  time.Now(),
+		WebhookSecret: webhook_secret,
+	}
+
+	if err = repository.Insert(&organisation); err != nil {
+		return nil, err
+	}
+
+	log.Infof("created organisation=%v,'%v'", organisation.ID, organisation.Name)
+
+	return &organisation, nil
+}
+
+// Finds the id of a role the members can have, nil for the default role.
+// The members can't be given permissions the default role doesn't have.
+func (this *organisation_service) memberRoleID(role_name string) (*int64, error) {
+	if len(role_name) == 0 || role_name == RoleNameContentOwner {
+		return nil, nil
+	}
+
+	role, err := this.role_repository.GetByName(role_name)
+
+	if err != nil {
+		return nil, err
+	} else if role == nil {
+		return nil, ErrNotFound
+	}
+
+	default_role, err := this.role_repository.GetByName(RoleNameContentOwner)
+
+	if err != nil {
+		return nil, err
+	} else if default_role == nil || !role.IsSubsetOf(default_role) {
+		return nil, ErrInvalidMemberRole
+	}
+
+	return &role.ID, nil
+}
+
+// Returns the member of the organisation
+func (this *organisation_service) getMember(organisation_id, owner_id int64) (*ContentOwner, error) {
+	owner := ContentOwner{ID: owner_id}
+
+	if err := this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return nil, err
+	} else if len(owner.Username) == 0 {
+		return nil, ErrNotFound
+	} else if owner.OrganisationID == nil || *owner.OrganisationID != organisation_id {
+		return nil, ErrNotAMember
+	}
+	return &owner, nil
+}
+
+func (this *organisation_service) Invite(organisation_id int64, invited_by *int64,
+	email, role_name string) (*OrganisationInvitation, string, error) {
+
+	organisation, err := this.repository.GetByID(organisation_id)
+
+	if err != nil {
+		return nil, "", err
+	} else if organisation == nil {
+		return nil, "", ErrNotFound
+	}
+
+	if len(email) < 3 {
+		return nil, "", ErrOwnerEmailTooShort
+	} else if exists, err := this.owner_repository.EmailExists(email); err != nil {
+		return nil, "", err
+	} else if exists {
+		return nil, "", ErrOwnerEmailExists
+	}
+
+	role_id, err := this.memberRoleID(role_name)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := GenerateInvitationToken()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+
+	invitation := OrganisationInvitation{
+		OrganisationID: organisation.ID,
+		Email:          email,
+		RoleID:         role_id,
+		HashedToken:    HashInvitationToken(token),
+		InvitedBy:      invited_by,
+		CreationDate:   now,
+		ExpirationDate: now.Add(InvitationExpiration),
+	}
+
+	if err = this.repository.CreateInvitation(&invitation); err != nil {
+		return nil, "", err
+	}
+
+	log.Infof("organisation=%v invitation=%v sent to email=%v role='%v'",
+		organisation.ID, invitation.ID, email, role_name)
+
+	return &invitation, token, nil
+}
+
+func (this *organisation_service) RevokeInvitation(organisation_id, invitation_id int64) error {
+	invitation, err := this.repository.GetInvitation(invitation_id)
+
+	if err != nil {
+		return err
+	} else if invitation == nil || invitation.OrganisationID != organisation_id {
+		return ErrNotFound
+	} else if !invitation.IsPending(time.Now()) {
+		return ErrInvalidInvitation
+	}
+
+	log.Infof("organisation=%v invitation=%v revoked", organisation_id, invitation.ID)
+
+	invitation.Revoked = true
+
+	return this.repository.SaveInvitation(invitation)
+}
+
+func (this *organisation_service) AcceptInvitation(
+	token, name, username, password string) (*ContentOwner, error) {
+
+	invitation, err := this.repository.GetInvitationByToken(HashInvitationToken(token))
+
+	if err != nil {
+		return nil, err
+	} else if invitation == nil || !invitation.IsPending(time.Now()) {
+		return nil, ErrInvalidInvitation
+	} else if len(password) < 8 {
+		return nil, ErrPasswordTooShort
+	}
+
+	owner, err := this.owner_service.CreateMember(
+		invitation.OrganisationID, name, username, invitation.Email, password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.RoleID != nil {
+		if err = this.role_repository.SetOwnerRole(owner.ID, invitation.RoleID); err != nil {
+			return nil, err
+		}
+		owner.RoleID = invitation.RoleID
+	}
+
+	now := time.Now()
+	invitation.AcceptDate = &now
+
+	if err = this.repository.SaveInvitation(invitation); err != nil {
+		return nil, err
+	}
+
+	log.Infof("organisation=%v invitation=%v accepted by user=%v",
+		invitation.OrganisationID, invitation.ID, owner.ID)
+
+	return owner, nil
+}
+
+func (this *organisation_service) SetMemberRole(
+	organisation_id, owner_id int64, role_name string) error {
+
+	owner, err := this.getMember(organisation_id, owner_id)
+
+	if err != nil {
+		return err
+	}
+
+	role_id, err := this.memberRoleID(role_name)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("organisation=%v member=%v set role='%v'", organisation_id, owner.ID, role_name)
+
+	return this.role_repository.SetOwnerRole(owner.ID, role_id)
+}
+
+func (this *organisation_service) RemoveMember(organisation_id, owner_id int64) error {
+	owner, err := this.getMember(organisation_id, owner_id)
+
+	if err != nil {
+		return err
+	}
+
+	members, err := this.repository.GetMembers(organisation_id)
+
+	if err != nil {
+		return err
+	} else if len(members) <= 1 {
+		return ErrLastMember
+	}
+
+	log.Infof("organisation=%v member=%v removed", organisation_id, owner.ID)
+
+	return this.repository.SetMemberOrganisation(owner.ID, nil)
+}
@@ -0,0 +1,69 @@
+package sm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// How long a reset token can be used
+	PasswordResetExpiration = 60 * 60 // 1 hour
+	// A new email isn't sent for the same user before this
+	PasswordResetInterval = 60 // 1 minute
+)
+
+// PasswordResetToken lets a content owner that forgot the password
+// set a new one, it is emailed and only its SHA-256 is stored
+type PasswordResetToken struct {
+	ID             int64
+	OwnerID        int64
+	HashedToken    string
+	CreationDate   time.Time
+	ExpirationDate time.Time
+	// nil until the token is used
+	UsedDate *time.Time
+}
+
+// GeneratePasswordResetToken returns a random reset token
+func GeneratePasswordResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type PasswordResetRepository interface {
+	Insert(token *PasswordResetToken) error
+
+	// Returns the newest token of the owner, nil if there is none
+	GetLatest(owner_id int64) (*PasswordResetToken, error)
+
+	// Marks an unused token that didn't expire as used and returns it,
+	// nil if there is none
+	Use(hashed_token string, now time.Time) (*PasswordResetToken, error)
+
+	// Marks all the unused tokens of the owner as used
+	Invalidate(owner_id int64, now time.Time) error
+
+	// Deletes the tokens that expired before the date
+	DeleteExpired(before time.Time) error
+}
+
+// errors
+
+var ErrInvalidResetToken = errors.New("The reset token is invalid or expired")
+
+// service
+
+type PasswordResetService interface {
+	// Queues an email with a reset token to the content owner with the email.
+	// It returns at once for any email, so the emails can't be guessed.
+	RequestReset(email string) error
+
+	// Sets the password of the owner of the token, the token can't be used again.
+	// The sessions and the personal access tokens of the owner are revoked.
+	Reset(token, new_password string) error
+}
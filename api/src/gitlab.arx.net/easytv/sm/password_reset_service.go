@@ -0,0 +1,155 @@
+package sm
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How many reset requests wait to be handled, the rest are dropped
+const password_reset_queue_size = 100
+
+type password_reset_service struct {
+	repository        PasswordResetRepository
+	owner_repository  ContentOwnerRepository
+	owner_service     ContentOwnerService
+	token_repository  PersonalAccessTokenRepository
+	client_repository OAuthClientRepository
+	mailer            Mailer
+	reset_url         string
+	// The emails of the requests, they are handled in the background
+	// so the response doesn't depend on the email
+	requests chan string
+}
+
+// NewPasswordResetService creates the service,
+// the token is appended to reset_url in the emails
+func NewPasswordResetService(repository PasswordResetRepository,
+	owner_repository ContentOwnerRepository,
+	owner_service ContentOwnerService,
+	token_repository PersonalAccessTokenRepository,
+	client_repository OAuthClientRepository,
+	mailer Mailer,
+	reset_url string) PasswordResetService {
+	service := &password_reset_service{
+		repository:        repository,
+		owner_repository:  owner_repository,
+		owner_service:     owner_service,
+		token_repository:  token_repository,
+		client_repository: client_repository,
+		mailer:            mailer,
+		reset_url:         reset_url,
+		requests:          make(chan string, password_reset_queue_size),
+	}
+
+	go service.work()
+
+	return service
+}
+
+func (this *password_reset_service) RequestReset(email string) error {
+	select {
+	case this.requests <- email:
+	default:
+		log.Warnf("password reset queue is full, request for email=%v dropped", email)
+	}
+	return nil
+}
+
+func (this *password_reset_service) work() {
+	for email := range this.requests {
+		if err := this.sendReset(email); err != nil {
+			log.Errorf("password reset for email=%v failed err=%v", email, err)
+		}
+	}
+}
+
+// Emails a reset token if the email belongs to a content owner
+func (this *password_reset_service) sendReset(email string) error {
+	owner, err := this.owner_repository.GetContentOwnerByEmail(email)
+
+	if err != nil {
+		return err
+	} else if owner == nil {
+		log.Infof("password reset requested for unknown email=%v", email)
+		return nil
+	}
+
+	now := time.Now()
+
+	latest, err := this.repository.GetLatest(owner.ID)
+
+	if err != nil {
+		return err
+	} else if latest != nil &&
+		now.Sub(latest.CreationDate) < PasswordResetInterval*time.Second {
+		log.Infof("password reset for user=%v was requested again too soon", owner.ID)
+		return nil
+	}
+
+	plain_token, err := GeneratePasswordResetToken()
+
+	if err != nil {
+		return err
+	}
+
+	token := PasswordResetToken{
+		OwnerID:        owner.ID,
+		HashedToken:    HashApiKey(plain_token),
+		CreationDate:   now,
+		ExpirationDate: now.Add(PasswordResetExpiration * time.Second),
+	}
+
+	if err = this.repository.Insert(&token); err != nil {
+		return err
+	}
+
+	log.Infof("password reset token=%v created for user=%v", token.ID, owner.ID)
+
+	return this.mailer.Send(&Mail{
+		To:      owner.Email,
+		Subject: "Reset your EasyTV password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"A password reset was requested for the user %s.\n"+
+			"Set a new password in the next %d minutes at:\n\n%s%s\n\n"+
+			"If you didn't request it, you can ignore this email.\n",
+			owner.Name, owner.Username, PasswordResetExpiration/60,
+			this.reset_url, plain_token),
+	})
+}
+
+func (this *password_reset_service) Reset(plain_token, new_password string) error {
+	// The token isn't spent on a password that would be refused
+	if len(new_password) < 8 {
+		return ErrPasswordTooShort
+	}
+
+	now := time.Now()
+
+	token, err := this.repository.Use(HashApiKey(plain_token), now)
+
+	if err != nil {
+		return err
+	} else if token == nil {
+		return ErrInvalidResetToken
+	}
+
+	if err = this.owner_service.ResetPassword(token.OwnerID, new_password); err != nil {
+		return err
+	}
+
+	log.Infof("password reset token=%v was used for user=%v", token.ID, token.OwnerID)
+
+	// Whoever knew the old password is logged out
+	if err = this.owner_repository.RevokeSessions(token.OwnerID, now); err != nil {
+		return err
+	} else if err = this.token_repository.RevokeForOwner(token.OwnerID); err != nil {
+		return err
+	} else if err = this.client_repository.RevokeForOwner(token.OwnerID); err != nil {
+		return err
+	}
+
+	// The older emails can't reset the new password
+	return this.repository.Invalidate(token.OwnerID, now)
+}
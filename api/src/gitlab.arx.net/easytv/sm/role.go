@@ -0,0 +1,229 @@
+package sm
+
+import (
+	"errors"
+)
+
+// The permissions that the roles grant
+const (
+	PermServiceRead  = "service:read"
+	PermServiceWrite = "service:write"
+	PermOwnerRead    = "owner:read"
+	PermOwnerWrite   = "owner:write"
+	PermJobRead      = "job:read"
+	PermJobCreate    = "job:create"
+	PermJobCancel    = "job:cancel"
+	PermTemplateRead = "template:read"
+	// Create, update and delete templates
+	PermTemplateWrite = "template:write"
+	PermWebhookRead   = "webhook:read"
+	PermWebhookWrite  = "webhook:write"
+	PermLogRead       = "log:read"
+	// Run srt commands through the api
+	PermSrtExec = "srt:exec"
+	// Manage the roles and assign them to the users
+	PermRoleManage = "role:manage"
+	// See the members and the invitations of the organisation
+	PermMemberRead = "member:read"
+	// Invite and remove members and set their roles
+	PermMemberWrite = "member:write"
+	PermClientRead  = "client:read"
+	// Create and revoke the oauth clients of the organisation
+	PermClientWrite = "client:write"
+	// See the locked logins and unlock them
+	PermLoginManage = "login:manage"
+	// See the files the content owners uploaded for the jobs
+	PermAssetRead = "asset:read"
+	// Upload and delete the files of the organisation
+	PermAssetWrite = "asset:write"
+)
+
+// Permissions are all the known permissions
+var Permissions = []string{
+	PermServiceRead,
+	PermServiceWrite,
+	PermOwnerRead,
+	PermOwnerWrite,
+	PermJobRead,
+	PermJobCreate,
+	PermJobCancel,
+	PermTemplateRead,
+	PermTemplateWrite,
+	PermWebhookRead,
+	PermWebhookWrite,
+	PermLogRead,
+	PermSrtExec,
+	PermRoleManage,
+	PermMemberRead,
+	PermMemberWrite,
+	PermClientRead,
+	PermClientWrite,
+	PermLoginManage,
+	PermAssetRead,
+	PermAssetWrite,
+}
+
+// IsValidPermission checks that the permission is one of Permissions
+func IsValidPermission(permission string) bool {
+	for _, known := range Permissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// The names of the built in roles
+const (
+	RoleNameAdmin         = "admin"
+	RoleNameAuditor       = "auditor"
+	RoleNameOperator      = "operator"
+	RoleNameContentOwner  = "content_owner"
+	RoleNameContentViewer = "content_viewer"
+)
+
+type Role struct {
+	ID          int64
+	Name        string
+	Description string
+	Permissions []string
+	// The built in roles are created with the database and can't change
+	BuiltIn bool
+}
+
+// Allows checks if the role grants the permission
+func (this *Role) Allows(permission string) bool {
+	for _, granted := range this.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSubsetOf checks if the other role grants all the permissions of the role
+func (this *Role) IsSubsetOf(other *Role) bool {
+	for _, permission := range this.Permissions {
+		if !other.Allows(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuiltInRoles are the roles the database is created with
+var BuiltInRoles = []Role{
+	{
+		Name:        RoleNameAdmin,
+		Description: "Full access to the manager",
+		Permissions: Permissions,
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleNameAuditor,
+		Description: "Read only access to the services, the content owners and the logs",
+		Permissions: []string{PermServiceRead, PermOwnerRead, PermJobRead, PermLogRead},
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleNameOperator,
+		Description: "Can cancel jobs but can't change the services",
+		Permissions: []string{PermServiceRead, PermOwnerRead, PermJobRead, PermJobCancel, PermLogRead},
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleNameContentOwner,
+		Description: "Full access to the jobs and the members of the organisation",
+		Permissions: []string{
+			PermServiceRead,
+			PermJobRead,
+			PermJobCreate,
+			PermJobCancel,
+			PermTemplateRead,
+			PermTemplateWrite,
+			PermWebhookRead,
+			PermWebhookWrite,
+			PermMemberRead,
+			PermMemberWrite,
+			PermClientRead,
+			PermClientWrite,
+			PermAssetRead,
+			PermAssetWrite,
+		},
+		BuiltIn: true,
+	},
+	{
+		Name:        RoleNameContentViewer,
+		Description: "Read only access to the jobs of the organisation",
+		Permissions: []string{
+			PermServiceRead,
+			PermJobRead,
+			PermTemplateRead,
+			PermWebhookRead,
+			PermMemberRead,
+			PermClientRead,
+			PermAssetRead,
+		},
+		BuiltIn: true,
+	},
+}
+
+// DefaultRoleName returns the role of the users
+// that weren't given one, depending on the kind of user
+func DefaultRoleName(user_role int) string {
+	if user_role == RoleAdmin {
+		return RoleNameAdmin
+	}
+	return RoleNameContentOwner
+}
+
+type RoleRepository interface {
+	Insert(role *Role) error
+
+	GetByID(id int64) (*Role, error)
+
+	GetByName(name string) (*Role, error)
+
+	GetAll() ([]*Role, error)
+
+	Save(role *Role) error
+
+	Delete(id int64) error
+
+	// Checks if an admin or a content owner has the role
+	IsInUse(id int64) (bool, error)
+
+	// role_id: nil for the default role
+	SetAdminRole(admin_id int64, role_id *int64) error
+
+	// role_id: nil for the default role
+	SetOwnerRole(owner_id int64, role_id *int64) error
+}
+
+// Errors
+
+var ErrRoleNameTooShort = errors.New("Role name is too short")
+var ErrRoleNameExists = errors.New("Role name exists")
+var ErrInvalidPermission = errors.New("Unknown permission")
+var ErrRoleIsBuiltIn = errors.New("Built in roles can't change")
+var ErrRoleIsInUse = errors.New("Role is assigned to users")
+
+// Service
+
+type RoleService interface {
+	CreateRole(name, description string, permissions []string) (*Role, error)
+
+	UpdateRole(id int64, description string, permissions []string) error
+
+	DeleteRole(id int64) error
+
+	// Returns the role of a session, user_role is the kind of the user
+	// (RoleAdmin or RoleContentOwner) and role_id is 0 for the default role.
+	GetSessionRole(user_role int, role_id int64) (*Role, error)
+
+	// Sets the role of an admin, an empty name for the default role
+	SetAdminRole(admin_id int64, role_name string) error
+
+	// Sets the role of a content owner, an empty name for the default role
+	SetOwnerRole(owner_id int64, role_name string) error
+}
@@ -0,0 +1,172 @@
+package sm
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+type role_service struct {
+	repository       RoleRepository
+	admin_repository AdminRepository
+	owner_repository ContentOwnerRepository
+}
+
+func NewRoleService(repository RoleRepository,
+	admin_repository AdminRepository,
+	owner_repository ContentOwnerRepository) RoleService {
+	return &role_service{
+		repository:       repository,
+		admin_repository: admin_repository,
+		owner_repository: owner_repository,
+	}
+}
+
+func check_permissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !IsValidPermission(permission) {
+			return ErrInvalidPermission
+		}
+	}
+	return nil
+}
+
+func (this *role_service) CreateRole(
+	name, description string, permissions []string) (*Role, error) {
+
+	if len(name) <= 1 {
+		return nil, ErrRoleNameTooShort
+	} else if err := check_permissions(permissions); err != nil {
+		return nil, err
+	}
+
+	existing, err := this.repository.GetByName(name)
+
+	if err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, ErrRoleNameExists
+	}
+
+	role := Role{
+		Name:        name,
+		Description: description,
+		Permissions: permissions,
+	}
+
+	if err = this.repository.Insert(&role); err != nil {
+		return nil, err
+	}
+
+	log.Infof("role=%v,'%v' created permissions=%v", role.ID, role.Name, role.Permissions)
+
+	return &role, nil
+}
+
+func (this *role_service) UpdateRole(id int64, description string, permissions []string) error {
+	if err := check_permissions(permissions); err != nil {
+		return err
+	}
+
+	role, err := this.repository.GetByID(id)
+
+	if err != nil {
+		return err
+	} else if role == nil {
+		return ErrNotFound
+	} else if role.BuiltIn {
+		return ErrRoleIsBuiltIn
+	}
+
+	log.Infof("role=%v,'%v' update permissions=%v", role.ID, role.Name, permissions)
+
+	role.Description = description
+	role.Permissions = permissions
+
+	return this.repository.Save(role)
+}
+
+func (this *role_service) DeleteRole(id int64) error {
+	role, err := this.repository.GetByID(id)
+
+	if err != nil {
+		return err
+	} else if role == nil {
+		return ErrNotFound
+	} else if role.BuiltIn {
+		return ErrRoleIsBuiltIn
+	}
+
+	in_use, err := this.repository.IsInUse(id)
+
+	if err != nil {
+		return err
+	} else if in_use {
+		return ErrRoleIsInUse
+	}
+
+	log.Infof("role=%v,'%v' deleted", role.ID, role.Name)
+
+	return this.repository.Delete(id)
+}
+
+func (this *role_service) GetSessionRole(user_role int, role_id int64) (*Role, error) {
+	if role_id == 0 {
+		return this.repository.GetByName(DefaultRoleName(user_role))
+	}
+	return this.repository.GetByID(role_id)
+}
+
+// Finds the id of the role, nil for the default role
+func (this *role_service) roleID(role_name string) (*int64, error) {
+	if len(role_name) == 0 {
+		return nil, nil
+	}
+
+	role, err := this.repository.GetByName(role_name)
+
+	if err != nil {
+		return nil, err
+	} else if role == nil {
+		return nil, ErrNotFound
+	}
+	return &role.ID, nil
+}
+
+func (this *role_service) SetAdminRole(admin_id int64, role_name string) error {
+	admin, err := this.admin_repository.GetByID(admin_id)
+
+	if err != nil {
+		return err
+	} else if admin == nil {
+		return ErrNotFound
+	}
+
+	role_id, err := this.roleID(role_name)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("admin=%v set role='%v'", admin.ID, role_name)
+
+	return this.repository.SetAdminRole(admin.ID, role_id)
+}
+
+func (this *role_service) SetOwnerRole(owner_id int64, role_name string) error {
+	owner := ContentOwner{ID: owner_id}
+
+	if err := this.owner_repository.GetContentOwnerByID(&owner); err != nil {
+		return err
+	} else if len(owner.Username) == 0 {
+		return ErrNotFound
+	}
+
+	role_id, err := this.roleID(role_name)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("content_owner=%v set role='%v'", owner.ID, role_name)
+
+	return this.repository.SetOwnerRole(owner.ID, role_id)
+}
@@ -0,0 +1,440 @@
+package sm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// Schema is a JSON Schema for the input or the output of a task.
+// The input and the output are validated as an object with a property
+// for every parameter.
+//
+// The supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, uniqueItems,
+// minProperties, maxProperties, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, minLength, maxLength and pattern.
+// Other annotations (title, description, ...) are ignored.
+type Schema map[string]interface{}
+
+// The keywords that can't be validated, a schema with them is rejected
+// instead of being partially checked
+var unsupported_schema_keywords = []string{
+	"$ref", "allOf", "anyOf", "oneOf", "not", "if", "then", "else",
+	"dependencies", "patternProperties", "additionalItems", "contains",
+	"propertyNames",
+}
+
+var schema_types = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// ParseSchema reads a schema from JSON text
+func ParseSchema(text string) (Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		return nil, &ErrInvalidSchema{Message: err.Error()}
+	}
+	return schema, schema.Check()
+}
+
+// String returns the schema encoded in JSON
+func (this Schema) String() string {
+	data, _ := json.Marshal(this)
+	return string(data)
+}
+
+// Check returns an ErrInvalidSchema if the schema is malformed
+// or uses keywords that aren't supported
+func (this Schema) Check() error {
+	if problem := check_schema(this, "#"); len(problem) > 0 {
+		return &ErrInvalidSchema{Message: problem}
+	}
+	return nil
+}
+
+// Required returns the properties that are required by the schema
+func (this Schema) Required() []string {
+	required := make([]string, 0)
+	values, _ := this["required"].([]interface{})
+	for _, value := range values {
+		if name, ok := value.(string); ok {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// IsRequired checks if the property is required by the schema
+func (this Schema) IsRequired(name string) bool {
+	for _, required := range this.Required() {
+		if required == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutRequired returns a copy of the schema where the given properties
+// are optional, e.g. the linked inputs that are known when the step starts
+func (this Schema) WithoutRequired(names []string) Schema {
+	schema := make(Schema)
+	for keyword, value := range this {
+		schema[keyword] = value
+	}
+
+	required := make([]interface{}, 0)
+	for _, name := range this.Required() {
+		excluded := false
+		for _, excluded_name := range names {
+			excluded = excluded || excluded_name == name
+		}
+		if !excluded {
+			required = append(required, name)
+		}
+	}
+	schema["required"] = required
+
+	return schema
+}
+
+// Validate returns the reasons that the value doesn't match the schema,
+// it is empty if the value is valid. `name` is used as the root of the
+// paths in the messages.
+func (this Schema) Validate(name string, value interface{}) []string {
+	problems := make([]string, 0)
+	validate_schema(this, value, name, &problems)
+	return problems
+}
+
+func check_schema(schema map[string]interface{}, path string) string {
+	for _, keyword := range unsupported_schema_keywords {
+		if _, ok := schema[keyword]; ok {
+			return fmt.Sprintf("%s: the keyword \"%s\" is not supported", path, keyword)
+		}
+	}
+
+	switch types := schema["type"].(type) {
+	case nil:
+	case string:
+		if !schema_types[types] {
+			return fmt.Sprintf("%s: unknown type \"%s\"", path, types)
+		}
+	case []interface{}:
+		for _, value := range types {
+			if type_name, ok := value.(string); !ok || !schema_types[type_name] {
+				return fmt.Sprintf("%s: unknown type %v", path, value)
+			}
+		}
+	default:
+		return fmt.Sprintf("%s: \"type\" should be a string or an array", path)
+	}
+
+	for _, keyword := range []string{"minimum", "maximum", "exclusiveMinimum",
+		"exclusiveMaximum", "multipleOf", "minLength", "maxLength", "minItems",
+		"maxItems", "minProperties", "maxProperties"} {
+		if value, ok := schema[keyword]; ok {
+			if number, ok := value.(float64); !ok {
+				return fmt.Sprintf("%s: \"%s\" should be a number", path, keyword)
+			} else if keyword == "multipleOf" && number <= 0 {
+				return fmt.Sprintf("%s: \"multipleOf\" should be greater than 0", path)
+			}
+		}
+	}
+
+	if value, ok := schema["enum"]; ok {
+		if values, ok := value.([]interface{}); !ok || len(values) == 0 {
+			return fmt.Sprintf("%s: \"enum\" should be a non empty array", path)
+		}
+	}
+
+	if value, ok := schema["uniqueItems"]; ok {
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("%s: \"uniqueItems\" should be a boolean", path)
+		}
+	}
+
+	if value, ok := schema["pattern"]; ok {
+		pattern, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("%s: \"pattern\" should be a string", path)
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Sprintf("%s: invalid \"pattern\" (%v)", path, err)
+		}
+	}
+
+	if value, ok := schema["required"]; ok {
+		values, ok := value.([]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: \"required\" should be an array of names", path)
+		}
+		for _, name := range values {
+			if _, ok := name.(string); !ok {
+				return fmt.Sprintf("%s: \"required\" should be an array of names", path)
+			}
+		}
+	}
+
+	if value, ok := schema["properties"]; ok {
+		properties, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: \"properties\" should be an object", path)
+		}
+		for name, property := range properties {
+			property_schema, ok := property.(map[string]interface{})
+			if !ok {
+				return fmt.Sprintf("%s/properties/%s: should be a schema", path, name)
+			}
+			if problem := check_schema(property_schema, path+"/properties/"+name); len(problem) > 0 {
+				return problem
+			}
+		}
+	}
+
+	switch additional := schema["additionalProperties"].(type) {
+	case nil, bool:
+	case map[string]interface{}:
+		if problem := check_schema(additional, path+"/additionalProperties"); len(problem) > 0 {
+			return problem
+		}
+	default:
+		return fmt.Sprintf("%s: \"additionalProperties\" should be a boolean or a schema", path)
+	}
+
+	switch items := schema["items"].(type) {
+	case nil:
+	case map[string]interface{}:
+		if problem := check_schema(items, path+"/items"); len(problem) > 0 {
+			return problem
+		}
+	default:
+		return fmt.Sprintf("%s: \"items\" should be a schema", path)
+	}
+
+	return ""
+}
+
+// Returns the number of a JSON value, the values of int
+// parameters have already been converted to int64
+func schema_number(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int64:
+		return float64(number), true
+	}
+	return 0, false
+}
+
+func schema_type_of(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	if number, ok := schema_number(value); ok {
+		if number == math.Trunc(number) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func schema_has_type(schema map[string]interface{}, type_name string) bool {
+	var types []interface{}
+
+	switch value := schema["type"].(type) {
+	case nil:
+		return true
+	case string:
+		types = []interface{}{value}
+	case []interface{}:
+		types = value
+	}
+
+	for _, allowed := range types {
+		if allowed == type_name || (allowed == "number" && type_name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares JSON values, numbers are compared by value
+func schema_equal(a, b interface{}) bool {
+	number_a, a_ok := schema_number(a)
+	number_b, b_ok := schema_number(b)
+	if a_ok || b_ok {
+		return a_ok && b_ok && number_a == number_b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func validate_schema(schema map[string]interface{}, value interface{}, path string, problems *[]string) {
+	add := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	type_name := schema_type_of(value)
+
+	if !schema_has_type(schema, type_name) {
+		add("should be of type %v", schema["type"])
+		return
+	}
+
+	if allowed, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range allowed {
+			if schema_equal(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("should be one of %v", allowed)
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && !schema_equal(constant, value) {
+		add("should be %v", constant)
+	}
+
+	if number, ok := schema_number(value); ok {
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			add("should be >= %v", minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && number > maximum {
+			add("should be <= %v", maximum)
+		}
+		if minimum, ok := schema["exclusiveMinimum"].(float64); ok && number <= minimum {
+			add("should be > %v", minimum)
+		}
+		if maximum, ok := schema["exclusiveMaximum"].(float64); ok && number >= maximum {
+			add("should be < %v", maximum)
+		}
+		if factor, ok := schema["multipleOf"].(float64); ok {
+			if quotient := number / factor; quotient != math.Trunc(quotient) {
+				add("should be a multiple of %v", factor)
+			}
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		length := float64(len([]rune(str)))
+		if min_length, ok := schema["minLength"].(float64); ok && length < min_length {
+			add("should have at least %v characters", min_length)
+		}
+		if max_length, ok := schema["maxLength"].(float64); ok && length > max_length {
+			add("should have at most %v characters", max_length)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if matched, _ := regexp.MatchString(pattern, str); !matched {
+				add("should match the pattern %s", pattern)
+			}
+		}
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		length := float64(len(array))
+		if min_items, ok := schema["minItems"].(float64); ok && length < min_items {
+			add("should have at least %v items", min_items)
+		}
+		if max_items, ok := schema["maxItems"].(float64); ok && length > max_items {
+			add("should have at most %v items", max_items)
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			for i := range array {
+				for j := i + 1; j < len(array); j++ {
+					if schema_equal(array[i], array[j]) {
+						add("items %d and %d are the same", i, j)
+					}
+				}
+			}
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range array {
+				validate_schema(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		validate_schema_object(schema, object, path, problems)
+	}
+}
+
+func validate_schema_object(schema map[string]interface{}, object map[string]interface{},
+	path string, problems *[]string) {
+
+	length := float64(len(object))
+	if min_properties, ok := schema["minProperties"].(float64); ok && length < min_properties {
+		*problems = append(*problems,
+			fmt.Sprintf("%s: should have at least %v properties", path, min_properties))
+	}
+	if max_properties, ok := schema["maxProperties"].(float64); ok && length > max_properties {
+		*problems = append(*problems,
+			fmt.Sprintf("%s: should have at most %v properties", path, max_properties))
+	}
+
+	required, _ := schema["required"].([]interface{})
+	for _, value := range required {
+		if name, _ := value.(string); len(name) > 0 {
+			if _, ok := object[name]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s.%s: is required", path, name))
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Sorted, so the messages are always the same
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property_path := path + "." + name
+
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			validate_schema(property, object[name], property_path, problems)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*problems = append(*problems, property_path+": is not allowed")
+			}
+		case map[string]interface{}:
+			validate_schema(additional, object[name], property_path, problems)
+		}
+	}
+}
+
+// errors
+
+type ErrInvalidSchema struct{ Message string }
+
+func (e *ErrInvalidSchema) Error() string {
+	return fmt.Sprintf("Invalid schema: %s", e.Message)
+}
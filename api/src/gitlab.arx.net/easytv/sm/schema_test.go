@@ -0,0 +1,157 @@
+package sm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func parse_test_value(t *testing.T, text string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		t.Fatalf("invalid test value %s: %v", text, err)
+	}
+	return value
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		keyword string
+		schema  string
+		value   string
+		valid   bool
+	}{
+		{"type", `{"type": "string"}`, `"a"`, true},
+		{"type", `{"type": "string"}`, `1`, false},
+		{"type", `{"type": ["string", "null"]}`, `null`, true},
+		{"type", `{"type": "integer"}`, `1.5`, false},
+		{"type", `{"type": "number"}`, `2`, true},
+		{"type", `{"type": "boolean"}`, `"true"`, false},
+		{"type", `{"type": "array"}`, `{}`, false},
+		{"type", `{"type": "object"}`, `{}`, true},
+		{"enum", `{"enum": ["a", 1]}`, `1.0`, true},
+		{"enum", `{"enum": ["a", 1]}`, `"b"`, false},
+		{"const", `{"const": {"a": 1}}`, `{"a": 1}`, true},
+		{"const", `{"const": 3}`, `4`, false},
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": "x", "b": 1}`, true},
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, false},
+		{"required", `{"required": ["a"]}`, `{"a": null}`, true},
+		{"required", `{"required": ["a"]}`, `{"b": 1}`, false},
+		{"additionalProperties", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, true},
+		{"additionalProperties", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"b": 1}`, false},
+		{"additionalProperties", `{"additionalProperties": {"type": "number"}}`, `{"b": "x"}`, false},
+		{"items", `{"items": {"type": "integer"}}`, `[1, 2]`, true},
+		{"items", `{"items": {"type": "integer"}}`, `[1, "2"]`, false},
+		{"minItems", `{"minItems": 2}`, `[1, 2]`, true},
+		{"minItems", `{"minItems": 2}`, `[1]`, false},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, false},
+		{"uniqueItems", `{"uniqueItems": true}`, `[1, "1"]`, true},
+		{"uniqueItems", `{"uniqueItems": true}`, `[1, 1.0]`, false},
+		{"minProperties", `{"minProperties": 1}`, `{}`, false},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, false},
+		{"minimum", `{"minimum": 1}`, `1`, true},
+		{"minimum", `{"minimum": 1}`, `0.5`, false},
+		{"maximum", `{"maximum": 1}`, `1`, true},
+		{"maximum", `{"maximum": 1}`, `2`, false},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, false},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `0.9`, true},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `1`, false},
+		{"multipleOf", `{"multipleOf": 0.5}`, `2.5`, true},
+		{"multipleOf", `{"multipleOf": 3}`, `7`, false},
+		{"minLength", `{"minLength": 2}`, `"é"`, false},
+		{"maxLength", `{"maxLength": 2}`, `"éé"`, true},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, false},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, true},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"ab1"`, false},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `1`, true},
+	}
+
+	for _, test := range tests {
+		schema, err := ParseSchema(test.schema)
+		if err != nil {
+			t.Errorf("%s: %s was rejected: %v", test.keyword, test.schema, err)
+			continue
+		}
+
+		problems := schema.Validate("input", parse_test_value(t, test.value))
+
+		if test.valid && len(problems) > 0 {
+			t.Errorf("%s: %s should accept %s, got %v", test.keyword, test.schema, test.value, problems)
+		} else if !test.valid && len(problems) == 0 {
+			t.Errorf("%s: %s should reject %s", test.keyword, test.schema, test.value)
+		}
+	}
+}
+
+func TestSchemaNestedPaths(t *testing.T) {
+	schema, err := ParseSchema(`{
+		"properties": {
+			"list": {"items": {"properties": {"n": {"type": "integer"}}}}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := schema.Validate("input", parse_test_value(t, `{"list": [{"n": 1}, {"n": "x"}]}`))
+
+	if len(problems) != 1 || !strings.HasPrefix(problems[0], "input.list[1].n: ") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	for _, keyword := range unsupported_schema_keywords {
+		text := `{"properties": {"a": {"` + keyword + `": {}}}}`
+
+		_, err := ParseSchema(text)
+
+		if _, ok := err.(*ErrInvalidSchema); !ok {
+			t.Errorf("%s: expected ErrInvalidSchema, got %v", keyword, err)
+		} else if !strings.Contains(err.Error(), `"`+keyword+`"`) {
+			t.Errorf("%s: the error doesn't name the keyword: %v", keyword, err)
+		}
+	}
+}
+
+func TestSchemaRejectsMalformedKeywords(t *testing.T) {
+	tests := []string{
+		`{"type": "text"}`,
+		`{"type": ["string", 1]}`,
+		`{"type": 1}`,
+		`{"minimum": "1"}`,
+		`{"multipleOf": 0}`,
+		`{"enum": []}`,
+		`{"uniqueItems": "yes"}`,
+		`{"pattern": "("}`,
+		`{"required": "a"}`,
+		`{"required": [1]}`,
+		`{"properties": []}`,
+		`{"properties": {"a": 1}}`,
+		`{"additionalProperties": 1}`,
+		`{"items": [{}]}`,
+		`{"items": {"type": "text"}}`,
+		`not json`,
+	}
+
+	for _, text := range tests {
+		if _, err := ParseSchema(text); err == nil {
+			t.Errorf("%s should be rejected", text)
+		}
+	}
+}
+
+func TestSchemaWithoutRequired(t *testing.T) {
+	schema, err := ParseSchema(`{"required": ["a", "b"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	optional := schema.WithoutRequired([]string{"a"})
+
+	if optional.IsRequired("a") || !optional.IsRequired("b") {
+		t.Errorf("unexpected required %v", optional.Required())
+	} else if !schema.IsRequired("a") {
+		t.Errorf("the original schema was changed")
+	}
+}
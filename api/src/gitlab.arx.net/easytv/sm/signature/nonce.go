@@ -0,0 +1,51 @@
+package signature
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of the verified requests
+type NonceStore interface {
+	// Add stores the nonce until the expiration,
+	// it returns false if the nonce is already stored
+	Add(nonce string, expiration time.Time) bool
+}
+
+type memory_nonce_store struct {
+	mutex      sync.Mutex
+	nonces     map[string]time.Time
+	last_purge time.Time
+}
+
+// NewMemoryNonceStore creates a NonceStore for a single instance of a module
+func NewMemoryNonceStore() NonceStore {
+	return &memory_nonce_store{
+		nonces:     make(map[string]time.Time),
+		last_purge: time.Now(),
+	}
+}
+
+func (this *memory_nonce_store) Add(nonce string, expiration time.Time) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := time.Now()
+
+	// Forget the expired nonces once in a while
+	if now.Sub(this.last_purge) > time.Minute {
+		for stored, stored_expiration := range this.nonces {
+			if stored_expiration.Before(now) {
+				delete(this.nonces, stored)
+			}
+		}
+		this.last_purge = now
+	}
+
+	if stored_expiration, ok := this.nonces[nonce]; ok && !stored_expiration.Before(now) {
+		return false
+	}
+
+	this.nonces[nonce] = expiration
+	return true
+}
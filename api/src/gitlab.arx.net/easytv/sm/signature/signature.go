@@ -0,0 +1,170 @@
+// Package signature signs the requests that the manager sends to the
+// modules and verifies them on the side of the modules.
+//
+// A request is signed with the signing secret of the module, the signature
+// is an HMAC-SHA256 over the method, the path, the timestamp, a nonce and
+// the body of the request:
+//
+//	METHOD\nPATH?QUERY\nTIMESTAMP\nNONCE\nHEX(SHA256(BODY))
+//
+// While a renewed secret is in its grace period the request carries a
+// signature for each secret separated by commas, a module accepts the
+// request if one of them is valid for its secret.
+//
+// A module verifies the requests with a Verifier:
+//
+//	verifier := signature.NewVerifier(os.Getenv("EASYTV_SIGNING_SECRET"))
+//	http.Handle("/start", verifier.Handler(start_handler))
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Easytv-Timestamp"
+	NonceHeader     = "X-Easytv-Nonce"
+	SignatureHeader = "X-Easytv-Signature"
+
+	// How far the timestamp of a request can be from the clock of the module
+	DefaultMaxSkew = 5 * time.Minute
+)
+
+var ErrMissingSignature = errors.New("The request isn't signed")
+var ErrInvalidTimestamp = errors.New("The timestamp of the request is too old or invalid")
+var ErrInvalidSignature = errors.New("The signature of the request is invalid")
+var ErrReplayedRequest = errors.New("The nonce of the request was already used")
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() string {
+	var random_bytes [32]byte
+	_, _ = rand.Read(random_bytes[:])
+	return hex.EncodeToString(random_bytes[:])
+}
+
+// Sign returns the signature of a request, "sha256=" followed by the hex HMAC
+func Sign(secret, method, path string, timestamp int64, nonce string, body []byte) string {
+	body_hash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n%s",
+		method, path, timestamp, nonce, hex.EncodeToString(body_hash[:]))
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest adds the timestamp, the nonce and the signature headers
+// to the request, body is the body the request was created with.
+func SignRequest(req *http.Request, secret string, body []byte) {
+	SignRequestWithSecrets(req, []string{secret}, body)
+}
+
+// SignRequestWithSecrets signs the request with each of the secrets,
+// it is used while the previous secret of a module still works
+func SignRequestWithSecrets(req *http.Request, secrets []string, body []byte) {
+	var nonce_bytes [16]byte
+	_, _ = rand.Read(nonce_bytes[:])
+
+	timestamp := time.Now().Unix()
+	nonce := hex.EncodeToString(nonce_bytes[:])
+
+	signatures := make([]string, len(secrets))
+	for i, secret := range secrets {
+		signatures[i] = Sign(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	}
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, strings.Join(signatures, ","))
+}
+
+// Verifier checks the signature of the requests sent by the manager
+type Verifier struct {
+	Secret  string
+	MaxSkew time.Duration
+	// The nonces of the verified requests, they are kept until
+	// their timestamp is too old to be accepted anyway
+	Nonces NonceStore
+}
+
+// NewVerifier creates a verifier that keeps the nonces in memory,
+// a module with more than one instance should share a NonceStore.
+func NewVerifier(secret string) *Verifier {
+	return &Verifier{
+		Secret:  secret,
+		MaxSkew: DefaultMaxSkew,
+		Nonces:  NewMemoryNonceStore(),
+	}
+}
+
+// Verify checks the signature of the request and returns its body,
+// the body of the request can still be read after it.
+func (this *Verifier) Verify(r *http.Request) ([]byte, error) {
+	str_timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	received := r.Header.Get(SignatureHeader)
+
+	if len(str_timestamp) == 0 || len(nonce) == 0 || len(received) == 0 {
+		return nil, ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(str_timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidTimestamp
+	}
+
+	date := time.Unix(timestamp, 0)
+	if skew := time.Since(date); skew > this.MaxSkew || skew < -this.MaxSkew {
+		return nil, ErrInvalidTimestamp
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := Sign(this.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	valid := false
+	for _, signature := range strings.Split(received, ",") {
+		if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+			valid = true
+		}
+	}
+
+	if !valid {
+		return nil, ErrInvalidSignature
+	}
+
+	// The nonce is only stored for valid signatures,
+	// otherwise anyone could use up the nonces
+	if !this.Nonces.Add(nonce, date.Add(this.MaxSkew)) {
+		return nil, ErrReplayedRequest
+	}
+
+	return body, nil
+}
+
+// Handler rejects the requests that aren't signed by the manager
+// with 401 before they reach the next handler
+func (this *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := this.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
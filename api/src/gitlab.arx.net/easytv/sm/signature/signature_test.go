@@ -0,0 +1,189 @@
+package signature
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const test_secret = "5f2b1c7e9a0d4e3f8b6a1c2d3e4f5a6b"
+const test_body = `{"job_id":1,"step_id":2}`
+
+// Creates a request signed with the secrets at the given timestamp
+func signedRequest(secrets []string, method, path string, timestamp int64,
+	nonce string, body string) *http.Request {
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+
+	signatures := ""
+	for i, secret := range secrets {
+		if i > 0 {
+			signatures += ","
+		}
+		signatures += Sign(secret, method, path, timestamp, nonce, []byte(body))
+	}
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, signatures)
+	return req
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	verifier := NewVerifier(test_secret)
+
+	req := httptest.NewRequest("POST", "/start?async=true", bytes.NewBufferString(test_body))
+	SignRequest(req, test_secret, []byte(test_body))
+
+	body, err := verifier.Verify(req)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(body) != test_body {
+		t.Errorf("body %q, expected %q", body, test_body)
+	}
+}
+
+func TestVerifyTimestampSkew(t *testing.T) {
+	now := time.Now().Unix()
+	max_skew := int64(DefaultMaxSkew / time.Second)
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		expected  error
+	}{
+		{"now", now, nil},
+		{"inside the skew", now - max_skew + 5, nil},
+		{"inside the future skew", now + max_skew - 5, nil},
+		{"too old", now - max_skew - 5, ErrInvalidTimestamp},
+		{"too far in the future", now + max_skew + 5, ErrInvalidTimestamp},
+	}
+
+	for i, test := range tests {
+		verifier := NewVerifier(test_secret)
+		req := signedRequest([]string{test_secret}, "POST", "/start", test.timestamp,
+			"nonce"+strconv.Itoa(i), test_body)
+
+		if _, err := verifier.Verify(req); err != test.expected {
+			t.Errorf("%s: error %v, expected %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestVerifyRejectsReplayedNonce(t *testing.T) {
+	verifier := NewVerifier(test_secret)
+	now := time.Now().Unix()
+
+	req := signedRequest([]string{test_secret}, "POST", "/start", now, "nonce", test_body)
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatal(err)
+	}
+
+	req = signedRequest([]string{test_secret}, "POST", "/start", now, "nonce", test_body)
+	if _, err := verifier.Verify(req); err != ErrReplayedRequest {
+		t.Errorf("replayed nonce: error %v, expected %v", err, ErrReplayedRequest)
+	}
+
+	// An invalid signature doesn't use up the nonce
+	req = signedRequest([]string{"wrong secret"}, "POST", "/start", now, "other nonce", test_body)
+	if _, err := verifier.Verify(req); err != ErrInvalidSignature {
+		t.Fatalf("wrong secret: error %v, expected %v", err, ErrInvalidSignature)
+	}
+
+	req = signedRequest([]string{test_secret}, "POST", "/start", now, "other nonce", test_body)
+	if _, err := verifier.Verify(req); err != nil {
+		t.Errorf("nonce of an invalid request: %v", err)
+	}
+}
+
+func TestVerifyGracePeriodSecrets(t *testing.T) {
+	previous_secret := GenerateSecret()
+	new_secret := GenerateSecret()
+
+	// The manager signs with both secrets during the grace period,
+	// the module accepts the request with either of them
+	for _, secret := range []string{previous_secret, new_secret} {
+		verifier := NewVerifier(secret)
+
+		req := httptest.NewRequest("POST", "/start", bytes.NewBufferString(test_body))
+		SignRequestWithSecrets(req, []string{previous_secret, new_secret}, []byte(test_body))
+
+		if _, err := verifier.Verify(req); err != nil {
+			t.Errorf("secret %s: %v", secret, err)
+		}
+	}
+
+	verifier := NewVerifier(GenerateSecret())
+
+	req := httptest.NewRequest("POST", "/start", bytes.NewBufferString(test_body))
+	SignRequestWithSecrets(req, []string{previous_secret, new_secret}, []byte(test_body))
+
+	if _, err := verifier.Verify(req); err != ErrInvalidSignature {
+		t.Errorf("other secret: error %v, expected %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"tampered body", "POST", "/start", `{"job_id":1,"step_id":3}`},
+		{"tampered path", "POST", "/cancel", test_body},
+		{"tampered query", "POST", "/start?async=true", test_body},
+		{"tampered method", "PUT", "/start", test_body},
+	}
+
+	for i, test := range tests {
+		verifier := NewVerifier(test_secret)
+		nonce := "nonce" + strconv.Itoa(i)
+
+		signed := signedRequest([]string{test_secret}, "POST", "/start", now, nonce, test_body)
+
+		req := httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+		req.Header = signed.Header
+
+		if _, err := verifier.Verify(req); err != ErrInvalidSignature {
+			t.Errorf("%s: error %v, expected %v", test.name, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestVerifyMissingHeaders(t *testing.T) {
+	verifier := NewVerifier(test_secret)
+
+	for _, header := range []string{TimestampHeader, NonceHeader, SignatureHeader} {
+		req := httptest.NewRequest("POST", "/start", bytes.NewBufferString(test_body))
+		SignRequest(req, test_secret, []byte(test_body))
+		req.Header.Del(header)
+
+		if _, err := verifier.Verify(req); err != ErrMissingSignature {
+			t.Errorf("without %s: error %v, expected %v", header, err, ErrMissingSignature)
+		}
+	}
+}
+
+func TestHandlerRejectsUnsigned(t *testing.T) {
+	verifier := NewVerifier(test_secret)
+	called := false
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/start", bytes.NewBufferString(test_body)))
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, expected %d", recorder.Code, http.StatusUnauthorized)
+	} else if called {
+		t.Error("the next handler was called for an unsigned request")
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+// FileStore keeps the assets in a directory of the local disk,
+// the key is the path of the file in Root
+type FileStore struct {
+	Root string
+}
+
+// filename returns the path of a key, the key can't leave the root
+func (this *FileStore) filename(key string) string {
+	return filepath.Join(this.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (this *FileStore) object(key string, info os.FileInfo) *sm.AssetObject {
+	return &sm.AssetObject{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}
+}
+
+func (this *FileStore) Put(key string, content io.Reader, size int64) error {
+	filename := this.filename(key)
+
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+
+	// The file is renamed when it is complete so it is never read half written
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".upload-")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, content)
+
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+
+	if err == nil {
+		err = os.Chmod(f.Name(), 0666)
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (this *FileStore) Get(key string) (io.ReadCloser, *sm.AssetObject, error) {
+	f, err := os.Open(this.filename(key))
+
+	if os.IsNotExist(err) {
+		return nil, nil, sm.ErrAssetNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	// The *os.File is seekable so the downloads can use ranges
+	return f, this.object(key, info), nil
+}
+
+func (this *FileStore) Stat(key string) (*sm.AssetObject, error) {
+	info, err := os.Stat(this.filename(key))
+
+	if os.IsNotExist(err) {
+		return nil, sm.ErrAssetNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return this.object(key, info), nil
+}
+
+func (this *FileStore) Delete(key string) error {
+	filename := this.filename(key)
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Remove the folders of the key that are empty now
+	root := filepath.Clean(this.Root)
+
+	for dir := filepath.Dir(filename); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (this *FileStore) List(prefix string) ([]*sm.AssetObject, error) {
+	objects := make([]*sm.AssetObject, 0)
+
+	// Only the folder of the prefix is walked
+	dir := this.Root
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		dir = this.filename(prefix[:i])
+	}
+
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		} else if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(this.Root, filename)
+
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(info.Name(), ".upload-") {
+			objects = append(objects, this.object(key, info))
+		}
+		return nil
+	})
+
+	return objects, err
+}
@@ -0,0 +1,536 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+const (
+	s3_time_format = "20060102T150405Z"
+	s3_date_format = "20060102"
+	// The content of the uploads isn't hashed so it can be streamed
+	s3_unsigned_payload = "UNSIGNED-PAYLOAD"
+	// The SHA-256 of an empty body
+	s3_empty_payload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// A single PUT can't be larger than 5 GiB, the content that is larger
+	// than a part is uploaded in parts of this size
+	s3_part_size = 64 << 20 // 64 MiB
+	// An upload can have up to 10000 parts, the parts of
+	// larger content are bigger
+	s3_max_parts = 10000
+	// The largest object of S3
+	s3_max_object_size = 5 << 40 // 5 TiB
+)
+
+var ErrObjectTooLarge = errors.New("The asset is larger than the 5 TiB of S3")
+
+// S3Store keeps the assets in a bucket of S3 or of a compatible server
+// like MinIO. The requests are signed with AWS Signature Version 4.
+type S3Store struct {
+	// The url of the server, e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// The bucket is in the path instead of the host, MinIO needs it
+	PathStyle bool
+	Client    *http.Client
+
+	// The tests replace the clock and the size of the parts
+	now       func() time.Time
+	part_size int64
+}
+
+// s3_escape encodes a string like the canonical request of the signature
+func s3_escape(value string, keep_slash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keep_slash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3_hmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3_canonical_query encodes the query sorted by key like the canonical request
+func s3_canonical_query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			params = append(params, s3_escape(k, false)+"="+s3_escape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3_authorization returns the Authorization header of AWS Signature Version 4,
+// headers are the signed headers with lowercase names
+func s3_authorization(access_key, secret_key, region string, date time.Time,
+	method, uri, canonical_query string, headers map[string]string, payload_hash string) string {
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical_headers strings.Builder
+	for _, name := range names {
+		canonical_headers.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signed_headers := strings.Join(names, ";")
+
+	canonical_request := strings.Join([]string{
+		method,
+		uri,
+		canonical_query,
+		canonical_headers.String(),
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	request_hash := sha256.Sum256([]byte(canonical_request))
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date.Format(s3_date_format), region)
+
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		date.Format(s3_time_format),
+		scope,
+		hex.EncodeToString(request_hash[:]),
+	}, "\n")
+
+	signing_key := s3_hmac([]byte("AWS4"+secret_key), date.Format(s3_date_format))
+	signing_key = s3_hmac(signing_key, region)
+	signing_key = s3_hmac(signing_key, "s3")
+	signing_key = s3_hmac(signing_key, "aws4_request")
+
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		access_key,
+		scope,
+		signed_headers,
+		hex.EncodeToString(s3_hmac(signing_key, string_to_sign)))
+}
+
+// request creates a signed request for a key of the bucket
+func (this *S3Store) request(method, key string, query url.Values,
+	body io.Reader, payload_hash string) (*http.Request, error) {
+
+	endpoint, err := url.Parse(this.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	host := endpoint.Host
+	uri := "/" + s3_escape(key, true)
+
+	if this.PathStyle && len(key) == 0 {
+		uri = "/" + s3_escape(this.Bucket, false)
+	} else if this.PathStyle {
+		uri = "/" + s3_escape(this.Bucket, false) + uri
+	} else {
+		host = this.Bucket + "." + host
+	}
+
+	canonical_query := s3_canonical_query(query)
+
+	target := endpoint.Scheme + "://" + host + uri
+	if len(canonical_query) > 0 {
+		target += "?" + canonical_query
+	}
+
+	r, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now
+	if this.now != nil {
+		now = this.now
+	}
+	date := now().UTC()
+
+	r.Host = host
+	r.Header.Set("X-Amz-Date", date.Format(s3_time_format))
+	r.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	r.Header.Set("Authorization", s3_authorization(this.AccessKey, this.SecretKey, this.Region,
+		date, method, uri, canonical_query, map[string]string{
+			"host":                 host,
+			"x-amz-content-sha256": payload_hash,
+			"x-amz-date":           date.Format(s3_time_format),
+		}, payload_hash))
+
+	return r, nil
+}
+
+func (this *S3Store) do(r *http.Request) (*http.Response, error) {
+	client := this.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(r)
+}
+
+// s3_error reads the error of a response and closes it
+func s3_error(resp *http.Response, method, key string) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return sm.ErrAssetNotFound
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return fmt.Errorf("s3 %s key=%s failed with status=%v: %s",
+		method, key, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (this *S3Store) object(key string, resp *http.Response) *sm.AssetObject {
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &sm.AssetObject{
+		Key:          key,
+		Size:         resp.ContentLength,
+		LastModified: modified,
+	}
+}
+
+// Put uploads the content with a single request up to the size of a part,
+// larger content is uploaded in parts
+func (this *S3Store) Put(key string, content io.Reader, size int64) error {
+	part_size := int64(s3_part_size)
+	if this.part_size > 0 {
+		part_size = this.part_size
+	}
+
+	if size > s3_max_object_size {
+		return ErrObjectTooLarge
+	} else if size > part_size*s3_max_parts {
+		part_size = (size + s3_max_parts - 1) / s3_max_parts
+	}
+
+	if size > part_size {
+		return this.putMultipart(key, content, size, part_size)
+	}
+
+	_, err := this.putContent(key, nil, content, size)
+	return err
+}
+
+// putContent sends content of a known size and returns the ETag of the response
+func (this *S3Store) putContent(key string, query url.Values, content io.Reader, size int64) (string, error) {
+	// A body of unknown length would be sent chunked, S3 needs the length
+	body := io.Reader(http.NoBody)
+	if size > 0 {
+		body = ioutil.NopCloser(io.LimitReader(content, size))
+	}
+
+	r, err := this.request(http.MethodPut, key, query, body, s3_unsigned_payload)
+	if err != nil {
+		return "", err
+	}
+
+	r.ContentLength = size
+
+	resp, err := this.do(r)
+	if err != nil {
+		return "", err
+	} else if resp.StatusCode != http.StatusOK {
+		return "", s3_error(resp, http.MethodPut, key)
+	}
+
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+type s3_initiate_result struct {
+	UploadId string
+}
+
+type s3_complete_part struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3_complete_upload struct {
+	XMLName xml.Name           `xml:"CompleteMultipartUpload"`
+	Parts   []s3_complete_part `xml:"Part"`
+}
+
+// s3_complete_result is the response of CompleteMultipartUpload,
+// it can be an Error even with the status 200
+type s3_complete_result struct {
+	XMLName xml.Name
+	Code    string
+	Message string
+}
+
+// putMultipart uploads the content in parts, the parts are discarded if it fails
+func (this *S3Store) putMultipart(key string, content io.Reader, size, part_size int64) error {
+	upload_id, err := this.initiateMultipart(key)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]s3_complete_part, 0, (size+part_size-1)/part_size)
+
+	for offset := int64(0); offset < size; offset += part_size {
+		length := part_size
+		if size-offset < length {
+			length = size - offset
+		}
+
+		query := url.Values{}
+		query.Set("partNumber", fmt.Sprint(len(parts)+1))
+		query.Set("uploadId", upload_id)
+
+		etag, err := this.putContent(key, query, content, length)
+		if err != nil {
+			this.abortMultipart(key, upload_id)
+			return err
+		}
+
+		parts = append(parts, s3_complete_part{PartNumber: len(parts) + 1, ETag: etag})
+	}
+
+	if err = this.completeMultipart(key, upload_id, parts); err != nil {
+		this.abortMultipart(key, upload_id)
+		return err
+	}
+	return nil
+}
+
+func (this *S3Store) initiateMultipart(key string) (string, error) {
+	r, err := this.request(http.MethodPost, key, url.Values{"uploads": {""}}, nil, s3_empty_payload)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return "", err
+	} else if resp.StatusCode != http.StatusOK {
+		return "", s3_error(resp, http.MethodPost, key)
+	}
+
+	var result s3_initiate_result
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if err != nil {
+		return "", err
+	} else if len(result.UploadId) == 0 {
+		return "", fmt.Errorf("s3 POST key=%s returned no upload id", key)
+	}
+	return result.UploadId, nil
+}
+
+func (this *S3Store) completeMultipart(key, upload_id string, parts []s3_complete_part) error {
+	body, err := xml.Marshal(s3_complete_upload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	body_hash := sha256.Sum256(body)
+
+	r, err := this.request(http.MethodPost, key, url.Values{"uploadId": {upload_id}},
+		bytes.NewReader(body), hex.EncodeToString(body_hash[:]))
+	if err != nil {
+		return err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusOK {
+		return s3_error(resp, http.MethodPost, key)
+	}
+
+	var result s3_complete_result
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	} else if result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 POST key=%s failed to complete the upload: %s %s",
+			key, result.Code, result.Message)
+	}
+	return nil
+}
+
+func (this *S3Store) abortMultipart(key, upload_id string) {
+	r, err := this.request(http.MethodDelete, key, url.Values{"uploadId": {upload_id}}, nil, s3_empty_payload)
+	if err != nil {
+		return
+	}
+
+	if resp, err := this.do(r); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (this *S3Store) Get(key string) (io.ReadCloser, *sm.AssetObject, error) {
+	r, err := this.request(http.MethodGet, key, nil, nil, s3_empty_payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return nil, nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, nil, s3_error(resp, http.MethodGet, key)
+	}
+
+	return resp.Body, this.object(key, resp), nil
+}
+
+func (this *S3Store) Stat(key string) (*sm.AssetObject, error) {
+	r, err := this.request(http.MethodHead, key, nil, nil, s3_empty_payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, s3_error(resp, http.MethodHead, key)
+	}
+
+	resp.Body.Close()
+	return this.object(key, resp), nil
+}
+
+func (this *S3Store) Delete(key string) error {
+	r, err := this.request(http.MethodDelete, key, nil, nil, s3_empty_payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return err
+	} else if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil
+	} else if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3_error(resp, http.MethodDelete, key)
+	}
+
+	resp.Body.Close()
+	return nil
+}
+
+type s3_list_result struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+func (this *S3Store) List(prefix string) ([]*sm.AssetObject, error) {
+	objects := make([]*sm.AssetObject, 0)
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+
+	// A response has up to 1000 keys
+	for {
+		r, err := this.request(http.MethodGet, "", query, nil, s3_empty_payload)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := this.do(r)
+		if err != nil {
+			return nil, err
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, s3_error(resp, http.MethodGet, prefix)
+		}
+
+		var result s3_list_result
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, &sm.AssetObject{
+				Key:          content.Key,
+				Size:         content.Size,
+				LastModified: content.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// CreateBucket creates the bucket if it doesn't exist, it is for the
+// local servers of the development
+func (this *S3Store) CreateBucket() error {
+	r, err := this.request(http.MethodPut, "", nil, nil, s3_empty_payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := this.do(r)
+	if err != nil {
+		return err
+	}
+
+	// 409 is BucketAlreadyOwnedByYou
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return s3_error(resp, http.MethodPut, "")
+	}
+
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package store has the implementations of sm.AssetStore
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.arx.net/easytv/sm"
+)
+
+// Open returns the AssetStore of the configuration.
+// ASSET_STORE is "fs" (the default) for the ASSET_DIR directory
+// or "s3" for the S3_BUCKET bucket.
+func Open() (sm.AssetStore, error) {
+	switch os.Getenv("ASSET_STORE") {
+	case "s3":
+		secret_key := os.Getenv("S3_SECRET_KEY")
+		if len(secret_key) == 0 {
+			content, err := ioutil.ReadFile(os.Getenv("S3_SECRET_KEY_FILE"))
+			if err != nil {
+				return nil, err
+			}
+			secret_key = strings.TrimSpace(string(content))
+		}
+
+		region := os.Getenv("S3_REGION")
+		if len(region) == 0 {
+			region = "us-east-1"
+		}
+
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if len(endpoint) == 0 {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+
+		store := &S3Store{
+			Endpoint:  endpoint,
+			Region:    region,
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: secret_key,
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		}
+
+		if os.Getenv("S3_CREATE_BUCKET") == "true" {
+			if err := store.CreateBucket(); err != nil {
+				return nil, err
+			}
+		}
+
+		log.Infof("assets are stored in bucket=%v of %v", store.Bucket, store.Endpoint)
+
+		return store, nil
+	default:
+		root := os.Getenv("ASSET_DIR")
+		if len(root) == 0 {
+			root = "/asset"
+		}
+
+		log.Infof("assets are stored in %v", root)
+
+		return &FileStore{Root: root}, nil
+	}
+}
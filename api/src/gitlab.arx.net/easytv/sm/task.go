@@ -1,14 +1,25 @@
 package sm
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // ParamType is the type of an input or output parameter of a task
 type ParamType int
 
 const (
-	IntParam             ParamType = 0
-	StringParam          ParamType = 1
-	DoubleParam          ParamType = 2
+	IntParam    ParamType = 0
+	StringParam ParamType = 1
+	DoubleParam ParamType = 2
+	BoolParam   ParamType = 3
+	// A JSON object
+	JsonParam  ParamType = 4
+	ArrayParam ParamType = 5
+	// The url of an asset that was uploaded to the manager
+	AssetParam ParamType = 6
+	// A string out of a fixed set of values
+	EnumParam            ParamType = 7
 	UnsupportedTypeParam ParamType = -1
 )
 
@@ -21,6 +32,16 @@ func ParamTypeStr(param_type ParamType) string {
 		return "string"
 	case DoubleParam:
 		return "double"
+	case BoolParam:
+		return "bool"
+	case JsonParam:
+		return "json"
+	case ArrayParam:
+		return "array"
+	case AssetParam:
+		return "asset"
+	case EnumParam:
+		return "enum"
 	}
 	return ""
 }
@@ -33,6 +54,12 @@ func GetParamType(value interface{}) ParamType {
 		return IntParam
 	case float64:
 		return DoubleParam
+	case bool:
+		return BoolParam
+	case map[string]interface{}:
+		return JsonParam
+	case []interface{}:
+		return ArrayParam
 	}
 	return UnsupportedTypeParam
 }
@@ -45,21 +72,361 @@ func GetParamTypeFromSting(value string) ParamType {
 		return IntParam
 	case "double":
 		return DoubleParam
+	case "bool":
+		return BoolParam
+	case "json":
+		return JsonParam
+	case "array":
+		return ArrayParam
+	case "asset":
+		return AssetParam
+	case "enum":
+		return EnumParam
 	}
 	return UnsupportedTypeParam
 }
 
+// TaskParam describes an input or output parameter of a task
+type TaskParam struct {
+	Type ParamType
+	// The allowed values of an EnumParam
+	Values []string
+	// Optional parameters can be omitted, an input that is
+	// omitted is sent with its default value if it has one
+	Required bool
+	Default  interface{}
+}
+
+// ParseTaskParam reads a parameter of a task as it is registered,
+// either the name of its type or an object
+// {"type": "enum", "values": [...], "required": false, "default": "..."}.
+// The result has to be checked with IsValid.
+func ParseTaskParam(data interface{}) TaskParam {
+	if type_name, ok := data.(string); ok {
+		return TaskParam{Type: GetParamTypeFromSting(type_name), Required: true}
+	}
+
+	param_data, ok := data.(map[string]interface{})
+	if !ok {
+		return TaskParam{Type: UnsupportedTypeParam}
+	}
+
+	type_name, _ := param_data["type"].(string)
+	param := TaskParam{Type: GetParamTypeFromSting(type_name), Required: true}
+
+	if values, ok := param_data["values"].([]interface{}); ok {
+		param.Values = make([]string, len(values))
+		for i, value := range values {
+			if param.Values[i], ok = value.(string); !ok {
+				return TaskParam{Type: UnsupportedTypeParam}
+			}
+		}
+	}
+
+	if required, ok := param_data["required"]; ok {
+		if param.Required, ok = required.(bool); !ok {
+			return TaskParam{Type: UnsupportedTypeParam}
+		}
+	}
+
+	if default_value, ok := param_data["default"]; ok && default_value != nil {
+		// A parameter with a default value doesn't have to be given
+		param.Required = false
+		if param.Default, ok = param.Check(default_value); !ok {
+			return TaskParam{Type: UnsupportedTypeParam}
+		}
+	}
+
+	return param
+}
+
+// IsValid checks that the type is supported, that only enums have values
+// and that the default value is valid. Assets can't have a default value,
+// they belong to a content owner.
+func (this TaskParam) IsValid() bool {
+	if this.Default != nil {
+		if this.Required || this.Type == AssetParam {
+			return false
+		} else if _, ok := this.Check(this.Default); !ok {
+			return false
+		}
+	}
+
+	switch this.Type {
+	case UnsupportedTypeParam:
+		return false
+	case EnumParam:
+		return len(this.Values) > 0
+	}
+	return len(this.Values) == 0
+}
+
+// Describe returns the parameter in the form it is registered
+func (this TaskParam) Describe() interface{} {
+	if this.Type != EnumParam && this.Required {
+		return ParamTypeStr(this.Type)
+	}
+
+	described := map[string]interface{}{
+		"type":     ParamTypeStr(this.Type),
+		"required": this.Required,
+	}
+	if this.Type == EnumParam {
+		described["values"] = this.Values
+	}
+	if this.Default != nil {
+		described["default"] = this.Default
+	}
+	return described
+}
+
+// DescribeParams returns the parameters of a task in the form they are registered
+func DescribeParams(params map[string]TaskParam) map[string]interface{} {
+	described := make(map[string]interface{})
+	for name, param := range params {
+		described[name] = param.Describe()
+	}
+	return described
+}
+
+// AssignableTo checks if the values of this output
+// are always valid values for the given input
+func (this TaskParam) AssignableTo(input TaskParam) bool {
+	if this.Type != input.Type {
+		return false
+	}
+
+	for _, value := range this.Values {
+		if !input.Allows(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCompatibleInput checks if the input parameters can replace the
+// old ones without breaking the clients: every old parameter is still
+// accepted and the new parameters can be omitted.
+func IsCompatibleInput(old_input, new_input map[string]TaskParam) bool {
+	for name, old_param := range old_input {
+		new_param, ok := new_input[name]
+		if !ok || !old_param.AssignableTo(new_param) {
+			return false
+		} else if new_param.Required && !old_param.Required {
+			return false
+		}
+	}
+
+	for name, new_param := range new_input {
+		if _, ok := old_input[name]; !ok && new_param.Required {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCompatibleOutput checks if the output parameters can replace the
+// old ones without breaking the steps that are linked to them: every
+// old output is still sent with values the old one could have.
+func IsCompatibleOutput(old_output, new_output map[string]TaskParam) bool {
+	for name, old_param := range old_output {
+		new_param, ok := new_output[name]
+		if !ok || !new_param.AssignableTo(old_param) {
+			return false
+		} else if old_param.Required && !new_param.Required {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows checks if the value is one of the values of the enum
+func (this TaskParam) Allows(value string) bool {
+	for _, allowed := range this.Values {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Check validates a value of the parameter and converts it to the type
+// of the parameter. Asset urls are only checked for their form, the
+// JobService checks that the asset exists.
+func (this TaskParam) Check(value interface{}) (interface{}, bool) {
+	switch this.Type {
+	case IntParam:
+		// Numbers in JSON are floats, they are converted
+		if number, ok := value.(float64); ok {
+			return int64(number), true
+		}
+		number, ok := value.(int64)
+		return number, ok
+	case DoubleParam:
+		number, ok := value.(float64)
+		return number, ok
+	case StringParam:
+		str, ok := value.(string)
+		return str, ok
+	case BoolParam:
+		flag, ok := value.(bool)
+		return flag, ok
+	case JsonParam:
+		object, ok := value.(map[string]interface{})
+		return object, ok
+	case ArrayParam:
+		array, ok := value.([]interface{})
+		return array, ok
+	case AssetParam:
+		url, ok := value.(string)
+		return url, ok && len(AssetUrlParam(url)) > 0
+	case EnumParam:
+		str, ok := value.(string)
+		return str, ok && this.Allows(str)
+	}
+	return nil, false
+}
+
+// RetryPolicy defines how many times the start request of a task is sent
+// before the job is aborted
+type RetryPolicy struct {
+	MaxAttempts int
+	// The delay before the first retry, it doubles after every attempt
+	BackoffBase time.Duration
+	// The http status codes that can be retried.
+	// Requests that couldn't reach the service are always retried.
+	StatusCodes []int
+}
+
+// The limits of a RetryPolicy
+const (
+	MaxRetryAttempts = 20
+	MinRetryBackoff  = time.Second
+	MaxRetryBackoff  = time.Hour
+)
+
+// DefaultRetryPolicy is used for tasks that didn't register their own
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BackoffBase: 10 * time.Second,
+	StatusCodes: []int{429, 502, 503, 504},
+}
+
+// ShouldRetry returns true if a response with this status code can be retried
+func (this RetryPolicy) ShouldRetry(status_code int) bool {
+	for _, code := range this.StatusCodes {
+		if code == status_code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before the next attempt, after `attempt` has failed.
+// It stays between MinRetryBackoff and MaxRetryBackoff.
+func (this RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := this.BackoffBase
+	if backoff < MinRetryBackoff {
+		backoff = MinRetryBackoff
+	}
+
+	// The doubling stops at the maximum so it can't overflow
+	for i := 1; i < attempt && backoff < MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > MaxRetryBackoff {
+		backoff = MaxRetryBackoff
+	}
+	return backoff
+}
+
+// IsValid checks the limits of the policy
+func (this RetryPolicy) IsValid() bool {
+	if this.MaxAttempts < 1 || this.MaxAttempts > MaxRetryAttempts {
+		return false
+	} else if this.BackoffBase < MinRetryBackoff || this.BackoffBase > MaxRetryBackoff {
+		return false
+	}
+
+	for _, code := range this.StatusCodes {
+		if code < 100 || code > 599 {
+			return false
+		}
+	}
+	return true
+}
+
 type Task struct {
-	ID          int64
-	ModuleID    int64
+	ID       int64
+	ModuleID int64
+	// The version of the contract (StartUrl, CancelUrl, Input, Output
+	// and the schemas), every change of the contract creates a new one
+	Version     int
 	Name        string
 	Description string
 	StartUrl    string
 	CancelUrl   string
 	Enabled     bool
 	Deleted     bool
-	Input       map[string]ParamType
-	Output      map[string]ParamType
+	Input       map[string]TaskParam
+	Output      map[string]TaskParam
+	Retry       RetryPolicy
+	// How long a step can wait for the `finish` request of the service
+	// after it was accepted (202). Zero means there is no limit.
+	MaxAsyncDuration time.Duration
+	// Optional contracts that the input and the output are validated against
+	InputSchema  Schema
+	OutputSchema Schema
+	// Set by GetTaskVersion for a version that no active job uses,
+	// no step starts with it
+	Retired bool
+}
+
+// IsOptionalInput checks if an input parameter can be omitted
+func (this *Task) IsOptionalInput(name string) bool {
+	if param, ok := this.Input[name]; ok && !param.Required {
+		return true
+	}
+	return this.InputSchema != nil && !this.InputSchema.IsRequired(name)
+}
+
+// IsOptionalOutput checks if an output parameter can be omitted
+func (this *Task) IsOptionalOutput(name string) bool {
+	if param, ok := this.Output[name]; ok && !param.Required {
+		return true
+	}
+	return this.OutputSchema != nil && !this.OutputSchema.IsRequired(name)
+}
+
+// InputDefaults returns the default values of the input parameters
+// that are missing from the input
+func (this *Task) InputDefaults(input map[string]interface{}) map[string]interface{} {
+	defaults := make(map[string]interface{})
+	for name, param := range this.Input {
+		if _, ok := input[name]; !ok && param.Default != nil {
+			defaults[name] = param.Default
+		}
+	}
+	return defaults
+}
+
+// TaskVersion is the contract of a task at some point, the steps of the
+// jobs are performed with the version they were created with
+type TaskVersion struct {
+	TaskID           int64
+	Version          int
+	StartUrl         string
+	CancelUrl        string
+	Input            map[string]TaskParam
+	Output           map[string]TaskParam
+	Retry            RetryPolicy
+	MaxAsyncDuration time.Duration
+	InputSchema      Schema
+	OutputSchema     Schema
+	CreationDate     time.Time
+	// A previous version that no active job uses
+	Retired bool
 }
 
 type TaskRepository interface {
@@ -69,15 +436,29 @@ type TaskRepository interface {
 
 	GetTask(id int64) (*Task, error)
 
+	// Returns the task with the contract of the given version,
+	// nil if the version doesn't exist
+	GetTaskVersion(id int64, version int) (*Task, error)
+
+	// Returns the versions of the task, the latest first
+	GetTaskVersions(id int64) ([]*TaskVersion, error)
+
 	GetTasks(module_id int64, fetch_deleted bool) ([]*Task, error)
 
 	SetAvailability(id int64, enabled bool) error
 
 	DeleteTask(id int64) error
 
+	// Saves the fields that aren't part of the contract
 	Save(task *Task) error
 
-	SaveVars(task *Task, is_input bool) error
+	// Creates a new version with the contract and the retry policy
+	// of the task and sets the Version of the task
+	SaveVersion(task *Task) error
+
+	// Retires the previous versions that no active job uses,
+	// returns how many were retired
+	RetireVersions() (int64, error)
 }
 
 // Errors
@@ -93,13 +474,18 @@ var ErrEmptyInput = errors.New("Input can't be empty")
 var ErrEmptyOutput = errors.New("Output can't be empty")
 var ErrTaskIsEnabled = errors.New("Task is enabled")
 var ErrTaskHasJobStepsInProgress = errors.New("Task has job steps in progress")
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+var ErrInvalidMaxAsyncDuration = errors.New("invalid max async duration")
 
 // Service
 type TaskService interface {
 	RegisterTask(
 		module_id int64,
 		name, description, start_url, cancel_url string,
-		input, output map[string]ParamType) (*Task, error)
+		input, output map[string]TaskParam,
+		input_schema, output_schema Schema,
+		retry RetryPolicy,
+		max_async_duration time.Duration) (*Task, error)
 
 	SetAvailability(id int64, enabled bool) error
 
@@ -107,5 +493,14 @@ type TaskService interface {
 
 	Update(id int64, fields map[string]string) error
 
-	UpdateVars(id int64, data map[string]ParamType, is_input bool) error
+	UpdateVars(id int64, data map[string]TaskParam, is_input bool) error
+
+	// Sets the schema of the input or the output, nil removes it
+	UpdateSchema(id int64, schema Schema, is_input bool) error
+
+	UpdateRetryPolicy(id int64, retry RetryPolicy) error
+
+	UpdateMaxAsyncDuration(id int64, max_async_duration time.Duration) error
+
+	RetireVersions() error
 }
@@ -2,6 +2,7 @@ package sm
 
 import (
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -22,7 +23,10 @@ func NewTaskService(
 func (this *task_service) RegisterTask(
 	module_id int64,
 	name, description, start_url, cancel_url string,
-	input, output map[string]ParamType) (*Task, error) {
+	input, output map[string]TaskParam,
+	input_schema, output_schema Schema,
+	retry RetryPolicy,
+	max_async_duration time.Duration) (*Task, error) {
 
 	if len(name) <= 1 {
 		return nil, ErrTaskNameTooShort
@@ -40,6 +44,18 @@ func (this *task_service) RegisterTask(
 		return nil, ErrEmptyInput
 	} else if len(output) == 0 {
 		return nil, ErrEmptyOutput
+	} else if !retry.IsValid() {
+		return nil, ErrInvalidRetryPolicy
+	} else if max_async_duration < 0 {
+		return nil, ErrInvalidMaxAsyncDuration
+	}
+
+	for _, schema := range []Schema{input_schema, output_schema} {
+		if schema == nil {
+			continue
+		} else if err := schema.Check(); err != nil {
+			return nil, err
+		}
 	}
 
 	exists, err := this.repository.NameExists(name)
@@ -56,23 +72,25 @@ func (this *task_service) RegisterTask(
 		Description: description,
 		StartUrl:    start_url,
 		CancelUrl:   cancel_url,
-		Input:       make(map[string]ParamType),
-		Output:      make(map[string]ParamType),
+		Input:       make(map[string]TaskParam),
+		Output:      make(map[string]TaskParam),
+		Retry:       retry,
+
+		MaxAsyncDuration: max_async_duration,
+		InputSchema:      input_schema,
+		OutputSchema:     output_schema,
 	}
 
 	for name, value := range input {
-		if value != StringParam &&
-			value != IntParam &&
-			value != DoubleParam {
+		if !value.IsValid() {
 			return nil, ErrInvalidInputType
 		}
 		task.Input[name] = value
 	}
 
 	for name, value := range output {
-		if value != StringParam &&
-			value != IntParam &&
-			value != DoubleParam {
+		// The default values are only used for the input
+		if !value.IsValid() || value.Default != nil {
 			return nil, ErrInvalidOutputType
 		}
 		task.Output[name] = value
@@ -132,11 +150,7 @@ func (this *task_service) Update(id int64, fields map[string]string) error {
 		return ErrNotFound
 	}
 
-	is_active, err := this.job_repository.TaskHasActiveJobs(task.ID)
-
-	if err != nil {
-		return err
-	}
+	is_contract := false
 
 	for name, value := range fields {
 		if name == "Name" {
@@ -146,54 +160,94 @@ func (this *task_service) Update(id int64, fields map[string]string) error {
 		} else if task.Enabled {
 			// For the rest of the field only allow updates if the task is disabled
 			return ErrTaskIsEnabled
-		} else if is_active {
-			// For the rest of the fields only allows update if the task is not currently used.
-			return ErrTaskHasJobStepsInProgress
 		} else if name == "StartUrl" {
 			task.StartUrl = value
+			is_contract = true
 		} else if name == "CancelUrl" {
 			task.CancelUrl = value
+			is_contract = true
 		}
 	}
 
-	return this.repository.Save(task)
+	if err = this.repository.Save(task); err != nil {
+		return err
+	} else if is_contract {
+		return this.saveVersion(task)
+	}
+	return nil
 }
 
-func (this *task_service) UpdateVars(id int64, data map[string]ParamType, is_input bool) error {
-	task, err := this.repository.GetTask(id)
+// The active steps keep using the version they were created with,
+// so the contract and the retry policy can change while the task is used.
+func (this *task_service) saveVersion(task *Task) error {
+	if err := this.repository.SaveVersion(task); err != nil {
+		return err
+	}
 
-	if err != nil {
-		return nil
-	} else if task == nil || task.Deleted {
-		return ErrNotFound
-	} else if task.Enabled {
-		return ErrTaskIsEnabled
+	log.Infof("task=%v version=%v created", task.ID, task.Version)
+
+	// The previous version may not be used by any job
+	if err := this.RetireVersions(); err != nil {
+		log.Errorf("task=%v failed to retire versions err=%v", task.ID, err)
 	}
+	return nil
+}
 
-	is_active, err := this.job_repository.TaskHasActiveJobs(task.ID)
+func (this *task_service) RetireVersions() error {
+	retired, err := this.repository.RetireVersions()
 
 	if err != nil {
 		return err
-	} else if is_active {
-		return ErrTaskHasJobStepsInProgress
+	} else if retired > 0 {
+		log.Infof("%v task versions retired", retired)
 	}
+	return nil
+}
 
-	if is_input {
-		task.Input = make(map[string]ParamType)
-	} else {
-		task.Output = make(map[string]ParamType)
+// UpdateVars replaces the input or the output of a task.
+// Changes that don't break the clients or the linked steps, like new
+// optional input or new output, are allowed while the task is enabled.
+func (this *task_service) UpdateVars(id int64, data map[string]TaskParam, is_input bool) error {
+	task, err := this.repository.GetTask(id)
+
+	if err != nil {
+		return err
+	} else if task == nil || task.Deleted {
+		return ErrNotFound
 	}
 
-	for name, value := range data {
-		if value != StringParam &&
-			value != IntParam &&
-			value != DoubleParam {
+	for _, value := range data {
+		if !value.IsValid() {
 			if is_input {
 				return ErrInvalidInputType
 			} else {
 				return ErrInvalidOutputType
 			}
+		} else if !is_input && value.Default != nil {
+			return ErrInvalidOutputType
 		}
+	}
+
+	var is_compatible bool
+	if is_input {
+		is_compatible = IsCompatibleInput(task.Input, data)
+	} else {
+		is_compatible = IsCompatibleOutput(task.Output, data)
+	}
+
+	if !is_compatible && task.Enabled {
+		return ErrTaskIsEnabled
+	}
+
+	log.Infof("task=%v update vars is_input=%v compatible=%v", task.ID, is_input, is_compatible)
+
+	if is_input {
+		task.Input = make(map[string]TaskParam)
+	} else {
+		task.Output = make(map[string]TaskParam)
+	}
+
+	for name, value := range data {
 		if is_input {
 			task.Input[name] = value
 		} else {
@@ -201,5 +255,76 @@ func (this *task_service) UpdateVars(id int64, data map[string]ParamType, is_inp
 		}
 	}
 
-	return this.repository.SaveVars(task, is_input)
+	return this.saveVersion(task)
+}
+
+func (this *task_service) UpdateRetryPolicy(id int64, retry RetryPolicy) error {
+	if !retry.IsValid() {
+		return ErrInvalidRetryPolicy
+	}
+
+	task, err := this.repository.GetTask(id)
+
+	if err != nil {
+		return err
+	} else if task == nil || task.Deleted {
+		return ErrNotFound
+	}
+
+	log.Infof("task=%v update retry policy max_attempts=%v backoff_base=%v status_codes=%v",
+		task.ID, retry.MaxAttempts, retry.BackoffBase, retry.StatusCodes)
+
+	// The active steps keep the policy of their version
+	task.Retry = retry
+
+	return this.saveVersion(task)
+}
+
+func (this *task_service) UpdateMaxAsyncDuration(id int64, max_async_duration time.Duration) error {
+	if max_async_duration < 0 {
+		return ErrInvalidMaxAsyncDuration
+	}
+
+	task, err := this.repository.GetTask(id)
+
+	if err != nil {
+		return err
+	} else if task == nil || task.Deleted {
+		return ErrNotFound
+	}
+
+	log.Infof("task=%v update max_async_duration=%v", task.ID, max_async_duration)
+
+	// The active steps keep the duration of their version
+	task.MaxAsyncDuration = max_async_duration
+
+	return this.saveVersion(task)
+}
+
+func (this *task_service) UpdateSchema(id int64, schema Schema, is_input bool) error {
+	if schema != nil {
+		if err := schema.Check(); err != nil {
+			return err
+		}
+	}
+
+	task, err := this.repository.GetTask(id)
+
+	if err != nil {
+		return err
+	} else if task == nil || task.Deleted {
+		return ErrNotFound
+	} else if task.Enabled {
+		return ErrTaskIsEnabled
+	}
+
+	log.Infof("task=%v update schema is_input=%v schema=%v", task.ID, is_input, schema)
+
+	if is_input {
+		task.InputSchema = schema
+	} else {
+		task.OutputSchema = schema
+	}
+
+	return this.saveVersion(task)
 }
@@ -0,0 +1,183 @@
+package sm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// The issuer shown by the authenticator apps
+	TotpIssuer = "EasyTV"
+	TotpDigits = 6
+	TotpPeriod = 30 // seconds
+	// The codes of the steps before and after the current one are accepted
+	// for the clock drift of the phones
+	TotpSkew = 1
+	// How long the code can be entered after the password
+	TotpPendingExpiration = 60 * 5 // 5 minutes
+	RecoveryCodeCount     = 10
+)
+
+var totp_encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TotpSecret is the shared secret of the authenticator app of a user,
+// UserRole is RoleAdmin or RoleContentOwner
+type TotpSecret struct {
+	UserRole int
+	UserID   int64
+	// base32, the codes are computed from it so it can't be hashed
+	Secret string
+	// false until the first code is verified
+	Enabled      bool
+	CreationDate time.Time
+	// The last step that a code was accepted for, a code can't be used twice
+	LastStep int64
+}
+
+// GenerateTotpSecret returns a random base32 secret
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totp_encoding.EncodeToString(b), nil
+}
+
+// TotpStep returns the time step of RFC 6238 for the time
+func TotpStep(now time.Time) int64 {
+	return now.Unix() / TotpPeriod
+}
+
+// TotpCode computes the code of a step with HMAC-SHA1
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := totp_encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TotpDigits, value%1000000), nil
+}
+
+// MatchTotpCode returns the step of the window around now that the code is for,
+// -1 if it doesn't match any
+func MatchTotpCode(secret, code string, now time.Time) int64 {
+	current := TotpStep(now)
+
+	for step := current - TotpSkew; step <= current+TotpSkew; step++ {
+		expected, err := TotpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// TotpProvisioningUri returns the otpauth uri that the QR code of the enrollment shows
+func TotpProvisioningUri(secret, account_name string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TotpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TotpDigits))
+	query.Set("period", fmt.Sprint(TotpPeriod))
+
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s",
+		url.PathEscape(TotpIssuer), url.PathEscape(account_name), query.Encode())
+}
+
+// GenerateRecoveryCodes returns the one time codes that replace a lost authenticator
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the form a recovery code is stored in,
+// the code is read without spaces and case
+func HashRecoveryCode(code string) string {
+	return HashApiKey(strings.ToLower(strings.Replace(strings.TrimSpace(code), " ", "", -1)))
+}
+
+type TotpRepository interface {
+	Get(user_role int, user_id int64) (*TotpSecret, error)
+
+	// Inserts or replaces the secret of the user
+	Save(secret *TotpSecret) error
+
+	// Deletes the secret and the recovery codes of the user
+	Delete(user_role int, user_id int64) error
+
+	// Records that a code of the step was accepted,
+	// false if the step or a later one was used already
+	UseStep(user_role int, user_id int64, step int64) (bool, error)
+
+	// Replaces the recovery codes of the user
+	SetRecoveryCodes(user_role int, user_id int64, hashed_codes []string) error
+
+	// Marks an unused recovery code as used, false if there is none
+	UseRecoveryCode(user_role int, user_id int64, hashed_code string) (bool, error)
+
+	// The number of the recovery codes that weren't used
+	CountRecoveryCodes(user_role int, user_id int64) (int, error)
+}
+
+// errors
+
+var ErrTotpAlreadyEnabled = errors.New("Two factor authentication is already enabled")
+var ErrTotpNotEnrolled = errors.New("Two factor authentication isn't enrolled")
+var ErrInvalidTotpCode = errors.New("Invalid authentication code")
+
+// service
+
+type TotpService interface {
+	// Creates a new secret that is enabled when a code is confirmed,
+	// returns the secret and its provisioning uri
+	Enroll(user_role int, user_id int64, account_name string) (string, string, error)
+
+	// Enables the secret with a code of the app, returns the recovery codes
+	Confirm(user_role int, user_id int64, code string) ([]string, error)
+
+	// Disables the two factor authentication, code is a code of the app
+	// or a recovery code
+	Disable(user_role int, user_id int64, code string) error
+
+	// Replaces the recovery codes, code is a code of the app
+	RegenerateRecoveryCodes(user_role int, user_id int64, code string) ([]string, error)
+
+	IsEnabled(user_role int, user_id int64) (bool, error)
+
+	// Checks a code of the app or a recovery code for the login
+	Verify(user_role int, user_id int64, code string) (bool, error)
+
+	// Removes the two factor authentication of a user that lost the app
+	// and the recovery codes
+	Reset(user_role int, user_id int64) error
+}
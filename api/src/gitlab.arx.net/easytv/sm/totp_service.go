@@ -0,0 +1,191 @@
+package sm
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type totp_service struct {
+	repository TotpRepository
+}
+
+func NewTotpService(repository TotpRepository) TotpService {
+	return &totp_service{
+		repository: repository,
+	}
+}
+
+func (this *totp_service) Enroll(user_role int, user_id int64,
+	account_name string) (string, string, error) {
+
+	current, err := this.repository.Get(user_role, user_id)
+
+	if err != nil {
+		return "", "", err
+	} else if current != nil && current.Enabled {
+		return "", "", ErrTotpAlreadyEnabled
+	}
+
+	secret, err := GenerateTotpSecret()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	err = this.repository.Save(&TotpSecret{
+		UserRole:     user_role,
+		UserID:       user_id,
+		Secret:       secret,
+		CreationDate: time.Now(),
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Infof("user=%v role=%v enrolled two factor authentication", user_id, user_role)
+
+	return secret, TotpProvisioningUri(secret, account_name), nil
+}
+
+// Checks a code of the app, a code can't be used twice
+func (this *totp_service) verifyCode(secret *TotpSecret, code string) (bool, error) {
+	step := MatchTotpCode(secret.Secret, code, time.Now())
+
+	if step == -1 {
+		return false, nil
+	}
+	return this.repository.UseStep(secret.UserRole, secret.UserID, step)
+}
+
+func (this *totp_service) newRecoveryCodes(user_role int, user_id int64) ([]string, error) {
+	codes, err := GenerateRecoveryCodes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashed_codes := make([]string, len(codes))
+	for i, code := range codes {
+		hashed_codes[i] = HashRecoveryCode(code)
+	}
+
+	if err = this.repository.SetRecoveryCodes(user_role, user_id, hashed_codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (this *totp_service) Confirm(user_role int, user_id int64, code string) ([]string, error) {
+	secret, err := this.repository.Get(user_role, user_id)
+
+	if err != nil {
+		return nil, err
+	} else if secret == nil {
+		return nil, ErrTotpNotEnrolled
+	} else if secret.Enabled {
+		return nil, ErrTotpAlreadyEnabled
+	}
+
+	step := MatchTotpCode(secret.Secret, code, time.Now())
+
+	if step == -1 {
+		return nil, ErrInvalidTotpCode
+	}
+
+	codes, err := this.newRecoveryCodes(user_role, user_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret.Enabled = true
+	secret.LastStep = step
+
+	if err = this.repository.Save(secret); err != nil {
+		return nil, err
+	}
+
+	log.Infof("user=%v role=%v enabled two factor authentication", user_id, user_role)
+
+	return codes, nil
+}
+
+func (this *totp_service) enabledSecret(user_role int, user_id int64) (*TotpSecret, error) {
+	secret, err := this.repository.Get(user_role, user_id)
+
+	if err != nil {
+		return nil, err
+	} else if secret == nil || !secret.Enabled {
+		return nil, ErrTotpNotEnrolled
+	}
+	return secret, nil
+}
+
+func (this *totp_service) Disable(user_role int, user_id int64, code string) error {
+	if ok, err := this.Verify(user_role, user_id, code); err != nil {
+		return err
+	} else if !ok {
+		return ErrInvalidTotpCode
+	}
+
+	log.Infof("user=%v role=%v disabled two factor authentication", user_id, user_role)
+
+	return this.repository.Delete(user_role, user_id)
+}
+
+func (this *totp_service) RegenerateRecoveryCodes(user_role int, user_id int64,
+	code string) ([]string, error) {
+
+	secret, err := this.enabledSecret(user_role, user_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := this.verifyCode(secret, code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidTotpCode
+	}
+
+	log.Infof("user=%v role=%v regenerated the recovery codes", user_id, user_role)
+
+	return this.newRecoveryCodes(user_role, user_id)
+}
+
+func (this *totp_service) IsEnabled(user_role int, user_id int64) (bool, error) {
+	secret, err := this.repository.Get(user_role, user_id)
+
+	if err != nil {
+		return false, err
+	}
+	return secret != nil && secret.Enabled, nil
+}
+
+func (this *totp_service) Verify(user_role int, user_id int64, code string) (bool, error) {
+	secret, err := this.enabledSecret(user_role, user_id)
+
+	if err != nil {
+		return false, err
+	}
+
+	if ok, err := this.verifyCode(secret, code); err != nil || ok {
+		return ok, err
+	}
+
+	ok, err := this.repository.UseRecoveryCode(user_role, user_id, HashRecoveryCode(code))
+
+	if ok {
+		log.Infof("user=%v role=%v used a recovery code", user_id, user_role)
+	}
+
+	return ok, err
+}
+
+func (this *totp_service) Reset(user_role int, user_id int64) error {
+	log.Infof("user=%v role=%v two factor authentication was reset", user_id, user_role)
+
+	return this.repository.Delete(user_role, user_id)
+}
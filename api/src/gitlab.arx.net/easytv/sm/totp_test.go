@@ -0,0 +1,100 @@
+package sm
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret of RFC 6238 appendix B, "12345678901234567890" in base32
+const rfc6238_secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The SHA-1 vectors of RFC 6238 appendix B, the codes are the
+// last 6 of the 8 digits in the RFC
+var rfc6238_vectors = []struct {
+	time int64
+	step int64
+	code string
+}{
+	{59, 0x1, "287082"},
+	{1111111109, 0x23523EC, "081804"},
+	{1111111111, 0x23523ED, "050471"},
+	{1234567890, 0x273EF07, "005924"},
+	{2000000000, 0x3F940AA, "279037"},
+	{20000000000, 0x27BC86AA, "353130"},
+}
+
+func TestTotpCodeRfc6238(t *testing.T) {
+	for _, vector := range rfc6238_vectors {
+		step := TotpStep(time.Unix(vector.time, 0))
+		if step != vector.step {
+			t.Errorf("time %d: step %x, expected %x", vector.time, step, vector.step)
+		}
+
+		code, err := TotpCode(rfc6238_secret, step)
+		if err != nil {
+			t.Fatal(err)
+		} else if code != vector.code {
+			t.Errorf("time %d: code %s, expected %s", vector.time, code, vector.code)
+		}
+	}
+}
+
+func TestMatchTotpCodeWindow(t *testing.T) {
+	for _, vector := range rfc6238_vectors {
+		tests := []struct {
+			offset   int64
+			expected int64
+		}{
+			{0, vector.step},
+			// One step of skew each way is accepted
+			{-TotpPeriod, vector.step},
+			{TotpPeriod, vector.step},
+			{-2 * TotpPeriod, -1},
+			{2 * TotpPeriod, -1},
+		}
+
+		for _, test := range tests {
+			if vector.time+test.offset < 0 {
+				// The steps start at the epoch
+				continue
+			}
+
+			now := time.Unix(vector.time+test.offset, 0)
+
+			if step := MatchTotpCode(rfc6238_secret, vector.code, now); step != test.expected {
+				t.Errorf("time %d%+d: matched step %d, expected %d",
+					vector.time, test.offset, step, test.expected)
+			}
+		}
+	}
+}
+
+func TestMatchTotpCodeRejects(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfc6238_secret, "050472"},
+		{"8 digits", rfc6238_secret, "14050471"},
+		{"empty code", rfc6238_secret, ""},
+		{"invalid secret", "not base32!", "050471"},
+	}
+
+	for _, test := range tests {
+		if step := MatchTotpCode(test.secret, test.code, now); step != -1 {
+			t.Errorf("%s: matched step %d", test.name, step)
+		}
+	}
+}
+
+func TestTotpCodeLowercaseSecret(t *testing.T) {
+	lower, err := TotpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if lower != "287082" {
+		t.Errorf("code %s, expected 287082", lower)
+	}
+}
@@ -0,0 +1,104 @@
+package sm
+
+import (
+	"errors"
+	"time"
+)
+
+// The events that are sent to the callback url of an organisation
+const (
+	EventStepStarted   = "step_started"
+	EventStepCompleted = "step_completed"
+	EventJobCompleted  = "job_completed"
+	EventJobCanceled   = "job_canceled"
+	EventJobAborted    = "job_aborted"
+)
+
+type DeliveryStatus int
+
+const (
+	DeliveryPending   DeliveryStatus = 0
+	DeliveryDelivered DeliveryStatus = 1
+	// All the attempts of the delivery have failed
+	DeliveryFailed DeliveryStatus = 2
+)
+
+func DeliveryStatusStr(status DeliveryStatus) string {
+	switch status {
+	case DeliveryPending:
+		return "pending"
+	case DeliveryDelivered:
+		return "delivered"
+	case DeliveryFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// WebhookRetryPolicy defines how many times a delivery is attempted
+var WebhookRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BackoffBase: 30 * time.Second,
+}
+
+// WebhookDelivery is an event of a job that is POSTed to a callback url
+type WebhookDelivery struct {
+	ID             int64
+	OrganisationID int64
+	JobID          int64
+	Url            string
+	Event          string
+	// The JSON body of the request
+	Payload      string
+	Status       DeliveryStatus
+	Attempts     int
+	StatusCode   *int
+	Error        string
+	CreationDate time.Time
+	LastAttempt  *time.Time
+	NextAttempt  time.Time
+	// The secret of the organisation, it is used to sign the request
+	Secret string
+}
+
+type WebhookRepository interface {
+	// Returns the callback url of the job, or the url of its organisation if
+	// the job doesn't have one, and the secret of the organisation.
+	// The url is empty if there is no callback.
+	GetJobEndpoint(job_id int64) (string, string, error)
+
+	CreateDelivery(delivery *WebhookDelivery) error
+
+	// Lease reserves the next pending delivery for the given duration,
+	// returns nil if there is nothing to send.
+	Lease(duration time.Duration) (*WebhookDelivery, error)
+
+	// Saves the outcome of an attempt
+	SaveAttempt(delivery *WebhookDelivery) error
+
+	// Returns the deliveries of the organisation, newest first
+	GetDeliveriesForOrganisation(organisation_id, limit, before_id int64) ([]*WebhookDelivery, error)
+}
+
+// JobEventNotifier is informed about the progress of the jobs
+type JobEventNotifier interface {
+	Notify(job *Job, event string, data map[string]interface{})
+}
+
+type WebhookService interface {
+	JobEventNotifier
+
+	// Sets the callback url of the organisation, an empty url disables the webhook
+	SetCallbackUrl(organisation_id int64, url string) error
+
+	// Generates a new signing secret for the organisation
+	RotateSecret(organisation_id int64) (string, error)
+
+	// Sends a delivery and saves the outcome
+	Deliver(delivery *WebhookDelivery) error
+}
+
+// errors
+
+var ErrInvalidCallbackUrl = errors.New("The callback url should be an http(s) url of a public host")
+var ErrCallbackUnreachable = errors.New("The callback couldn't be reached")
@@ -0,0 +1,89 @@
+package sm
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebhookDispatcher sends the pending webhook deliveries
+// with a fixed number of goroutines.
+//
+// A delivery is leased by moving its next attempt into the future,
+// if the process dies before the attempt is saved it is sent again
+// when the lease expires.
+type WebhookDispatcher struct {
+	repository    WebhookRepository
+	service       WebhookService
+	size          int
+	lease         time.Duration
+	poll_interval time.Duration
+	stop          chan struct{}
+	wait_group    sync.WaitGroup
+}
+
+// NewWebhookDispatcher creates a dispatcher with `size` senders.
+// lease: how long a delivery is reserved, it should be longer than
+// the timeout of the requests.
+func NewWebhookDispatcher(repository WebhookRepository,
+	service WebhookService,
+	size int,
+	lease time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repository:    repository,
+		service:       service,
+		size:          size,
+		lease:         lease,
+		poll_interval: time.Second,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start the senders
+func (this *WebhookDispatcher) Start() {
+	log.Infof("webhook_dispatcher starting %d senders", this.size)
+
+	for i := 0; i < this.size; i++ {
+		this.wait_group.Add(1)
+		go this.work()
+	}
+}
+
+// Stop the senders and wait for the deliveries in progress
+func (this *WebhookDispatcher) Stop() {
+	close(this.stop)
+	this.wait_group.Wait()
+}
+
+func (this *WebhookDispatcher) work() {
+	defer this.wait_group.Done()
+
+	for {
+		select {
+		case <-this.stop:
+			return
+		default:
+		}
+
+		delivery, err := this.repository.Lease(this.lease)
+
+		if err != nil {
+			log.Errorf("webhook_dispatcher failed to lease delivery err=%v", err)
+		}
+
+		if err != nil || delivery == nil {
+			// Nothing to send, wait before asking again
+			select {
+			case <-this.stop:
+				return
+			case <-time.After(this.poll_interval):
+			}
+			continue
+		}
+
+		if err = this.service.Deliver(delivery); err != nil {
+			log.Errorf("delivery=%v failed to save attempt err=%v", delivery.ID, err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package sm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type webhook_service struct {
+	repository              WebhookRepository
+	organisation_repository OrganisationRepository
+	client                  *http.Client
+}
+
+func NewWebhookService(repository WebhookRepository,
+	organisation_repository OrganisationRepository) WebhookService {
+	return &webhook_service{
+		repository:              repository,
+		organisation_repository: organisation_repository,
+		client:                  NewCallbackClient(10 * time.Second),
+	}
+}
+
+// The ranges of the addresses that aren't reachable from the internet
+var internal_networks = parse_networks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parse_networks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
+
+// IsPublicIP checks that an address isn't loopback, link-local, private
+// or otherwise internal, the callbacks are only sent to public addresses
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range internal_networks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var ErrCallbackAddressNotAllowed = errors.New("The callback resolves to an internal address")
+
+// NewCallbackClient returns the client of the callbacks. The address is
+// checked when the connection is made, after the name is resolved,
+// so a name that changes its address later can't reach the internal network.
+// The redirects aren't followed.
+func NewCallbackClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return ErrCallbackAddressNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// GenerateWebhookSecret returns a random secret for signing the deliveries
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignWebhook returns the signature of a delivery, the receiver computes
+// the HMAC-SHA256 of "<timestamp>.<body>" with its secret and compares them
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsValidCallbackUrl checks that the url can receive deliveries, an http(s) url
+// of a public host. The address of a name is checked when a delivery is sent.
+func IsValidCallbackUrl(value string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+
+	if len(host) == 0 || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	} else if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return false
+	}
+	return true
+}
+
+// Stores a delivery for the event, the dispatcher sends it.
+// Failures are only logged, they never affect the job.
+func (this *webhook_service) Notify(job *Job, event string, data map[string]interface{}) {
+	url, secret, err := this.repository.GetJobEndpoint(job.ID)
+
+	if err != nil {
+		log.Errorf("job=%v event=%v failed to get webhook endpoint err=%v", job.ID, event, err)
+		return
+	} else if len(url) == 0 || len(secret) == 0 {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"event":  event,
+		"job_id": job.ID,
+		"status": job.Status,
+		"date":   time.Now().Unix(),
+	}
+	for key, value := range data {
+		payload[key] = value
+	}
+
+	json_data, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("job=%v event=%v failed to encode payload err=%v", job.ID, event, err)
+		return
+	}
+
+	delivery := WebhookDelivery{
+		OrganisationID: job.OrganisationID,
+		JobID:          job.ID,
+		Url:            url,
+		Event:          event,
+		Payload:        string(json_data),
+		Status:         DeliveryPending,
+		CreationDate:   time.Now(),
+		NextAttempt:    time.Now(),
+	}
+
+	if err = this.repository.CreateDelivery(&delivery); err != nil {
+		log.Errorf("job=%v event=%v failed to save delivery err=%v", job.ID, event, err)
+		return
+	}
+
+	log.Infof("job=%v event=%v delivery=%v queued", job.ID, event, delivery.ID)
+}
+
+func (this *webhook_service) SetCallbackUrl(organisation_id int64, url string) error {
+	if len(url) > 0 && !IsValidCallbackUrl(url) {
+		return ErrInvalidCallbackUrl
+	}
+
+	organisation, err := this.organisation_repository.GetByID(organisation_id)
+	if err != nil {
+		return err
+	} else if organisation == nil {
+		return ErrNotFound
+	}
+
+	organisation.CallbackUrl = url
+
+	log.Infof("organisation=%v set callback url=%v", organisation_id, url)
+
+	return this.organisation_repository.SaveWebhook(organisation)
+}
+
+func (this *webhook_service) RotateSecret(organisation_id int64) (string, error) {
+	organisation, err := this.organisation_repository.GetByID(organisation_id)
+	if err != nil {
+		return "", err
+	} else if organisation == nil {
+		return "", ErrNotFound
+	}
+
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+
+	organisation.WebhookSecret = secret
+
+	log.Infof("organisation=%v rotated webhook secret", organisation_id)
+
+	return secret, this.organisation_repository.SaveWebhook(organisation)
+}
+
+func (this *webhook_service) Deliver(delivery *WebhookDelivery) error {
+	delivery.Attempts++
+	delivery.LastAttempt = new(time.Time)
+	*delivery.LastAttempt = time.Now()
+	delivery.StatusCode = nil
+	delivery.Error = ""
+
+	err := this.send(delivery)
+
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		log.Infof("delivery=%v job=%v event=%v delivered attempt=%v",
+			delivery.ID, delivery.JobID, delivery.Event, delivery.Attempts)
+	} else if delivery.Attempts >= WebhookRetryPolicy.MaxAttempts {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+		log.Warnf("delivery=%v job=%v event=%v failed after %d attempts err=%v",
+			delivery.ID, delivery.JobID, delivery.Event, delivery.Attempts, err)
+	} else {
+		delivery.Error = err.Error()
+		delivery.NextAttempt = time.Now().Add(WebhookRetryPolicy.Backoff(delivery.Attempts))
+		log.Warnf("delivery=%v job=%v event=%v attempt=%v failed, retry at %v err=%v",
+			delivery.ID, delivery.JobID, delivery.Event, delivery.Attempts,
+			delivery.NextAttempt, err)
+	}
+
+	return this.repository.SaveAttempt(delivery)
+}
+
+func (this *webhook_service) send(delivery *WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest("POST", delivery.Url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(EasyTVEventHeader, delivery.Event)
+	req.Header.Add(EasyTVDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Add(EasyTVTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Add(EasyTVSignatureHeader, SignWebhook(delivery.Secret, timestamp, body))
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		// The details stay in the log, the delivery only says that it failed
+		log.Warnf("delivery=%v job=%v request failed err=%v", delivery.ID, delivery.JobID, err)
+		return ErrCallbackUnreachable
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = &resp.StatusCode
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Callback responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -67,7 +67,8 @@ function($scope,$rootScope,adminDashboardService,modalService,errorService){
             if(response.data.code == 200){
                 data = {
                     title: "success",
-                    message: "It was registered with the api key: " + response.data.api_key,
+                    message: "It was registered with the api key: " + response.data.api_key +
+                        " and the signing secret: " + response.data.signing_secret,
                     buttons: modalService.buttons["ok"]
                 }
                 clear_inputs();
@@ -3,6 +3,7 @@ app
     var enter = 13;
     $scope.username = "";
     $scope.password = "";
+    $scope.is_admin = false;
     $scope.list = [
         {
             "name": "English",
@@ -21,7 +22,7 @@ app
     $scope.language = window.localStorage.getItem("lang");
 
     $scope.login_clicked = function (){
-        loginService.login($scope.username,$scope.password);
+        loginService.login($scope.username,$scope.password,$scope.is_admin);
     }
 
     $scope.changeLanguage = function(lang) {
@@ -1,7 +1,9 @@
 app
 .service("loginService",["requestService", "$http",function(requestService, $http){
-    this.login = function(username,password){
-        requestService.post_request("/api/user/login",{
+    this.login = function(username,password,is_admin){
+        // the admins log in through their own path
+        var url = is_admin ? "/api/user/admin/login" : "/api/user/login";
+        requestService.post_request(url,{
             username: username,
             password: password}, "").then(function(response){
                 if(response.data.code == 200){